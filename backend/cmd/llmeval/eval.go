@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"message-flow/backend/internal/llm"
+)
+
+// builtinPrompt is the prompt version that runs the providers' own prompt.
+const builtinPrompt = "builtin"
+
+// priorityLabels orders the confusion matrix; labels outside it follow in
+// alphabetical order.
+var priorityLabels = []string{"high", "medium", "low"}
+
+// Example is one labelled message. Expected fields use the AnalysisResult
+// names and may be left out; an example only counts towards the metrics it
+// has a label for.
+type Example struct {
+	ID          string  `json:"id"`
+	Text        string  `json:"text"`
+	ContactName string  `json:"contact_name"`
+	IsImportant *bool   `json:"is_important"`
+	Priority    *string `json:"priority"`
+	Sentiment   *string `json:"sentiment"`
+}
+
+// Config lists the providers and prompt versions to evaluate. Every provider
+// runs every prompt version.
+type Config struct {
+	Providers []ProviderSpec `json:"providers"`
+	Prompts   []PromptSpec   `json:"prompts"`
+	// Glossary is available to prompt templates as {{.Glossary}}.
+	Glossary string `json:"glossary"`
+}
+
+// ProviderSpec describes a provider as stored in llm_providers. The API key
+// is read from APIKeyEnv so configs can be committed.
+type ProviderSpec struct {
+	Name            string  `json:"name"`
+	ProviderName    string  `json:"provider_name"`
+	ModelName       string  `json:"model_name"`
+	BaseURL         string  `json:"base_url"`
+	APIKeyEnv       string  `json:"api_key_env"`
+	Temperature     float64 `json:"temperature"`
+	MaxTokens       int     `json:"max_tokens"`
+	CostPer1KInput  float64 `json:"cost_per_1k_input"`
+	CostPer1KOutput float64 `json:"cost_per_1k_output"`
+}
+
+// PromptSpec is an importance_detection prompt template, read from File, or
+// the built-in prompt when File is empty.
+type PromptSpec struct {
+	Version string `json:"version"`
+	File    string `json:"file"`
+
+	template string
+}
+
+// defaultConfig evaluates the built-in prompt on the mock provider.
+func defaultConfig() Config {
+	return Config{
+		Providers: []ProviderSpec{{Name: "mock", ProviderName: "mock", ModelName: "mock"}},
+		Prompts:   []PromptSpec{{Version: builtinPrompt}},
+	}
+}
+
+func loadConfig(path string) (Config, error) {
+	if path == "" {
+		return defaultConfig(), nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	var config Config
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return Config{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if len(config.Providers) == 0 {
+		return Config{}, errors.New("config has no providers")
+	}
+	if len(config.Prompts) == 0 {
+		config.Prompts = []PromptSpec{{Version: builtinPrompt}}
+	}
+	for i := range config.Prompts {
+		prompt := &config.Prompts[i]
+		if prompt.File == "" {
+			if prompt.Version == "" {
+				prompt.Version = builtinPrompt
+			}
+			continue
+		}
+		if prompt.Version == "" {
+			prompt.Version = prompt.File
+		}
+		text, err := os.ReadFile(prompt.File)
+		if err != nil {
+			return Config{}, err
+		}
+		if _, err := llm.ParsePromptTemplate(llm.FeatureImportanceDetection, string(text)); err != nil {
+			return Config{}, fmt.Errorf("prompt %s: %w", prompt.Version, err)
+		}
+		prompt.template = string(text)
+	}
+	for i := range config.Providers {
+		if config.Providers[i].Name == "" {
+			config.Providers[i].Name = config.Providers[i].ProviderName
+		}
+	}
+	return config, nil
+}
+
+// loadDataset reads one Example per line. Blank lines are skipped.
+func loadDataset(r io.Reader) ([]Example, error) {
+	var examples []Example
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var example Example
+		if err := json.Unmarshal(scanner.Bytes(), &example); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if strings.TrimSpace(example.Text) == "" {
+			return nil, fmt.Errorf("line %d: text is required", line)
+		}
+		if example.ID == "" {
+			example.ID = fmt.Sprintf("%d", line)
+		}
+		examples = append(examples, example)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(examples) == 0 {
+		return nil, errors.New("dataset is empty")
+	}
+	return examples, nil
+}
+
+// Report is the outcome of one evaluation.
+type Report struct {
+	Dataset     string      `json:"dataset"`
+	Examples    int         `json:"examples"`
+	GeneratedAt time.Time   `json:"generated_at"`
+	Runs        []RunReport `json:"runs"`
+}
+
+// RunReport measures one provider with one prompt version. Rates are
+// fractions between 0 and 1; examples whose call failed are left out of the
+// quality metrics and counted in Errors.
+type RunReport struct {
+	Provider      string           `json:"provider"`
+	Model         string           `json:"model"`
+	PromptVersion string           `json:"prompt_version"`
+	Examples      int              `json:"examples"`
+	Errors        int              `json:"errors"`
+	ErrorRate     float64          `json:"error_rate"`
+	Importance    BinaryMetrics    `json:"importance"`
+	Priority      ConfusionMatrix  `json:"priority"`
+	Sentiment     AccuracyMetrics  `json:"sentiment"`
+	Cost          CostMetrics      `json:"cost"`
+	Latency       LatencyMetrics   `json:"latency"`
+	Failures      []ExampleFailure `json:"failures,omitempty"`
+}
+
+// BinaryMetrics scores is_important, with important as the positive class.
+type BinaryMetrics struct {
+	Labelled       int     `json:"labelled"`
+	TruePositives  int     `json:"true_positives"`
+	FalsePositives int     `json:"false_positives"`
+	FalseNegatives int     `json:"false_negatives"`
+	TrueNegatives  int     `json:"true_negatives"`
+	Precision      float64 `json:"precision"`
+	Recall         float64 `json:"recall"`
+	F1             float64 `json:"f1"`
+}
+
+// ConfusionMatrix counts Matrix[expected][predicted] over Labels.
+type ConfusionMatrix struct {
+	Labelled int      `json:"labelled"`
+	Labels   []string `json:"labels"`
+	Matrix   [][]int  `json:"matrix"`
+	Accuracy float64  `json:"accuracy"`
+}
+
+type AccuracyMetrics struct {
+	Labelled int     `json:"labelled"`
+	Correct  int     `json:"correct"`
+	Accuracy float64 `json:"accuracy"`
+}
+
+type CostMetrics struct {
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	Total        float64 `json:"total"`
+	PerMessage   float64 `json:"per_message"`
+}
+
+// LatencyMetrics are nearest-rank percentiles in milliseconds over every
+// call, failed ones included.
+type LatencyMetrics struct {
+	P50 float64 `json:"p50_ms"`
+	P90 float64 `json:"p90_ms"`
+	P95 float64 `json:"p95_ms"`
+	P99 float64 `json:"p99_ms"`
+	Max float64 `json:"max_ms"`
+}
+
+// ExampleFailure is an example whose call failed.
+type ExampleFailure struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+// outcome is one example's call.
+type outcome struct {
+	result  *llm.AnalysisResult
+	usage   llm.UsageRecord
+	latency time.Duration
+	err     error
+}
+
+// newFactory returns a factory with the mock provider enabled. Like prompt
+// files, fixtures are taken from the working directory, so those committed
+// next to a dataset work when the command runs from the repository.
+func newFactory() *llm.Factory {
+	factory := llm.NewFactory(nil)
+	factory.MockProviders = true
+	factory.MockFixturesDir = "."
+	return factory
+}
+
+// Evaluator runs a dataset through providers built by Factory.
+type Evaluator struct {
+	Factory     *llm.Factory
+	Concurrency int
+	// Timeout bounds each call.
+	Timeout time.Duration
+}
+
+// Run evaluates every provider with every prompt version.
+func (e *Evaluator) Run(ctx context.Context, config Config, examples []Example) (*Report, error) {
+	report := &Report{Examples: len(examples), GeneratedAt: time.Now().UTC(), Runs: []RunReport{}}
+	for i, spec := range config.Providers {
+		providerConfig := &llm.ProviderConfig{
+			ID:              int64(i + 1),
+			ProviderName:    spec.ProviderName,
+			ModelName:       spec.ModelName,
+			BaseURL:         spec.BaseURL,
+			Temperature:     spec.Temperature,
+			MaxTokens:       spec.MaxTokens,
+			CostPer1KInput:  spec.CostPer1KInput,
+			CostPer1KOutput: spec.CostPer1KOutput,
+		}
+		if spec.APIKeyEnv != "" {
+			providerConfig.APIKey = os.Getenv(spec.APIKeyEnv)
+		}
+		provider := e.Factory.CreateProvider(providerConfig)
+		if provider == nil {
+			return nil, fmt.Errorf("provider %s: unknown provider_name %q", spec.Name, spec.ProviderName)
+		}
+		for _, prompt := range config.Prompts {
+			outcomes := e.runPrompt(ctx, provider, prompt, config.Glossary, examples)
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			run := score(examples, outcomes, spec)
+			run.Provider, run.Model, run.PromptVersion = spec.Name, spec.ModelName, prompt.Version
+			report.Runs = append(report.Runs, run)
+		}
+	}
+	return report, nil
+}
+
+func (e *Evaluator) runPrompt(ctx context.Context, provider llm.Provider, prompt PromptSpec, glossary string, examples []Example) []outcome {
+	outcomes := make([]outcome, len(examples))
+	workers := e.Concurrency
+	if workers <= 0 {
+		workers = 1
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				outcomes[i] = e.analyze(ctx, provider, prompt, glossary, examples[i])
+			}
+		}()
+	}
+	for i := range examples {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return outcomes
+}
+
+func (e *Evaluator) analyze(ctx context.Context, provider llm.Provider, prompt PromptSpec, glossary string, example Example) outcome {
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+	var options llm.CallOptions
+	if prompt.template != "" {
+		rendered, err := llm.RenderPrompt(llm.FeatureImportanceDetection, prompt.template, llm.PromptData{
+			Message:     example.Text,
+			ContactName: example.ContactName,
+			Glossary:    glossary,
+		})
+		if err != nil {
+			return outcome{err: err}
+		}
+		options.Prompt = rendered
+	}
+	start := time.Now()
+	result, usage, err := provider.Analyze(ctx, example.Text, options)
+	latency := usage.Latency
+	if latency == 0 {
+		latency = time.Since(start)
+	}
+	if err == nil && result == nil {
+		err = errors.New("provider returned no result")
+	}
+	return outcome{result: result, usage: usage, latency: latency, err: err}
+}
+
+// score compares outcomes with the labels.
+func score(examples []Example, outcomes []outcome, spec ProviderSpec) RunReport {
+	run := RunReport{Examples: len(examples)}
+	priorities := map[string]map[string]int{}
+	labels := map[string]bool{}
+	var latencies []time.Duration
+	for i, example := range examples {
+		out := outcomes[i]
+		if out.latency > 0 {
+			latencies = append(latencies, out.latency)
+		}
+		run.Cost.InputTokens += out.usage.InputTokens
+		run.Cost.OutputTokens += out.usage.OutputTokens
+		run.Cost.Total += out.usage.TotalCost(spec.CostPer1KInput, spec.CostPer1KOutput)
+		if out.err != nil {
+			run.Errors++
+			run.Failures = append(run.Failures, ExampleFailure{ID: example.ID, Error: out.err.Error()})
+			continue
+		}
+		if example.IsImportant != nil {
+			run.Importance.Labelled++
+			switch expected, predicted := *example.IsImportant, out.result.IsImportant; {
+			case expected && predicted:
+				run.Importance.TruePositives++
+			case !expected && predicted:
+				run.Importance.FalsePositives++
+			case expected && !predicted:
+				run.Importance.FalseNegatives++
+			default:
+				run.Importance.TrueNegatives++
+			}
+		}
+		if example.Priority != nil {
+			expected, predicted := normalizeLabel(*example.Priority), normalizeLabel(out.result.Priority)
+			if priorities[expected] == nil {
+				priorities[expected] = map[string]int{}
+			}
+			priorities[expected][predicted]++
+			labels[expected], labels[predicted] = true, true
+			run.Priority.Labelled++
+		}
+		if example.Sentiment != nil {
+			run.Sentiment.Labelled++
+			if normalizeLabel(*example.Sentiment) == normalizeLabel(out.result.Sentiment) {
+				run.Sentiment.Correct++
+			}
+		}
+	}
+	run.ErrorRate = ratio(run.Errors, run.Examples)
+
+	importance := &run.Importance
+	importance.Precision = ratio(importance.TruePositives, importance.TruePositives+importance.FalsePositives)
+	importance.Recall = ratio(importance.TruePositives, importance.TruePositives+importance.FalseNegatives)
+	if importance.Precision+importance.Recall > 0 {
+		importance.F1 = 2 * importance.Precision * importance.Recall / (importance.Precision + importance.Recall)
+	}
+
+	run.Priority.Labels = orderLabels(labels)
+	run.Priority.Matrix = make([][]int, len(run.Priority.Labels))
+	correct := 0
+	for i, expected := range run.Priority.Labels {
+		run.Priority.Matrix[i] = make([]int, len(run.Priority.Labels))
+		for j, predicted := range run.Priority.Labels {
+			run.Priority.Matrix[i][j] = priorities[expected][predicted]
+			if i == j {
+				correct += priorities[expected][predicted]
+			}
+		}
+	}
+	run.Priority.Accuracy = ratio(correct, run.Priority.Labelled)
+	run.Sentiment.Accuracy = ratio(run.Sentiment.Correct, run.Sentiment.Labelled)
+	run.Cost.PerMessage = run.Cost.Total / float64(max(run.Examples, 1))
+	run.Latency = latencyPercentiles(latencies)
+	return run
+}
+
+func normalizeLabel(label string) string {
+	label = strings.ToLower(strings.TrimSpace(label))
+	if label == "" {
+		return "none"
+	}
+	return label
+}
+
+func orderLabels(labels map[string]bool) []string {
+	ordered := []string{}
+	for _, label := range priorityLabels {
+		if labels[label] {
+			ordered = append(ordered, label)
+		}
+	}
+	var others []string
+	for label := range labels {
+		known := false
+		for _, candidate := range priorityLabels {
+			known = known || candidate == label
+		}
+		if !known {
+			others = append(others, label)
+		}
+	}
+	sort.Strings(others)
+	return append(ordered, others...)
+}
+
+func latencyPercentiles(latencies []time.Duration) LatencyMetrics {
+	if len(latencies) == 0 {
+		return LatencyMetrics{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) float64 {
+		rank := int(math.Ceil(p / 100 * float64(len(latencies))))
+		if rank < 1 {
+			rank = 1
+		}
+		return float64(latencies[rank-1].Microseconds()) / 1000
+	}
+	return LatencyMetrics{P50: percentile(50), P90: percentile(90), P95: percentile(95), P99: percentile(99), Max: percentile(100)}
+}
+
+func ratio(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) / float64(whole)
+}
+
+// Thresholds fail an evaluation when any run falls below them. Zero values
+// are not checked, except MaxErrorRate, which is checked when below one.
+type Thresholds struct {
+	MinPrecision         float64
+	MinRecall            float64
+	MinPriorityAccuracy  float64
+	MinSentimentAccuracy float64
+	MaxErrorRate         float64
+}
+
+// Check lists the runs that miss a threshold.
+func (t Thresholds) Check(report *Report) []string {
+	var failures []string
+	for _, run := range report.Runs {
+		name := run.Provider + "/" + run.PromptVersion
+		check := func(metric string, value, limit float64, below bool) {
+			if (below && value < limit) || (!below && value > limit) {
+				failures = append(failures, fmt.Sprintf("%s: %s %.3f, limit %.3f", name, metric, value, limit))
+			}
+		}
+		check("is_important precision", run.Importance.Precision, t.MinPrecision, true)
+		check("is_important recall", run.Importance.Recall, t.MinRecall, true)
+		check("priority accuracy", run.Priority.Accuracy, t.MinPriorityAccuracy, true)
+		check("sentiment accuracy", run.Sentiment.Accuracy, t.MinSentimentAccuracy, true)
+		if t.MaxErrorRate < 1 {
+			check("error rate", run.ErrorRate, t.MaxErrorRate, false)
+		}
+	}
+	return failures
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const testDataset = `{"id":"a","text":"Server is down, customers cannot pay","is_important":true,"priority":"high","sentiment":"negative"}
+{"id":"b","text":"Invoice question for next month","is_important":true,"priority":"medium","sentiment":"neutral"}
+
+{"id":"c","text":"Thanks, have a nice weekend","is_important":false,"priority":"low","sentiment":"positive"}
+{"id":"d","text":"Lunch menu for Friday","is_important":false,"priority":"low"}
+{"id":"e","text":"broken fixture"}
+`
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEvaluateMockProvider(t *testing.T) {
+	fixtures := writeFile(t, "fixtures.json", `{"rules": [
+		{"contains": ["server"], "analysis": {"is_important": true, "priority": "high", "sentiment": "negative"}},
+		{"contains": ["invoice"], "analysis": {"is_important": false, "priority": "low", "sentiment": "neutral"}},
+		{"contains": ["thanks"], "analysis": {"is_important": true, "priority": "medium", "sentiment": "positive"}},
+		{"contains": ["lunch"], "analysis": {"is_important": false, "priority": "low", "sentiment": "positive"}},
+		{"contains": ["broken"], "error": "boom"}
+	]}`)
+	// Fixtures are named relative to the directory the command runs in.
+	t.Chdir(filepath.Dir(fixtures))
+	examples, err := loadDataset(strings.NewReader(testDataset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config{
+		Providers: []ProviderSpec{{Name: "mock", ProviderName: "mock", ModelName: "mock", BaseURL: "mock://?fixtures=fixtures.json&input_tokens=1000&output_tokens=500", CostPer1KInput: 0.01, CostPer1KOutput: 0.02}},
+		Prompts:   []PromptSpec{{Version: builtinPrompt}},
+	}
+	evaluator := &Evaluator{Factory: newFactory(), Concurrency: 3}
+	report, err := evaluator.Run(context.Background(), config, examples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Runs) != 1 {
+		t.Fatalf("expected one run, got %d", len(report.Runs))
+	}
+	run := report.Runs[0]
+	if run.Examples != 5 || run.Errors != 1 || run.Failures[0].ID != "e" {
+		t.Fatalf("unexpected errors: %+v", run)
+	}
+	importance := run.Importance
+	if importance.TruePositives != 1 || importance.FalsePositives != 1 || importance.FalseNegatives != 1 || importance.TrueNegatives != 1 {
+		t.Fatalf("unexpected importance counts: %+v", importance)
+	}
+	if importance.Precision != 0.5 || importance.Recall != 0.5 {
+		t.Fatalf("unexpected precision/recall: %+v", importance)
+	}
+	want := [][]int{{1, 0, 0}, {0, 0, 1}, {0, 1, 1}}
+	if strings.Join(run.Priority.Labels, ",") != "high,medium,low" || fmt.Sprint(run.Priority.Matrix) != fmt.Sprint(want) {
+		t.Fatalf("unexpected confusion matrix %v %v", run.Priority.Labels, run.Priority.Matrix)
+	}
+	if run.Priority.Accuracy != 0.5 {
+		t.Fatalf("priority accuracy = %v", run.Priority.Accuracy)
+	}
+	// d has no sentiment label.
+	if run.Sentiment.Labelled != 3 || run.Sentiment.Correct != 3 {
+		t.Fatalf("unexpected sentiment: %+v", run.Sentiment)
+	}
+	// The failed call's input tokens are paid for too.
+	if run.Cost.InputTokens != 5000 || run.Cost.OutputTokens != 2000 || run.Cost.Total < 0.089 || run.Cost.Total > 0.091 {
+		t.Fatalf("unexpected cost: %+v", run.Cost)
+	}
+
+	failures := Thresholds{MinRecall: 0.8, MaxErrorRate: 0.1}.Check(report)
+	if len(failures) != 2 {
+		t.Fatalf("expected recall and error rate failures, got %v", failures)
+	}
+
+	var markdown, encoded bytes.Buffer
+	if err := writeMarkdownReport(&markdown, report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(markdown.String(), "| mock | mock | builtin | 1 | 50.0% | 50.0% |") {
+		t.Fatalf("unexpected markdown:\n%s", markdown.String())
+	}
+	if err := writeJSONReport(&encoded, report); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(encoded.Bytes(), &decoded); err != nil || decoded.Runs[0].Importance.Recall != 0.5 {
+		t.Fatalf("JSON report does not round-trip: %v", err)
+	}
+}
+
+func TestEvaluatePromptVersionsOverHTTP(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var body struct {
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		prompt := body.Messages[len(body.Messages)-1].Content
+		// The strict prompt calls everything important.
+		content := `{\"is_important\":false,\"priority\":\"low\",\"sentiment\":\"neutral\"}`
+		if strings.Contains(prompt, "STRICT") {
+			content = `{\"is_important\":true,\"priority\":\"high\",\"sentiment\":\"negative\"}`
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"x","object":"chat.completion","model":"gpt-test","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"%s"}}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`, content)
+	}))
+	defer server.Close()
+
+	prompt := writeFile(t, "strict.tmpl", "STRICT: flag anything that might matter. JSON with is_important, priority, sentiment.\n{{.Message}}")
+	config := writeFile(t, "eval.json", fmt.Sprintf(`{
+		"providers": [{"name": "openai-test", "provider_name": "openai", "model_name": "gpt-test", "base_url": %q, "max_tokens": 100}],
+		"prompts": [{"version": "builtin"}, {"version": "strict", "file": %q}]
+	}`, server.URL, prompt))
+	loaded, err := loadConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	examples, err := loadDataset(strings.NewReader(`{"text":"Server is down","is_important":true,"priority":"high"}` + "\n" + `{"text":"Thanks","is_important":false,"priority":"low"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := (&Evaluator{Factory: newFactory(), Concurrency: 2}).Run(context.Background(), loaded, examples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 4 || len(report.Runs) != 2 {
+		t.Fatalf("expected 2 runs of 2 calls, got %d calls and %d runs", calls.Load(), len(report.Runs))
+	}
+	builtin, strict := report.Runs[0], report.Runs[1]
+	if builtin.PromptVersion != "builtin" || builtin.Importance.Recall != 0 || builtin.Priority.Accuracy != 0.5 {
+		t.Fatalf("unexpected builtin run: %+v", builtin)
+	}
+	if strict.PromptVersion != "strict" || strict.Importance.Recall != 1 || strict.Importance.Precision != 0.5 {
+		t.Fatalf("unexpected strict run: %+v", strict)
+	}
+	if strict.Latency.Max <= 0 || strict.Cost.InputTokens != 20 {
+		t.Fatalf("latency and tokens not recorded: %+v %+v", strict.Latency, strict.Cost)
+	}
+}
+
+func TestLoadConfigRejectsTemplateWithoutMessage(t *testing.T) {
+	prompt := writeFile(t, "bad.tmpl", "Classify the message.")
+	config := writeFile(t, "eval.json", fmt.Sprintf(`{"providers": [{"provider_name": "mock"}], "prompts": [{"file": %q}]}`, prompt))
+	if _, err := loadConfig(config); err == nil {
+		t.Fatal("expected a template without {{.Message}} to be rejected")
+	}
+}
@@ -0,0 +1,97 @@
+// Command llmeval measures how well providers and prompt versions analyze a
+// labelled dataset, so prompt and provider changes can be gated in CI:
+//
+//	llmeval -dataset testdata/messages.jsonl -config eval.json -json report.json -markdown report.md -min-recall 0.8
+//
+// Without -config the built-in prompt runs on the mock provider, which needs
+// no network access. It exits with status 1 when a run misses a threshold.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	datasetPath := flag.String("dataset", "", "labelled JSONL dataset")
+	configPath := flag.String("config", "", "JSON file listing providers and prompt versions; defaults to the mock provider")
+	jsonPath := flag.String("json", "", "write the JSON report to this file, - for stdout")
+	markdownPath := flag.String("markdown", "", "write the Markdown report to this file, - for stdout (the default)")
+	concurrency := flag.Int("concurrency", 4, "calls in flight per run")
+	timeout := flag.Duration("timeout", time.Minute, "timeout per call")
+	var thresholds Thresholds
+	flag.Float64Var(&thresholds.MinPrecision, "min-precision", 0, "fail when is_important precision is below this")
+	flag.Float64Var(&thresholds.MinRecall, "min-recall", 0, "fail when is_important recall is below this")
+	flag.Float64Var(&thresholds.MinPriorityAccuracy, "min-priority-accuracy", 0, "fail when priority accuracy is below this")
+	flag.Float64Var(&thresholds.MinSentimentAccuracy, "min-sentiment-accuracy", 0, "fail when sentiment accuracy is below this")
+	flag.Float64Var(&thresholds.MaxErrorRate, "max-error-rate", 1, "fail when the share of failed calls is above this")
+	flag.Parse()
+
+	if *datasetPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *jsonPath == "" && *markdownPath == "" {
+		*markdownPath = "-"
+	}
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	file, err := os.Open(*datasetPath)
+	if err != nil {
+		log.Fatalf("failed to open dataset: %v", err)
+	}
+	examples, err := loadDataset(file)
+	file.Close()
+	if err != nil {
+		log.Fatalf("failed to load dataset: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	evaluator := &Evaluator{Factory: newFactory(), Concurrency: *concurrency, Timeout: *timeout}
+	report, err := evaluator.Run(ctx, config, examples)
+	if err != nil {
+		log.Fatalf("evaluation failed: %v", err)
+	}
+	report.Dataset = *datasetPath
+
+	if err := writeReport(*jsonPath, report, writeJSONReport); err != nil {
+		log.Fatalf("failed to write JSON report: %v", err)
+	}
+	if err := writeReport(*markdownPath, report, writeMarkdownReport); err != nil {
+		log.Fatalf("failed to write Markdown report: %v", err)
+	}
+	if failures := thresholds.Check(report); len(failures) > 0 {
+		for _, failure := range failures {
+			fmt.Fprintln(os.Stderr, "below threshold:", failure)
+		}
+		os.Exit(1)
+	}
+}
+
+func writeReport(path string, report *Report, write func(io.Writer, *Report) error) error {
+	switch path {
+	case "":
+		return nil
+	case "-":
+		return write(os.Stdout, report)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file, report); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
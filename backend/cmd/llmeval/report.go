@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+func writeJSONReport(w io.Writer, report *Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// writeMarkdownReport renders a summary table of all runs followed by each
+// run's priority confusion matrix.
+func writeMarkdownReport(w io.Writer, report *Report) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# LLM evaluation\n\n")
+	fmt.Fprintf(&b, "Dataset `%s`, %d examples, generated %s.\n\n", report.Dataset, report.Examples, report.GeneratedAt.Format("2006-01-02 15:04 MST"))
+	b.WriteString("| Provider | Model | Prompt | Errors | Precision | Recall | F1 | Priority acc. | Sentiment acc. | Cost | p50 ms | p95 ms | p99 ms |\n")
+	b.WriteString("|---|---|---|---:|---:|---:|---:|---:|---:|---:|---:|---:|---:|\n")
+	for _, run := range report.Runs {
+		fmt.Fprintf(&b, "| %s | %s | %s | %d | %s | %s | %s | %s | %s | $%.4f | %.1f | %.1f | %.1f |\n",
+			run.Provider, run.Model, run.PromptVersion, run.Errors,
+			percent(run.Importance.Precision), percent(run.Importance.Recall), percent(run.Importance.F1),
+			percent(run.Priority.Accuracy), percent(run.Sentiment.Accuracy),
+			run.Cost.Total, run.Latency.P50, run.Latency.P95, run.Latency.P99)
+	}
+	for _, run := range report.Runs {
+		fmt.Fprintf(&b, "\n## %s / %s\n\n", run.Provider, run.PromptVersion)
+		importance := run.Importance
+		fmt.Fprintf(&b, "is_important: %d labelled, %d true positives, %d false positives, %d false negatives, %d true negatives.\n\n",
+			importance.Labelled, importance.TruePositives, importance.FalsePositives, importance.FalseNegatives, importance.TrueNegatives)
+		if len(run.Priority.Labels) > 0 {
+			b.WriteString("Priority (rows expected, columns predicted):\n\n")
+			b.WriteString("| expected \\ predicted | " + strings.Join(run.Priority.Labels, " | ") + " |\n")
+			b.WriteString("|---|" + strings.Repeat("---:|", len(run.Priority.Labels)) + "\n")
+			for i, label := range run.Priority.Labels {
+				cells := make([]string, len(run.Priority.Matrix[i]))
+				for j, count := range run.Priority.Matrix[i] {
+					cells[j] = fmt.Sprint(count)
+				}
+				b.WriteString("| " + label + " | " + strings.Join(cells, " | ") + " |\n")
+			}
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "Tokens: %d in, %d out, $%.6f per message.\n", run.Cost.InputTokens, run.Cost.OutputTokens, run.Cost.PerMessage)
+		if len(run.Failures) > 0 {
+			b.WriteString("\nFailed examples:\n\n")
+			for _, failure := range run.Failures {
+				fmt.Fprintf(&b, "- `%s`: %s\n", failure.ID, failure.Error)
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func percent(value float64) string {
+	return fmt.Sprintf("%.1f%%", value*100)
+}
@@ -37,25 +37,57 @@ func main() {
 		log.Fatalf("failed to init auth: %v", err)
 	}
 	hub := realtime.NewHub()
-	var llmQueue *llm.Queue
+	var llmQueue llm.Queue = llm.NewPostgresQueue(store)
 	if cfg.RedisURL != "" {
-		queue, err := llm.NewQueue(cfg.RedisURL)
+		queue, err := llm.NewRedisQueue(cfg.RedisURL)
 		if err != nil {
-			log.Printf("failed to init redis queue: %v", err)
+			log.Printf("failed to init redis queue, using postgres: %v", err)
 		} else {
 			llmQueue = queue
 		}
 	}
+	var rateLimiter llm.RateLimiter
+	if cfg.RedisURL != "" {
+		limiter, err := llm.NewRedisRateLimiter(cfg.RedisURL)
+		if err != nil {
+			log.Printf("failed to init redis rate limiter: %v", err)
+		} else {
+			rateLimiter = limiter
+		}
+	}
 	llmStore := llm.NewStore(store, cfg.MasterKey)
-	llmFactory := llm.NewFactory()
+	llmFactory := llm.NewFactory(rateLimiter)
+	llmFactory.MockProviders = cfg.LLMMockProvider
+	llmFactory.MockFixturesDir = cfg.LLMMockFixturesDir
 	llmRouter := llm.NewRouter(llmFactory, llmStore)
+	llmRouter.Breakers = llm.NewCircuitBreakers(hub)
 	llmService := llm.NewService(llmRouter, llmStore)
+	llmService.Budget = llm.NewBudgetGuard(llmStore, hub)
+	if cfg.LLMCacheTTLMinutes > 0 {
+		llmService.CacheTTL = time.Duration(cfg.LLMCacheTTLMinutes) * time.Minute
+		llmService.Cache = llm.NewLRUCache(llm.DefaultCacheEntries)
+		if cfg.RedisURL != "" {
+			cache, err := llm.NewRedisCache(cfg.RedisURL)
+			if err != nil {
+				log.Printf("failed to init redis cache, using in-process cache: %v", err)
+			} else {
+				llmService.Cache = cache
+			}
+		}
+	}
 	healthMonitor := &llm.HealthMonitor{Router: llmRouter, Store: llmStore}
 	healthScheduler := llm.NewHealthScheduler(healthMonitor, llmStore)
-	var workerScheduler *llm.WorkerScheduler
-	if llmQueue != nil {
-		workerScheduler = llm.NewWorkerScheduler(llmQueue, llmService, store, hub)
-	}
+	workerScheduler := llm.NewWorkerScheduler(llmQueue, llmService, store, hub)
+	workerScheduler.Concurrency = cfg.LLMWorkers
+	workerScheduler.MicroBatchSize = cfg.LLMMicroBatch
+	// Jobs left from before a restart are drained without waiting for the
+	// tenant's WhatsApp session.
+	go func() {
+		if err := workerScheduler.Resume(context.Background()); err != nil {
+			log.Printf("failed to resume analysis workers: %v", err)
+		}
+	}()
+	digestScheduler := llm.NewDigestScheduler(llmService)
 
 	var waManager *whatsapp.Manager
 	if cfg.DatabaseURL != "" {
@@ -68,6 +100,8 @@ func main() {
 	}
 	if waManager != nil {
 		waSyncer := whatsapp.NewSyncer(store, llmQueue, hub)
+		waSyncer.Workers = workerScheduler
+		waSyncer.Digests = digestScheduler
 		waManager.SetSyncer(waSyncer)
 		// Auto-reconnect existing WhatsApp sessions on startup
 		go func() {
@@ -78,7 +112,7 @@ func main() {
 		}()
 	}
 
-	api := handlers.NewAPI(store, authService, hub, llmService, llmStore, llmQueue, healthScheduler, workerScheduler, waManager)
+	api := handlers.NewAPI(store, authService, hub, llmService, llmStore, llmQueue, healthScheduler, workerScheduler, digestScheduler, waManager)
 	limiter := middleware.NewRateLimiter(60, time.Minute)
 	rt := router.New(api, authService, limiter, cfg.FrontendOrigin, hub)
 
@@ -1,6 +1,9 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+)
 
 type Config struct {
 	DatabaseURL    string
@@ -9,16 +12,33 @@ type Config struct {
 	FrontendOrigin string
 	RedisURL       string
 	MasterKey      string
+	// LLMWorkers is the size of the shared analysis worker pool and
+	// LLMMicroBatch the number of short messages sent per prompt (0 or 1
+	// disables micro-batching).
+	LLMWorkers    int
+	LLMMicroBatch int
+	// LLMCacheTTLMinutes is how long analysis results are reused for
+	// identical messages; 0 disables the cache.
+	LLMCacheTTLMinutes int
+	// LLMMockProvider lets tenants create mock providers, which read their
+	// fixtures from LLMMockFixturesDir only.
+	LLMMockProvider    bool
+	LLMMockFixturesDir string
 }
 
 func Load() Config {
 	cfg := Config{
-		DatabaseURL:    os.Getenv("DATABASE_URL"),
-		Port:           os.Getenv("PORT"),
-		JWTSecret:      os.Getenv("JWT_SECRET"),
-		FrontendOrigin: os.Getenv("FRONTEND_ORIGIN"),
-		RedisURL:       os.Getenv("REDIS_URL"),
-		MasterKey:      os.Getenv("MASTER_KEY"),
+		DatabaseURL:        os.Getenv("DATABASE_URL"),
+		Port:               os.Getenv("PORT"),
+		JWTSecret:          os.Getenv("JWT_SECRET"),
+		FrontendOrigin:     os.Getenv("FRONTEND_ORIGIN"),
+		RedisURL:           os.Getenv("REDIS_URL"),
+		MasterKey:          os.Getenv("MASTER_KEY"),
+		LLMWorkers:         envInt("LLM_WORKERS", 4),
+		LLMMicroBatch:      envInt("LLM_MICRO_BATCH", 0),
+		LLMCacheTTLMinutes: envInt("LLM_CACHE_TTL_MINUTES", 1440),
+		LLMMockProvider:    envBool("LLM_MOCK_PROVIDER", false),
+		LLMMockFixturesDir: os.Getenv("LLM_MOCK_FIXTURES_DIR"),
 	}
 	if cfg.Port == "" {
 		cfg.Port = "8080"
@@ -28,3 +48,19 @@ func Load() Config {
 	}
 	return cfg
 }
+
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func envBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
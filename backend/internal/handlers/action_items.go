@@ -3,9 +3,11 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"message-flow/backend/internal/auth"
@@ -91,6 +93,7 @@ func (a *API) CreateActionItem(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusInternalServerError, "failed to create action item")
 		return
 	}
+	a.refreshScore(ctx, tenantID, item.ConversationID)
 
 	if user, ok := auth.UserFromContext(r.Context()); ok {
 		a.logActivity(ctx, tenantID, user, "action_item.create", map[string]any{
@@ -170,6 +173,7 @@ func (a *API) UpdateActionItem(w http.ResponseWriter, r *http.Request, actionIte
 		writeError(w, http.StatusNotFound, "action item not found")
 		return
 	}
+	a.refreshScore(ctx, tenantID, item.ConversationID)
 
 	if user, ok := auth.UserFromContext(r.Context()); ok {
 		a.logActivity(ctx, tenantID, user, "action_item.update", map[string]any{
@@ -198,23 +202,24 @@ func (a *API) DeleteActionItem(w http.ResponseWriter, r *http.Request, actionIte
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	var rowsAffected int64
+	var conversationID int64
 	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
-		command, err := conn.Exec(ctx, `
-			DELETE FROM action_items WHERE id=$1 AND tenant_id=$2`, actionItemID, tenantID)
-		if err != nil {
-			return err
+		err := conn.QueryRow(ctx, `
+			DELETE FROM action_items WHERE id=$1 AND tenant_id=$2
+			RETURNING conversation_id`, actionItemID, tenantID).Scan(&conversationID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return errNotFound
 		}
-		rowsAffected = command.RowsAffected()
-		return nil
+		return err
 	}); err != nil {
+		if errors.Is(err, errNotFound) {
+			writeError(w, http.StatusNotFound, "action item not found")
+			return
+		}
 		writeError(w, http.StatusInternalServerError, "failed to delete action item")
 		return
 	}
-	if rowsAffected == 0 {
-		writeError(w, http.StatusNotFound, "action item not found")
-		return
-	}
+	a.refreshScore(ctx, tenantID, conversationID)
 
 	if user, ok := auth.UserFromContext(r.Context()); ok {
 		a.logActivity(ctx, tenantID, user, "action_item.delete", map[string]any{
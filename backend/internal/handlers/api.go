@@ -19,14 +19,15 @@ type API struct {
 	Hub             *realtime.Hub
 	LLM             *llm.Service
 	LLMStore        *llm.Store
-	Queue           *llm.Queue
+	Queue           llm.Queue
 	HealthScheduler *llm.HealthScheduler
 	WorkerScheduler *llm.WorkerScheduler
+	DigestScheduler *llm.DigestScheduler
 	WhatsApp        *whatsapp.Manager
 }
 
-func NewAPI(store *db.Store, authService *auth.Service, hub *realtime.Hub, llmService *llm.Service, llmStore *llm.Store, queue *llm.Queue, scheduler *llm.HealthScheduler, workerScheduler *llm.WorkerScheduler, waManager *whatsapp.Manager) *API {
-	return &API{Store: store, Auth: authService, Hub: hub, LLM: llmService, LLMStore: llmStore, Queue: queue, HealthScheduler: scheduler, WorkerScheduler: workerScheduler, WhatsApp: waManager}
+func NewAPI(store *db.Store, authService *auth.Service, hub *realtime.Hub, llmService *llm.Service, llmStore *llm.Store, queue llm.Queue, scheduler *llm.HealthScheduler, workerScheduler *llm.WorkerScheduler, digestScheduler *llm.DigestScheduler, waManager *whatsapp.Manager) *API {
+	return &API{Store: store, Auth: authService, Hub: hub, LLM: llmService, LLMStore: llmStore, Queue: queue, HealthScheduler: scheduler, WorkerScheduler: workerScheduler, DigestScheduler: digestScheduler, WhatsApp: waManager}
 }
 
 func (a *API) tenantID(r *http.Request) int64 {
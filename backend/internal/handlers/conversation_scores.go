@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"message-flow/backend/internal/llm"
+)
+
+type scoreRequest struct {
+	Explain    bool  `json:"explain"`
+	ProviderID int64 `json:"provider_id"`
+}
+
+// GetConversationScore returns a conversation's attention score with the
+// factors behind it and, if one was asked for, the explanation of it.
+func (a *API) GetConversationScore(w http.ResponseWriter, r *http.Request, conversationID int64) {
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	score, err := a.LLMStore.ConversationScore(ctx, tenantID, conversationID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "conversation not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to load score")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": score})
+}
+
+// ScoreConversation recomputes a conversation's score. With explain set a
+// provider is asked to explain the new score.
+func (a *API) ScoreConversation(w http.ResponseWriter, r *http.Request, conversationID int64) {
+	var req scoreRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	tenantID := a.tenantID(r)
+	timeout := 5 * time.Second
+	if req.Explain {
+		timeout = 2 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	var score *llm.ConversationScore
+	var err error
+	if req.Explain {
+		score, err = a.LLM.ExplainConversationScore(ctx, tenantID, conversationID, req.ProviderID)
+	} else {
+		score, err = a.LLMStore.RefreshConversationScore(ctx, tenantID, conversationID)
+	}
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		writeError(w, http.StatusNotFound, "conversation not found")
+		return
+	case errors.Is(err, llm.ErrEmptyConversation):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, llm.ErrNoProviders):
+		writeError(w, http.StatusServiceUnavailable, "no provider is configured")
+		return
+	case err != nil && req.Explain:
+		writeError(w, http.StatusBadGateway, "failed to explain score: "+err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to score conversation")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": score})
+}
+
+// refreshScore recomputes a conversation's score after a reply or an action
+// item changed it. It is best effort, as the score is refreshed again when
+// read once stale.
+func (a *API) refreshScore(ctx context.Context, tenantID, conversationID int64) {
+	if a.LLMStore != nil {
+		_, _ = a.LLMStore.RefreshConversationScore(ctx, tenantID, conversationID)
+	}
+}
@@ -3,29 +3,74 @@ package handlers
 import (
 	"context"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"message-flow/backend/internal/llm"
 	"message-flow/backend/internal/models"
 )
 
+// conversationOrders are the orders ListConversations can sort by.
+var conversationOrders = map[string]string{
+	"recent": "last_message_at DESC NULLS LAST, created_at DESC",
+	"score":  "score DESC NULLS LAST, last_message_at DESC NULLS LAST, created_at DESC",
+}
+
+// ListConversations lists conversations, most recent first or, with
+// sort=score, those needing attention first. min_score and max_score keep
+// conversations scored within the bounds.
 func (a *API) ListConversations(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	sort := query.Get("sort")
+	if sort == "" {
+		sort = "recent"
+	}
+	order, ok := conversationOrders[sort]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "sort must be recent or score")
+		return
+	}
+	var bounds [2]*float64
+	for i, name := range []string{"min_score", "max_score"} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > 100 {
+			writeError(w, http.StatusBadRequest, name+" must be between 0 and 100")
+			return
+		}
+		bounds[i] = &parsed
+	}
+
 	tenantID := a.tenantID(r)
 	page, limit := parsePagination(r)
 	offset := (page - 1) * limit
 
+	if a.LLMStore != nil && (sort == "score" || bounds[0] != nil || bounds[1] != nil) {
+		// Best effort and on a budget of its own, so a slow refresh cannot
+		// time out the listing: a stale score still sorts, less accurately.
+		refreshCtx, cancelRefresh := context.WithTimeout(r.Context(), llm.StaleScoreTimeout)
+		_ = a.LLMStore.RefreshStaleScores(refreshCtx, tenantID)
+		cancelRefresh()
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
 	conversations := []models.Conversation{}
 	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
 		rows, err := conn.Query(ctx, `
-			SELECT id, tenant_id, contact_number, contact_name, last_message_at, created_at, profile_picture_url
+			SELECT id, tenant_id, contact_number, contact_name, last_message_at, created_at, profile_picture_url, score, scored_at
 			FROM conversations
 			WHERE tenant_id=$1
-			ORDER BY last_message_at DESC NULLS LAST, created_at DESC
-			LIMIT $2 OFFSET $3`, tenantID, limit, offset)
+			  AND ($4::DOUBLE PRECISION IS NULL OR score >= $4)
+			  AND ($5::DOUBLE PRECISION IS NULL OR score <= $5)
+			ORDER BY `+order+`
+			LIMIT $2 OFFSET $3`, tenantID, limit, offset, bounds[0], bounds[1])
 		if err != nil {
 			return err
 		}
@@ -33,7 +78,7 @@ func (a *API) ListConversations(w http.ResponseWriter, r *http.Request) {
 
 		for rows.Next() {
 			var convo models.Conversation
-			if err := rows.Scan(&convo.ID, &convo.TenantID, &convo.ContactNumber, &convo.ContactName, &convo.LastMessageAt, &convo.CreatedAt, &convo.ProfilePictureURL); err != nil {
+			if err := rows.Scan(&convo.ID, &convo.TenantID, &convo.ContactNumber, &convo.ContactName, &convo.LastMessageAt, &convo.CreatedAt, &convo.ProfilePictureURL, &convo.Score, &convo.ScoredAt); err != nil {
 				return err
 			}
 			conversations = append(conversations, convo)
@@ -2,31 +2,66 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"message-flow/backend/internal/llm"
 	"message-flow/backend/internal/models"
 )
 
+const dailySummaryColumns = `id, tenant_id, conversation_id, summary_text, key_points_json, TO_CHAR(summary_date, 'YYYY-MM-DD'), created_at`
+
+func scanDailySummary(row pgx.Row, summary *models.DailySummary) error {
+	return row.Scan(&summary.ID, &summary.TenantID, &summary.ConversationID, &summary.SummaryText, &summary.KeyPointsJSON, &summary.SummaryDate, &summary.CreatedAt)
+}
+
+// GetDailySummary returns the latest conversation summary. With date it
+// returns that day's digest and conversation summaries instead, and with
+// conversation_id only what concerns that conversation; conversation_id
+// alone lists the conversation's summaries, newest first.
 func (a *API) GetDailySummary(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	date := query.Get("date")
+	if date != "" {
+		if _, err := time.Parse(llm.DigestDateLayout, date); err != nil {
+			writeError(w, http.StatusBadRequest, "date must be YYYY-MM-DD")
+			return
+		}
+	}
+	var conversationID *int64
+	if value := query.Get("conversation_id"); value != "" {
+		id, ok := ParseID(value)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "invalid conversation_id")
+			return
+		}
+		conversationID = &id
+	}
 	tenantID := a.tenantID(r)
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	var summary models.DailySummary
-	query := `
-		SELECT id, tenant_id, conversation_id, summary_text, key_points_json, created_at
-		FROM daily_summaries
-		WHERE tenant_id=$1
-		ORDER BY created_at DESC
-		LIMIT 1`
+	switch {
+	case date != "":
+		a.getDailyDigest(ctx, w, tenantID, date, conversationID)
+		return
+	case conversationID != nil:
+		a.listConversationSummaries(ctx, w, r, tenantID, *conversationID)
+		return
+	}
 
+	var summary models.DailySummary
 	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
-		return conn.QueryRow(ctx, query, tenantID).Scan(
-			&summary.ID, &summary.TenantID, &summary.ConversationID, &summary.SummaryText, &summary.KeyPointsJSON, &summary.CreatedAt,
-		)
+		return scanDailySummary(conn.QueryRow(ctx, `
+			SELECT `+dailySummaryColumns+`
+			FROM daily_summaries
+			WHERE tenant_id=$1
+			ORDER BY created_at DESC
+			LIMIT 1`, tenantID), &summary)
 	}); err != nil {
 		writeError(w, http.StatusNotFound, "no summary available")
 		return
@@ -34,3 +69,207 @@ func (a *API) GetDailySummary(w http.ResponseWriter, r *http.Request) {
 
 	writeJSON(w, http.StatusOK, summary)
 }
+
+func (a *API) getDailyDigest(ctx context.Context, w http.ResponseWriter, tenantID int64, date string, conversationID *int64) {
+	digest, err := a.LLMStore.DailyDigest(ctx, tenantID, date)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		writeError(w, http.StatusInternalServerError, "failed to load digest")
+		return
+	}
+	if digest != nil && digest.Digest != nil && conversationID != nil {
+		filtered := digest.Digest.ForConversation(*conversationID)
+		digest.Digest = &filtered
+	}
+
+	summaries := []models.DailySummary{}
+	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			SELECT `+dailySummaryColumns+`
+			FROM daily_summaries
+			WHERE tenant_id=$1 AND summary_date=$2
+			  AND ($3::BIGINT IS NULL OR conversation_id=$3)
+			ORDER BY conversation_id ASC`, tenantID, date, conversationID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var summary models.DailySummary
+			if err := scanDailySummary(rows, &summary); err != nil {
+				return err
+			}
+			summaries = append(summaries, summary)
+		}
+		return rows.Err()
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load summaries")
+		return
+	}
+	if digest == nil && len(summaries) == 0 {
+		writeError(w, http.StatusNotFound, "no summary for this date")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{
+		"date":      date,
+		"digest":    digest,
+		"summaries": summaries,
+	}})
+}
+
+func (a *API) listConversationSummaries(ctx context.Context, w http.ResponseWriter, r *http.Request, tenantID, conversationID int64) {
+	page, limit := parsePagination(r)
+	offset := (page - 1) * limit
+
+	summaries := []models.DailySummary{}
+	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			SELECT `+dailySummaryColumns+`
+			FROM daily_summaries
+			WHERE tenant_id=$1 AND conversation_id=$2
+			ORDER BY created_at DESC
+			LIMIT $3 OFFSET $4`, tenantID, conversationID, limit, offset)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var summary models.DailySummary
+			if err := scanDailySummary(rows, &summary); err != nil {
+				return err
+			}
+			summaries = append(summaries, summary)
+		}
+		return rows.Err()
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load summaries")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"data":  summaries,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+// ListDailyDigests lists the tenant's digests, newest first, without their
+// contents.
+func (a *API) ListDailyDigests(w http.ResponseWriter, r *http.Request) {
+	tenantID := a.tenantID(r)
+	page, limit := parsePagination(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	digests, err := a.LLMStore.ListDailyDigests(ctx, tenantID, limit, (page-1)*limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to list digests")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"data":  digests,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+func (a *API) GetDigestSettings(w http.ResponseWriter, r *http.Request) {
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	settings, err := a.LLMStore.DigestSettings(ctx, tenantID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load digest settings")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": settings})
+}
+
+func (a *API) UpdateDigestSettings(w http.ResponseWriter, r *http.Request) {
+	var req llm.DigestSettings
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if err := llm.ValidateDigestSettings(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	before, _ := a.LLMStore.DigestSettings(ctx, tenantID)
+	if err := a.LLMStore.SaveDigestSettings(ctx, tenantID, req); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update digest settings")
+		return
+	}
+	if a.DigestScheduler != nil {
+		if req.Enabled {
+			a.DigestScheduler.EnsureTenant(context.Background(), tenantID)
+		} else {
+			a.DigestScheduler.Stop(tenantID)
+		}
+	}
+	a.logAudit(ctx, r, tenantID, authUserIDPtr(r), "daily_digest.settings.update", stringPtr("daily_digest_settings"), nil, before, req)
+	writeJSON(w, http.StatusOK, map[string]any{"data": req})
+}
+
+type generateDigestRequest struct {
+	Date string `json:"date"`
+}
+
+// GenerateDailyDigest makes a digest now, in the background, replacing the
+// digest of that date if there was one. The date defaults to that of the
+// latest digest due.
+func (a *API) GenerateDailyDigest(w http.ResponseWriter, r *http.Request) {
+	var req generateDigestRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	settings, err := a.LLMStore.DigestSettings(ctx, tenantID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load digest settings")
+		return
+	}
+	now := time.Now()
+	if req.Date == "" {
+		req.Date = settings.LatestDate(now)
+	}
+	start, end, err := settings.Period(req.Date)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "date must be YYYY-MM-DD")
+		return
+	}
+	if end.After(now) {
+		writeError(w, http.StatusBadRequest, "the digest of this date is not due yet")
+		return
+	}
+	claimed, err := a.LLMStore.ClaimDigest(ctx, tenantID, req.Date, start, end, true)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to start digest")
+		return
+	}
+	if !claimed {
+		writeError(w, http.StatusConflict, llm.ErrDigestRunning.Error())
+		return
+	}
+
+	go func(date string) {
+		runCtx, cancel := context.WithTimeout(context.Background(), llm.DefaultDigestTimeout)
+		defer cancel()
+		_, _ = a.LLM.GenerateDailyDigest(runCtx, tenantID, date, start, end)
+	}(req.Date)
+
+	a.logAudit(ctx, r, tenantID, authUserIDPtr(r), "daily_digest.generate", stringPtr("daily_digests"), nil, nil, req)
+	writeJSON(w, http.StatusAccepted, map[string]any{"data": map[string]any{
+		"date":         req.Date,
+		"status":       llm.DigestRunning,
+		"period_start": start,
+		"period_end":   end,
+	}})
+}
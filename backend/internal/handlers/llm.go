@@ -4,11 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"message-flow/backend/internal/auth"
@@ -38,12 +38,16 @@ type providerRequest struct {
 	IsActive             *bool    `json:"is_active"`
 	IsDefault            *bool    `json:"is_default"`
 	IsFallback           *bool    `json:"is_fallback"`
+	// SafetySettings maps harm categories to thresholds (Gemini only).
+	SafetySettings map[string]string `json:"safety_settings"`
 }
 
 type analyzeRequest struct {
 	ProviderID int64  `json:"provider_id"`
 	MessageID  *int64 `json:"message_id"`
 	Message    string `json:"message"`
+	// BypassCache forces a fresh provider call for content analyzed before.
+	BypassCache bool `json:"bypass_cache"`
 }
 
 type batchAnalyzeRequest struct {
@@ -54,9 +58,11 @@ type batchAnalyzeRequest struct {
 }
 
 type summarizeRequest struct {
-	ProviderID     *int64   `json:"provider_id"`
-	ConversationID *int64   `json:"conversation_id"`
-	Messages       []string `json:"messages"`
+	ProviderID     *int64     `json:"provider_id"`
+	ConversationID *int64     `json:"conversation_id"`
+	Messages       []string   `json:"messages"`
+	From           *time.Time `json:"from"`
+	To             *time.Time `json:"to"`
 }
 
 func (a *API) CreateProvider(w http.ResponseWriter, r *http.Request) {
@@ -65,7 +71,7 @@ func (a *API) CreateProvider(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "invalid request")
 		return
 	}
-	if req.ProviderName == "" || req.APIKey == "" {
+	if req.ProviderName == "" || (req.APIKey == "" && !keylessProvider(req.ProviderName)) {
 		writeError(w, http.StatusBadRequest, "provider_name and api_key are required")
 		return
 	}
@@ -78,6 +84,10 @@ func (a *API) CreateProvider(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "unsupported provider")
 		return
 	}
+	if err := llm.ValidateSafetySettings(req.SafetySettings); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	if req.ModelName != "" {
 		config.ModelName = req.ModelName
 	}
@@ -108,6 +118,10 @@ func (a *API) CreateProvider(w http.ResponseWriter, r *http.Request) {
 	if req.MaxRequestsPerMinute != nil {
 		config.MaxRequestsPerMinute = *req.MaxRequestsPerMinute
 	}
+	if err := a.validateProvider(config); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	maxPerDay := 10000
 	if req.MaxRequestsPerDay != nil {
 		maxPerDay = *req.MaxRequestsPerDay
@@ -141,11 +155,11 @@ func (a *API) CreateProvider(w http.ResponseWriter, r *http.Request) {
 			_, _ = conn.Exec(ctx, `UPDATE llm_providers SET is_default=FALSE WHERE tenant_id=$1`, tenantID)
 		}
 		query := `
-			INSERT INTO llm_providers (tenant_id, provider_name, api_key, model_name, display_name, base_url, azure_endpoint, azure_deployment, azure_api_version, temperature, max_tokens, cost_per_1k_input, cost_per_1k_output, max_requests_per_minute, max_requests_per_day, monthly_budget, is_active, is_default, is_fallback, health_status, created_at)
-			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,'unknown',$20)
-			RETURNING id, tenant_id, provider_name, model_name, display_name, base_url, azure_endpoint, azure_deployment, azure_api_version, temperature, max_tokens, cost_per_1k_input, cost_per_1k_output, max_requests_per_minute, max_requests_per_day, monthly_budget, is_active, is_default, is_fallback, health_status, last_health_check, created_at`
-		return conn.QueryRow(ctx, query, tenantID, config.ProviderName, encrypted, config.ModelName, req.DisplayName, req.BaseURL, req.AzureEndpoint, req.AzureDeployment, req.AzureAPIVersion, config.Temperature, config.MaxTokens, config.CostPer1KInput, config.CostPer1KOutput, config.MaxRequestsPerMinute, maxPerDay, req.MonthlyBudget, isActive, isDefault, isFallback, time.Now().UTC()).Scan(
-			&provider.ID, &provider.TenantID, &provider.ProviderName, &provider.ModelName, &provider.DisplayName, &provider.BaseURL, &provider.AzureEndpoint, &provider.AzureDeployment, &provider.AzureAPIVersion, &provider.Temperature, &provider.MaxTokens, &provider.CostPer1KInput, &provider.CostPer1KOutput, &provider.MaxRequestsPerMinute, &provider.MaxRequestsPerDay, &provider.MonthlyBudget, &provider.IsActive, &provider.IsDefault, &provider.IsFallback, &provider.HealthStatus, &provider.LastHealthCheck, &provider.CreatedAt,
+			INSERT INTO llm_providers (tenant_id, provider_name, api_key, model_name, display_name, base_url, azure_endpoint, azure_deployment, azure_api_version, temperature, max_tokens, cost_per_1k_input, cost_per_1k_output, max_requests_per_minute, max_requests_per_day, monthly_budget, is_active, is_default, is_fallback, health_status, created_at, safety_settings)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,'unknown',$20,$21::jsonb)
+			RETURNING id, tenant_id, provider_name, model_name, display_name, base_url, azure_endpoint, azure_deployment, azure_api_version, temperature, max_tokens, cost_per_1k_input, cost_per_1k_output, max_requests_per_minute, max_requests_per_day, monthly_budget, is_active, is_default, is_fallback, health_status, last_health_check, created_at, COALESCE(safety_settings, '{}'::jsonb)`
+		return conn.QueryRow(ctx, query, tenantID, config.ProviderName, encrypted, config.ModelName, req.DisplayName, req.BaseURL, req.AzureEndpoint, req.AzureDeployment, req.AzureAPIVersion, config.Temperature, config.MaxTokens, config.CostPer1KInput, config.CostPer1KOutput, config.MaxRequestsPerMinute, maxPerDay, req.MonthlyBudget, isActive, isDefault, isFallback, time.Now().UTC(), safetySettingsJSON(req.SafetySettings)).Scan(
+			&provider.ID, &provider.TenantID, &provider.ProviderName, &provider.ModelName, &provider.DisplayName, &provider.BaseURL, &provider.AzureEndpoint, &provider.AzureDeployment, &provider.AzureAPIVersion, &provider.Temperature, &provider.MaxTokens, &provider.CostPer1KInput, &provider.CostPer1KOutput, &provider.MaxRequestsPerMinute, &provider.MaxRequestsPerDay, &provider.MonthlyBudget, &provider.IsActive, &provider.IsDefault, &provider.IsFallback, &provider.HealthStatus, &provider.LastHealthCheck, &provider.CreatedAt, &provider.SafetySettings,
 		)
 	}); err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to create provider")
@@ -153,6 +167,7 @@ func (a *API) CreateProvider(w http.ResponseWriter, r *http.Request) {
 	}
 
 	provider.APIKey = "****"
+	a.evictProvider(tenantID, provider.ID)
 	writeJSON(w, http.StatusCreated, provider)
 	if a.HealthScheduler != nil {
 		a.HealthScheduler.EnsureTenant(context.Background(), tenantID)
@@ -172,7 +187,7 @@ func (a *API) ListProviders(w http.ResponseWriter, r *http.Request) {
 	providers := []models.LLMProvider{}
 	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
 		rows, err := conn.Query(ctx, `
-			SELECT id, tenant_id, provider_name, model_name, display_name, base_url, azure_endpoint, azure_deployment, azure_api_version, temperature, max_tokens, cost_per_1k_input, cost_per_1k_output, max_requests_per_minute, max_requests_per_day, monthly_budget, is_active, is_default, is_fallback, health_status, last_health_check, created_at
+			SELECT id, tenant_id, provider_name, model_name, display_name, base_url, azure_endpoint, azure_deployment, azure_api_version, temperature, max_tokens, cost_per_1k_input, cost_per_1k_output, max_requests_per_minute, max_requests_per_day, monthly_budget, is_active, is_default, is_fallback, health_status, last_health_check, created_at, COALESCE(safety_settings, '{}'::jsonb)
 			FROM llm_providers
 			WHERE tenant_id=$1
 			ORDER BY id DESC`, tenantID)
@@ -182,7 +197,7 @@ func (a *API) ListProviders(w http.ResponseWriter, r *http.Request) {
 		defer rows.Close()
 		for rows.Next() {
 			var item models.LLMProvider
-			if err := rows.Scan(&item.ID, &item.TenantID, &item.ProviderName, &item.ModelName, &item.DisplayName, &item.BaseURL, &item.AzureEndpoint, &item.AzureDeployment, &item.AzureAPIVersion, &item.Temperature, &item.MaxTokens, &item.CostPer1KInput, &item.CostPer1KOutput, &item.MaxRequestsPerMinute, &item.MaxRequestsPerDay, &item.MonthlyBudget, &item.IsActive, &item.IsDefault, &item.IsFallback, &item.HealthStatus, &item.LastHealthCheck, &item.CreatedAt); err != nil {
+			if err := rows.Scan(&item.ID, &item.TenantID, &item.ProviderName, &item.ModelName, &item.DisplayName, &item.BaseURL, &item.AzureEndpoint, &item.AzureDeployment, &item.AzureAPIVersion, &item.Temperature, &item.MaxTokens, &item.CostPer1KInput, &item.CostPer1KOutput, &item.MaxRequestsPerMinute, &item.MaxRequestsPerDay, &item.MonthlyBudget, &item.IsActive, &item.IsDefault, &item.IsFallback, &item.HealthStatus, &item.LastHealthCheck, &item.CreatedAt, &item.SafetySettings); err != nil {
 				return err
 			}
 			item.APIKey = "****"
@@ -204,10 +219,10 @@ func (a *API) GetProvider(w http.ResponseWriter, r *http.Request, providerID int
 	var provider models.LLMProvider
 	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
 		query := `
-			SELECT id, tenant_id, provider_name, model_name, display_name, base_url, azure_endpoint, azure_deployment, azure_api_version, temperature, max_tokens, cost_per_1k_input, cost_per_1k_output, max_requests_per_minute, max_requests_per_day, monthly_budget, is_active, is_default, is_fallback, health_status, last_health_check, created_at
+			SELECT id, tenant_id, provider_name, model_name, display_name, base_url, azure_endpoint, azure_deployment, azure_api_version, temperature, max_tokens, cost_per_1k_input, cost_per_1k_output, max_requests_per_minute, max_requests_per_day, monthly_budget, is_active, is_default, is_fallback, health_status, last_health_check, created_at, COALESCE(safety_settings, '{}'::jsonb)
 			FROM llm_providers WHERE tenant_id=$1 AND id=$2`
 		return conn.QueryRow(ctx, query, tenantID, providerID).Scan(
-			&provider.ID, &provider.TenantID, &provider.ProviderName, &provider.ModelName, &provider.DisplayName, &provider.BaseURL, &provider.AzureEndpoint, &provider.AzureDeployment, &provider.AzureAPIVersion, &provider.Temperature, &provider.MaxTokens, &provider.CostPer1KInput, &provider.CostPer1KOutput, &provider.MaxRequestsPerMinute, &provider.MaxRequestsPerDay, &provider.MonthlyBudget, &provider.IsActive, &provider.IsDefault, &provider.IsFallback, &provider.HealthStatus, &provider.LastHealthCheck, &provider.CreatedAt,
+			&provider.ID, &provider.TenantID, &provider.ProviderName, &provider.ModelName, &provider.DisplayName, &provider.BaseURL, &provider.AzureEndpoint, &provider.AzureDeployment, &provider.AzureAPIVersion, &provider.Temperature, &provider.MaxTokens, &provider.CostPer1KInput, &provider.CostPer1KOutput, &provider.MaxRequestsPerMinute, &provider.MaxRequestsPerDay, &provider.MonthlyBudget, &provider.IsActive, &provider.IsDefault, &provider.IsFallback, &provider.HealthStatus, &provider.LastHealthCheck, &provider.CreatedAt, &provider.SafetySettings,
 		)
 	}); err != nil {
 		writeError(w, http.StatusNotFound, "provider not found")
@@ -229,11 +244,37 @@ func (a *API) UpdateProvider(w http.ResponseWriter, r *http.Request, providerID
 			return
 		}
 	}
+	if err := llm.ValidateSafetySettings(req.SafetySettings); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	tenantID := a.tenantID(r)
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
+	if req.ProviderName != "" || req.BaseURL != nil {
+		config := llm.ProviderConfig{ProviderName: req.ProviderName}
+		var baseURL string
+		if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+			return conn.QueryRow(ctx, `SELECT provider_name, COALESCE(base_url, '') FROM llm_providers WHERE tenant_id=$1 AND id=$2`, tenantID, providerID).Scan(&config.ProviderName, &baseURL)
+		}); err != nil {
+			writeError(w, http.StatusNotFound, "provider not found")
+			return
+		}
+		if req.ProviderName != "" {
+			config.ProviderName = req.ProviderName
+		}
+		config.BaseURL = baseURL
+		if req.BaseURL != nil {
+			config.BaseURL = *req.BaseURL
+		}
+		if err := a.validateProvider(&config); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
 	var encrypted *string
 	if req.APIKey != "" {
 		if a.LLMStore == nil || a.LLMStore.MasterKey == "" {
@@ -272,17 +313,19 @@ func (a *API) UpdateProvider(w http.ResponseWriter, r *http.Request, providerID
 			    monthly_budget=COALESCE($15, monthly_budget),
 			    is_active=COALESCE($16, is_active),
 			    is_default=COALESCE($17, is_default),
-			    is_fallback=COALESCE($18, is_fallback)
+			    is_fallback=COALESCE($18, is_fallback),
+			    safety_settings=COALESCE($21::jsonb, safety_settings)
 			WHERE tenant_id=$19 AND id=$20
-			RETURNING id, tenant_id, provider_name, model_name, COALESCE(display_name, ''), COALESCE(base_url, ''), COALESCE(azure_endpoint, ''), COALESCE(azure_deployment, ''), COALESCE(azure_api_version, ''), temperature, max_tokens, cost_per_1k_input, cost_per_1k_output, max_requests_per_minute, max_requests_per_day, monthly_budget, is_active, is_default, is_fallback, health_status, last_health_check, created_at`
-		return conn.QueryRow(ctx, query, emptyString(req.ProviderName), encrypted, emptyString(req.ModelName), req.DisplayName, req.BaseURL, req.AzureEndpoint, req.AzureDeployment, req.AzureAPIVersion, req.Temperature, req.MaxTokens, req.CostPer1KInput, req.CostPer1KOutput, req.MaxRequestsPerMinute, req.MaxRequestsPerDay, req.MonthlyBudget, req.IsActive, req.IsDefault, req.IsFallback, tenantID, providerID).Scan(
-			&provider.ID, &provider.TenantID, &provider.ProviderName, &provider.ModelName, &provider.DisplayName, &provider.BaseURL, &provider.AzureEndpoint, &provider.AzureDeployment, &provider.AzureAPIVersion, &provider.Temperature, &provider.MaxTokens, &provider.CostPer1KInput, &provider.CostPer1KOutput, &provider.MaxRequestsPerMinute, &provider.MaxRequestsPerDay, &provider.MonthlyBudget, &provider.IsActive, &provider.IsDefault, &provider.IsFallback, &provider.HealthStatus, &provider.LastHealthCheck, &provider.CreatedAt,
+			RETURNING id, tenant_id, provider_name, model_name, COALESCE(display_name, ''), COALESCE(base_url, ''), COALESCE(azure_endpoint, ''), COALESCE(azure_deployment, ''), COALESCE(azure_api_version, ''), temperature, max_tokens, cost_per_1k_input, cost_per_1k_output, max_requests_per_minute, max_requests_per_day, monthly_budget, is_active, is_default, is_fallback, health_status, last_health_check, created_at, COALESCE(safety_settings, '{}'::jsonb)`
+		return conn.QueryRow(ctx, query, emptyString(req.ProviderName), encrypted, emptyString(req.ModelName), req.DisplayName, req.BaseURL, req.AzureEndpoint, req.AzureDeployment, req.AzureAPIVersion, req.Temperature, req.MaxTokens, req.CostPer1KInput, req.CostPer1KOutput, req.MaxRequestsPerMinute, req.MaxRequestsPerDay, req.MonthlyBudget, req.IsActive, req.IsDefault, req.IsFallback, tenantID, providerID, safetySettingsJSON(req.SafetySettings)).Scan(
+			&provider.ID, &provider.TenantID, &provider.ProviderName, &provider.ModelName, &provider.DisplayName, &provider.BaseURL, &provider.AzureEndpoint, &provider.AzureDeployment, &provider.AzureAPIVersion, &provider.Temperature, &provider.MaxTokens, &provider.CostPer1KInput, &provider.CostPer1KOutput, &provider.MaxRequestsPerMinute, &provider.MaxRequestsPerDay, &provider.MonthlyBudget, &provider.IsActive, &provider.IsDefault, &provider.IsFallback, &provider.HealthStatus, &provider.LastHealthCheck, &provider.CreatedAt, &provider.SafetySettings,
 		)
 	}); err != nil {
 		writeError(w, http.StatusNotFound, "provider not found")
 		return
 	}
 	provider.APIKey = "****"
+	a.evictProvider(tenantID, providerID)
 	writeJSON(w, http.StatusOK, provider)
 }
 
@@ -305,6 +348,7 @@ func (a *API) DeleteProvider(w http.ResponseWriter, r *http.Request, providerID
 		return
 	}
 
+	a.evictProvider(tenantID, providerID)
 	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
 	a.writeProviderHistory(ctx, tenantID, providerID, authUserID(r), map[string]any{
 		"event":       "deleted",
@@ -345,21 +389,24 @@ func (a *API) AnalyzeMessage(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "invalid request")
 		return
 	}
-	if req.ProviderID == 0 || req.Message == "" {
-		writeError(w, http.StatusBadRequest, "provider_id and message are required")
+	if req.Message == "" {
+		writeError(w, http.StatusBadRequest, "message is required")
 		return
 	}
 	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
 	defer cancel()
 	tenantID := a.tenantID(r)
+	if req.BypassCache {
+		ctx = llm.WithoutCache(ctx)
+	}
 
-	result, err := a.LLM.Analyze(ctx, tenantID, req.ProviderID, req.Message, req.MessageID)
+	result, source, err := a.LLM.Analyze(ctx, tenantID, req.ProviderID, req.Message, req.MessageID)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "analysis failed")
 		return
 	}
 	if req.MessageID != nil {
-		_ = llm.StoreAnalysis(ctx, a.Store, tenantID, *req.MessageID, result)
+		_ = llm.StoreAnalysis(ctx, a.Store, tenantID, *req.MessageID, result, source)
 	}
 	writeJSON(w, http.StatusOK, result)
 }
@@ -394,64 +441,71 @@ func (a *API) BatchAnalyze(w http.ResponseWriter, r *http.Request) {
 
 	results := []map[string]any{}
 	for _, msg := range req.Messages {
-		result, err := a.LLM.AnalyzeWithFallback(ctx, tenantID, msg.Content, &msg.MessageID)
+		result, source, err := a.LLM.AnalyzeWithFallback(ctx, tenantID, msg.Content, &msg.MessageID)
 		if err != nil {
 			results = append(results, map[string]any{"message_id": msg.MessageID, "error": err.Error()})
 			continue
 		}
-		_ = llm.StoreAnalysis(ctx, a.Store, tenantID, msg.MessageID, result)
+		_ = llm.StoreAnalysis(ctx, a.Store, tenantID, msg.MessageID, result, source)
 		results = append(results, map[string]any{"message_id": msg.MessageID, "analysis": result})
 	}
 	writeJSON(w, http.StatusOK, map[string]any{"data": results})
 }
 
 func (a *API) SummarizeConversation(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("DEBUG: SummarizeConversation ENTRY")
 	var req summarizeRequest
 	if err := readJSON(r, &req); err != nil {
-		fmt.Println("DEBUG: readJSON failed:", err)
 		writeError(w, http.StatusBadRequest, "invalid request")
 		return
 	}
-	fmt.Println("DEBUG: readJSON OK, req:", req)
+	if req.From != nil && req.To != nil && !req.From.Before(*req.To) {
+		writeError(w, http.StatusBadRequest, "from must be before to")
+		return
+	}
 	tenantID := a.tenantID(r)
-	fmt.Println("DEBUG: tenantID:", tenantID)
-	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	// Long conversations are summarized in several rounds.
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
 	defer cancel()
 
-	messages := req.Messages
 	conversationID := req.ConversationID
-	fmt.Println("DEBUG: conversationID:", conversationID, "messages len:", len(messages))
+	contactName, contactNumber := "", ""
+	messages := make([]llm.TranscriptMessage, 0, len(req.Messages))
+	for _, content := range req.Messages {
+		messages = append(messages, llm.TranscriptMessage{Content: content})
+	}
 	if len(messages) == 0 && conversationID != nil {
-		fmt.Println("DEBUG: Loading messages from DB for conversation:", *conversationID)
-		messages = []string{}
+		// from and to narrow the conversation to [from, to).
 		if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+			if err := conn.QueryRow(ctx, `
+				SELECT COALESCE(NULLIF(contact_name, ''), contact_number), contact_number FROM conversations
+				WHERE tenant_id=$1 AND id=$2`, tenantID, *conversationID).Scan(&contactName, &contactNumber); err != nil && !errors.Is(err, pgx.ErrNoRows) {
+				return err
+			}
 			rows, err := conn.Query(ctx, `
-				SELECT content FROM messages WHERE tenant_id=$1 AND conversation_id=$2 ORDER BY timestamp ASC`, tenantID, *conversationID)
+				SELECT sender, content, timestamp FROM messages
+				WHERE tenant_id=$1 AND conversation_id=$2
+				  AND ($3::timestamptz IS NULL OR timestamp >= $3)
+				  AND ($4::timestamptz IS NULL OR timestamp < $4)
+				ORDER BY timestamp ASC`, tenantID, *conversationID, req.From, req.To)
 			if err != nil {
-				fmt.Println("DEBUG: DB query error:", err)
 				return err
 			}
 			defer rows.Close()
 			for rows.Next() {
-				var content string
-				if err := rows.Scan(&content); err != nil {
-					fmt.Println("DEBUG: DB scan error:", err)
+				var message llm.TranscriptMessage
+				if err := rows.Scan(&message.Sender, &message.Content, &message.Timestamp); err != nil {
 					return err
 				}
-				messages = append(messages, content)
+				message.Sender = llm.SenderName(message.Sender, contactNumber, contactName)
+				messages = append(messages, message)
 			}
-			fmt.Println("DEBUG: Loaded", len(messages), "messages from DB")
 			return rows.Err()
 		}); err != nil {
-			fmt.Println("DEBUG: WithTenantConn error:", err)
 			writeError(w, http.StatusInternalServerError, "failed to load messages: "+err.Error())
 			return
 		}
 	}
-	fmt.Println("DEBUG: Total messages:", len(messages))
 	if len(messages) == 0 {
-		fmt.Println("DEBUG: No messages, returning 400")
 		writeError(w, http.StatusBadRequest, "messages or conversation_id required")
 		return
 	}
@@ -460,32 +514,10 @@ func (a *API) SummarizeConversation(w http.ResponseWriter, r *http.Request) {
 	if req.ProviderID != nil {
 		providerID = *req.ProviderID
 	}
-	fmt.Println("DEBUG: providerID from request:", providerID)
-	if providerID == 0 {
-		fmt.Println("DEBUG: Getting default provider...")
-		provider, err := a.LLM.Router.GetDefaultProvider(ctx, tenantID)
-		if err != nil {
-			fmt.Println("DEBUG: GetDefaultProvider error:", err)
-			// Continue to fallback
-		} else {
-			providerID = provider.GetConfig().ID
-			fmt.Println("DEBUG: Got default provider ID:", providerID)
-		}
-	}
-
-	var result *llm.SummaryResult
-	var err error
-
-	if providerID != 0 {
-		fmt.Println("DEBUG: Calling LLM.Summarize with providerID:", providerID)
-		result, err = a.LLM.Summarize(ctx, tenantID, providerID, messages)
-	} else {
-		err = errors.New("no provider available")
-	}
 
+	result, err := a.LLM.SummarizeTranscript(ctx, tenantID, providerID, contactName, messages)
 	if err != nil {
-		fmt.Println("DEBUG: LLM.Summarize error:", err)
-		// Mock fallback: return sample summary when LLM fails
+		// Mock fallback: return sample summary when every provider fails
 		result = &llm.SummaryResult{
 			Summary:     "This conversation discusses various topics. Due to a temporary service issue, an AI-generated summary is not available at this time.",
 			KeyPoints:   []string{"Multiple messages exchanged", "Topics discussed include general conversation"},
@@ -494,7 +526,6 @@ func (a *API) SummarizeConversation(w http.ResponseWriter, r *http.Request) {
 			Topics:      []string{"general"},
 		}
 	}
-	fmt.Println("DEBUG: Summarize completed, writing response")
 
 	if conversationID != nil {
 		keyPoints, _ := json.Marshal(map[string]any{
@@ -502,6 +533,8 @@ func (a *API) SummarizeConversation(w http.ResponseWriter, r *http.Request) {
 			"action_items": result.ActionItems,
 			"sentiment":    result.Sentiment,
 			"topics":       result.Topics,
+			"from":         req.From,
+			"to":           req.To,
 		})
 		_ = a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
 			_, err := conn.Exec(ctx, `
@@ -527,7 +560,7 @@ func (a *API) GetUsageStats(w http.ResponseWriter, r *http.Request) {
 			       COALESCE(SUM(CASE WHEN success THEN 1 ELSE 0 END), 0) AS success,
 			       COALESCE(SUM(CASE WHEN success THEN 0 ELSE 1 END), 0) AS failed,
 			       COALESCE(SUM(total_cost), 0),
-			       COALESCE(AVG(response_time_ms), 0)
+			       COALESCE(AVG(response_time_ms) FILTER (WHERE NOT cache_hit), 0)
 			FROM llm_usage_logs
 			WHERE tenant_id=$1`
 		return conn.QueryRow(ctx, query, tenantID).Scan(&stats.TotalRequests, &stats.SuccessfulRequests, &stats.FailedRequests, &stats.TotalCost, &avgLatencyMs)
@@ -649,12 +682,38 @@ func (a *API) GetCosts(w http.ResponseWriter, r *http.Request) {
 		alert = true
 	}
 
+	providerBudgets := []map[string]any{}
+	if a.LLMStore != nil {
+		budgets, err := a.LLMStore.ProviderBudgets(ctx, tenantID, time.Now())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to load provider budgets")
+			return
+		}
+		for _, item := range budgets {
+			providerBudgets = append(providerBudgets, map[string]any{
+				"provider_id":          item.ProviderID,
+				"provider":             item.Provider,
+				"monthly_budget":       item.MonthlyBudget,
+				"month_spent":          item.MonthSpent,
+				"budget_used_pct":      item.BudgetUsed() * 100,
+				"max_requests_per_day": item.MaxRequestsPerDay,
+				"requests_today":       item.RequestsToday,
+				"requests_used_pct":    item.RequestsUsed() * 100,
+				"status":               item.Status(),
+			})
+			if item.Status() != "ok" {
+				alert = true
+			}
+		}
+	}
+
 	writeJSON(w, http.StatusOK, map[string]any{
 		"total_cost":         totalCost,
 		"provider_costs":     providerCosts,
 		"feature_costs":      featureCosts,
 		"daily_costs":        dailyCosts,
 		"conversation_costs": conversationCosts,
+		"provider_budgets":   providerBudgets,
 		"budget_alert":       alert,
 	})
 }
@@ -687,12 +746,17 @@ func (a *API) GetHealthStatus(w http.ResponseWriter, r *http.Request) {
 			if err := rows.Scan(&id, &name, &status, &lastCheck, &avgLatency); err != nil {
 				return err
 			}
+			circuit := llm.BreakerState{State: llm.BreakerClosed}
+			if a.LLM != nil && a.LLM.Router.Breakers != nil {
+				circuit = a.LLM.Router.Breakers.State(tenantID, id)
+			}
 			items = append(items, map[string]any{
 				"provider_id":    id,
 				"provider":       name,
 				"status":         status,
 				"last_check":     lastCheck,
 				"avg_latency_ms": avgLatency,
+				"circuit":        circuit,
 			})
 		}
 		return rows.Err()
@@ -712,10 +776,12 @@ func (a *API) GetProviderComparison(w http.ResponseWriter, r *http.Request) {
 	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
 		query := `
 			SELECT p.id, p.provider_name, p.model_name,
-			       COALESCE(AVG(l.response_time_ms), 0) AS avg_latency,
+			       COALESCE(AVG(l.response_time_ms) FILTER (WHERE NOT l.cache_hit), 0) AS avg_latency,
 			       COALESCE(SUM(CASE WHEN l.success THEN 1 ELSE 0 END), 0) AS success_count,
 			       COALESCE(COUNT(l.id), 0) AS total_count,
-			       COALESCE(SUM(l.total_cost), 0) AS total_cost
+			       COALESCE(SUM(l.total_cost), 0) AS total_cost,
+			       COALESCE(SUM(CASE WHEN l.validation_errors > 0 THEN 1 ELSE 0 END), 0) AS invalid_count,
+			       COALESCE(SUM(CASE WHEN l.repaired THEN 1 ELSE 0 END), 0) AS repaired_count
 			FROM llm_providers p
 			LEFT JOIN llm_usage_logs l ON l.provider_id = p.id
 			WHERE p.tenant_id=$1
@@ -734,12 +800,16 @@ func (a *API) GetProviderComparison(w http.ResponseWriter, r *http.Request) {
 			var successCount int64
 			var totalCount int64
 			var totalCost float64
-			if err := result.Scan(&id, &name, &model, &avgLatency, &successCount, &totalCount, &totalCost); err != nil {
+			var invalidCount int64
+			var repairedCount int64
+			if err := result.Scan(&id, &name, &model, &avgLatency, &successCount, &totalCount, &totalCost, &invalidCount, &repairedCount); err != nil {
 				return err
 			}
 			successRate := 0.0
+			validationFailureRate := 0.0
 			if totalCount > 0 {
 				successRate = (float64(successCount) / float64(totalCount)) * 100
+				validationFailureRate = (float64(invalidCount) / float64(totalCount)) * 100
 			}
 			rows = append(rows, map[string]any{
 				"provider_id":    id,
@@ -749,9 +819,28 @@ func (a *API) GetProviderComparison(w http.ResponseWriter, r *http.Request) {
 				"success_rate":   successRate,
 				"monthly_spent":  totalCost,
 				"requests":       totalCount,
+				// Calls whose output failed schema validation, and how many of
+				// those a repair request fixed.
+				"validation_failures":     invalidCount,
+				"validation_failure_rate": validationFailureRate,
+				"repaired":                repairedCount,
 			})
 		}
-		return result.Err()
+		if err := result.Err(); err != nil {
+			return err
+		}
+		shadows, err := shadowComparison(ctx, conn, tenantID)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			shadow := shadows[row["provider_id"].(int64)]
+			if shadow == nil {
+				shadow = []map[string]any{}
+			}
+			row["shadow"] = shadow
+		}
+		return nil
 	}); err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to load comparison")
 		return
@@ -759,6 +848,65 @@ func (a *API) GetProviderComparison(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]any{"data": rows})
 }
 
+// shadowComparison aggregates shadow results by shadow provider: for each
+// feature and production provider it was compared with, how often the two
+// agreed, next to both sides' latency and cost. Rates are percentages of the
+// samples the shadow answered.
+func shadowComparison(ctx context.Context, conn *pgxpool.Conn, tenantID int64) (map[int64][]map[string]any, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT shadow_provider_id, primary_provider_id, feature_name,
+		       COUNT(*),
+		       COUNT(*) FILTER (WHERE shadow_error IS NOT NULL),
+		       COUNT(priority_match), COUNT(*) FILTER (WHERE priority_match),
+		       COUNT(importance_match), COUNT(*) FILTER (WHERE importance_match),
+		       AVG(sentiment_delta), AVG(topic_overlap),
+		       COALESCE(AVG(primary_latency_ms), 0), COALESCE(AVG(shadow_latency_ms), 0),
+		       COALESCE(SUM(primary_cost), 0), COALESCE(SUM(shadow_cost), 0)
+		FROM llm_shadow_results
+		WHERE tenant_id=$1
+		GROUP BY shadow_provider_id, primary_provider_id, feature_name
+		ORDER BY shadow_provider_id, feature_name, primary_provider_id`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	comparison := map[int64][]map[string]any{}
+	for rows.Next() {
+		var shadowID, primaryID int64
+		var feature string
+		var samples, errorCount, priorityCompared, priorityMatched, importanceCompared, importanceMatched int64
+		var sentimentDelta, topicOverlap *float64
+		var primaryLatency, shadowLatency, primaryCost, shadowCost float64
+		if err := rows.Scan(&shadowID, &primaryID, &feature, &samples, &errorCount, &priorityCompared, &priorityMatched,
+			&importanceCompared, &importanceMatched, &sentimentDelta, &topicOverlap, &primaryLatency, &shadowLatency, &primaryCost, &shadowCost); err != nil {
+			return nil, err
+		}
+		entry := map[string]any{
+			"feature":             feature,
+			"primary_provider_id": primaryID,
+			"samples":             samples,
+			"errors":              errorCount,
+			// Nil when the feature's output has no such field.
+			"priority_match_rate":    nil,
+			"importance_match_rate":  nil,
+			"avg_sentiment_delta":    sentimentDelta,
+			"avg_topic_overlap":      topicOverlap,
+			"primary_avg_latency_ms": primaryLatency,
+			"shadow_avg_latency_ms":  shadowLatency,
+			"primary_cost":           primaryCost,
+			"shadow_cost":            shadowCost,
+		}
+		if priorityCompared > 0 {
+			entry["priority_match_rate"] = (float64(priorityMatched) / float64(priorityCompared)) * 100
+		}
+		if importanceCompared > 0 {
+			entry["importance_match_rate"] = (float64(importanceMatched) / float64(importanceCompared)) * 100
+		}
+		comparison[shadowID] = append(comparison[shadowID], entry)
+	}
+	return comparison, rows.Err()
+}
+
 func (a *API) GetProviderHistory(w http.ResponseWriter, r *http.Request, providerID int64) {
 	tenantID := a.tenantID(r)
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
@@ -943,9 +1091,13 @@ func (a *API) GetCostBreakdown(w http.ResponseWriter, r *http.Request) {
 	byProvider := []map[string]any{}
 	byFeature := []map[string]any{}
 	byDay := []map[string]any{}
+	var cacheHits int64
+	var cacheSaved float64
+	var cacheTokens int64
 	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
 		rows, err := conn.Query(ctx, `
-			SELECT p.provider_name, COALESCE(SUM(l.total_cost),0)
+			SELECT p.provider_name, COALESCE(SUM(l.total_cost),0), COALESCE(SUM(l.saved_cost),0),
+			       COALESCE(SUM(CASE WHEN l.cache_hit THEN 1 ELSE 0 END),0)
 			FROM llm_usage_logs l
 			JOIN llm_providers p ON p.id = l.provider_id
 			WHERE l.tenant_id=$1
@@ -957,14 +1109,25 @@ func (a *API) GetCostBreakdown(w http.ResponseWriter, r *http.Request) {
 		for rows.Next() {
 			var name string
 			var cost float64
-			if err := rows.Scan(&name, &cost); err != nil {
+			var saved float64
+			var hits int64
+			if err := rows.Scan(&name, &cost, &saved, &hits); err != nil {
 				rows.Close()
 				return err
 			}
-			byProvider = append(byProvider, map[string]any{"provider": name, "total_cost": cost})
+			byProvider = append(byProvider, map[string]any{"provider": name, "total_cost": cost, "saved_cost": saved, "cache_hits": hits})
 		}
 		rows.Close()
 
+		// Cache hits are logged at no cost; saved_cost is what they would
+		// have cost.
+		if err := conn.QueryRow(ctx, `
+			SELECT COUNT(*), COALESCE(SUM(saved_cost),0), COALESCE(SUM(saved_tokens),0)
+			FROM llm_usage_logs
+			WHERE tenant_id=$1 AND cache_hit`, tenantID).Scan(&cacheHits, &cacheSaved, &cacheTokens); err != nil {
+			return err
+		}
+
 		rows, err = conn.Query(ctx, `
 			SELECT feature_used, COALESCE(SUM(total_cost),0)
 			FROM llm_usage_logs
@@ -1015,6 +1178,11 @@ func (a *API) GetCostBreakdown(w http.ResponseWriter, r *http.Request) {
 		"by_provider": byProvider,
 		"by_feature":  byFeature,
 		"by_day":      byDay,
+		"cache": map[string]any{
+			"hits":         cacheHits,
+			"saved_cost":   cacheSaved,
+			"tokens_saved": cacheTokens,
+		},
 	})
 }
 
@@ -1028,8 +1196,9 @@ func (a *API) GetUsageByFeature(w http.ResponseWriter, r *http.Request) {
 		rows, err := conn.Query(ctx, `
 			SELECT feature_used,
 			       COALESCE(SUM(total_tokens),0),
+			       COALESCE(SUM(saved_tokens),0),
 			       COALESCE(SUM(total_cost),0),
-			       COALESCE(AVG(response_time_ms),0)
+			       COALESCE(AVG(response_time_ms) FILTER (WHERE NOT cache_hit),0)
 			FROM llm_usage_logs
 			WHERE tenant_id=$1
 			GROUP BY feature_used`, tenantID)
@@ -1040,14 +1209,16 @@ func (a *API) GetUsageByFeature(w http.ResponseWriter, r *http.Request) {
 		for rows.Next() {
 			var feature string
 			var tokens int64
+			var savedTokens int64
 			var cost float64
 			var latency float64
-			if err := rows.Scan(&feature, &tokens, &cost, &latency); err != nil {
+			if err := rows.Scan(&feature, &tokens, &savedTokens, &cost, &latency); err != nil {
 				return err
 			}
 			data = append(data, map[string]any{
 				"feature":        feature,
 				"total_tokens":   tokens,
+				"saved_tokens":   savedTokens,
 				"total_cost":     cost,
 				"avg_latency_ms": latency,
 			})
@@ -1122,7 +1293,7 @@ func (a *API) GetRecommendations(w http.ResponseWriter, r *http.Request) {
 	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
 		rows, err := conn.Query(ctx, `
 			SELECT p.provider_name,
-			       COALESCE(AVG(l.response_time_ms),0) AS avg_latency,
+			       COALESCE(AVG(l.response_time_ms) FILTER (WHERE NOT l.cache_hit),0) AS avg_latency,
 			       COALESCE(SUM(CASE WHEN l.success THEN 1 ELSE 0 END),0) AS success_count,
 			       COALESCE(COUNT(l.id),0) AS total_count,
 			       COALESCE(SUM(l.total_cost),0) AS total_cost
@@ -1180,11 +1351,14 @@ func (a *API) GetRecommendations(w http.ResponseWriter, r *http.Request) {
 
 func defaultFeatures() []string {
 	return []string{
-		"importance_detection",
-		"summarization",
-		"action_extraction",
-		"daily_summary",
-		"conversation_scoring",
+		llm.FeatureImportanceDetection,
+		llm.FeatureSummarization,
+		llm.FeatureActionExtraction,
+		llm.FeatureDailySummary,
+		llm.FeatureConversationScoring,
+		llm.FeatureSemanticSearch,
+		llm.FeatureConversationQA,
+		llm.FeatureReplyDrafting,
 	}
 }
 
@@ -1244,11 +1418,76 @@ func defaultProviderConfig(provider string) *llm.ProviderConfig {
 			CostPer1KOutput:      0.0003,
 			MaxRequestsPerMinute: 60,
 		}
+	case "gemini":
+		return &llm.ProviderConfig{
+			ProviderName:         "gemini",
+			ModelName:            "gemini-2.0-flash",
+			Temperature:          0.2,
+			MaxTokens:            1024,
+			CostPer1KInput:       0.0001,
+			CostPer1KOutput:      0.0004,
+			MaxRequestsPerMinute: 60,
+		}
+	case "mock":
+		return &llm.ProviderConfig{
+			ProviderName:         "mock",
+			ModelName:            "mock-1",
+			BaseURL:              "mock://",
+			Temperature:          0,
+			MaxTokens:            1024,
+			CostPer1KInput:       0.001,
+			CostPer1KOutput:      0.002,
+			MaxRequestsPerMinute: 600,
+		}
+	case "ollama":
+		return &llm.ProviderConfig{
+			ProviderName:         "ollama",
+			ModelName:            "llama3.1",
+			BaseURL:              "http://localhost:11434",
+			Temperature:          0.2,
+			MaxTokens:            1024,
+			CostPer1KInput:       0,
+			CostPer1KOutput:      0,
+			MaxRequestsPerMinute: 60,
+		}
 	default:
 		return nil
 	}
 }
 
+// safetySettingsJSON encodes safety settings for a jsonb column, or nil to
+// leave the column alone.
+func safetySettingsJSON(settings map[string]string) *string {
+	if settings == nil {
+		return nil
+	}
+	encoded, _ := json.Marshal(settings)
+	value := string(encoded)
+	return &value
+}
+
+// validateProvider checks a provider configuration before it is stored,
+// e.g. that the mock provider is enabled and its fixtures allowed.
+func (a *API) validateProvider(config *llm.ProviderConfig) error {
+	if a.LLM == nil || a.LLM.Router == nil {
+		return nil
+	}
+	return a.LLM.Router.ValidateProvider(config)
+}
+
+// evictProvider makes the next call use the provider's stored
+// configuration.
+func (a *API) evictProvider(tenantID, providerID int64) {
+	if a.LLM != nil && a.LLM.Router != nil {
+		a.LLM.Router.Evict(tenantID, providerID)
+	}
+}
+
+// keylessProvider reports whether the provider runs without an API key.
+func keylessProvider(provider string) bool {
+	return provider == "ollama" || provider == "mock"
+}
+
 func emptyString(value string) *string {
 	if value == "" {
 		return nil
@@ -1299,5 +1538,6 @@ func providerSnapshot(provider models.LLMProvider) map[string]any {
 		"is_default":              provider.IsDefault,
 		"is_fallback":             provider.IsFallback,
 		"health_status":           provider.HealthStatus,
+		"safety_settings":         provider.SafetySettings,
 	}
 }
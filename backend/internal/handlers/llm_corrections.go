@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"message-flow/backend/internal/llm"
+)
+
+// CorrectMessageAnalysis records an agent's correction of a message's
+// importance, priority, sentiment or topics. The stored analysis and the
+// important messages list follow the correction.
+func (a *API) CorrectMessageAnalysis(w http.ResponseWriter, r *http.Request, messageID int64) {
+	var req llm.AnalysisCorrection
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if err := llm.ValidateCorrection(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	userID := authUserIDPtr(r)
+	correction, before, err := a.LLMStore.CorrectAnalysis(ctx, tenantID, messageID, req, userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "message not found")
+			return
+		}
+		if errors.Is(err, llm.ErrCorrectionPriority) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to correct analysis")
+		return
+	}
+	a.LLM.InvalidateFewShot(tenantID)
+	a.logAudit(ctx, r, tenantID, userID, "message.analysis.correct", stringPtr("message"), &messageID, before, correction)
+	writeJSON(w, http.StatusOK, map[string]any{"data": correction})
+}
+
+func (a *API) GetFewShotSettings(w http.ResponseWriter, r *http.Request) {
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	settings, err := a.LLMStore.FewShotSettings(ctx, tenantID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load few-shot settings")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": settings})
+}
+
+func (a *API) UpdateFewShotSettings(w http.ResponseWriter, r *http.Request) {
+	var req llm.FewShotSettings
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if req.Examples == 0 {
+		req.Examples = llm.DefaultFewShotExamples
+	}
+	if req.Examples < 1 || req.Examples > llm.MaxFewShotExamples {
+		writeError(w, http.StatusBadRequest, "examples must be between 1 and 10")
+		return
+	}
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	before, _ := a.LLMStore.FewShotSettings(ctx, tenantID)
+	if err := a.LLMStore.SaveFewShotSettings(ctx, tenantID, req); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update few-shot settings")
+		return
+	}
+	a.LLM.InvalidateFewShot(tenantID)
+	a.logAudit(ctx, r, tenantID, authUserIDPtr(r), "llm.few_shot.update", stringPtr("llm_prompt_settings"), nil, before, req)
+	writeJSON(w, http.StatusOK, map[string]any{"data": req})
+}
+
+// GetCorrectionStats reports per provider how often its analyses disagreed
+// with the labels agents gave the same messages. Only each message's latest
+// correction counts, compared with the machine analysis it replaced; rates
+// are percentages of the reviewed messages that had one.
+func (a *API) GetCorrectionStats(w http.ResponseWriter, r *http.Request) {
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	data := []map[string]any{}
+	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			WITH latest AS (
+				SELECT DISTINCT ON (message_id) *
+				FROM analysis_corrections
+				WHERE tenant_id=$1
+				ORDER BY message_id, created_at DESC
+			), compared AS (
+				SELECT provider_id, model_name, original IS NOT NULL AS has_original,
+				       (original->>'is_important')::BOOLEAN IS DISTINCT FROM is_important AS importance_error,
+				       LOWER(COALESCE(original->>'priority', '')) <> priority AS priority_error,
+				       LOWER(COALESCE(original->>'sentiment', '')) <> sentiment AS sentiment_error,
+				       (SELECT COALESCE(ARRAY_AGG(DISTINCT LOWER(t) ORDER BY LOWER(t)), '{}')
+				        FROM JSONB_ARRAY_ELEMENTS_TEXT(CASE WHEN JSONB_TYPEOF(original->'topics') = 'array' THEN original->'topics' ELSE '[]'::JSONB END) t)
+				       IS DISTINCT FROM
+				       (SELECT COALESCE(ARRAY_AGG(DISTINCT LOWER(t) ORDER BY LOWER(t)), '{}') FROM UNNEST(topics) t) AS topics_error
+				FROM latest
+			)
+			SELECT c.provider_id, COALESCE(p.provider_name, ''), c.model_name,
+			       COUNT(*),
+			       COUNT(*) FILTER (WHERE c.has_original),
+			       COUNT(*) FILTER (WHERE c.has_original AND c.importance_error),
+			       COUNT(*) FILTER (WHERE c.has_original AND c.priority_error),
+			       COUNT(*) FILTER (WHERE c.has_original AND c.sentiment_error),
+			       COUNT(*) FILTER (WHERE c.has_original AND c.topics_error),
+			       COUNT(*) FILTER (WHERE c.has_original AND NOT (c.importance_error OR c.priority_error OR c.sentiment_error OR c.topics_error))
+			FROM compared c
+			LEFT JOIN llm_providers p ON p.id = c.provider_id
+			GROUP BY c.provider_id, p.provider_name, c.model_name
+			ORDER BY c.provider_id, c.model_name`, tenantID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var providerID *int64
+			var provider, model string
+			var reviewed, compared, importanceErrors, priorityErrors, sentimentErrors, topicErrors, confirmed int64
+			if err := rows.Scan(&providerID, &provider, &model, &reviewed, &compared, &importanceErrors, &priorityErrors, &sentimentErrors, &topicErrors, &confirmed); err != nil {
+				return err
+			}
+			rate := func(count int64) float64 {
+				if compared == 0 {
+					return 0
+				}
+				return (float64(count) / float64(compared)) * 100
+			}
+			data = append(data, map[string]any{
+				// A nil provider covers fallback and batch analyses.
+				"provider_id":           providerID,
+				"provider":              provider,
+				"model":                 model,
+				"reviewed":              reviewed,
+				"compared":              compared,
+				"confirmed":             confirmed,
+				"importance_errors":     importanceErrors,
+				"importance_error_rate": rate(importanceErrors),
+				"priority_errors":       priorityErrors,
+				"priority_error_rate":   rate(priorityErrors),
+				"sentiment_errors":      sentimentErrors,
+				"sentiment_error_rate":  rate(sentimentErrors),
+				"topic_errors":          topicErrors,
+				"topic_error_rate":      rate(topicErrors),
+			})
+		}
+		return rows.Err()
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load correction stats")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": data})
+}
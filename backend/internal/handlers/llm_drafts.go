@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"message-flow/backend/internal/llm"
+)
+
+// maxDraftLength bounds the agent's text sent for rewriting, and the reply
+// settings.
+const maxDraftLength = 4000
+
+type draftReplyRequest struct {
+	Count      int    `json:"count"`
+	ProviderID int64  `json:"provider_id"`
+	Tone       string `json:"tone"`
+	Guidelines string `json:"guidelines"`
+	Draft      string `json:"draft"`
+	Rewrite    string `json:"rewrite"`
+	Language   string `json:"language"`
+}
+
+type draftFeedbackRequest struct {
+	Status    string  `json:"status"`
+	Index     *int    `json:"index"`
+	FinalText *string `json:"final_text"`
+}
+
+type replySettingsRequest struct {
+	Tone       string `json:"tone"`
+	Guidelines string `json:"guidelines"`
+}
+
+var rewriteModes = map[string]bool{
+	llm.RewriteShorter:    true,
+	llm.RewriteFriendlier: true,
+	llm.RewriteFormal:     true,
+	llm.RewriteTranslate:  true,
+}
+
+// DraftReply suggests one to three replies for a conversation, or rewrites
+// the agent's draft when one is given. The draft is stored so the agent's
+// choice can be recorded with ReplyDraftFeedback or when replying.
+func (a *API) DraftReply(w http.ResponseWriter, r *http.Request, conversationID int64) {
+	var req draftReplyRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	req.Draft = strings.TrimSpace(req.Draft)
+	switch {
+	case req.Count < 0 || req.Count > llm.MaxDraftSuggestions:
+		writeError(w, http.StatusBadRequest, "count must be between 1 and 3")
+		return
+	case len(req.Draft) > maxDraftLength || len(req.Tone) > maxDraftLength || len(req.Guidelines) > maxDraftLength:
+		writeError(w, http.StatusBadRequest, "draft, tone or guidelines is too long")
+		return
+	case req.Draft != "" && !rewriteModes[req.Rewrite]:
+		writeError(w, http.StatusBadRequest, "rewrite must be shorter, friendlier, formal or translate")
+		return
+	case req.Draft == "" && req.Rewrite != "":
+		writeError(w, http.StatusBadRequest, "rewrite needs a draft")
+		return
+	case req.Rewrite == llm.RewriteTranslate && strings.TrimSpace(req.Language) == "":
+		writeError(w, http.StatusBadRequest, "language is required to translate")
+		return
+	}
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+
+	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		var id int64
+		return conn.QueryRow(ctx, `SELECT id FROM conversations WHERE tenant_id=$1 AND id=$2`, tenantID, conversationID).Scan(&id)
+	}); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "conversation not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to load conversation")
+		return
+	}
+
+	draft, err := a.LLM.DraftReply(ctx, tenantID, conversationID, llm.DraftOptions{
+		ProviderID: req.ProviderID,
+		Count:      req.Count,
+		Tone:       strings.TrimSpace(req.Tone),
+		Guidelines: strings.TrimSpace(req.Guidelines),
+		Draft:      req.Draft,
+		Rewrite:    req.Rewrite,
+		Language:   strings.TrimSpace(req.Language),
+		CreatedBy:  authUserIDPtr(r),
+	})
+	switch {
+	case errors.Is(err, llm.ErrEmptyConversation):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, llm.ErrNoProviders), errors.Is(err, llm.ErrDraftUnsupported):
+		writeError(w, http.StatusServiceUnavailable, "no provider can draft replies")
+		return
+	case err != nil:
+		writeError(w, http.StatusBadGateway, "failed to draft reply: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"data": draft})
+}
+
+// ReplyDraftFeedback records whether the agent accepted one of a draft's
+// suggestions or rejected them all.
+func (a *API) ReplyDraftFeedback(w http.ResponseWriter, r *http.Request, draftID int64) {
+	var req draftFeedbackRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if req.Status != llm.DraftAccepted && req.Status != llm.DraftRejected {
+		writeError(w, http.StatusBadRequest, "status must be accepted or rejected")
+		return
+	}
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	draft, err := a.LLMStore.DecideReplyDraft(ctx, tenantID, draftID, req.Status, req.Index, req.FinalText, authUserIDPtr(r))
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		writeError(w, http.StatusNotFound, "draft not found")
+		return
+	case errors.Is(err, llm.ErrDraftIndex):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, llm.ErrDraftDecided):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to record feedback")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": draft})
+}
+
+func (a *API) GetReplySettings(w http.ResponseWriter, r *http.Request) {
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	settings, err := a.LLMStore.ReplySettings(ctx, tenantID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load reply settings")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": settings})
+}
+
+func (a *API) UpdateReplySettings(w http.ResponseWriter, r *http.Request) {
+	var req replySettingsRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	settings := llm.ReplySettings{Tone: strings.TrimSpace(req.Tone), Guidelines: strings.TrimSpace(req.Guidelines)}
+	if len(settings.Tone) > maxDraftLength || len(settings.Guidelines) > maxDraftLength {
+		writeError(w, http.StatusBadRequest, "tone or guidelines is too long")
+		return
+	}
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	before, _ := a.LLMStore.ReplySettings(ctx, tenantID)
+	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		_, err := conn.Exec(ctx, `
+			INSERT INTO llm_prompt_settings (tenant_id, reply_tone, reply_guidelines, updated_at)
+			VALUES ($1,$2,$3,$4)
+			ON CONFLICT (tenant_id) DO UPDATE
+			SET reply_tone=EXCLUDED.reply_tone, reply_guidelines=EXCLUDED.reply_guidelines, updated_at=EXCLUDED.updated_at`,
+			tenantID, settings.Tone, settings.Guidelines, time.Now().UTC())
+		return err
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update reply settings")
+		return
+	}
+	a.logAudit(ctx, r, tenantID, authUserIDPtr(r), "llm.reply_settings.update", stringPtr("llm_prompt_settings"), nil, before, settings)
+	writeJSON(w, http.StatusOK, map[string]any{"data": settings})
+}
+
+// GetReplyDraftStats reports per provider how many drafts agents accepted,
+// rejected or left undecided, and how often an accepted draft was edited
+// before it was sent.
+func (a *API) GetReplyDraftStats(w http.ResponseWriter, r *http.Request) {
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	data := []map[string]any{}
+	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			SELECT d.provider_id, COALESCE(p.provider_name, ''), d.model, d.mode,
+			       COUNT(*),
+			       COUNT(*) FILTER (WHERE d.status='accepted'),
+			       COUNT(*) FILTER (WHERE d.status='rejected'),
+			       COUNT(*) FILTER (WHERE d.status='accepted' AND d.edited)
+			FROM reply_drafts d
+			LEFT JOIN llm_providers p ON p.id = d.provider_id
+			WHERE d.tenant_id=$1
+			GROUP BY d.provider_id, p.provider_name, d.model, d.mode
+			ORDER BY d.provider_id, d.model, d.mode`, tenantID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var providerID *int64
+			var provider, model, mode string
+			var drafts, accepted, rejected, edited int64
+			if err := rows.Scan(&providerID, &provider, &model, &mode, &drafts, &accepted, &rejected, &edited); err != nil {
+				return err
+			}
+			acceptanceRate := 0.0
+			if decided := accepted + rejected; decided > 0 {
+				acceptanceRate = (float64(accepted) / float64(decided)) * 100
+			}
+			editRate := 0.0
+			if accepted > 0 {
+				editRate = (float64(edited) / float64(accepted)) * 100
+			}
+			data = append(data, map[string]any{
+				"provider_id": providerID,
+				"provider":    provider,
+				"model":       model,
+				"mode":        mode,
+				"drafts":      drafts,
+				"accepted":    accepted,
+				"rejected":    rejected,
+				"pending":     drafts - accepted - rejected,
+				"edited":      edited,
+				// Of the drafts an agent decided on; pending ones are left out.
+				"acceptance_rate": acceptanceRate,
+				"edit_rate":       editRate,
+			})
+		}
+		return rows.Err()
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load reply draft stats")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": data})
+}
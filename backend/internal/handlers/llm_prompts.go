@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"message-flow/backend/internal/llm"
+)
+
+var errPromptPinned = errors.New("prompt version is pinned")
+
+const promptColumns = `id, feature_name, version, template, description, created_by, created_at`
+
+type promptRequest struct {
+	FeatureName string `json:"feature_name"`
+	Template    string `json:"template"`
+	Description string `json:"description"`
+	Pin         bool   `json:"pin"`
+}
+
+type promptPreviewRequest struct {
+	FeatureName string   `json:"feature_name"`
+	Template    string   `json:"template"`
+	ID          *int64   `json:"id"`
+	Message     string   `json:"message"`
+	Messages    []string `json:"messages"`
+	ContactName string   `json:"contact_name"`
+}
+
+type glossaryRequest struct {
+	Glossary string `json:"glossary"`
+}
+
+func scanPrompt(row pgx.Row, prompt *llm.PromptTemplate) error {
+	return row.Scan(&prompt.ID, &prompt.FeatureName, &prompt.Version, &prompt.Template, &prompt.Description, &prompt.CreatedBy, &prompt.CreatedAt)
+}
+
+// ListPrompts lists every template version, newest first, marking the pinned
+// version and the one each feature currently uses.
+func (a *API) ListPrompts(w http.ResponseWriter, r *http.Request) {
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	feature := r.URL.Query().Get("feature")
+	data := []map[string]any{}
+	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		pins := map[string]int{}
+		pinRows, err := conn.Query(ctx, `SELECT feature_name, version FROM llm_prompt_pins WHERE tenant_id=$1`, tenantID)
+		if err != nil {
+			return err
+		}
+		for pinRows.Next() {
+			var name string
+			var version int
+			if err := pinRows.Scan(&name, &version); err != nil {
+				pinRows.Close()
+				return err
+			}
+			pins[name] = version
+		}
+		pinRows.Close()
+		if err := pinRows.Err(); err != nil {
+			return err
+		}
+
+		rows, err := conn.Query(ctx, `
+			SELECT `+promptColumns+`
+			FROM llm_prompt_templates
+			WHERE tenant_id=$1 AND ($2 = '' OR feature_name=$2)
+			ORDER BY feature_name, version DESC`, tenantID, feature)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		seen := map[string]bool{}
+		for rows.Next() {
+			var prompt llm.PromptTemplate
+			if err := scanPrompt(rows, &prompt); err != nil {
+				return err
+			}
+			pinned, hasPin := pins[prompt.FeatureName]
+			active := pinned == prompt.Version
+			if !hasPin {
+				// Without a pin the newest version, listed first, is used.
+				active = !seen[prompt.FeatureName]
+			}
+			seen[prompt.FeatureName] = true
+			data = append(data, map[string]any{
+				"id":           prompt.ID,
+				"feature_name": prompt.FeatureName,
+				"version":      prompt.Version,
+				"template":     prompt.Template,
+				"description":  prompt.Description,
+				"created_by":   prompt.CreatedBy,
+				"created_at":   prompt.CreatedAt,
+				"pinned":       hasPin && active,
+				"active":       active,
+			})
+		}
+		return rows.Err()
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load prompts")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": data})
+}
+
+// CreatePrompt stores a template as the next version for its feature.
+func (a *API) CreatePrompt(w http.ResponseWriter, r *http.Request) {
+	var req promptRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if _, err := llm.ParsePromptTemplate(req.FeatureName, req.Template); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid template: "+err.Error())
+		return
+	}
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var prompt llm.PromptTemplate
+	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		// Concurrent creates for a feature would pick the same next version,
+		// so they take turns; the pin commits with the version or not at all.
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('llm_prompt_templates:' || $1::text || ':' || $2))`, tenantID, req.FeatureName); err != nil {
+			return err
+		}
+		row := tx.QueryRow(ctx, `
+			INSERT INTO llm_prompt_templates (tenant_id, feature_name, version, template, description, created_by, created_at)
+			SELECT $1, $2, COALESCE(MAX(version), 0) + 1, $3, $4, $5, $6
+			FROM llm_prompt_templates
+			WHERE tenant_id=$1 AND feature_name=$2
+			RETURNING `+promptColumns, tenantID, req.FeatureName, req.Template, req.Description, authUserIDPtr(r), time.Now().UTC())
+		if err := scanPrompt(row, &prompt); err != nil {
+			return err
+		}
+		if req.Pin {
+			if err := pinPrompt(ctx, tx, tenantID, prompt.FeatureName, prompt.Version); err != nil {
+				return err
+			}
+		}
+		return tx.Commit(ctx)
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to create prompt")
+		return
+	}
+	a.LLM.InvalidatePrompts(tenantID)
+	a.logAudit(ctx, r, tenantID, authUserIDPtr(r), "llm.prompt.create", stringPtr("llm_prompt"), &prompt.ID, nil, map[string]any{
+		"feature_name": prompt.FeatureName,
+		"version":      prompt.Version,
+		"pinned":       req.Pin,
+	})
+	writeJSON(w, http.StatusCreated, map[string]any{"data": prompt})
+}
+
+func (a *API) GetPrompt(w http.ResponseWriter, r *http.Request, promptID int64) {
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	prompt, err := a.loadPrompt(ctx, tenantID, promptID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "prompt not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": prompt})
+}
+
+// DeletePrompt removes a version unless it is pinned; unpin it first.
+func (a *API) DeletePrompt(w http.ResponseWriter, r *http.Request, promptID int64) {
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var prompt llm.PromptTemplate
+	err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		row := conn.QueryRow(ctx, `SELECT `+promptColumns+` FROM llm_prompt_templates WHERE tenant_id=$1 AND id=$2`, tenantID, promptID)
+		if err := scanPrompt(row, &prompt); err != nil {
+			return errNotFound
+		}
+		var pinned bool
+		if err := conn.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM llm_prompt_pins WHERE tenant_id=$1 AND feature_name=$2 AND version=$3)`,
+			tenantID, prompt.FeatureName, prompt.Version).Scan(&pinned); err != nil {
+			return err
+		}
+		if pinned {
+			return errPromptPinned
+		}
+		_, err := conn.Exec(ctx, `DELETE FROM llm_prompt_templates WHERE tenant_id=$1 AND id=$2`, tenantID, promptID)
+		return err
+	})
+	switch {
+	case errors.Is(err, errNotFound):
+		writeError(w, http.StatusNotFound, "prompt not found")
+		return
+	case errors.Is(err, errPromptPinned):
+		writeError(w, http.StatusConflict, "prompt version is pinned")
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, "failed to delete prompt")
+		return
+	}
+	a.LLM.InvalidatePrompts(tenantID)
+	a.logAudit(ctx, r, tenantID, authUserIDPtr(r), "llm.prompt.delete", stringPtr("llm_prompt"), &promptID, map[string]any{
+		"feature_name": prompt.FeatureName,
+		"version":      prompt.Version,
+	}, nil)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// PinPrompt makes a feature use this version until it is unpinned, even
+// when newer versions are added.
+func (a *API) PinPrompt(w http.ResponseWriter, r *http.Request, promptID int64) {
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var prompt llm.PromptTemplate
+	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		row := conn.QueryRow(ctx, `SELECT `+promptColumns+` FROM llm_prompt_templates WHERE tenant_id=$1 AND id=$2`, tenantID, promptID)
+		if err := scanPrompt(row, &prompt); err != nil {
+			return err
+		}
+		return pinPrompt(ctx, conn, tenantID, prompt.FeatureName, prompt.Version)
+	}); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "prompt not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to pin prompt")
+		return
+	}
+	a.LLM.InvalidatePrompts(tenantID)
+	a.logAudit(ctx, r, tenantID, authUserIDPtr(r), "llm.prompt.pin", stringPtr("llm_prompt"), &promptID, nil, map[string]any{
+		"feature_name": prompt.FeatureName,
+		"version":      prompt.Version,
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{"feature_name": prompt.FeatureName, "version": prompt.Version}})
+}
+
+// UnpinPrompt returns a feature to its newest version.
+func (a *API) UnpinPrompt(w http.ResponseWriter, r *http.Request, feature string) {
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		command, err := conn.Exec(ctx, `DELETE FROM llm_prompt_pins WHERE tenant_id=$1 AND feature_name=$2`, tenantID, feature)
+		if err != nil {
+			return err
+		}
+		if command.RowsAffected() == 0 {
+			return errNotFound
+		}
+		return nil
+	}); err != nil {
+		writeError(w, http.StatusNotFound, "pin not found")
+		return
+	}
+	a.LLM.InvalidatePrompts(tenantID)
+	a.logAudit(ctx, r, tenantID, authUserIDPtr(r), "llm.prompt.unpin", stringPtr("llm_prompt"), nil, nil, map[string]any{
+		"feature_name": feature,
+	})
+	writeJSON(w, http.StatusOK, map[string]string{"status": "unpinned"})
+}
+
+// PreviewPrompt renders a template without calling a provider. It renders
+// the template in the body, else the version given by id, else the version
+// the feature currently uses. Missing inputs are filled from sample data.
+func (a *API) PreviewPrompt(w http.ResponseWriter, r *http.Request) {
+	var req promptPreviewRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	version := 0
+	text := req.Template
+	if text == "" {
+		var prompt *llm.PromptTemplate
+		var err error
+		if req.ID != nil {
+			prompt, err = a.loadPrompt(ctx, tenantID, *req.ID)
+		} else {
+			prompt, err = a.LLMStore.ActivePrompt(ctx, tenantID, req.FeatureName)
+			if err == nil && prompt == nil {
+				err = errNotFound
+			}
+		}
+		if err != nil {
+			writeError(w, http.StatusNotFound, "prompt not found")
+			return
+		}
+		req.FeatureName = prompt.FeatureName
+		version = prompt.Version
+		text = prompt.Template
+	}
+
+	glossary, err := a.LLMStore.PromptGlossary(ctx, tenantID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load glossary")
+		return
+	}
+	data := llm.SamplePromptData(req.FeatureName)
+	data.Glossary = glossary
+	if req.Message != "" {
+		data.Message = req.Message
+	}
+	if len(req.Messages) > 0 {
+		data.Messages = req.Messages
+		data.Transcript = strings.Join(req.Messages, "\n")
+	}
+	if req.ContactName != "" {
+		data.ContactName = req.ContactName
+	}
+	rendered, err := llm.RenderPrompt(req.FeatureName, text, data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid template: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{
+		"feature_name": req.FeatureName,
+		"version":      version,
+		"prompt":       rendered,
+	}})
+}
+
+func (a *API) GetPromptGlossary(w http.ResponseWriter, r *http.Request) {
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	glossary, err := a.LLMStore.PromptGlossary(ctx, tenantID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load glossary")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{"glossary": glossary}})
+}
+
+func (a *API) UpdatePromptGlossary(w http.ResponseWriter, r *http.Request) {
+	var req glossaryRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	before, _ := a.LLMStore.PromptGlossary(ctx, tenantID)
+	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		_, err := conn.Exec(ctx, `
+			INSERT INTO llm_prompt_settings (tenant_id, glossary, updated_at)
+			VALUES ($1,$2,$3)
+			ON CONFLICT (tenant_id) DO UPDATE SET glossary=EXCLUDED.glossary, updated_at=EXCLUDED.updated_at`,
+			tenantID, req.Glossary, time.Now().UTC())
+		return err
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update glossary")
+		return
+	}
+	a.LLM.InvalidatePrompts(tenantID)
+	a.logAudit(ctx, r, tenantID, authUserIDPtr(r), "llm.prompt.glossary", stringPtr("llm_prompt_settings"), nil,
+		map[string]any{"glossary": before}, map[string]any{"glossary": req.Glossary})
+	writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{"glossary": req.Glossary}})
+}
+
+func (a *API) loadPrompt(ctx context.Context, tenantID, promptID int64) (*llm.PromptTemplate, error) {
+	var prompt llm.PromptTemplate
+	err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		row := conn.QueryRow(ctx, `SELECT `+promptColumns+` FROM llm_prompt_templates WHERE tenant_id=$1 AND id=$2`, tenantID, promptID)
+		return scanPrompt(row, &prompt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &prompt, nil
+}
+
+// execer runs a statement on a connection or in a transaction.
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+func pinPrompt(ctx context.Context, conn execer, tenantID int64, feature string, version int) error {
+	_, err := conn.Exec(ctx, `
+		INSERT INTO llm_prompt_pins (tenant_id, feature_name, version, updated_at)
+		VALUES ($1,$2,$3,$4)
+		ON CONFLICT (tenant_id, feature_name) DO UPDATE SET version=EXCLUDED.version, updated_at=EXCLUDED.updated_at`,
+		tenantID, feature, version, time.Now().UTC())
+	return err
+}
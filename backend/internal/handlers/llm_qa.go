@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"message-flow/backend/internal/llm"
+)
+
+// maxQuestionLength bounds questions, which are sent to the provider as is.
+const maxQuestionLength = 1000
+
+type askRequest struct {
+	Question   string `json:"question"`
+	ProviderID int64  `json:"provider_id"`
+}
+
+// AskConversation answers a question about a conversation from its messages,
+// action items and summaries. The answer cites the IDs of the messages it is
+// based on, which are returned as sources.
+func (a *API) AskConversation(w http.ResponseWriter, r *http.Request, conversationID int64) {
+	var req askRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	req.Question = strings.TrimSpace(req.Question)
+	if req.Question == "" {
+		writeError(w, http.StatusBadRequest, "question is required")
+		return
+	}
+	if len(req.Question) > maxQuestionLength {
+		writeError(w, http.StatusBadRequest, "question is too long")
+		return
+	}
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+
+	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		var id int64
+		return conn.QueryRow(ctx, `SELECT id FROM conversations WHERE tenant_id=$1 AND id=$2`, tenantID, conversationID).Scan(&id)
+	}); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "conversation not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to load conversation")
+		return
+	}
+
+	answer, err := a.LLM.AskConversation(ctx, tenantID, conversationID, req.ProviderID, req.Question)
+	switch {
+	case errors.Is(err, llm.ErrEmptyConversation):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, llm.ErrNoProviders):
+		writeError(w, http.StatusServiceUnavailable, "no provider is configured")
+		return
+	case err != nil:
+		writeError(w, http.StatusBadGateway, "failed to answer: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": answer})
+}
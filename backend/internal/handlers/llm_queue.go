@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (a *API) GetQueueStats(w http.ResponseWriter, r *http.Request) {
+	if a.Queue == nil {
+		writeError(w, http.StatusServiceUnavailable, "queue not configured")
+		return
+	}
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	stats, err := a.Queue.Stats(ctx, tenantID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load queue stats")
+		return
+	}
+	var oldestAge float64
+	if stats.OldestEnqueuedAt != nil {
+		oldestAge = time.Since(*stats.OldestEnqueuedAt).Seconds()
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{
+		"backend":               stats.Backend,
+		"depth":                 stats.Depth(),
+		"ready":                 stats.Ready,
+		"scheduled_retries":     stats.Scheduled,
+		"in_flight":             stats.InFlight,
+		"dead_letters":          stats.DeadLetters,
+		"processed_last_hour":   stats.ProcessedLastHour,
+		"throughput_per_minute": float64(stats.ProcessedLastHour) / 60,
+		"oldest_enqueued_at":    stats.OldestEnqueuedAt,
+		"oldest_age_seconds":    oldestAge,
+	}})
+}
+
+func (a *API) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if a.Queue == nil {
+		writeError(w, http.StatusServiceUnavailable, "queue not configured")
+		return
+	}
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 && parsed <= 500 {
+			limit = parsed
+		}
+	}
+	letters, err := a.Queue.DeadLetters(ctx, tenantID, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load dead letters")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": letters})
+}
+
+// ReplayDeadLetters and PurgeDeadLetters take either {"ids": [...]} or
+// {"all": true}.
+func (a *API) ReplayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	ids, ok := readDeadLetterSelection(w, r)
+	if !ok {
+		return
+	}
+	a.replayDeadLetters(w, r, ids)
+}
+
+func (a *API) ReplayDeadLetter(w http.ResponseWriter, r *http.Request, id string) {
+	a.replayDeadLetters(w, r, []string{id})
+}
+
+func (a *API) PurgeDeadLetters(w http.ResponseWriter, r *http.Request) {
+	ids, ok := readDeadLetterSelection(w, r)
+	if !ok {
+		return
+	}
+	a.purgeDeadLetters(w, r, ids)
+}
+
+func (a *API) PurgeDeadLetter(w http.ResponseWriter, r *http.Request, id string) {
+	a.purgeDeadLetters(w, r, []string{id})
+}
+
+func readDeadLetterSelection(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	var req struct {
+		IDs []string `json:"ids"`
+		All bool     `json:"all"`
+	}
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid payload")
+		return nil, false
+	}
+	if req.All {
+		return nil, true
+	}
+	if len(req.IDs) == 0 {
+		writeError(w, http.StatusBadRequest, "ids or all is required")
+		return nil, false
+	}
+	return req.IDs, true
+}
+
+func (a *API) replayDeadLetters(w http.ResponseWriter, r *http.Request, ids []string) {
+	if a.Queue == nil {
+		writeError(w, http.StatusServiceUnavailable, "queue not configured")
+		return
+	}
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	replayed, err := a.Queue.Replay(ctx, tenantID, ids)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to replay dead letters")
+		return
+	}
+	if replayed > 0 && a.WorkerScheduler != nil {
+		a.WorkerScheduler.EnsureTenant(context.Background(), tenantID)
+	}
+	a.logAudit(ctx, r, tenantID, authUserIDPtr(r), "llm.queue.replay", stringPtr("dead_letter"), nil, nil, map[string]any{
+		"ids":      ids,
+		"replayed": replayed,
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{"replayed": replayed}})
+}
+
+func (a *API) purgeDeadLetters(w http.ResponseWriter, r *http.Request, ids []string) {
+	if a.Queue == nil {
+		writeError(w, http.StatusServiceUnavailable, "queue not configured")
+		return
+	}
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	purged, err := a.Queue.Purge(ctx, tenantID, ids)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to purge dead letters")
+		return
+	}
+	a.logAudit(ctx, r, tenantID, authUserIDPtr(r), "llm.queue.purge", stringPtr("dead_letter"), nil, nil, map[string]any{
+		"ids":    ids,
+		"purged": purged,
+	})
+	writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{"purged": purged}})
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"message-flow/backend/internal/llm"
+)
+
+// maxRedactionPreview bounds the sample text of a redaction preview.
+const maxRedactionPreview = 10000
+
+type redactionPreviewRequest struct {
+	Text string `json:"text"`
+	// Settings to try instead of the saved ones.
+	Settings *llm.RedactionSettings `json:"settings"`
+}
+
+func (a *API) GetRedactionSettings(w http.ResponseWriter, r *http.Request) {
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	settings, err := a.LLMStore.RedactionSettings(ctx, tenantID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load redaction settings")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": settings})
+}
+
+func (a *API) UpdateRedactionSettings(w http.ResponseWriter, r *http.Request) {
+	var req llm.RedactionSettings
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if req.Detectors == nil {
+		req.Detectors = []string{}
+	}
+	if req.CustomPatterns == nil {
+		req.CustomPatterns = []llm.CustomPattern{}
+	}
+	if err := llm.ValidateRedactionSettings(req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	before, _ := a.LLMStore.RedactionSettings(ctx, tenantID)
+	if err := a.LLMStore.SaveRedactionSettings(ctx, tenantID, req); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update redaction settings")
+		return
+	}
+	a.LLM.InvalidateRedaction(tenantID)
+	a.logAudit(ctx, r, tenantID, authUserIDPtr(r), "llm.redaction.update", stringPtr("llm_redaction_settings"), nil, before, req)
+	writeJSON(w, http.StatusOK, map[string]any{"data": req})
+}
+
+// PreviewRedaction shows what would be sent to providers for a sample text,
+// with the saved settings or the ones given. Nothing is sent or logged.
+func (a *API) PreviewRedaction(w http.ResponseWriter, r *http.Request) {
+	var req redactionPreviewRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if req.Text == "" || len(req.Text) > maxRedactionPreview {
+		writeError(w, http.StatusBadRequest, "text is required and must be at most 10000 bytes")
+		return
+	}
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var settings llm.RedactionSettings
+	if req.Settings != nil {
+		settings = *req.Settings
+		// A preview of settings being edited shows their effect even before
+		// redaction is turned on.
+		settings.Enabled = true
+	} else {
+		var err error
+		if settings, err = a.LLMStore.RedactionSettings(ctx, tenantID); err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to load redaction settings")
+			return
+		}
+	}
+	redactor, err := llm.NewRedactor(settings)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	redaction := redactor.Start()
+	redacted := redaction.Redact(req.Text)
+	writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{
+		"enabled":      redactor != nil,
+		"redacted":     redacted,
+		"counts":       redaction.Counts(),
+		"placeholders": redaction.Placeholders(),
+	}})
+}
+
+// ListRedactionLogs returns the latest redaction audit entries, optionally
+// for one message.
+func (a *API) ListRedactionLogs(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 && parsed <= 500 {
+			limit = parsed
+		}
+	}
+	var messageID *int64
+	if value := r.URL.Query().Get("message_id"); value != "" {
+		id, ok := ParseID(value)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "invalid message_id")
+			return
+		}
+		messageID = &id
+	}
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	items := []map[string]any{}
+	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			SELECT id, message_id, feature_used, counts, placeholders, created_at
+			FROM llm_redaction_logs
+			WHERE tenant_id=$1 AND ($2::BIGINT IS NULL OR message_id = $2)
+			ORDER BY created_at DESC
+			LIMIT $3`, tenantID, messageID, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			var message *int64
+			var feature string
+			var counts map[string]int
+			var placeholders []string
+			var createdAt time.Time
+			if err := rows.Scan(&id, &message, &feature, &counts, &placeholders, &createdAt); err != nil {
+				return err
+			}
+			items = append(items, map[string]any{
+				"id":           id,
+				"message_id":   message,
+				"feature":      feature,
+				"counts":       counts,
+				"placeholders": placeholders,
+				"created_at":   createdAt,
+			})
+		}
+		return rows.Err()
+	}); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load redaction logs")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": items})
+}
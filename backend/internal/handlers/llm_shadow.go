@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"message-flow/backend/internal/llm"
+)
+
+type shadowConfigRequest struct {
+	ProviderID    int64   `json:"provider_id"`
+	SamplePercent float64 `json:"sample_percent"`
+	Enabled       *bool   `json:"enabled"`
+}
+
+func (a *API) ListShadowConfigs(w http.ResponseWriter, r *http.Request) {
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	configs, err := a.LLMStore.ShadowConfigs(ctx, tenantID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load shadow settings")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": configs})
+}
+
+// UpdateShadowConfig starts or changes shadowing of a feature: a share of its
+// production calls is repeated on the given provider for comparison.
+func (a *API) UpdateShadowConfig(w http.ResponseWriter, r *http.Request, feature string) {
+	if !llm.IsShadowFeature(feature) {
+		writeError(w, http.StatusBadRequest, "only importance_detection and summarization can be shadowed")
+		return
+	}
+	var req shadowConfigRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if req.ProviderID == 0 {
+		writeError(w, http.StatusBadRequest, "provider_id required")
+		return
+	}
+	if req.SamplePercent < 0 || req.SamplePercent > 100 {
+		writeError(w, http.StatusBadRequest, "sample_percent must be between 0 and 100")
+		return
+	}
+	config := llm.ShadowConfig{FeatureName: feature, ProviderID: req.ProviderID, SamplePercent: req.SamplePercent, Enabled: true}
+	if req.Enabled != nil {
+		config.Enabled = *req.Enabled
+	}
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.Store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		var id int64
+		return conn.QueryRow(ctx, `SELECT id FROM llm_providers WHERE tenant_id=$1 AND id=$2`, tenantID, req.ProviderID).Scan(&id)
+	}); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusBadRequest, "provider not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to load provider")
+		return
+	}
+
+	var before any
+	if configs, err := a.LLMStore.ShadowConfigs(ctx, tenantID); err == nil {
+		for _, existing := range configs {
+			if existing.FeatureName == feature {
+				before = existing
+			}
+		}
+	}
+	if err := a.LLMStore.SaveShadowConfig(ctx, tenantID, &config); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update shadow settings")
+		return
+	}
+	a.LLM.InvalidateShadows(tenantID)
+	a.logAudit(ctx, r, tenantID, authUserIDPtr(r), "llm.shadow.update", stringPtr("llm_shadow_configs"), nil, before, config)
+	writeJSON(w, http.StatusOK, map[string]any{"data": config})
+}
+
+// DeleteShadowConfig stops shadowing a feature. Its results are kept.
+func (a *API) DeleteShadowConfig(w http.ResponseWriter, r *http.Request, feature string) {
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := a.LLMStore.DeleteShadowConfig(ctx, tenantID, feature); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "shadow settings not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "failed to delete shadow settings")
+		return
+	}
+	a.LLM.InvalidateShadows(tenantID)
+	a.logAudit(ctx, r, tenantID, authUserIDPtr(r), "llm.shadow.delete", stringPtr("llm_shadow_configs"), nil, map[string]string{"feature_name": feature}, nil)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// ListShadowResults returns the latest production outputs next to their
+// shadows, optionally for one feature.
+func (a *API) ListShadowResults(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 && parsed <= 500 {
+			limit = parsed
+		}
+	}
+	tenantID := a.tenantID(r)
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	results, err := a.LLMStore.ShadowResults(ctx, tenantID, r.URL.Query().Get("feature"), limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to load shadow results")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": results})
+}
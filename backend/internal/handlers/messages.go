@@ -8,19 +8,29 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"message-flow/backend/internal/auth"
+	"message-flow/backend/internal/llm"
 	"message-flow/backend/internal/models"
 )
 
+// outboundSender is the stored sender of replies and forwards.
+const outboundSender = "agent"
+
 type replyRequest struct {
 	ConversationID int64  `json:"conversation_id"`
 	Content        string `json:"content"`
-	Sender         string `json:"sender"`
+	// Sender is accepted for older clients and ignored.
+	Sender string `json:"sender"`
+	// DraftID and DraftIndex name the drafted suggestion the reply is based
+	// on; sending it records the draft as accepted.
+	DraftID    *int64 `json:"draft_id"`
+	DraftIndex *int   `json:"draft_index"`
 }
 
 type forwardRequest struct {
-	MessageID            int64  `json:"message_id"`
-	TargetConversationID int64  `json:"target_conversation_id"`
-	Sender               string `json:"sender"`
+	MessageID            int64 `json:"message_id"`
+	TargetConversationID int64 `json:"target_conversation_id"`
+	// Sender is accepted for older clients and ignored.
+	Sender string `json:"sender"`
 }
 
 func (a *API) ReplyMessage(w http.ResponseWriter, r *http.Request) {
@@ -33,10 +43,9 @@ func (a *API) ReplyMessage(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "conversation_id and content are required")
 		return
 	}
-	sender := req.Sender
-	if sender == "" {
-		sender = "agent"
-	}
+	// The tenant sends replies and forwards, whatever sender the client
+	// names; scoring and digests tell outbound messages by their sender.
+	sender := outboundSender
 
 	tenantID := a.tenantID(r)
 	now := time.Now().UTC()
@@ -86,6 +95,17 @@ func (a *API) ReplyMessage(w http.ResponseWriter, r *http.Request) {
 		return err
 	})
 
+	a.enqueueEmbedding(ctx, tenantID, message.ID, message.Content)
+	a.refreshScore(ctx, tenantID, req.ConversationID)
+	if req.DraftID != nil && a.LLMStore != nil {
+		index := 0
+		if req.DraftIndex != nil {
+			index = *req.DraftIndex
+		}
+		// Best effort: the reply is sent either way.
+		_, _ = a.LLMStore.DecideReplyDraft(ctx, tenantID, *req.DraftID, llm.DraftAccepted, &index, &req.Content, authUserIDPtr(r))
+	}
+
 	if user, ok := auth.UserFromContext(r.Context()); ok {
 		a.logActivity(ctx, tenantID, user, "message.reply", map[string]any{
 			"conversation_id": req.ConversationID,
@@ -112,10 +132,9 @@ func (a *API) ForwardMessage(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "message_id and target_conversation_id are required")
 		return
 	}
-	sender := req.Sender
-	if sender == "" {
-		sender = "agent"
-	}
+	// The tenant sends replies and forwards, whatever sender the client
+	// names; scoring and digests tell outbound messages by their sender.
+	sender := outboundSender
 
 	tenantID := a.tenantID(r)
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
@@ -153,6 +172,9 @@ func (a *API) ForwardMessage(w http.ResponseWriter, r *http.Request) {
 		return err
 	})
 
+	a.enqueueEmbedding(ctx, tenantID, message.ID, message.Content)
+	a.refreshScore(ctx, tenantID, req.TargetConversationID)
+
 	if user, ok := auth.UserFromContext(r.Context()); ok {
 		a.logActivity(ctx, tenantID, user, "message.forward", map[string]any{
 			"source_message_id": req.MessageID,
@@ -169,3 +191,14 @@ func (a *API) ForwardMessage(w http.ResponseWriter, r *http.Request) {
 
 	writeJSON(w, http.StatusCreated, message)
 }
+
+// enqueueEmbedding queues a message for semantic search indexing.
+func (a *API) enqueueEmbedding(ctx context.Context, tenantID, messageID int64, content string) {
+	if a.Queue == nil {
+		return
+	}
+	_ = a.Queue.Enqueue(ctx, llm.QueueMessage{TenantID: tenantID, MessageID: messageID, Content: content, Feature: llm.QueueFeatureEmbedding, CreatedAt: time.Now().UTC()})
+	if a.WorkerScheduler != nil {
+		a.WorkerScheduler.EnsureTenant(context.Background(), tenantID)
+	}
+}
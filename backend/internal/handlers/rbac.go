@@ -84,6 +84,17 @@ func RequiredRole(path, method string) string {
 		return roleViewer
 	case strings.HasPrefix(path, "/api/v1/conversations/") && strings.HasSuffix(path, "/messages"):
 		return roleViewer
+	case strings.HasPrefix(path, "/api/v1/conversations/") && strings.HasSuffix(path, "/ask"):
+		return roleMember
+	case strings.HasPrefix(path, "/api/v1/conversations/") && strings.HasSuffix(path, "/draft-reply"):
+		return roleMember
+	case strings.HasPrefix(path, "/api/v1/conversations/") && strings.HasSuffix(path, "/score"):
+		if method == http.MethodGet {
+			return roleViewer
+		}
+		return roleMember
+	case strings.HasPrefix(path, "/api/v1/reply-drafts/"):
+		return roleMember
 	case path == "/api/v1/messages/reply":
 		return roleMember
 	case path == "/api/v1/messages/forward":
@@ -92,6 +103,8 @@ func RequiredRole(path, method string) string {
 		return roleViewer
 	case strings.HasPrefix(path, "/api/v1/messages/") && strings.HasSuffix(path, "/labels"):
 		return roleMember
+	case strings.HasPrefix(path, "/api/v1/messages/") && strings.HasSuffix(path, "/analysis"):
+		return roleMember
 	case path == "/api/v1/action-items":
 		if method == http.MethodGet {
 			return roleViewer
@@ -106,6 +119,17 @@ func RequiredRole(path, method string) string {
 		return roleManager
 	case path == "/api/v1/daily-summary":
 		return roleViewer
+	case path == "/api/v1/daily-summary/history":
+		return roleViewer
+	case path == "/api/v1/daily-summary/settings":
+		if method == http.MethodGet {
+			return roleManager
+		}
+		return roleAdmin
+	case path == "/api/v1/daily-summary/generate":
+		return roleManager
+	case path == "/api/v1/search/semantic":
+		return roleViewer
 	case path == "/api/v1/conversations/summarize":
 		return roleMember
 	case path == "/api/v1/llm/providers":
@@ -122,16 +146,50 @@ func RequiredRole(path, method string) string {
 		return roleManager
 	case path == "/api/v1/llm/analytics/usage-by-feature":
 		return roleManager
+	case path == "/api/v1/llm/analytics/reply-drafts":
+		return roleManager
+	case path == "/api/v1/llm/analytics/corrections":
+		return roleManager
+	case path == "/api/v1/llm/few-shot":
+		if method == http.MethodGet {
+			return roleManager
+		}
+		return roleAdmin
+	case path == "/api/v1/llm/redaction/preview":
+		return roleManager
+	case path == "/api/v1/llm/redaction/logs":
+		return roleAdmin
+	case path == "/api/v1/llm/redaction":
+		if method == http.MethodGet {
+			return roleManager
+		}
+		return roleAdmin
+	case path == "/api/v1/llm/shadow", strings.HasPrefix(path, "/api/v1/llm/shadow/"):
+		return roleAdmin
+	case path == "/api/v1/llm/reply-settings":
+		if method == http.MethodGet {
+			return roleManager
+		}
+		return roleAdmin
 	case path == "/api/v1/llm/health":
 		return roleManager
 	case path == "/api/v1/llm/features":
 		return roleAdmin
 	case strings.HasPrefix(path, "/api/v1/llm/features/"):
 		return roleAdmin
+	case path == "/api/v1/llm/prompts/preview":
+		return roleManager
+	case path == "/api/v1/llm/prompts", strings.HasPrefix(path, "/api/v1/llm/prompts/"):
+		if method == http.MethodGet {
+			return roleManager
+		}
+		return roleAdmin
 	case path == "/api/v1/llm/bulk-test":
 		return roleAdmin
 	case path == "/api/v1/llm/recommendations":
 		return roleManager
+	case path == "/api/v1/llm/queue", strings.HasPrefix(path, "/api/v1/llm/queue/"):
+		return roleAdmin
 	case path == "/api/v1/team/users":
 		if method == http.MethodGet {
 			return roleAdmin
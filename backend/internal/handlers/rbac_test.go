@@ -16,6 +16,37 @@ func TestRequiredRole(t *testing.T) {
 		{"/api/v1/team/users", http.MethodPost, roleAdmin},
 		{"/api/v1/workflows", http.MethodGet, roleManager},
 		{"/api/v1/webhooks/incoming", http.MethodPost, ""},
+		{"/api/v1/llm/queue", http.MethodGet, roleAdmin},
+		{"/api/v1/llm/queue/dead-letters/replay", http.MethodPost, roleAdmin},
+		{"/api/v1/llm/prompts", http.MethodGet, roleManager},
+		{"/api/v1/llm/prompts", http.MethodPost, roleAdmin},
+		{"/api/v1/llm/prompts/preview", http.MethodPost, roleManager},
+		{"/api/v1/llm/prompts/3/pin", http.MethodPost, roleAdmin},
+		{"/api/v1/search/semantic", http.MethodGet, roleViewer},
+		{"/api/v1/conversations/7/ask", http.MethodPost, roleMember},
+		{"/api/v1/conversations/7/draft-reply", http.MethodPost, roleMember},
+		{"/api/v1/conversations/7/score", http.MethodGet, roleViewer},
+		{"/api/v1/conversations/7/score", http.MethodPost, roleMember},
+		{"/api/v1/daily-summary", http.MethodGet, roleViewer},
+		{"/api/v1/daily-summary/history", http.MethodGet, roleViewer},
+		{"/api/v1/daily-summary/settings", http.MethodGet, roleManager},
+		{"/api/v1/daily-summary/settings", http.MethodPut, roleAdmin},
+		{"/api/v1/daily-summary/generate", http.MethodPost, roleManager},
+		{"/api/v1/reply-drafts/4/feedback", http.MethodPost, roleMember},
+		{"/api/v1/llm/reply-settings", http.MethodGet, roleManager},
+		{"/api/v1/llm/reply-settings", http.MethodPut, roleAdmin},
+		{"/api/v1/llm/analytics/reply-drafts", http.MethodGet, roleManager},
+		{"/api/v1/llm/redaction", http.MethodGet, roleManager},
+		{"/api/v1/llm/redaction", http.MethodPut, roleAdmin},
+		{"/api/v1/llm/redaction/preview", http.MethodPost, roleManager},
+		{"/api/v1/llm/redaction/logs", http.MethodGet, roleAdmin},
+		{"/api/v1/llm/shadow", http.MethodGet, roleAdmin},
+		{"/api/v1/llm/shadow/importance_detection", http.MethodPut, roleAdmin},
+		{"/api/v1/llm/shadow/results", http.MethodGet, roleAdmin},
+		{"/api/v1/messages/9/analysis", http.MethodPatch, roleMember},
+		{"/api/v1/llm/few-shot", http.MethodGet, roleManager},
+		{"/api/v1/llm/few-shot", http.MethodPut, roleAdmin},
+		{"/api/v1/llm/analytics/corrections", http.MethodGet, roleManager},
 	}
 
 	for _, test := range tests {
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"message-flow/backend/internal/llm"
+)
+
+// SemanticSearch returns the messages closest in meaning to q across all of
+// the tenant's conversations. Only messages embedded since a provider with
+// embeddings was configured can be found.
+func (a *API) SemanticSearch(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+	limit := llm.DefaultSemanticResults
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if parsed, err := strconv.Atoi(value); err == nil && parsed > 0 && parsed <= llm.MaxSemanticResults {
+			limit = parsed
+		}
+	}
+	tenantID := a.tenantID(r)
+	// Embedding the query is a provider call, so allow more than a query.
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	matches, err := a.LLM.SemanticSearch(ctx, tenantID, query, limit)
+	switch {
+	case errors.Is(err, llm.ErrEmbedUnsupported), errors.Is(err, llm.ErrNoProviders):
+		writeError(w, http.StatusServiceUnavailable, "no provider with embeddings is configured")
+		return
+	case err != nil:
+		writeError(w, http.StatusBadGateway, "semantic search failed: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"data": matches})
+}
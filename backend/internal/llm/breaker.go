@@ -0,0 +1,211 @@
+package llm
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"message-flow/backend/internal/realtime"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+type BreakerState struct {
+	State       string     `json:"state"`
+	Failures    int        `json:"failures"`
+	OpenedAt    *time.Time `json:"opened_at"`
+	LastFailure string     `json:"last_failure,omitempty"`
+}
+
+type breaker struct {
+	state       string
+	failures    int
+	openedAt    time.Time
+	probing     bool
+	lastFailure string
+}
+
+// CircuitBreakers tracks a breaker per (tenant, provider). A breaker opens
+// after FailureThreshold consecutive failures, lets a single probe call
+// through once Cooldown has passed (half-open), and closes again on success.
+type CircuitBreakers struct {
+	Hub              *realtime.Hub
+	FailureThreshold int
+	Cooldown         time.Duration
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+	now      func() time.Time
+}
+
+func NewCircuitBreakers(hub *realtime.Hub) *CircuitBreakers {
+	return &CircuitBreakers{
+		Hub:              hub,
+		FailureThreshold: 5,
+		Cooldown:         30 * time.Second,
+		breakers:         map[string]*breaker{},
+		now:              time.Now,
+	}
+}
+
+// Available reports whether the provider may be put in a call chain. It does
+// not claim the half-open probe; Allow does that right before the call.
+func (c *CircuitBreakers) Available(tenantID, providerID int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.breakers[fmtKey(tenantID, providerID)]
+	if !ok {
+		return true
+	}
+	switch b.state {
+	case BreakerOpen:
+		return c.now().Sub(b.openedAt) >= c.Cooldown
+	case BreakerHalfOpen:
+		return !b.probing
+	default:
+		return true
+	}
+}
+
+func (c *CircuitBreakers) Allow(tenantID, providerID int64) bool {
+	c.mu.Lock()
+	b := c.get(tenantID, providerID)
+	previous := b.state
+	allowed := true
+	switch b.state {
+	case BreakerOpen:
+		if c.now().Sub(b.openedAt) < c.Cooldown {
+			allowed = false
+			break
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+	case BreakerHalfOpen:
+		if b.probing {
+			allowed = false
+			break
+		}
+		b.probing = true
+	}
+	state := c.snapshot(b)
+	c.mu.Unlock()
+	c.notify(tenantID, providerID, previous, state)
+	return allowed
+}
+
+func (c *CircuitBreakers) Success(tenantID, providerID int64) {
+	c.mu.Lock()
+	b := c.get(tenantID, providerID)
+	previous := b.state
+	b.state = BreakerClosed
+	b.failures = 0
+	b.probing = false
+	b.lastFailure = ""
+	state := c.snapshot(b)
+	c.mu.Unlock()
+	c.notify(tenantID, providerID, previous, state)
+}
+
+func (c *CircuitBreakers) Failure(tenantID, providerID int64, err error) {
+	c.mu.Lock()
+	b := c.get(tenantID, providerID)
+	previous := b.state
+	b.failures++
+	b.probing = false
+	if err != nil {
+		b.lastFailure = err.Error()
+	}
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= c.FailureThreshold) {
+		b.state = BreakerOpen
+		b.openedAt = c.now()
+	}
+	state := c.snapshot(b)
+	c.mu.Unlock()
+	c.notify(tenantID, providerID, previous, state)
+}
+
+// Release gives back a half-open probe claimed by Allow when the call ended
+// without telling us anything about the provider (throttled or cancelled).
+func (c *CircuitBreakers) Release(tenantID, providerID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if b, ok := c.breakers[fmtKey(tenantID, providerID)]; ok {
+		b.probing = false
+	}
+}
+
+// RecordHealth feeds a health check result into the breaker. A passing check
+// on an open breaker moves it to half-open so live traffic can confirm
+// recovery.
+func (c *CircuitBreakers) RecordHealth(tenantID, providerID int64, err error) {
+	if err != nil {
+		c.Failure(tenantID, providerID, err)
+		return
+	}
+	c.mu.Lock()
+	b := c.get(tenantID, providerID)
+	previous := b.state
+	switch b.state {
+	case BreakerOpen:
+		b.state = BreakerHalfOpen
+		b.probing = false
+	case BreakerClosed:
+		b.failures = 0
+	}
+	state := c.snapshot(b)
+	c.mu.Unlock()
+	c.notify(tenantID, providerID, previous, state)
+}
+
+func (c *CircuitBreakers) State(tenantID, providerID int64) BreakerState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.breakers[fmtKey(tenantID, providerID)]
+	if !ok {
+		return BreakerState{State: BreakerClosed}
+	}
+	return c.snapshot(b)
+}
+
+func (c *CircuitBreakers) get(tenantID, providerID int64) *breaker {
+	key := fmtKey(tenantID, providerID)
+	b, ok := c.breakers[key]
+	if !ok {
+		b = &breaker{state: BreakerClosed}
+		c.breakers[key] = b
+	}
+	return b
+}
+
+func (c *CircuitBreakers) snapshot(b *breaker) BreakerState {
+	state := BreakerState{State: b.state, Failures: b.failures, LastFailure: b.lastFailure}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		state.OpenedAt = &openedAt
+	}
+	return state
+}
+
+func (c *CircuitBreakers) notify(tenantID, providerID int64, previous string, state BreakerState) {
+	if previous == state.State || c.Hub == nil {
+		return
+	}
+	c.Hub.Broadcast(tenantID, map[string]any{
+		"type":           "llm.circuit",
+		"provider_id":    providerID,
+		"previous_state": previous,
+		"circuit":        state,
+	})
+}
+
+type circuitOpenError struct {
+	providerID int64
+}
+
+func (e circuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for provider %d", e.providerID)
+}
@@ -0,0 +1,92 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	now := time.Now()
+	breakers := NewCircuitBreakers(nil)
+	breakers.FailureThreshold = 2
+	breakers.now = func() time.Time { return now }
+
+	failure := errors.New("boom")
+	breakers.Failure(1, 7, failure)
+	if state := breakers.State(1, 7).State; state != BreakerClosed {
+		t.Fatalf("expected closed after one failure, got %s", state)
+	}
+	breakers.Failure(1, 7, failure)
+	if state := breakers.State(1, 7).State; state != BreakerOpen {
+		t.Fatalf("expected open, got %s", state)
+	}
+	if breakers.Available(1, 7) || breakers.Allow(1, 7) {
+		t.Fatalf("expected open breaker to refuse calls")
+	}
+	if !breakers.Available(2, 7) {
+		t.Fatalf("breakers must be tracked per tenant")
+	}
+
+	now = now.Add(breakers.Cooldown)
+	if !breakers.Allow(1, 7) {
+		t.Fatalf("expected probe after cooldown")
+	}
+	if state := breakers.State(1, 7).State; state != BreakerHalfOpen {
+		t.Fatalf("expected half-open, got %s", state)
+	}
+	if breakers.Allow(1, 7) {
+		t.Fatalf("expected a single probe while half-open")
+	}
+	breakers.Failure(1, 7, failure)
+	if state := breakers.State(1, 7).State; state != BreakerOpen {
+		t.Fatalf("expected failed probe to reopen, got %s", state)
+	}
+
+	breakers.RecordHealth(1, 7, nil)
+	if state := breakers.State(1, 7).State; state != BreakerHalfOpen {
+		t.Fatalf("expected passing health check to half-open, got %s", state)
+	}
+	if !breakers.Allow(1, 7) {
+		t.Fatalf("expected probe after health check")
+	}
+	breakers.Success(1, 7)
+	if state := breakers.State(1, 7); state.State != BreakerClosed || state.Failures != 0 {
+		t.Fatalf("expected closed after success, got %+v", state)
+	}
+}
+
+func TestRunFeatureCountsDeadlinesAsFailures(t *testing.T) {
+	store := &fakeProviderStore{
+		providers: map[int64]ProviderConfig{5: {ID: 5, ProviderName: "mock", ModelName: "mock-1", BaseURL: "mock://?latency=1s"}},
+		defaultID: 5,
+	}
+	factory := NewFactory(nil)
+	factory.MockProviders = true
+	router := NewRouter(factory, store)
+	router.Breakers = NewCircuitBreakers(nil)
+	router.Breakers.FailureThreshold = 1
+	service := &Service{Router: router}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, _, err := service.Analyze(cancelled, 1, 0, "hello", nil); err == nil {
+		t.Fatal("expected the cancelled call to fail")
+	}
+	if state := router.Breakers.State(1, 5).State; state != BreakerClosed {
+		t.Fatalf("expected a cancellation to leave the breaker closed, got %s", state)
+	}
+
+	hung, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := service.Analyze(hung, 1, 0, "hello", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to end the call, got %v", err)
+	}
+	if state := router.Breakers.State(1, 5).State; state != BreakerOpen {
+		t.Fatalf("expected a hung provider to open the breaker, got %s", state)
+	}
+}
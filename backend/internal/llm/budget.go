@@ -0,0 +1,184 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"message-flow/backend/internal/realtime"
+)
+
+var ErrBudgetExceeded = errors.New("provider budget exceeded")
+
+type ProviderBudget struct {
+	ProviderID        int64   `json:"provider_id"`
+	Provider          string  `json:"provider"`
+	MonthlyBudget     float64 `json:"monthly_budget"`
+	MonthSpent        float64 `json:"month_spent"`
+	MaxRequestsPerDay int     `json:"max_requests_per_day"`
+	RequestsToday     int     `json:"requests_today"`
+}
+
+// BudgetUsed is the fraction of the monthly budget spent, or 0 when the
+// provider has no budget.
+func (b ProviderBudget) BudgetUsed() float64 {
+	if b.MonthlyBudget <= 0 {
+		return 0
+	}
+	return b.MonthSpent / b.MonthlyBudget
+}
+
+// RequestsUsed is the fraction of the daily request cap used, or 0 when the
+// provider has no cap.
+func (b ProviderBudget) RequestsUsed() float64 {
+	if b.MaxRequestsPerDay <= 0 {
+		return 0
+	}
+	return float64(b.RequestsToday) / float64(b.MaxRequestsPerDay)
+}
+
+func (b ProviderBudget) Status() string {
+	used := b.BudgetUsed()
+	if requests := b.RequestsUsed(); requests > used {
+		used = requests
+	}
+	switch {
+	case used >= 1:
+		return "exceeded"
+	case used >= 0.8:
+		return "warning"
+	default:
+		return "ok"
+	}
+}
+
+var budgetThresholds = []int{80, 100}
+
+// crossedThresholds returns the alert thresholds (in percent) passed when
+// usage moves from before to after.
+func crossedThresholds(before, after float64) []int {
+	var crossed []int
+	for _, threshold := range budgetThresholds {
+		limit := float64(threshold) / 100
+		if before < limit && after >= limit {
+			crossed = append(crossed, threshold)
+		}
+	}
+	return crossed
+}
+
+// BudgetGuard enforces monthly_budget and max_requests_per_day per provider.
+// Spend is loaded from llm_usage_logs once per TTL and tracked in memory in
+// between.
+type BudgetGuard struct {
+	Store *Store
+	Hub   *realtime.Hub
+	TTL   time.Duration
+
+	mu      sync.Mutex
+	tenants map[int64]*tenantBudgets
+}
+
+type tenantBudgets struct {
+	loaded    time.Time
+	day       time.Time
+	providers map[int64]*ProviderBudget
+}
+
+func NewBudgetGuard(store *Store, hub *realtime.Hub) *BudgetGuard {
+	return &BudgetGuard{Store: store, Hub: hub, TTL: time.Minute, tenants: map[int64]*tenantBudgets{}}
+}
+
+// Allow returns ErrBudgetExceeded when the provider has reached its monthly
+// budget or daily request cap.
+func (g *BudgetGuard) Allow(ctx context.Context, tenantID, providerID int64) error {
+	budgets, err := g.load(ctx, tenantID)
+	if err != nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	budget, ok := budgets.providers[providerID]
+	if !ok {
+		return nil
+	}
+	if budget.MonthlyBudget > 0 && budget.MonthSpent >= budget.MonthlyBudget {
+		return fmt.Errorf("%w: %s spent %.2f of %.2f monthly budget", ErrBudgetExceeded, budget.Provider, budget.MonthSpent, budget.MonthlyBudget)
+	}
+	if budget.MaxRequestsPerDay > 0 && budget.RequestsToday >= budget.MaxRequestsPerDay {
+		return fmt.Errorf("%w: %s reached %d requests today", ErrBudgetExceeded, budget.Provider, budget.MaxRequestsPerDay)
+	}
+	return nil
+}
+
+// Record adds a completed call to the cached spend and raises alerts when
+// usage crosses 80% or 100% of a cap.
+func (g *BudgetGuard) Record(ctx context.Context, tenantID, providerID int64, cost float64) {
+	budgets, err := g.load(ctx, tenantID)
+	if err != nil {
+		return
+	}
+	g.mu.Lock()
+	budget, ok := budgets.providers[providerID]
+	if !ok {
+		budgets.loaded = time.Time{}
+		g.mu.Unlock()
+		return
+	}
+	before := *budget
+	budget.MonthSpent += cost
+	budget.RequestsToday++
+	after := *budget
+	g.mu.Unlock()
+
+	for _, threshold := range crossedThresholds(before.BudgetUsed(), after.BudgetUsed()) {
+		g.alert(ctx, tenantID, after, "monthly_budget", threshold,
+			fmt.Sprintf("LLM provider %s has used %d%% of its monthly budget (%.2f of %.2f).", after.Provider, threshold, after.MonthSpent, after.MonthlyBudget))
+	}
+	for _, threshold := range crossedThresholds(before.RequestsUsed(), after.RequestsUsed()) {
+		g.alert(ctx, tenantID, after, "daily_requests", threshold,
+			fmt.Sprintf("LLM provider %s has used %d%% of its daily request limit (%d of %d).", after.Provider, threshold, after.RequestsToday, after.MaxRequestsPerDay))
+	}
+}
+
+func (g *BudgetGuard) alert(ctx context.Context, tenantID int64, budget ProviderBudget, kind string, threshold int, content string) {
+	_ = g.Store.NotifyAdmins(ctx, tenantID, "llm.budget", content)
+	if g.Hub != nil {
+		g.Hub.Broadcast(tenantID, map[string]any{
+			"type":        "llm.budget",
+			"provider_id": budget.ProviderID,
+			"kind":        kind,
+			"threshold":   threshold,
+			"budget":      budget,
+		})
+	}
+}
+
+func (g *BudgetGuard) load(ctx context.Context, tenantID int64) (*tenantBudgets, error) {
+	now := time.Now().UTC()
+	day := now.Truncate(24 * time.Hour)
+
+	g.mu.Lock()
+	budgets, ok := g.tenants[tenantID]
+	if ok && now.Sub(budgets.loaded) < g.TTL && budgets.day.Equal(day) {
+		g.mu.Unlock()
+		return budgets, nil
+	}
+	g.mu.Unlock()
+
+	list, err := g.Store.ProviderBudgets(ctx, tenantID, now)
+	if err != nil {
+		return nil, err
+	}
+	budgets = &tenantBudgets{loaded: now, day: day, providers: map[int64]*ProviderBudget{}}
+	for i := range list {
+		budgets.providers[list[i].ProviderID] = &list[i]
+	}
+
+	g.mu.Lock()
+	g.tenants[tenantID] = budgets
+	g.mu.Unlock()
+	return budgets, nil
+}
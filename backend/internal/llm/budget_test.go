@@ -0,0 +1,32 @@
+package llm
+
+import "testing"
+
+func TestCrossedThresholds(t *testing.T) {
+	if got := crossedThresholds(0.5, 0.79); len(got) != 0 {
+		t.Fatalf("expected no thresholds, got %v", got)
+	}
+	if got := crossedThresholds(0.79, 0.8); len(got) != 1 || got[0] != 80 {
+		t.Fatalf("expected 80, got %v", got)
+	}
+	if got := crossedThresholds(0.5, 1.2); len(got) != 2 || got[1] != 100 {
+		t.Fatalf("expected 80 and 100, got %v", got)
+	}
+	if got := crossedThresholds(1.0, 1.5); len(got) != 0 {
+		t.Fatalf("expected no repeat alerts, got %v", got)
+	}
+}
+
+func TestProviderBudgetStatus(t *testing.T) {
+	budget := ProviderBudget{MonthlyBudget: 100, MonthSpent: 50, MaxRequestsPerDay: 10, RequestsToday: 9}
+	if budget.Status() != "warning" {
+		t.Fatalf("expected warning, got %s", budget.Status())
+	}
+	budget.RequestsToday = 10
+	if budget.Status() != "exceeded" {
+		t.Fatalf("expected exceeded, got %s", budget.Status())
+	}
+	if (ProviderBudget{MonthSpent: 1000}).Status() != "ok" {
+		t.Fatalf("expected ok without caps")
+	}
+}
@@ -0,0 +1,229 @@
+package llm
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultCacheTTL is how long a cached analysis is served.
+	DefaultCacheTTL = 24 * time.Hour
+	// DefaultCacheEntries bounds the in-process cache.
+	DefaultCacheEntries = 10000
+)
+
+// ResponseCache stores provider results for a while so identical inputs are
+// not paid for twice. Get reports a miss as ok=false with a nil error.
+type ResponseCache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// LRUCache is a per-process ResponseCache that evicts the least recently
+// used entry once full.
+type LRUCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func NewLRUCache(capacity int) *LRUCache {
+	if capacity <= 0 {
+		capacity = DefaultCacheEntries
+	}
+	return &LRUCache{capacity: capacity, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *LRUCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := element.Value.(*lruEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(element)
+	return entry.value, true, nil
+}
+
+func (c *LRUCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := time.Now().Add(ttl)
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*lruEntry)
+		entry.value = value
+		entry.expires = expires
+		c.order.MoveToFront(element)
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+	return nil
+}
+
+// RedisCache shares cached results across replicas.
+type RedisCache struct {
+	client *redis.Client
+}
+
+func NewRedisCache(redisURL string) (*RedisCache, error) {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisCache{client: redis.NewClient(opt)}, nil
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := c.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(ctx, key, value, ttl).Err()
+}
+
+type cacheBypassKey struct{}
+
+// WithoutCache makes calls with the returned context skip cached results.
+// Fresh results still replace what was cached.
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheBypassKey{}, true)
+}
+
+func cacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(cacheBypassKey{}).(bool)
+	return bypass
+}
+
+// cachedCall ties a feature call to the cache. Results are cached per
+// provider and model, since another model may well answer differently.
+type cachedCall struct {
+	feature       string
+	promptVersion int
+	// examples fingerprints the few-shot examples shown with the prompt, and
+	// prompt the rendered tenant prompt, which also carries the glossary and
+	// the contact name that the version alone does not identify.
+	examples string
+	prompt   string
+	content  string
+	// load decodes a cached result into the caller's result; store encodes
+	// the caller's result after a successful call.
+	load  func([]byte) error
+	store func() ([]byte, error)
+	// hit, when set, is told which provider's cached result was served.
+	hit func(config *ProviderConfig, record UsageRecord)
+}
+
+// cacheEntry is what is cached: the result and the tokens it took, so a
+// hit can be logged with the cost it saved.
+type cacheEntry struct {
+	Result       json.RawMessage `json:"result"`
+	Model        string          `json:"model"`
+	InputTokens  int             `json:"input_tokens"`
+	OutputTokens int             `json:"output_tokens"`
+}
+
+func (c *cachedCall) key(tenantID int64, config *ProviderConfig) string {
+	sum := sha256.Sum256([]byte(normalizeContent(c.content)))
+	version := fmt.Sprintf("v%d", c.promptVersion)
+	if c.examples != "" {
+		version += "e" + c.examples
+	}
+	if c.prompt != "" {
+		version += "p" + c.prompt
+	}
+	return fmt.Sprintf("llm:cache:%d:%s:%s:%d:%s:%s", tenantID, c.feature, version, config.ID, config.ModelName, hex.EncodeToString(sum[:]))
+}
+
+// promptFingerprint identifies a rendered tenant prompt in cache keys; the
+// built-in prompt leaves the key unchanged.
+func promptFingerprint(prompt string) string {
+	if prompt == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:8])
+}
+
+// normalizeContent makes copies that differ only in spacing share a key.
+func normalizeContent(content string) string {
+	return strings.Join(strings.Fields(content), " ")
+}
+
+// cacheLookup loads a cached result for the provider into the caller's
+// result and returns the usage to log for the hit.
+func (s *Service) cacheLookup(ctx context.Context, tenantID int64, call *cachedCall, config *ProviderConfig) (UsageRecord, bool) {
+	if s.Cache == nil || call == nil || cacheBypassed(ctx) {
+		return UsageRecord{}, false
+	}
+	start := time.Now()
+	raw, ok, err := s.Cache.Get(ctx, call.key(tenantID, config))
+	if err != nil || !ok {
+		return UsageRecord{}, false
+	}
+	var entry cacheEntry
+	if json.Unmarshal(raw, &entry) != nil || call.load(entry.Result) != nil {
+		return UsageRecord{}, false
+	}
+	return UsageRecord{
+		InputTokens:   entry.InputTokens,
+		OutputTokens:  entry.OutputTokens,
+		TotalTokens:   entry.InputTokens + entry.OutputTokens,
+		Latency:       time.Since(start),
+		Model:         entry.Model,
+		PromptVersion: call.promptVersion,
+		CacheHit:      true,
+	}, true
+}
+
+func (s *Service) cacheStore(ctx context.Context, tenantID int64, call *cachedCall, config *ProviderConfig, record UsageRecord) {
+	if s.Cache == nil || call == nil {
+		return
+	}
+	result, err := call.store()
+	if err != nil {
+		return
+	}
+	raw, err := json.Marshal(cacheEntry{Result: result, Model: record.Model, InputTokens: record.InputTokens, OutputTokens: record.OutputTokens})
+	if err != nil {
+		return
+	}
+	ttl := s.CacheTTL
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	_ = s.Cache.Set(ctx, call.key(tenantID, config), raw, ttl)
+}
@@ -0,0 +1,126 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	cache := NewLRUCache(2)
+	_ = cache.Set(ctx, "a", []byte("1"), time.Minute)
+	_ = cache.Set(ctx, "b", []byte("2"), time.Minute)
+	if _, ok, _ := cache.Get(ctx, "a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+	_ = cache.Set(ctx, "c", []byte("3"), time.Minute)
+	if _, ok, _ := cache.Get(ctx, "b"); ok {
+		t.Fatal("expected b to be evicted")
+	}
+	if value, ok, _ := cache.Get(ctx, "a"); !ok || string(value) != "1" {
+		t.Fatalf("expected a to survive, got %q %v", value, ok)
+	}
+}
+
+func TestLRUCacheExpires(t *testing.T) {
+	ctx := context.Background()
+	cache := NewLRUCache(10)
+	_ = cache.Set(ctx, "a", []byte("1"), -time.Second)
+	if _, ok, _ := cache.Get(ctx, "a"); ok {
+		t.Fatal("expected expired entry to miss")
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	config := &ProviderConfig{ID: 3, ModelName: "gpt-4o"}
+	call := &cachedCall{feature: FeatureImportanceDetection, promptVersion: 2, content: "  Invoice   overdue\n"}
+	same := &cachedCall{feature: FeatureImportanceDetection, promptVersion: 2, content: "Invoice overdue"}
+	if call.key(1, config) != same.key(1, config) {
+		t.Fatal("expected whitespace to be normalized")
+	}
+	other := []string{
+		call.key(2, config),
+		call.key(1, &ProviderConfig{ID: 3, ModelName: "gpt-4o-mini"}),
+		(&cachedCall{feature: FeatureImportanceDetection, promptVersion: 3, content: "Invoice overdue"}).key(1, config),
+		(&cachedCall{feature: FeatureImportanceDetection, promptVersion: 2, content: "invoice overdue"}).key(1, config),
+	}
+	for _, key := range other {
+		if key == call.key(1, config) {
+			t.Fatalf("expected a different key, got %s", key)
+		}
+	}
+}
+
+func TestRenderedPromptChangesCacheKey(t *testing.T) {
+	config := &ProviderConfig{ID: 1, ModelName: "m"}
+	call := &cachedCall{feature: FeatureImportanceDetection, promptVersion: 4, content: "hello"}
+	plain := call.key(7, config)
+	if promptFingerprint("") != "" {
+		t.Fatal("the built-in prompt should leave the key unchanged")
+	}
+	// Same template version and message, but another contact or glossary.
+	call.prompt = promptFingerprint("Glossary: ACME\nContact: Ana\nMessage: hello")
+	ana := call.key(7, config)
+	call.prompt = promptFingerprint("Glossary: ACME\nContact: Ben\nMessage: hello")
+	if ana == plain || call.key(7, config) == ana {
+		t.Fatal("analyses made with different rendered prompts must not share a cache key")
+	}
+}
+
+func TestCacheLookupRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	service := &Service{Cache: NewLRUCache(10)}
+	config := &ProviderConfig{ID: 1, ModelName: "mock-1"}
+	var result *AnalysisResult
+	call := &cachedCall{
+		feature: FeatureImportanceDetection,
+		content: "hello",
+		load:    func(raw []byte) error { return json.Unmarshal(raw, &result) },
+		store:   func() ([]byte, error) { return json.Marshal(result) },
+	}
+	if _, ok := service.cacheLookup(ctx, 1, call, config); ok {
+		t.Fatal("expected a miss")
+	}
+	result = &AnalysisResult{Priority: "high"}
+	service.cacheStore(ctx, 1, call, config, UsageRecord{InputTokens: 40, OutputTokens: 10, Model: "mock-1"})
+	result = nil
+
+	usage, ok := service.cacheLookup(ctx, 1, call, config)
+	if !ok || result == nil || result.Priority != "high" {
+		t.Fatalf("expected a hit, got %+v", result)
+	}
+	if !usage.CacheHit || usage.TotalTokens != 50 || usage.Model != "mock-1" {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+	if _, ok := service.cacheLookup(WithoutCache(ctx), 1, call, config); ok {
+		t.Fatal("expected the bypass to skip the cache")
+	}
+}
+
+func TestAnalyzeCacheHitKeepsSource(t *testing.T) {
+	ctx := context.Background()
+	store := &fakeProviderStore{
+		providers: map[int64]ProviderConfig{5: {ID: 5, ProviderName: "mock", ModelName: "mock-1", BaseURL: "mock://"}},
+		defaultID: 5,
+	}
+	factory := NewFactory(nil)
+	factory.MockProviders = true
+	router := NewRouter(factory, store)
+	service := &Service{Router: router, Cache: NewLRUCache(10)}
+
+	if _, source, err := service.Analyze(ctx, 1, 0, "the invoice is overdue", nil); err != nil || source == nil {
+		t.Fatalf("first analysis: source %+v, err %v", source, err)
+	}
+	// A failing provider proves the second analysis is served from the cache.
+	store.providers[5] = ProviderConfig{ID: 5, ProviderName: "mock", ModelName: "mock-1", BaseURL: "mock://?failure_rate=1"}
+	router.Evict(1, 5)
+	_, source, err := service.Analyze(ctx, 1, 0, "the invoice is overdue", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source == nil || source.ProviderID != 5 || source.Model != "mock-1" {
+		t.Fatalf("cached analysis source = %+v", source)
+	}
+}
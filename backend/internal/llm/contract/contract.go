@@ -2,14 +2,175 @@ package contract
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 )
 
+// BatchItem is one message in a micro-batch analysis request.
+type BatchItem struct {
+	MessageID int64  `json:"message_id"`
+	Content   string `json:"content"`
+}
+
+// BatchAnalyzer is implemented by providers that can analyze several messages
+// in one prompt. Results are keyed by message ID; messages the model skipped
+// are missing from the map. Batches always use the built-in prompt, as it
+// cannot carry CallOptions per message.
+type BatchAnalyzer interface {
+	AnalyzeBatch(ctx context.Context, items []BatchItem) (map[int64]*AnalysisResult, UsageRecord, error)
+}
+
+var ErrBatchUnsupported = errors.New("provider does not support batch analysis")
+
+// Embeddings holds one vector per input text, in input order, and the
+// embedding model that produced them. Vectors from different models cannot be
+// compared.
+type Embeddings struct {
+	Model   string
+	Vectors [][]float32
+}
+
+// Embedder is implemented by providers that can turn text into vectors for
+// semantic search.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) (*Embeddings, UsageRecord, error)
+}
+
+var ErrEmbedUnsupported = errors.New("provider does not support embeddings")
+
+// ContextMessage is a conversation message given to a model as evidence.
+type ContextMessage struct {
+	MessageID int64     `json:"message_id"`
+	Sender    string    `json:"sender"`
+	Timestamp time.Time `json:"timestamp"`
+	Content   string    `json:"content"`
+}
+
+// QuestionRequest asks a question about a conversation. Messages are the
+// retrieved messages the answer may cite; Notes are what is already known
+// about the conversation, such as action items and earlier summaries.
+type QuestionRequest struct {
+	Question    string
+	ContactName string
+	Messages    []ContextMessage
+	Notes       []string
+}
+
+// AnswerResult is an answer grounded in the given messages. Citations are
+// the IDs of the messages it relies on; Found is false when the messages do
+// not answer the question.
+type AnswerResult struct {
+	Answer     string  `json:"answer"`
+	Found      bool    `json:"found"`
+	Citations  []int64 `json:"citations"`
+	Confidence float64 `json:"confidence"`
+}
+
+// QuestionAnswerer is implemented by providers that can answer questions
+// about a conversation.
+type QuestionAnswerer interface {
+	Answer(ctx context.Context, request QuestionRequest) (*AnswerResult, UsageRecord, error)
+}
+
+var ErrAnswerUnsupported = errors.New("provider does not support question answering")
+
+// Rewrite modes for DraftRequest.
+const (
+	RewriteShorter    = "shorter"
+	RewriteFriendlier = "friendlier"
+	RewriteFormal     = "formal"
+	RewriteTranslate  = "translate"
+)
+
+// DraftRequest asks for replies the agent could send next. History is the
+// latest messages, oldest first, and Analysis the latest analysis of an
+// inbound message, if any. Tone and Guidelines are the tenant's instructions
+// for replies. With Draft set the agent's own text is rewritten as Rewrite
+// says instead, into Language when translating.
+type DraftRequest struct {
+	ContactName string
+	History     []ContextMessage
+	Analysis    *AnalysisResult
+	Tone        string
+	Guidelines  string
+	Count       int
+	Draft       string
+	Rewrite     string
+	Language    string
+}
+
+// DraftResult holds the suggested replies, best first.
+type DraftResult struct {
+	Suggestions []string `json:"suggestions"`
+}
+
+// ReplyDrafter is implemented by providers that can suggest or rewrite
+// replies.
+type ReplyDrafter interface {
+	DraftReply(ctx context.Context, request DraftRequest) (*DraftResult, UsageRecord, error)
+}
+
+var ErrDraftUnsupported = errors.New("provider does not support reply drafts")
+
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimitError is returned when a provider call is refused by the local
+// quota or rejected upstream with HTTP 429. It matches ErrRateLimited.
+type RateLimitError struct {
+	Provider   string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	if e.Provider == "" {
+		return fmt.Sprintf("rate limited, retry after %s", e.RetryAfter)
+	}
+	return fmt.Sprintf("%s rate limited, retry after %s", e.Provider, e.RetryAfter)
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// ValidationError is returned when a model's answer does not match the
+// expected result schema, even after it was asked to correct it.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid model output: " + strings.Join(e.Problems, "; ")
+}
+
+// AnalysisExample is a message with the labels an agent gave it. Providers
+// show examples before the message to analyze so the model follows the
+// tenant's judgement.
+type AnalysisExample struct {
+	Message     string   `json:"message"`
+	IsImportant bool     `json:"is_important"`
+	Priority    string   `json:"priority"`
+	Sentiment   string   `json:"sentiment"`
+	Topics      []string `json:"topics"`
+}
+
+// CallOptions carry a tenant's instructions for one call. Prompt, when set,
+// is sent instead of the provider's built-in prompt and must still ask for
+// the JSON shape the called method parses. Examples are added to the prompt
+// of Analyze calls, whether built-in or set with Prompt.
+type CallOptions struct {
+	Prompt   string
+	Examples []AnalysisExample
+}
+
+// Provider is an LLM backend. Every call returns the usage of that call, also
+// when it fails, so callers can log it without sharing state between calls.
 type Provider interface {
 	Name() string
-	Analyze(ctx context.Context, message string) (*AnalysisResult, error)
-	Summarize(ctx context.Context, messages []string) (*SummaryResult, error)
-	ExtractActions(ctx context.Context, text string) ([]string, error)
+	Analyze(ctx context.Context, message string, options CallOptions) (*AnalysisResult, UsageRecord, error)
+	Summarize(ctx context.Context, messages []string, options CallOptions) (*SummaryResult, UsageRecord, error)
+	ExtractActions(ctx context.Context, text string, options CallOptions) ([]string, UsageRecord, error)
 	HealthCheck(ctx context.Context) (*HealthCheckResult, error)
 	GetConfig() *ProviderConfig
 	GetUsage(ctx context.Context) (*UsageStats, error)
@@ -29,6 +190,9 @@ type ProviderConfig struct {
 	CostPer1KInput       float64
 	CostPer1KOutput      float64
 	MaxRequestsPerMinute int
+	// SafetySettings maps a harm category to a blocking threshold for
+	// providers that support them (Gemini).
+	SafetySettings map[string]string
 }
 
 type AnalysisResult struct {
@@ -67,14 +231,28 @@ type UsageStats struct {
 	AverageLatency     time.Duration `json:"average_latency"`
 }
 
+// UsageRecord describes a single provider call. Model is the model that
+// served it, Attempts counts retries and HTTPStatus is the status of the last
+// response (0 when none arrived). PromptVersion is the tenant prompt template
+// version used, 0 for the built-in prompt. ValidationErrors counts answers
+// that failed schema validation and Repaired is set when a repair request
+// fixed one. CacheHit marks a result served from the response cache; its
+// tokens are those of the original call.
 type UsageRecord struct {
-	InputTokens  int
-	OutputTokens int
-	TotalTokens  int
-	Latency      time.Duration
-	Success      bool
-	ErrorMessage string
-	Feature      string
+	InputTokens      int
+	OutputTokens     int
+	TotalTokens      int
+	Latency          time.Duration
+	Success          bool
+	ErrorMessage     string
+	Feature          string
+	Model            string
+	Attempts         int
+	HTTPStatus       int
+	PromptVersion    int
+	ValidationErrors int
+	Repaired         bool
+	CacheHit         bool
 }
 
 func (u UsageRecord) InputCost(costPer1K float64) float64 {
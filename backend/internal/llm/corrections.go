@@ -0,0 +1,403 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// DefaultFewShotExamples and MaxFewShotExamples bound how many recent
+	// corrections are shown to the model.
+	DefaultFewShotExamples = 3
+	MaxFewShotExamples     = 10
+	// fewShotMessageLength truncates example messages to keep prompts small.
+	fewShotMessageLength = 500
+	fewShotTTL           = time.Minute
+	maxCorrectionTopics  = 20
+)
+
+// ErrCorrectionPriority is returned when a correction leaves a message
+// important without a priority, e.g. one that was never analyzed.
+var ErrCorrectionPriority = errors.New("priority is required for an important message")
+
+var (
+	correctionPriorities = map[string]bool{"high": true, "medium": true, "low": true}
+	correctionSentiments = map[string]bool{"positive": true, "neutral": true, "negative": true}
+)
+
+// AnalysisCorrection is an agent's correction of a message analysis. Fields
+// left nil keep the current value; sending the current values confirms the
+// analysis.
+type AnalysisCorrection struct {
+	IsImportant *bool     `json:"is_important"`
+	Priority    *string   `json:"priority"`
+	Sentiment   *string   `json:"sentiment"`
+	Topics      *[]string `json:"topics"`
+}
+
+// Correction is a stored correction. Original is the analysis the model or
+// the fallback produced before any agent corrected the message, and
+// ProviderID the provider that produced it, nil for fallback analyses and
+// analyses stored before providers were recorded with them. The labels are
+// the message's full labels after the correction.
+type Correction struct {
+	ID          int64           `json:"id"`
+	MessageID   int64           `json:"message_id"`
+	ProviderID  *int64          `json:"provider_id"`
+	Model       string          `json:"model"`
+	Original    *AnalysisResult `json:"original"`
+	IsImportant bool            `json:"is_important"`
+	Priority    string          `json:"priority"`
+	Sentiment   string          `json:"sentiment"`
+	Topics      []string        `json:"topics"`
+	CorrectedBy *int64          `json:"corrected_by"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// FewShotSettings controls whether recent corrections are shown to the model
+// as examples when analyzing messages.
+type FewShotSettings struct {
+	Enabled  bool `json:"enabled"`
+	Examples int  `json:"examples"`
+}
+
+// ValidateCorrection normalizes a correction and checks its labels.
+func ValidateCorrection(correction *AnalysisCorrection) error {
+	if correction.IsImportant == nil && correction.Priority == nil && correction.Sentiment == nil && correction.Topics == nil {
+		return errors.New("nothing to correct")
+	}
+	if correction.Priority != nil {
+		priority := strings.ToLower(strings.TrimSpace(*correction.Priority))
+		if !correctionPriorities[priority] {
+			return errors.New("priority must be high, medium or low")
+		}
+		correction.Priority = &priority
+	}
+	if correction.Sentiment != nil {
+		sentiment := strings.ToLower(strings.TrimSpace(*correction.Sentiment))
+		if !correctionSentiments[sentiment] {
+			return errors.New("sentiment must be positive, neutral or negative")
+		}
+		correction.Sentiment = &sentiment
+	}
+	if correction.Topics != nil {
+		topics := []string{}
+		seen := map[string]bool{}
+		for _, topic := range *correction.Topics {
+			topic = strings.TrimSpace(topic)
+			if topic == "" || seen[strings.ToLower(topic)] {
+				continue
+			}
+			if len(topic) > 100 {
+				return errors.New("topics must be at most 100 characters")
+			}
+			seen[strings.ToLower(topic)] = true
+			topics = append(topics, topic)
+		}
+		if len(topics) > maxCorrectionTopics {
+			return fmt.Errorf("at most %d topics", maxCorrectionTopics)
+		}
+		correction.Topics = &topics
+	}
+	return nil
+}
+
+// apply returns analysis with the correction's fields replaced. A corrected
+// sentiment resets the score to the label's end of the scale. An important
+// result without a priority is ErrCorrectionPriority.
+func (c AnalysisCorrection) apply(analysis *AnalysisResult) (AnalysisResult, error) {
+	var corrected AnalysisResult
+	if analysis != nil {
+		corrected = *analysis
+	}
+	if c.IsImportant != nil {
+		corrected.IsImportant = *c.IsImportant
+	}
+	if c.Priority != nil {
+		corrected.Priority = *c.Priority
+	}
+	if c.Sentiment != nil && *c.Sentiment != corrected.Sentiment {
+		corrected.Sentiment = *c.Sentiment
+		corrected.SentimentScore = sentimentValue(*c.Sentiment, 0)
+	}
+	if c.Topics != nil {
+		corrected.Topics = *c.Topics
+	}
+	if corrected.Topics == nil {
+		corrected.Topics = []string{}
+	}
+	if corrected.IsImportant && corrected.Priority == "" {
+		return corrected, ErrCorrectionPriority
+	}
+	return corrected, nil
+}
+
+// CorrectAnalysis applies an agent's correction to a message: its stored
+// analysis and important_messages row are updated and the correction is
+// recorded with the machine analysis it replaced, and the conversation is
+// rescored. A missing message is pgx.ErrNoRows. It returns the correction
+// and the analysis it replaced.
+func (s *Store) CorrectAnalysis(ctx context.Context, tenantID, messageID int64, correction AnalysisCorrection, userID *int64) (*Correction, *AnalysisResult, error) {
+	var record Correction
+	var before *AnalysisResult
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		var metadata []byte
+		var conversationID int64
+		var stored struct {
+			Analysis *AnalysisResult `json:"analysis"`
+			Source   *AnalysisSource `json:"analysis_source"`
+		}
+		if err := tx.QueryRow(ctx, `
+			SELECT metadata_json, conversation_id FROM messages WHERE tenant_id=$1 AND id=$2 FOR UPDATE`, tenantID, messageID).Scan(&metadata, &conversationID); err != nil {
+			return err
+		}
+		payload := map[string]any{}
+		if len(metadata) > 0 {
+			_ = json.Unmarshal(metadata, &payload)
+			if json.Unmarshal(metadata, &stored) == nil {
+				before = stored.Analysis
+			}
+		}
+		corrected, err := correction.apply(before)
+		if err != nil {
+			return err
+		}
+
+		// The first correction of a message keeps what the machine said; later
+		// ones carry it over so error rates are measured against the model,
+		// not against an earlier correction.
+		var original []byte
+		err = tx.QueryRow(ctx, `
+			SELECT provider_id, model_name, original FROM analysis_corrections
+			WHERE tenant_id=$1 AND message_id=$2
+			ORDER BY created_at ASC
+			LIMIT 1`, tenantID, messageID).Scan(&record.ProviderID, &record.Model, &original)
+		if errors.Is(err, pgx.ErrNoRows) {
+			if before != nil {
+				original, _ = json.Marshal(before)
+			}
+			if stored.Source != nil {
+				record.ProviderID, record.Model = &stored.Source.ProviderID, stored.Source.Model
+			}
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(original) > 0 {
+			var analysis AnalysisResult
+			if json.Unmarshal(original, &analysis) == nil {
+				record.Original = &analysis
+			}
+		}
+
+		now := time.Now().UTC()
+		payload["analysis"] = corrected
+		payload["analysis_correction"] = map[string]any{"corrected_by": userID, "corrected_at": now}
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE messages SET metadata_json=$1 WHERE tenant_id=$2 AND id=$3`, string(encoded), tenantID, messageID); err != nil {
+			return err
+		}
+		if corrected.IsImportant {
+			tag, err := tx.Exec(ctx, `UPDATE important_messages SET priority=$3 WHERE tenant_id=$1 AND message_id=$2`, tenantID, messageID, corrected.Priority)
+			if err != nil {
+				return err
+			}
+			if tag.RowsAffected() == 0 {
+				if _, err := tx.Exec(ctx, `
+					INSERT INTO important_messages (tenant_id, message_id, priority, reason, created_at)
+					VALUES ($1, $2, $3, $4, $5)`, tenantID, messageID, corrected.Priority, corrected.Reason, now); err != nil {
+					return err
+				}
+			}
+		} else if _, err := tx.Exec(ctx, `DELETE FROM important_messages WHERE tenant_id=$1 AND message_id=$2`, tenantID, messageID); err != nil {
+			return err
+		}
+
+		record.MessageID = messageID
+		record.IsImportant, record.Priority, record.Sentiment, record.Topics = corrected.IsImportant, corrected.Priority, corrected.Sentiment, corrected.Topics
+		record.CorrectedBy = userID
+		if err := tx.QueryRow(ctx, `
+			INSERT INTO analysis_corrections (tenant_id, message_id, provider_id, model_name, original, is_important, priority, sentiment, topics, corrected_by, created_at)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+			RETURNING id, created_at`,
+			tenantID, messageID, record.ProviderID, record.Model, nullJSON(original), record.IsImportant, record.Priority, record.Sentiment, record.Topics, userID, now).Scan(&record.ID, &record.CreatedAt); err != nil {
+			return err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return err
+		}
+		// As in StoreAnalysis, a failed rescore waits for the next analysis.
+		_, _ = refreshConversationScore(ctx, conn, tenantID, conversationID)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &record, before, nil
+}
+
+// FewShotSettings returns the tenant's few-shot settings, disabled when none
+// are set.
+func (s *Store) FewShotSettings(ctx context.Context, tenantID int64) (FewShotSettings, error) {
+	settings := FewShotSettings{Examples: DefaultFewShotExamples}
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		return conn.QueryRow(ctx, `
+			SELECT few_shot_enabled, few_shot_examples FROM llm_prompt_settings WHERE tenant_id=$1`, tenantID).Scan(&settings.Enabled, &settings.Examples)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return FewShotSettings{Examples: DefaultFewShotExamples}, nil
+	}
+	return settings, err
+}
+
+func (s *Store) SaveFewShotSettings(ctx context.Context, tenantID int64, settings FewShotSettings) error {
+	return s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		_, err := conn.Exec(ctx, `
+			INSERT INTO llm_prompt_settings (tenant_id, few_shot_enabled, few_shot_examples, updated_at)
+			VALUES ($1,$2,$3,$4)
+			ON CONFLICT (tenant_id) DO UPDATE
+			SET few_shot_enabled=EXCLUDED.few_shot_enabled, few_shot_examples=EXCLUDED.few_shot_examples, updated_at=EXCLUDED.updated_at`,
+			tenantID, settings.Enabled, settings.Examples, time.Now().UTC())
+		return err
+	})
+}
+
+// correctedExample is a recent correction as a few-shot example.
+type correctedExample struct {
+	messageID int64
+	example   AnalysisExample
+}
+
+// RecentCorrections returns the latest labels of the most recently corrected
+// messages, newest first.
+func (s *Store) RecentCorrections(ctx context.Context, tenantID int64, limit int) ([]correctedExample, error) {
+	examples := []correctedExample{}
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			SELECT message_id, content, is_important, priority, sentiment, topics FROM (
+				SELECT DISTINCT ON (c.message_id) c.message_id, m.content, c.is_important, c.priority, c.sentiment, c.topics, c.created_at
+				FROM analysis_corrections c
+				JOIN messages m ON m.id = c.message_id AND m.tenant_id = c.tenant_id
+				WHERE c.tenant_id=$1
+				ORDER BY c.message_id, c.created_at DESC
+			) latest
+			ORDER BY created_at DESC
+			LIMIT $2`, tenantID, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var item correctedExample
+			if err := rows.Scan(&item.messageID, &item.example.Message, &item.example.IsImportant, &item.example.Priority, &item.example.Sentiment, &item.example.Topics); err != nil {
+				return err
+			}
+			if len(item.example.Message) > fewShotMessageLength {
+				item.example.Message = truncateUTF8(item.example.Message, fewShotMessageLength)
+			}
+			examples = append(examples, item)
+		}
+		return rows.Err()
+	})
+	return examples, err
+}
+
+// truncateUTF8 cuts text to at most limit bytes without splitting a rune.
+func truncateUTF8(text string, limit int) string {
+	for limit > 0 && limit < len(text) && text[limit]&0xC0 == 0x80 {
+		limit--
+	}
+	return text[:limit]
+}
+
+type fewShotEntry struct {
+	examples []correctedExample
+	limit    int
+	expires  time.Time
+}
+
+type fewShotCache struct {
+	mu      sync.Mutex
+	entries map[int64]fewShotEntry
+}
+
+// InvalidateFewShot drops the cached examples of a tenant after a correction
+// or a settings change.
+func (s *Service) InvalidateFewShot(tenantID int64) {
+	s.fewShot.mu.Lock()
+	defer s.fewShot.mu.Unlock()
+	delete(s.fewShot.entries, tenantID)
+}
+
+// fewShotExamples returns the corrections to show the model when analyzing
+// a message, leaving out the message itself. Like a broken prompt template,
+// examples that cannot be loaded are skipped rather than failing the call.
+func (s *Service) fewShotExamples(ctx context.Context, tenantID int64, messageID *int64) []AnalysisExample {
+	if s.Store == nil || s.Store.DB == nil {
+		return nil
+	}
+	s.fewShot.mu.Lock()
+	entry, ok := s.fewShot.entries[tenantID]
+	s.fewShot.mu.Unlock()
+	if !ok || time.Now().After(entry.expires) {
+		entry = fewShotEntry{expires: time.Now().Add(fewShotTTL)}
+		settings, err := s.Store.FewShotSettings(ctx, tenantID)
+		if err != nil {
+			return nil
+		}
+		if settings.Enabled && settings.Examples > 0 {
+			entry.limit = settings.Examples
+			// One extra in case the message being analyzed is among them.
+			if entry.examples, err = s.Store.RecentCorrections(ctx, tenantID, settings.Examples+1); err != nil {
+				return nil
+			}
+		}
+		s.fewShot.mu.Lock()
+		if s.fewShot.entries == nil {
+			s.fewShot.entries = map[int64]fewShotEntry{}
+		}
+		s.fewShot.entries[tenantID] = entry
+		s.fewShot.mu.Unlock()
+	}
+	var examples []AnalysisExample
+	for _, item := range entry.examples {
+		if len(examples) == entry.limit {
+			break
+		}
+		if messageID == nil || item.messageID != *messageID {
+			examples = append(examples, item.example)
+		}
+	}
+	return examples
+}
+
+// examplesFingerprint identifies a set of examples in cache keys, so an
+// analysis made with other examples is not served from the cache.
+func examplesFingerprint(examples []AnalysisExample) string {
+	if len(examples) == 0 {
+		return ""
+	}
+	encoded, _ := json.Marshal(examples)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:8])
+}
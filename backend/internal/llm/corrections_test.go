@@ -0,0 +1,82 @@
+package llm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateCorrectionNormalizes(t *testing.T) {
+	priority, sentiment := " HIGH ", "Negative"
+	topics := []string{"Billing", " billing", "", "Refund"}
+	correction := AnalysisCorrection{Priority: &priority, Sentiment: &sentiment, Topics: &topics}
+	if err := ValidateCorrection(&correction); err != nil {
+		t.Fatal(err)
+	}
+	if *correction.Priority != "high" || *correction.Sentiment != "negative" {
+		t.Fatalf("labels not normalized: %q %q", *correction.Priority, *correction.Sentiment)
+	}
+	if got := *correction.Topics; len(got) != 2 || got[0] != "Billing" || got[1] != "Refund" {
+		t.Fatalf("topics not cleaned: %v", got)
+	}
+
+	bad := "urgent"
+	for _, invalid := range []AnalysisCorrection{{}, {Priority: &bad}, {Sentiment: &bad}} {
+		if err := ValidateCorrection(&invalid); err == nil {
+			t.Fatalf("expected %+v to be rejected", invalid)
+		}
+	}
+}
+
+func TestCorrectionApplyKeepsUncorrectedFields(t *testing.T) {
+	important, sentiment := false, "positive"
+	original := &AnalysisResult{IsImportant: true, Priority: "high", Reason: "deadline", Sentiment: "negative", SentimentScore: -0.6, Topics: []string{"invoice"}}
+	corrected, err := AnalysisCorrection{IsImportant: &important, Sentiment: &sentiment}.apply(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if corrected.IsImportant || corrected.Priority != "high" || corrected.Reason != "deadline" || corrected.Topics[0] != "invoice" {
+		t.Fatalf("unexpected correction: %+v", corrected)
+	}
+	if corrected.Sentiment != "positive" || corrected.SentimentScore != 1 {
+		t.Fatalf("sentiment score should follow the corrected label: %+v", corrected)
+	}
+	if !original.IsImportant {
+		t.Fatal("the original analysis was modified")
+	}
+
+	// A message without an analysis gets one made of the correction alone.
+	priority := "low"
+	fresh, err := AnalysisCorrection{Priority: &priority}.apply(nil)
+	if err != nil || fresh.Priority != "low" || fresh.Topics == nil {
+		t.Fatalf("unexpected analysis: %+v, %v", fresh, err)
+	}
+}
+
+func TestCorrectionNeedsPriorityWhenImportant(t *testing.T) {
+	important := true
+	if _, err := (AnalysisCorrection{IsImportant: &important}).apply(nil); !errors.Is(err, ErrCorrectionPriority) {
+		t.Fatalf("err = %v, want ErrCorrectionPriority", err)
+	}
+	priority := "high"
+	if _, err := (AnalysisCorrection{IsImportant: &important, Priority: &priority}).apply(nil); err != nil {
+		t.Fatal(err)
+	}
+	// A stored analysis supplies the priority.
+	if _, err := (AnalysisCorrection{IsImportant: &important}).apply(&AnalysisResult{Priority: "low"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExamplesChangeCacheKey(t *testing.T) {
+	config := &ProviderConfig{ID: 1, ModelName: "m"}
+	call := &cachedCall{feature: FeatureImportanceDetection, content: "hello"}
+	plain := call.key(7, config)
+	call.examples = examplesFingerprint([]AnalysisExample{{Message: "refund now", IsImportant: true, Priority: "high"}})
+	if call.examples == "" || call.key(7, config) == plain {
+		t.Fatal("analyses made with examples must not share the plain cache key")
+	}
+	if examplesFingerprint(nil) != "" {
+		t.Fatal("no examples should leave the key unchanged")
+	}
+}
@@ -0,0 +1,640 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// DigestDateLayout is the layout of digest dates, which are local to the
+	// tenant's time zone.
+	DigestDateLayout = "2006-01-02"
+	// DefaultDigestTime is when digests run unless the tenant set a time.
+	DefaultDigestTime = "08:00"
+
+	// maxDigestConversations bounds the conversations summarized for one
+	// digest, the most recently active first.
+	maxDigestConversations  = 200
+	digestImportantMessages = 10
+	digestActionItems       = 50
+	digestSentimentShifts   = 10
+	digestContentLength     = 280
+	// A sentiment shift compares the period with the week before it and is
+	// reported from half a point on the -1 to 1 scale.
+	sentimentBaseline       = 7 * 24 * time.Hour
+	sentimentShiftThreshold = 0.5
+	// A digest left running this long is assumed lost with its replica and
+	// may be started again.
+	digestStaleAfter = time.Hour
+)
+
+// Digest statuses.
+const (
+	DigestRunning   = "running"
+	DigestCompleted = "completed"
+	DigestFailed    = "failed"
+)
+
+// ErrDigestRunning is returned when a digest is started while it runs.
+var ErrDigestRunning = errors.New("digest is already running")
+
+// DigestSettings say when a tenant's daily digest runs: every day at
+// LocalTime ("15:04") in Timezone, an IANA zone name.
+type DigestSettings struct {
+	Enabled   bool   `json:"enabled"`
+	LocalTime string `json:"local_time"`
+	Timezone  string `json:"timezone"`
+}
+
+// ValidateDigestSettings fills in defaults and checks the time and zone.
+func ValidateDigestSettings(settings *DigestSettings) error {
+	if settings.LocalTime == "" {
+		settings.LocalTime = DefaultDigestTime
+	}
+	if settings.Timezone == "" {
+		settings.Timezone = "UTC"
+	}
+	if _, err := time.Parse("15:04", settings.LocalTime); err != nil {
+		return errors.New("local_time must be HH:MM")
+	}
+	if _, err := time.LoadLocation(settings.Timezone); err != nil {
+		return errors.New("unknown timezone")
+	}
+	return nil
+}
+
+func (settings DigestSettings) location() *time.Location {
+	if loc, err := time.LoadLocation(settings.Timezone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// Period returns the span a digest covers: the day up to the digest's run
+// time on its date.
+func (settings DigestSettings) Period(date string) (time.Time, time.Time, error) {
+	loc := settings.location()
+	day, err := time.ParseInLocation(DigestDateLayout, date, loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	clock, err := time.Parse("15:04", settings.LocalTime)
+	if err != nil {
+		clock, _ = time.Parse("15:04", DefaultDigestTime)
+	}
+	end := time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+	return end.AddDate(0, 0, -1).UTC(), end.UTC(), nil
+}
+
+// due returns today's digest date once its run time has passed.
+func (settings DigestSettings) due(now time.Time) (string, bool) {
+	date := now.In(settings.location()).Format(DigestDateLayout)
+	_, end, err := settings.Period(date)
+	if err != nil || now.Before(end) {
+		return "", false
+	}
+	return date, true
+}
+
+// LatestDate returns the date of the latest digest that is due: today's
+// once its run time has passed, otherwise yesterday's.
+func (settings DigestSettings) LatestDate(now time.Time) string {
+	if date, ok := settings.due(now); ok {
+		return date
+	}
+	return now.In(settings.location()).AddDate(0, 0, -1).Format(DigestDateLayout)
+}
+
+// DigestMessage is an important message of the period.
+type DigestMessage struct {
+	MessageID      int64     `json:"message_id"`
+	ConversationID int64     `json:"conversation_id"`
+	ContactName    string    `json:"contact_name"`
+	Priority       string    `json:"priority"`
+	Reason         string    `json:"reason"`
+	Content        string    `json:"content"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// DigestActionItem is an action item created in the period.
+type DigestActionItem struct {
+	ID             int64      `json:"id"`
+	ConversationID int64      `json:"conversation_id"`
+	ContactName    string     `json:"contact_name"`
+	Description    string     `json:"description"`
+	Status         string     `json:"status"`
+	DueDate        *time.Time `json:"due_date"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// SentimentShift is a conversation whose average sentiment in the period
+// moved away from the week before. Values are on a -1 to 1 scale.
+type SentimentShift struct {
+	ConversationID int64   `json:"conversation_id"`
+	ContactName    string  `json:"contact_name"`
+	Previous       float64 `json:"previous"`
+	Current        float64 `json:"current"`
+	Change         float64 `json:"change"`
+	Direction      string  `json:"direction"`
+}
+
+// DigestConversation is a conversation active in the period and its
+// summary, or the error that kept it from being summarized.
+type DigestConversation struct {
+	ConversationID int64  `json:"conversation_id"`
+	ContactName    string `json:"contact_name"`
+	Messages       int    `json:"messages"`
+	SummaryID      *int64 `json:"summary_id"`
+	Summary        string `json:"summary"`
+	Sentiment      string `json:"sentiment"`
+	Error          string `json:"error,omitempty"`
+}
+
+// Digest is what happened across a tenant's conversations in a period.
+type Digest struct {
+	ImportantMessages []DigestMessage      `json:"important_messages"`
+	ActionItems       []DigestActionItem   `json:"action_items"`
+	SentimentShifts   []SentimentShift     `json:"sentiment_shifts"`
+	Conversations     []DigestConversation `json:"conversations"`
+}
+
+// ForConversation returns the part of the digest about one conversation.
+func (d Digest) ForConversation(conversationID int64) Digest {
+	filtered := Digest{ImportantMessages: []DigestMessage{}, ActionItems: []DigestActionItem{}, SentimentShifts: []SentimentShift{}, Conversations: []DigestConversation{}}
+	for _, message := range d.ImportantMessages {
+		if message.ConversationID == conversationID {
+			filtered.ImportantMessages = append(filtered.ImportantMessages, message)
+		}
+	}
+	for _, item := range d.ActionItems {
+		if item.ConversationID == conversationID {
+			filtered.ActionItems = append(filtered.ActionItems, item)
+		}
+	}
+	for _, shift := range d.SentimentShifts {
+		if shift.ConversationID == conversationID {
+			filtered.SentimentShifts = append(filtered.SentimentShifts, shift)
+		}
+	}
+	for _, conversation := range d.Conversations {
+		if conversation.ConversationID == conversationID {
+			filtered.Conversations = append(filtered.Conversations, conversation)
+		}
+	}
+	return filtered
+}
+
+// DailyDigest is a stored digest. Digest is nil while it runs and in
+// listings.
+type DailyDigest struct {
+	ID                  int64      `json:"id"`
+	Date                string     `json:"date"`
+	PeriodStart         time.Time  `json:"period_start"`
+	PeriodEnd           time.Time  `json:"period_end"`
+	Status              string     `json:"status"`
+	Conversations       int        `json:"conversations"`
+	FailedConversations int        `json:"failed_conversations"`
+	Digest              *Digest    `json:"digest"`
+	ErrorMessage        *string    `json:"error_message"`
+	StartedAt           time.Time  `json:"started_at"`
+	CompletedAt         *time.Time `json:"completed_at"`
+}
+
+// DigestSettings returns the tenant's digest settings, disabled when none
+// are set.
+func (s *Store) DigestSettings(ctx context.Context, tenantID int64) (DigestSettings, error) {
+	settings := DigestSettings{LocalTime: DefaultDigestTime, Timezone: "UTC"}
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		return conn.QueryRow(ctx, `
+			SELECT enabled, local_time, timezone FROM daily_digest_settings WHERE tenant_id=$1`, tenantID).Scan(&settings.Enabled, &settings.LocalTime, &settings.Timezone)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return DigestSettings{LocalTime: DefaultDigestTime, Timezone: "UTC"}, nil
+	}
+	return settings, err
+}
+
+func (s *Store) SaveDigestSettings(ctx context.Context, tenantID int64, settings DigestSettings) error {
+	return s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		_, err := conn.Exec(ctx, `
+			INSERT INTO daily_digest_settings (tenant_id, enabled, local_time, timezone, updated_at)
+			VALUES ($1,$2,$3,$4,$5)
+			ON CONFLICT (tenant_id) DO UPDATE
+			SET enabled=EXCLUDED.enabled, local_time=EXCLUDED.local_time, timezone=EXCLUDED.timezone, updated_at=EXCLUDED.updated_at`,
+			tenantID, settings.Enabled, settings.LocalTime, settings.Timezone, time.Now().UTC())
+		return err
+	})
+}
+
+// ClaimDigest marks a digest as running, so one replica makes it. A stale
+// run is always started over; with rerun a finished digest is too. It
+// reports whether the digest was claimed.
+func (s *Store) ClaimDigest(ctx context.Context, tenantID int64, date string, start, end time.Time, rerun bool) (bool, error) {
+	var claimed bool
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		tag, err := conn.Exec(ctx, `
+			INSERT INTO daily_digests (tenant_id, digest_date, period_start, period_end, status, started_at)
+			VALUES ($1,$2,$3,$4,'running',$5)
+			ON CONFLICT (tenant_id, digest_date) DO UPDATE
+			SET period_start=EXCLUDED.period_start, period_end=EXCLUDED.period_end, status='running',
+			    conversations=0, failed_conversations=0, digest=NULL, error_message=NULL,
+			    started_at=EXCLUDED.started_at, completed_at=NULL
+			WHERE CASE WHEN daily_digests.status = 'running' THEN daily_digests.started_at < $6 ELSE $7::BOOLEAN END`,
+			tenantID, date, start, end, time.Now().UTC(), time.Now().UTC().Add(-digestStaleAfter), rerun)
+		claimed = tag.RowsAffected() > 0
+		return err
+	})
+	return claimed, err
+}
+
+func (s *Store) finishDigest(ctx context.Context, tenantID int64, date string, status string, conversations, failed int, digest *Digest, message *string) error {
+	var encoded []byte
+	if digest != nil {
+		var err error
+		if encoded, err = json.Marshal(digest); err != nil {
+			return err
+		}
+	}
+	return s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		_, err := conn.Exec(ctx, `
+			UPDATE daily_digests
+			SET status=$3, conversations=$4, failed_conversations=$5, digest=$6, error_message=$7, completed_at=$8
+			WHERE tenant_id=$1 AND digest_date=$2`,
+			tenantID, date, status, conversations, failed, nullJSON(encoded), message, time.Now().UTC())
+		return err
+	})
+}
+
+const digestColumns = `id, TO_CHAR(digest_date, 'YYYY-MM-DD'), period_start, period_end, status, conversations, failed_conversations, error_message, started_at, completed_at`
+
+func scanDigest(row pgx.Row, digest *DailyDigest, extra ...any) error {
+	return row.Scan(append([]any{&digest.ID, &digest.Date, &digest.PeriodStart, &digest.PeriodEnd, &digest.Status, &digest.Conversations,
+		&digest.FailedConversations, &digest.ErrorMessage, &digest.StartedAt, &digest.CompletedAt}, extra...)...)
+}
+
+// DailyDigest returns the digest of a date. A missing digest is
+// pgx.ErrNoRows.
+func (s *Store) DailyDigest(ctx context.Context, tenantID int64, date string) (*DailyDigest, error) {
+	var digest DailyDigest
+	var raw []byte
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		return scanDigest(conn.QueryRow(ctx, `
+			SELECT `+digestColumns+`, digest FROM daily_digests
+			WHERE tenant_id=$1 AND digest_date=$2`, tenantID, date), &digest, &raw)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > 0 {
+		var body Digest
+		if json.Unmarshal(raw, &body) == nil {
+			digest.Digest = &body
+		}
+	}
+	return &digest, nil
+}
+
+// ListDailyDigests returns digests newest first, without their bodies.
+func (s *Store) ListDailyDigests(ctx context.Context, tenantID int64, limit, offset int) ([]DailyDigest, error) {
+	digests := []DailyDigest{}
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			SELECT `+digestColumns+` FROM daily_digests
+			WHERE tenant_id=$1
+			ORDER BY digest_date DESC
+			LIMIT $2 OFFSET $3`, tenantID, limit, offset)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var digest DailyDigest
+			if err := scanDigest(rows, &digest); err != nil {
+				return err
+			}
+			digests = append(digests, digest)
+		}
+		return rows.Err()
+	})
+	return digests, err
+}
+
+// activeConversations returns the conversations with messages in the
+// period, the most recently active first.
+func (s *Store) activeConversations(ctx context.Context, tenantID int64, start, end time.Time) ([]DigestConversation, error) {
+	conversations := []DigestConversation{}
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			SELECT c.id, COALESCE(NULLIF(c.contact_name, ''), c.contact_number), COUNT(*)
+			FROM messages m
+			JOIN conversations c ON c.id = m.conversation_id AND c.tenant_id = m.tenant_id
+			WHERE m.tenant_id=$1 AND m.timestamp >= $2 AND m.timestamp < $3
+			GROUP BY c.id
+			ORDER BY MAX(m.timestamp) DESC
+			LIMIT $4`, tenantID, start, end, maxDigestConversations)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var conversation DigestConversation
+			if err := rows.Scan(&conversation.ConversationID, &conversation.ContactName, &conversation.Messages); err != nil {
+				return err
+			}
+			conversations = append(conversations, conversation)
+		}
+		return rows.Err()
+	})
+	return conversations, err
+}
+
+func (s *Store) periodMessages(ctx context.Context, tenantID, conversationID int64, start, end time.Time) ([]TranscriptMessage, error) {
+	messages := []TranscriptMessage{}
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			SELECT m.sender, m.content, m.timestamp, c.contact_number, COALESCE(c.contact_name, '')
+			FROM messages m
+			JOIN conversations c ON c.id = m.conversation_id AND c.tenant_id = m.tenant_id
+			WHERE m.tenant_id=$1 AND m.conversation_id=$2 AND m.timestamp >= $3 AND m.timestamp < $4
+			ORDER BY m.timestamp ASC`, tenantID, conversationID, start, end)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var message TranscriptMessage
+			var contactNumber, contactName string
+			if err := rows.Scan(&message.Sender, &message.Content, &message.Timestamp, &contactNumber, &contactName); err != nil {
+				return err
+			}
+			message.Sender = SenderName(message.Sender, contactNumber, contactName)
+			messages = append(messages, message)
+		}
+		return rows.Err()
+	})
+	return messages, err
+}
+
+// saveDailySummary stores a conversation's summary for a digest date,
+// replacing the one of an earlier run.
+func (s *Store) saveDailySummary(ctx context.Context, tenantID, conversationID int64, date string, start, end time.Time, result *SummaryResult) (int64, error) {
+	keyPoints, err := json.Marshal(map[string]any{
+		"key_points":   result.KeyPoints,
+		"action_items": result.ActionItems,
+		"sentiment":    result.Sentiment,
+		"topics":       result.Topics,
+		"from":         start,
+		"to":           end,
+	})
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		if _, err := conn.Exec(ctx, `
+			DELETE FROM daily_summaries WHERE tenant_id=$1 AND conversation_id=$2 AND summary_date=$3`, tenantID, conversationID, date); err != nil {
+			return err
+		}
+		return conn.QueryRow(ctx, `
+			INSERT INTO daily_summaries (tenant_id, conversation_id, summary_text, key_points_json, summary_date, created_at)
+			VALUES ($1,$2,$3,$4,$5,$6)
+			RETURNING id`, tenantID, conversationID, result.Summary, string(keyPoints), date, time.Now().UTC()).Scan(&id)
+	})
+	return id, err
+}
+
+func (s *Store) digestImportantMessages(ctx context.Context, tenantID int64, start, end time.Time) ([]DigestMessage, error) {
+	messages := []DigestMessage{}
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			SELECT m.id, m.conversation_id, COALESCE(NULLIF(c.contact_name, ''), c.contact_number), i.priority, COALESCE(i.reason, ''), m.content, m.timestamp
+			FROM important_messages i
+			JOIN messages m ON m.id = i.message_id AND m.tenant_id = i.tenant_id
+			JOIN conversations c ON c.id = m.conversation_id AND c.tenant_id = m.tenant_id
+			WHERE i.tenant_id=$1 AND m.timestamp >= $2 AND m.timestamp < $3
+			ORDER BY CASE LOWER(i.priority) WHEN 'high' THEN 0 WHEN 'medium' THEN 1 ELSE 2 END, m.timestamp DESC
+			LIMIT $4`, tenantID, start, end, digestImportantMessages)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var message DigestMessage
+			if err := rows.Scan(&message.MessageID, &message.ConversationID, &message.ContactName, &message.Priority, &message.Reason, &message.Content, &message.Timestamp); err != nil {
+				return err
+			}
+			if len(message.Content) > digestContentLength {
+				message.Content = truncateUTF8(message.Content, digestContentLength)
+			}
+			messages = append(messages, message)
+		}
+		return rows.Err()
+	})
+	return messages, err
+}
+
+func (s *Store) digestActionItems(ctx context.Context, tenantID int64, start, end time.Time) ([]DigestActionItem, error) {
+	items := []DigestActionItem{}
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			SELECT a.id, a.conversation_id, COALESCE(NULLIF(c.contact_name, ''), c.contact_number), a.description, a.status, a.due_date, a.created_at
+			FROM action_items a
+			JOIN conversations c ON c.id = a.conversation_id AND c.tenant_id = a.tenant_id
+			WHERE a.tenant_id=$1 AND a.created_at >= $2 AND a.created_at < $3
+			ORDER BY a.created_at ASC
+			LIMIT $4`, tenantID, start, end, digestActionItems)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var item DigestActionItem
+			if err := rows.Scan(&item.ID, &item.ConversationID, &item.ContactName, &item.Description, &item.Status, &item.DueDate, &item.CreatedAt); err != nil {
+				return err
+			}
+			items = append(items, item)
+		}
+		return rows.Err()
+	})
+	return items, err
+}
+
+// sentimentSample is the sentiment of one analyzed inbound message.
+type sentimentSample struct {
+	conversationID int64
+	contactName    string
+	timestamp      time.Time
+	value          float64
+}
+
+func (s *Store) sentimentSamples(ctx context.Context, tenantID int64, start, end time.Time) ([]sentimentSample, error) {
+	samples := []sentimentSample{}
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			SELECT m.conversation_id, COALESCE(NULLIF(c.contact_name, ''), c.contact_number), m.timestamp,
+			       COALESCE(m.metadata_json->'analysis'->>'sentiment', ''),
+			       COALESCE((m.metadata_json->'analysis'->>'sentiment_score')::DOUBLE PRECISION, 0)
+			FROM messages m
+			JOIN conversations c ON c.id = m.conversation_id AND c.tenant_id = m.tenant_id
+			WHERE m.tenant_id=$1 AND m.timestamp >= $2 AND m.timestamp < $3
+			  AND m.sender NOT IN `+outboundSenders+` AND m.metadata_json ? 'analysis'`, tenantID, start, end)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var sample sentimentSample
+			var label string
+			var score float64
+			if err := rows.Scan(&sample.conversationID, &sample.contactName, &sample.timestamp, &label, &score); err != nil {
+				return err
+			}
+			sample.value = sentimentValue(label, score)
+			samples = append(samples, sample)
+		}
+		return rows.Err()
+	})
+	return samples, err
+}
+
+// sentimentShifts compares each conversation's average sentiment from start
+// on with its average before start, largest changes first. Conversations
+// missing either side are left out.
+func sentimentShifts(samples []sentimentSample, start time.Time) []SentimentShift {
+	type sums struct {
+		contactName                 string
+		previous, current           float64
+		previousCount, currentCount int
+	}
+	byConversation := map[int64]*sums{}
+	for _, sample := range samples {
+		entry := byConversation[sample.conversationID]
+		if entry == nil {
+			entry = &sums{contactName: sample.contactName}
+			byConversation[sample.conversationID] = entry
+		}
+		if sample.timestamp.Before(start) {
+			entry.previous += sample.value
+			entry.previousCount++
+		} else {
+			entry.current += sample.value
+			entry.currentCount++
+		}
+	}
+	shifts := []SentimentShift{}
+	for conversationID, entry := range byConversation {
+		if entry.previousCount == 0 || entry.currentCount == 0 {
+			continue
+		}
+		previous := entry.previous / float64(entry.previousCount)
+		current := entry.current / float64(entry.currentCount)
+		change := current - previous
+		if math.Abs(change) < sentimentShiftThreshold {
+			continue
+		}
+		direction := "improved"
+		if change < 0 {
+			direction = "worsened"
+		}
+		shifts = append(shifts, SentimentShift{
+			ConversationID: conversationID,
+			ContactName:    entry.contactName,
+			Previous:       round1(previous),
+			Current:        round1(current),
+			Change:         round1(change),
+			Direction:      direction,
+		})
+	}
+	sort.Slice(shifts, func(i, j int) bool {
+		if a, b := math.Abs(shifts[i].Change), math.Abs(shifts[j].Change); a != b {
+			return a > b
+		}
+		return shifts[i].ConversationID < shifts[j].ConversationID
+	})
+	if len(shifts) > digestSentimentShifts {
+		shifts = shifts[:digestSentimentShifts]
+	}
+	return shifts
+}
+
+// GenerateDailyDigest makes a claimed digest: every conversation active in
+// the period is summarized by the providers of daily_summary, and the
+// period's important messages, new action items and sentiment shifts are
+// collected. Conversations that cannot be summarized are counted and
+// skipped; the digest fails only when its data cannot be loaded.
+func (s *Service) GenerateDailyDigest(ctx context.Context, tenantID int64, date string, start, end time.Time) (*Digest, error) {
+	digest, conversations, failed, err := s.buildDigest(ctx, tenantID, date, start, end)
+	status := DigestCompleted
+	var message *string
+	if err != nil {
+		status, digest = DigestFailed, nil
+		text := err.Error()
+		message = &text
+	} else if failed > 0 {
+		text := fmt.Sprintf("%d of %d conversations could not be summarized", failed, conversations)
+		message = &text
+	}
+	// The outcome is recorded even when ctx ran out.
+	if finishErr := s.Store.finishDigest(context.WithoutCancel(ctx), tenantID, date, status, conversations, failed, digest, message); err == nil {
+		err = finishErr
+	}
+	return digest, err
+}
+
+func (s *Service) buildDigest(ctx context.Context, tenantID int64, date string, start, end time.Time) (*Digest, int, int, error) {
+	digest := &Digest{}
+	var err error
+	if digest.Conversations, err = s.Store.activeConversations(ctx, tenantID, start, end); err != nil {
+		return nil, 0, 0, err
+	}
+	failed := 0
+	for i := range digest.Conversations {
+		conversation := &digest.Conversations[i]
+		if err := s.summarizeForDigest(ctx, tenantID, date, start, end, conversation); err != nil {
+			if ctx.Err() != nil {
+				return nil, len(digest.Conversations), failed, ctx.Err()
+			}
+			conversation.Error = err.Error()
+			failed++
+		}
+	}
+	if digest.ImportantMessages, err = s.Store.digestImportantMessages(ctx, tenantID, start, end); err != nil {
+		return nil, len(digest.Conversations), failed, err
+	}
+	if digest.ActionItems, err = s.Store.digestActionItems(ctx, tenantID, start, end); err != nil {
+		return nil, len(digest.Conversations), failed, err
+	}
+	samples, err := s.Store.sentimentSamples(ctx, tenantID, start.Add(-sentimentBaseline), end)
+	if err != nil {
+		return nil, len(digest.Conversations), failed, err
+	}
+	digest.SentimentShifts = sentimentShifts(samples, start)
+	return digest, len(digest.Conversations), failed, nil
+}
+
+func (s *Service) summarizeForDigest(ctx context.Context, tenantID int64, date string, start, end time.Time, conversation *DigestConversation) error {
+	messages, err := s.Store.periodMessages(ctx, tenantID, conversation.ConversationID, start, end)
+	if err != nil {
+		return err
+	}
+	result, err := s.summarizeTranscript(ctx, tenantID, 0, FeatureDailySummary, conversation.ContactName, messages)
+	if err != nil {
+		return err
+	}
+	id, err := s.Store.saveDailySummary(ctx, tenantID, conversation.ConversationID, date, start, end, result)
+	if err != nil {
+		return err
+	}
+	conversation.SummaryID = &id
+	conversation.Summary = result.Summary
+	conversation.Sentiment = result.Sentiment
+	return nil
+}
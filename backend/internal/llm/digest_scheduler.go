@@ -0,0 +1,119 @@
+package llm
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultDigestTimeout bounds one digest run.
+const DefaultDigestTimeout = 30 * time.Minute
+
+// DigestScheduler makes each tenant's daily digest once its local run time
+// has passed. Every tenant with digests enabled gets a goroutine that checks
+// its settings each Interval; digests are claimed in the database, so
+// replicas do not make the same digest twice, and a run lost with its
+// replica is claimed again once stale. Tenants start when they enable
+// digests or when their WhatsApp session attaches, e.g. after a restart; a
+// digest missed while no replica ran is made later that day.
+type DigestScheduler struct {
+	Interval time.Duration
+	Timeout  time.Duration
+
+	service *Service
+
+	mu      sync.Mutex
+	workers map[int64]context.CancelFunc
+}
+
+func NewDigestScheduler(service *Service) *DigestScheduler {
+	return &DigestScheduler{Interval: time.Minute, Timeout: DefaultDigestTimeout, service: service, workers: map[int64]context.CancelFunc{}}
+}
+
+// Resume starts the tenant if it has digests enabled.
+func (s *DigestScheduler) Resume(ctx context.Context, tenantID int64) error {
+	settings, err := s.service.Store.DigestSettings(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	if settings.Enabled {
+		s.EnsureTenant(context.Background(), tenantID)
+	}
+	return nil
+}
+
+func (s *DigestScheduler) EnsureTenant(ctx context.Context, tenantID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.workers[tenantID]; ok {
+		return
+	}
+	workerCtx, cancel := context.WithCancel(ctx)
+	s.workers[tenantID] = cancel
+	go s.run(workerCtx, tenantID)
+}
+
+// Stop ends the tenant's goroutine, e.g. once digests are disabled. A digest
+// under way is finished.
+func (s *DigestScheduler) Stop(tenantID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cancel, ok := s.workers[tenantID]; ok {
+		cancel()
+		delete(s.workers, tenantID)
+	}
+}
+
+func (s *DigestScheduler) run(ctx context.Context, tenantID int64) {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	// last is the latest date this replica saw finished.
+	var last string
+	for {
+		s.tick(ctx, tenantID, &last)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *DigestScheduler) tick(ctx context.Context, tenantID int64, last *string) {
+	settings, err := s.service.Store.DigestSettings(ctx, tenantID)
+	if err != nil || !settings.Enabled {
+		return
+	}
+	date, ok := settings.due(time.Now())
+	if !ok || date == *last {
+		return
+	}
+	start, end, err := settings.Period(date)
+	if err != nil {
+		return
+	}
+	claimed, err := s.service.Store.ClaimDigest(ctx, tenantID, date, start, end, false)
+	if err != nil {
+		return
+	}
+	if !claimed {
+		// Another run is under way; it is claimed again if it goes stale.
+		if digest, err := s.service.Store.DailyDigest(ctx, tenantID, date); err == nil && digest.Status != DigestRunning {
+			*last = date
+		}
+		return
+	}
+	*last = date
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = DefaultDigestTimeout
+	}
+	runCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	// Failures are stored with the digest.
+	_, _ = s.service.GenerateDailyDigest(runCtx, tenantID, date, start, end)
+}
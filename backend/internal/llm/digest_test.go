@@ -0,0 +1,108 @@
+package llm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateDigestSettings(t *testing.T) {
+	settings := DigestSettings{Enabled: true}
+	if err := ValidateDigestSettings(&settings); err != nil {
+		t.Fatalf("defaults rejected: %v", err)
+	}
+	if settings.LocalTime != DefaultDigestTime || settings.Timezone != "UTC" {
+		t.Fatalf("defaults not filled in: %+v", settings)
+	}
+	if err := ValidateDigestSettings(&DigestSettings{LocalTime: "25:00"}); err == nil {
+		t.Fatal("invalid time accepted")
+	}
+	if err := ValidateDigestSettings(&DigestSettings{Timezone: "Mars/Olympus"}); err == nil {
+		t.Fatal("unknown zone accepted")
+	}
+}
+
+func TestDigestPeriodFollowsLocalTime(t *testing.T) {
+	settings := DigestSettings{LocalTime: "08:30", Timezone: "Europe/Berlin"}
+	start, end, err := settings.Period("2024-03-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Clocks went forward that night, so the period is 23 hours long.
+	if want := time.Date(2024, 3, 31, 6, 30, 0, 0, time.UTC); !end.Equal(want) {
+		t.Fatalf("end = %v, want %v", end, want)
+	}
+	if want := time.Date(2024, 3, 30, 7, 30, 0, 0, time.UTC); !start.Equal(want) {
+		t.Fatalf("start = %v, want %v", start, want)
+	}
+	if _, _, err := settings.Period("31/03/2024"); err == nil {
+		t.Fatal("invalid date accepted")
+	}
+}
+
+func TestDigestDue(t *testing.T) {
+	settings := DigestSettings{LocalTime: "08:00", Timezone: "America/New_York"}
+	// 11:59 UTC is 07:59 in New York.
+	before := time.Date(2024, 6, 10, 11, 59, 0, 0, time.UTC)
+	if date, ok := settings.due(before); ok {
+		t.Fatalf("due before the run time: %s", date)
+	}
+	if date := settings.LatestDate(before); date != "2024-06-09" {
+		t.Fatalf("latest date before the run time = %s", date)
+	}
+	after := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	if date, ok := settings.due(after); !ok || date != "2024-06-10" {
+		t.Fatalf("due = %s, %v; want 2024-06-10", date, ok)
+	}
+	// Past midnight UTC it is still the evening before in New York.
+	if date, ok := settings.due(time.Date(2024, 6, 11, 2, 0, 0, 0, time.UTC)); !ok || date != "2024-06-10" {
+		t.Fatalf("due = %s, %v; want 2024-06-10", date, ok)
+	}
+}
+
+func TestSentimentShifts(t *testing.T) {
+	start := time.Date(2024, 6, 10, 8, 0, 0, 0, time.UTC)
+	earlier, during := start.Add(-48*time.Hour), start.Add(time.Hour)
+	samples := []sentimentSample{
+		// Worsened from 0.6 to -0.7.
+		{conversationID: 1, contactName: "Ana", timestamp: earlier, value: 0.4},
+		{conversationID: 1, contactName: "Ana", timestamp: earlier, value: 0.8},
+		{conversationID: 1, contactName: "Ana", timestamp: during, value: -0.7},
+		// Improved by 0.6.
+		{conversationID: 2, contactName: "Ben", timestamp: earlier, value: -0.2},
+		{conversationID: 2, contactName: "Ben", timestamp: during, value: 0.4},
+		// Too small a change.
+		{conversationID: 3, contactName: "Cy", timestamp: earlier, value: 0},
+		{conversationID: 3, contactName: "Cy", timestamp: during, value: 0.3},
+		// Nothing to compare with.
+		{conversationID: 4, contactName: "Di", timestamp: during, value: -1},
+	}
+	shifts := sentimentShifts(samples, start)
+	if len(shifts) != 2 {
+		t.Fatalf("shifts = %+v", shifts)
+	}
+	if shifts[0].ConversationID != 1 || shifts[0].Direction != "worsened" || shifts[0].Previous != 0.6 || shifts[0].Current != -0.7 || shifts[0].Change != -1.3 {
+		t.Fatalf("largest shift = %+v", shifts[0])
+	}
+	if shifts[1].ConversationID != 2 || shifts[1].Direction != "improved" || shifts[1].ContactName != "Ben" {
+		t.Fatalf("second shift = %+v", shifts[1])
+	}
+}
+
+func TestDigestForConversation(t *testing.T) {
+	digest := Digest{
+		ImportantMessages: []DigestMessage{{MessageID: 1, ConversationID: 7}, {MessageID: 2, ConversationID: 8}},
+		ActionItems:       []DigestActionItem{{ID: 3, ConversationID: 8}},
+		SentimentShifts:   []SentimentShift{{ConversationID: 7}},
+		Conversations:     []DigestConversation{{ConversationID: 7}, {ConversationID: 8}},
+	}
+	filtered := digest.ForConversation(7)
+	if len(filtered.ImportantMessages) != 1 || filtered.ImportantMessages[0].MessageID != 1 {
+		t.Fatalf("important messages = %+v", filtered.ImportantMessages)
+	}
+	if filtered.ActionItems == nil || len(filtered.ActionItems) != 0 {
+		t.Fatalf("action items = %#v, want an empty list", filtered.ActionItems)
+	}
+	if len(filtered.SentimentShifts) != 1 || len(filtered.Conversations) != 1 {
+		t.Fatalf("filtered = %+v", filtered)
+	}
+}
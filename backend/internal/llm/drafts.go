@@ -0,0 +1,313 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// MaxDraftSuggestions caps the replies suggested at once.
+	MaxDraftSuggestions = 3
+	// draftHistoryMessages is how much of the conversation a draft sees.
+	draftHistoryMessages = 20
+)
+
+// Draft statuses. A draft is pending until the agent accepts one of its
+// suggestions or rejects them all.
+const (
+	DraftPending  = "pending"
+	DraftAccepted = "accepted"
+	DraftRejected = "rejected"
+)
+
+var (
+	// ErrDraftDecided is returned when feedback is given twice for a draft.
+	ErrDraftDecided = errors.New("draft was already accepted or rejected")
+	// ErrDraftIndex is returned when an accepted suggestion does not exist.
+	ErrDraftIndex = errors.New("draft has no suggestion at this index")
+)
+
+// DraftOptions shape a reply draft. Count defaults to one suggestion; Tone
+// and Guidelines replace the tenant's reply settings when set. With Draft
+// set the agent's text is rewritten as Rewrite says instead of suggesting
+// new replies.
+type DraftOptions struct {
+	ProviderID int64
+	Count      int
+	Tone       string
+	Guidelines string
+	Draft      string
+	Rewrite    string
+	Language   string
+	CreatedBy  *int64
+}
+
+// ReplyDraft is a stored set of suggested replies and what the agent did
+// with them. Edited is true when the accepted reply was changed before
+// sending.
+type ReplyDraft struct {
+	ID             int64      `json:"id"`
+	ConversationID int64      `json:"conversation_id"`
+	ProviderID     *int64     `json:"provider_id"`
+	Model          string     `json:"model"`
+	Mode           string     `json:"mode"`
+	Rewrite        string     `json:"rewrite,omitempty"`
+	Suggestions    []string   `json:"suggestions"`
+	Status         string     `json:"status"`
+	AcceptedIndex  *int       `json:"accepted_index"`
+	FinalText      *string    `json:"final_text"`
+	Edited         bool       `json:"edited"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DecidedAt      *time.Time `json:"decided_at"`
+}
+
+// ReplySettings are a tenant's instructions for drafted replies.
+type ReplySettings struct {
+	Tone       string `json:"tone"`
+	Guidelines string `json:"guidelines"`
+}
+
+// DraftReply suggests replies for a conversation, or rewrites the agent's
+// draft, from its latest messages, the latest analysis of an inbound message
+// and the tenant's reply settings. The suggestions are stored so the agent's
+// decision can be recorded against the provider that made them.
+func (s *Service) DraftReply(ctx context.Context, tenantID, conversationID int64, options DraftOptions) (*ReplyDraft, error) {
+	recent, err := s.Store.RecentMessages(ctx, tenantID, conversationID, draftHistoryMessages)
+	if err != nil {
+		return nil, err
+	}
+	if len(recent) == 0 && options.Draft == "" {
+		return nil, ErrEmptyConversation
+	}
+	request := DraftRequest{
+		History:  make([]ContextMessage, 0, len(recent)),
+		Count:    draftCount(options.Count),
+		Draft:    options.Draft,
+		Rewrite:  options.Rewrite,
+		Language: options.Language,
+	}
+	for i := len(recent) - 1; i >= 0; i-- {
+		request.History = append(request.History, recent[i])
+	}
+	if request.Draft != "" {
+		// A rewrite is one text, not alternatives.
+		request.Count = 1
+	}
+	if request.ContactName, err = s.Store.ConversationContactName(ctx, tenantID, conversationID); err != nil {
+		return nil, err
+	}
+	if request.Analysis, err = s.Store.LatestAnalysis(ctx, tenantID, conversationID); err != nil {
+		return nil, err
+	}
+	settings, err := s.Store.ReplySettings(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	request.Tone, request.Guidelines = settings.Tone, settings.Guidelines
+	if options.Tone != "" {
+		request.Tone = options.Tone
+	}
+	if options.Guidelines != "" {
+		request.Guidelines = options.Guidelines
+	}
+
+	redaction, err := s.redaction(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	request = redactDraft(redaction, request)
+
+	draft := &ReplyDraft{ConversationID: conversationID, Mode: "suggest", Status: DraftPending}
+	if request.Draft != "" {
+		draft.Mode, draft.Rewrite = "rewrite", request.Rewrite
+	}
+	err = s.runFeature(ctx, tenantID, FeatureReplyDrafting, options.ProviderID, nil, FeatureReplyDrafting, nil, func(provider Provider) (UsageRecord, error) {
+		drafter, ok := provider.(ReplyDrafter)
+		if !ok {
+			return UsageRecord{}, ErrDraftUnsupported
+		}
+		result, usage, err := drafter.DraftReply(ctx, request)
+		if err != nil {
+			return usage, err
+		}
+		if len(result.Suggestions) == 0 {
+			usage.ValidationErrors++
+			return usage, &ValidationError{Problems: []string{"no suggestions"}}
+		}
+		if len(result.Suggestions) > request.Count {
+			result.Suggestions = result.Suggestions[:request.Count]
+		}
+		providerID := provider.GetConfig().ID
+		draft.ProviderID, draft.Suggestions = &providerID, result.Suggestions
+		draft.Model = usage.Model
+		if draft.Model == "" {
+			draft.Model = provider.GetConfig().ModelName
+		}
+		return usage, nil
+	})
+	s.logRedaction(ctx, tenantID, nil, FeatureReplyDrafting, redaction)
+	if err != nil {
+		return nil, err
+	}
+	redaction.RestoreAll(draft.Suggestions)
+	if err := s.Store.CreateReplyDraft(ctx, tenantID, draft, options.CreatedBy); err != nil {
+		return nil, err
+	}
+	return draft, nil
+}
+
+// redactDraft returns a copy of request with the conversation and the
+// agent's draft redacted. Tone and guidelines are the tenant's own text and
+// are sent as they are.
+func redactDraft(redaction *Redaction, request DraftRequest) DraftRequest {
+	if redaction == nil {
+		return request
+	}
+	request.ContactName = redaction.Redact(request.ContactName)
+	request.Draft = redaction.Redact(request.Draft)
+	history := make([]ContextMessage, len(request.History))
+	for i, message := range request.History {
+		message.Sender = redaction.Redact(message.Sender)
+		message.Content = redaction.Redact(message.Content)
+		history[i] = message
+	}
+	request.History = history
+	if request.Analysis != nil {
+		analysis := *request.Analysis
+		analysis.Reason = redaction.Redact(analysis.Reason)
+		analysis.ActionRequired = redaction.Redact(analysis.ActionRequired)
+		request.Analysis = &analysis
+	}
+	return request
+}
+
+func draftCount(count int) int {
+	if count <= 0 {
+		return 1
+	}
+	if count > MaxDraftSuggestions {
+		return MaxDraftSuggestions
+	}
+	return count
+}
+
+// LatestAnalysis returns the analysis of the newest analyzed message of a
+// conversation, or nil when none was analyzed.
+func (s *Store) LatestAnalysis(ctx context.Context, tenantID, conversationID int64) (*AnalysisResult, error) {
+	var raw []byte
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		return conn.QueryRow(ctx, `
+			SELECT metadata_json->'analysis' FROM messages
+			WHERE tenant_id=$1 AND conversation_id=$2 AND metadata_json ? 'analysis'
+			ORDER BY timestamp DESC
+			LIMIT 1`, tenantID, conversationID).Scan(&raw)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var analysis AnalysisResult
+	if err := json.Unmarshal(raw, &analysis); err != nil {
+		return nil, nil
+	}
+	return &analysis, nil
+}
+
+// ReplySettings returns the tenant's reply settings, empty when none are
+// set.
+func (s *Store) ReplySettings(ctx context.Context, tenantID int64) (ReplySettings, error) {
+	var settings ReplySettings
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		return conn.QueryRow(ctx, `
+			SELECT reply_tone, reply_guidelines FROM llm_prompt_settings WHERE tenant_id=$1`, tenantID).Scan(&settings.Tone, &settings.Guidelines)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ReplySettings{}, nil
+	}
+	return settings, err
+}
+
+// CreateReplyDraft stores a new draft and fills in its ID and creation time.
+func (s *Store) CreateReplyDraft(ctx context.Context, tenantID int64, draft *ReplyDraft, createdBy *int64) error {
+	suggestions, err := json.Marshal(draft.Suggestions)
+	if err != nil {
+		return err
+	}
+	return s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		return conn.QueryRow(ctx, `
+			INSERT INTO reply_drafts (tenant_id, conversation_id, provider_id, model, mode, rewrite, suggestions, status, created_by, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+			RETURNING id, created_at`,
+			tenantID, draft.ConversationID, draft.ProviderID, draft.Model, draft.Mode, draft.Rewrite, suggestions, draft.Status, createdBy,
+		).Scan(&draft.ID, &draft.CreatedAt)
+	})
+}
+
+const replyDraftColumns = `id, conversation_id, provider_id, model, mode, rewrite, suggestions, status, accepted_index, final_text, edited, created_at, decided_at`
+
+func scanReplyDraft(row pgx.Row, draft *ReplyDraft) error {
+	var suggestions []byte
+	if err := row.Scan(&draft.ID, &draft.ConversationID, &draft.ProviderID, &draft.Model, &draft.Mode, &draft.Rewrite, &suggestions,
+		&draft.Status, &draft.AcceptedIndex, &draft.FinalText, &draft.Edited, &draft.CreatedAt, &draft.DecidedAt); err != nil {
+		return err
+	}
+	return json.Unmarshal(suggestions, &draft.Suggestions)
+}
+
+// DecideReplyDraft records whether the agent accepted a suggestion of a
+// pending draft or rejected them all. finalText is what was actually sent,
+// if known; it marks the draft as edited when it differs from the accepted
+// suggestion. A missing draft is pgx.ErrNoRows.
+func (s *Store) DecideReplyDraft(ctx context.Context, tenantID, draftID int64, status string, index *int, finalText *string, decidedBy *int64) (*ReplyDraft, error) {
+	var draft ReplyDraft
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		row := conn.QueryRow(ctx, `SELECT `+replyDraftColumns+` FROM reply_drafts WHERE tenant_id=$1 AND id=$2`, tenantID, draftID)
+		if err := scanReplyDraft(row, &draft); err != nil {
+			return err
+		}
+		if draft.Status != DraftPending {
+			return ErrDraftDecided
+		}
+		edited, err := draftEdited(draft.Suggestions, status, index, finalText)
+		if err != nil {
+			return err
+		}
+		if status == DraftRejected {
+			index = nil
+		}
+		row = conn.QueryRow(ctx, `
+			UPDATE reply_drafts SET status=$3, accepted_index=$4, final_text=$5, edited=$6, decided_by=$7, decided_at=NOW()
+			WHERE tenant_id=$1 AND id=$2 AND status='pending'
+			RETURNING `+replyDraftColumns, tenantID, draftID, status, index, finalText, edited, decidedBy)
+		if err := scanReplyDraft(row, &draft); errors.Is(err, pgx.ErrNoRows) {
+			// Decided by a concurrent request since it was read.
+			return ErrDraftDecided
+		} else if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &draft, nil
+}
+
+// draftEdited checks a decision against the draft's suggestions and reports
+// whether the reply sent differs from the accepted suggestion.
+func draftEdited(suggestions []string, status string, index *int, finalText *string) (bool, error) {
+	if status != DraftAccepted {
+		return false, nil
+	}
+	if index == nil || *index < 0 || *index >= len(suggestions) {
+		return false, ErrDraftIndex
+	}
+	return finalText != nil && normalizeContent(*finalText) != normalizeContent(suggestions[*index]), nil
+}
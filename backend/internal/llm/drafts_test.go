@@ -0,0 +1,42 @@
+package llm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDraftEdited(t *testing.T) {
+	suggestions := []string{"It ships today.", "Sorry, checking now."}
+	index := func(i int) *int { return &i }
+	text := func(s string) *string { return &s }
+
+	tests := []struct {
+		name      string
+		status    string
+		index     *int
+		finalText *string
+		edited    bool
+		err       error
+	}{
+		{"rejected", DraftRejected, nil, nil, false, nil},
+		{"accepted as is", DraftAccepted, index(1), text("Sorry,  checking now."), false, nil},
+		{"accepted without text", DraftAccepted, index(0), nil, false, nil},
+		{"accepted and edited", DraftAccepted, index(0), text("It ships tomorrow."), true, nil},
+		{"missing index", DraftAccepted, nil, nil, false, ErrDraftIndex},
+		{"index out of range", DraftAccepted, index(2), nil, false, ErrDraftIndex},
+	}
+	for _, test := range tests {
+		edited, err := draftEdited(suggestions, test.status, test.index, test.finalText)
+		if edited != test.edited || !errors.Is(err, test.err) {
+			t.Fatalf("%s: got edited=%v err=%v", test.name, edited, err)
+		}
+	}
+}
+
+func TestDraftCount(t *testing.T) {
+	for count, want := range map[int]int{-1: 1, 0: 1, 2: 2, 3: 3, 9: MaxDraftSuggestions} {
+		if got := draftCount(count); got != want {
+			t.Fatalf("draftCount(%d)=%d, expected %d", count, got, want)
+		}
+	}
+}
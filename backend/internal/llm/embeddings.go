@@ -0,0 +1,298 @@
+package llm
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// QueueFeatureEmbedding marks queue messages that embed a message for
+// semantic search instead of analyzing it.
+const QueueFeatureEmbedding = "embedding"
+
+const (
+	DefaultSemanticResults = 10
+	MaxSemanticResults     = 50
+	// maxSemanticScan bounds how many stored vectors are ranked in process
+	// when pgvector is not installed; older messages are left out beyond it.
+	maxSemanticScan = 20000
+)
+
+// SemanticMatch is a message found by semantic search. Score is the cosine
+// similarity to the query, 1 for identical meaning.
+type SemanticMatch struct {
+	MessageID      int64     `json:"message_id"`
+	ConversationID int64     `json:"conversation_id"`
+	ContactName    string    `json:"contact_name"`
+	Sender         string    `json:"sender"`
+	Content        string    `json:"content"`
+	Timestamp      time.Time `json:"timestamp"`
+	Score          float64   `json:"score"`
+}
+
+// Embed turns texts into vectors with the first provider assigned to
+// semantic_search that supports embeddings, and returns the provider used.
+// ErrEmbedUnsupported means no provider in the chain could.
+func (s *Service) Embed(ctx context.Context, tenantID int64, texts []string, messageID *int64, usageFeature string) (*Embeddings, int64, error) {
+	redaction, err := s.redaction(ctx, tenantID)
+	if err != nil {
+		return nil, 0, err
+	}
+	texts = redaction.RedactAll(texts)
+	var result *Embeddings
+	var providerID int64
+	err = s.runFeature(ctx, tenantID, FeatureSemanticSearch, 0, messageID, usageFeature, nil, func(provider Provider) (UsageRecord, error) {
+		embedder, ok := provider.(Embedder)
+		if !ok {
+			return UsageRecord{}, ErrEmbedUnsupported
+		}
+		embeddings, usage, err := embedder.Embed(ctx, texts)
+		if err == nil {
+			result, providerID = embeddings, provider.GetConfig().ID
+		}
+		return usage, err
+	})
+	s.logRedaction(ctx, tenantID, messageID, usageFeature, redaction)
+	return result, providerID, err
+}
+
+// EmbedMessages embeds messages in one call and stores their vectors.
+func (s *Service) EmbedMessages(ctx context.Context, tenantID int64, items []BatchItem) error {
+	texts := make([]string, len(items))
+	for i, item := range items {
+		texts[i] = item.Content
+	}
+	var messageID *int64
+	if len(items) == 1 {
+		messageID = &items[0].MessageID
+	}
+	embeddings, providerID, err := s.Embed(ctx, tenantID, texts, messageID, "embed")
+	if err != nil {
+		return err
+	}
+	for i, item := range items {
+		if err := s.Store.StoreEmbedding(ctx, tenantID, item.MessageID, providerID, embeddings.Model, embeddings.Vectors[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SemanticSearch returns up to limit messages closest in meaning to query.
+// Only messages embedded with the model that embedded the query are
+// searched.
+func (s *Service) SemanticSearch(ctx context.Context, tenantID int64, query string, limit int) ([]SemanticMatch, error) {
+	embeddings, _, err := s.Embed(ctx, tenantID, []string{query}, nil, "semantic_search")
+	if err != nil {
+		return nil, err
+	}
+	return s.Store.SearchEmbeddings(ctx, tenantID, 0, embeddings.Model, embeddings.Vectors[0], limit)
+}
+
+// StoreEmbedding saves the vector of a message, replacing an earlier one from
+// the same model.
+func (s *Store) StoreEmbedding(ctx context.Context, tenantID, messageID, providerID int64, model string, vector []float32) error {
+	pgvector, err := s.hasVectorColumn(ctx, tenantID)
+	if err != nil {
+		return err
+	}
+	return s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		if pgvector {
+			_, err := conn.Exec(ctx, `
+				INSERT INTO message_embeddings (tenant_id, message_id, provider_id, model, dimensions, embedding, embedding_vector, created_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7::vector, NOW())
+				ON CONFLICT (message_id, model) DO UPDATE
+				SET provider_id=EXCLUDED.provider_id, dimensions=EXCLUDED.dimensions, embedding=EXCLUDED.embedding,
+					embedding_vector=EXCLUDED.embedding_vector, created_at=EXCLUDED.created_at`,
+				tenantID, messageID, providerID, model, len(vector), vector, vectorLiteral(vector))
+			return err
+		}
+		_, err := conn.Exec(ctx, `
+			INSERT INTO message_embeddings (tenant_id, message_id, provider_id, model, dimensions, embedding, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, NOW())
+			ON CONFLICT (message_id, model) DO UPDATE
+			SET provider_id=EXCLUDED.provider_id, dimensions=EXCLUDED.dimensions, embedding=EXCLUDED.embedding, created_at=EXCLUDED.created_at`,
+			tenantID, messageID, providerID, model, len(vector), vector)
+		return err
+	})
+}
+
+const semanticMatchColumns = `m.id, m.conversation_id, COALESCE(NULLIF(c.contact_name, ''), c.contact_number), m.sender, m.content, m.timestamp`
+
+// SearchEmbeddings ranks the tenant's messages embedded with model by cosine
+// similarity to query, within one conversation unless conversationID is 0.
+// With pgvector the database ranks them; otherwise the most recent vectors
+// are ranked here.
+func (s *Store) SearchEmbeddings(ctx context.Context, tenantID, conversationID int64, model string, query []float32, limit int) ([]SemanticMatch, error) {
+	if limit <= 0 || limit > MaxSemanticResults {
+		limit = DefaultSemanticResults
+	}
+	pgvector, err := s.hasVectorColumn(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	matches := []SemanticMatch{}
+	err = s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		if pgvector {
+			rows, err := conn.Query(ctx, `
+				SELECT `+semanticMatchColumns+`, 1 - (e.embedding_vector <=> $3::vector)
+				FROM message_embeddings e
+				JOIN messages m ON m.id = e.message_id
+				JOIN conversations c ON c.id = m.conversation_id
+				WHERE e.tenant_id=$1 AND e.model=$2 AND e.dimensions=$4 AND e.embedding_vector IS NOT NULL
+				  AND ($6::bigint = 0 OR m.conversation_id = $6)
+				ORDER BY e.embedding_vector <=> $3::vector
+				LIMIT $5`, tenantID, model, vectorLiteral(query), len(query), limit, conversationID)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var match SemanticMatch
+				if err := rows.Scan(&match.MessageID, &match.ConversationID, &match.ContactName, &match.Sender, &match.Content, &match.Timestamp, &match.Score); err != nil {
+					return err
+				}
+				matches = append(matches, match)
+			}
+			return rows.Err()
+		}
+
+		rows, err := conn.Query(ctx, `
+			SELECT e.message_id, e.embedding
+			FROM message_embeddings e
+			JOIN messages m ON m.id = e.message_id
+			WHERE e.tenant_id=$1 AND e.model=$2 AND e.dimensions=$3
+			  AND ($5::bigint = 0 OR m.conversation_id = $5)
+			ORDER BY e.created_at DESC
+			LIMIT $4`, tenantID, model, len(query), maxSemanticScan, conversationID)
+		if err != nil {
+			return err
+		}
+		var candidates []embeddingCandidate
+		for rows.Next() {
+			var candidate embeddingCandidate
+			if err := rows.Scan(&candidate.messageID, &candidate.vector); err != nil {
+				rows.Close()
+				return err
+			}
+			candidates = append(candidates, candidate)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		ranked := rankByCosine(query, candidates, limit)
+		if len(ranked) == 0 {
+			return nil
+		}
+		ids := make([]int64, len(ranked))
+		for i, candidate := range ranked {
+			ids[i] = candidate.messageID
+		}
+		rows, err = conn.Query(ctx, `
+			SELECT `+semanticMatchColumns+`
+			FROM messages m
+			JOIN conversations c ON c.id = m.conversation_id
+			WHERE m.tenant_id=$1 AND m.id = ANY($2)`, tenantID, ids)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		found := map[int64]SemanticMatch{}
+		for rows.Next() {
+			var match SemanticMatch
+			if err := rows.Scan(&match.MessageID, &match.ConversationID, &match.ContactName, &match.Sender, &match.Content, &match.Timestamp); err != nil {
+				return err
+			}
+			found[match.MessageID] = match
+		}
+		for _, candidate := range ranked {
+			if match, ok := found[candidate.messageID]; ok {
+				match.Score = candidate.score
+				matches = append(matches, match)
+			}
+		}
+		return rows.Err()
+	})
+	return matches, err
+}
+
+// hasVectorColumn reports whether the pgvector column exists. A positive
+// answer is kept; a negative one is checked again after a minute, so
+// installing the extension does not need a restart.
+func (s *Store) hasVectorColumn(ctx context.Context, tenantID int64) (bool, error) {
+	s.vectorMu.Lock()
+	defer s.vectorMu.Unlock()
+	if s.vectorColumn || time.Now().Before(s.vectorCheckedAt.Add(time.Minute)) {
+		return s.vectorColumn, nil
+	}
+	var exists bool
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		return conn.QueryRow(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM information_schema.columns
+				WHERE table_name='message_embeddings' AND column_name='embedding_vector'
+			)`).Scan(&exists)
+	})
+	if err != nil {
+		return false, err
+	}
+	s.vectorColumn, s.vectorCheckedAt = exists, time.Now()
+	return exists, nil
+}
+
+type embeddingCandidate struct {
+	messageID int64
+	vector    []float32
+	score     float64
+}
+
+// rankByCosine returns the limit candidates most similar to query, best
+// first. Candidates of another length are skipped.
+func rankByCosine(query []float32, candidates []embeddingCandidate, limit int) []embeddingCandidate {
+	ranked := make([]embeddingCandidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		if len(candidate.vector) != len(query) {
+			continue
+		}
+		candidate.score = cosineSimilarity(query, candidate.vector)
+		ranked = append(ranked, candidate)
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// vectorLiteral formats a vector as pgvector text input, e.g. [0.1,0.2].
+func vectorLiteral(vector []float32) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, value := range vector {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(value), 'g', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
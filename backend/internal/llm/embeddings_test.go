@@ -0,0 +1,29 @@
+package llm
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRankByCosine(t *testing.T) {
+	query := []float32{1, 0}
+	ranked := rankByCosine(query, []embeddingCandidate{
+		{messageID: 1, vector: []float32{0, 1}},
+		{messageID: 2, vector: []float32{2, 0}},
+		{messageID: 3, vector: []float32{1, 1}},
+		{messageID: 4, vector: []float32{1, 0, 0}},
+		{messageID: 5, vector: []float32{0, 0}},
+	}, 2)
+	if len(ranked) != 2 || ranked[0].messageID != 2 || ranked[1].messageID != 3 {
+		t.Fatalf("unexpected ranking %+v", ranked)
+	}
+	if math.Abs(ranked[0].score-1) > 1e-9 || math.Abs(ranked[1].score-math.Sqrt2/2) > 1e-6 {
+		t.Fatalf("unexpected scores %+v", ranked)
+	}
+}
+
+func TestVectorLiteral(t *testing.T) {
+	if got := vectorLiteral([]float32{0.5, -1, 0.1}); got != "[0.5,-1,0.1]" {
+		t.Fatalf("unexpected literal %s", got)
+	}
+}
@@ -1,6 +1,10 @@
 package llm
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"strings"
 	"sync"
 
@@ -8,19 +12,30 @@ import (
 )
 
 type Factory struct {
+	// MockProviders enables the mock provider, which reads fixtures from
+	// MockFixturesDir only; an empty MockFixturesDir disables fixtures.
+	MockProviders   bool
+	MockFixturesDir string
+
 	mu        sync.Mutex
 	instances map[string]Provider
+	limiter   RateLimiter
 }
 
-func NewFactory() *Factory {
-	return &Factory{instances: map[string]Provider{}}
+// NewFactory creates a provider factory. Every provider it builds is wrapped
+// with the given rate limiter; nil uses an in-process limiter.
+func NewFactory(limiter RateLimiter) *Factory {
+	if limiter == nil {
+		limiter = NewLocalRateLimiter()
+	}
+	return &Factory{instances: map[string]Provider{}, limiter: limiter}
 }
 
 func (f *Factory) CreateProvider(config *ProviderConfig) Provider {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	key := config.ProviderName + ":" + config.ModelName + ":" + config.BaseURL + ":" + config.AzureEndpoint + ":" + config.AzureDeployment
+	key := configKey(config)
 	if provider, ok := f.instances[key]; ok {
 		return provider
 	}
@@ -37,13 +52,64 @@ func (f *Factory) CreateProvider(config *ProviderConfig) Provider {
 		provider = providers.NewOpenAIProvider(config)
 	case "cohere":
 		provider = providers.NewCohereProvider(config)
+	case "ollama":
+		provider = providers.NewOllamaProvider(config)
+	case "mock":
+		if !f.MockProviders {
+			return nil
+		}
+		provider = providers.NewMockProvider(config, f.MockFixturesDir)
 	case "google", "gemini":
-		// Use OpenAI provider with Gemini-compatible base URL
-		// User should configure base_url to point to Gemini API or use an OpenAI-compatible gateway
-		provider = providers.NewOpenAIProvider(config)
+		provider = providers.NewGeminiProvider(config)
 	default:
 		return nil
 	}
+	provider = newRateLimitedProvider(provider, f.limiter)
 	f.instances[key] = provider
 	return provider
 }
+
+// Evict drops the instances built for a provider, e.g. after its
+// configuration changed or it was deleted.
+func (f *Factory) Evict(providerID int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, provider := range f.instances {
+		if provider.GetConfig().ID == providerID {
+			delete(f.instances, key)
+		}
+	}
+}
+
+// configKey identifies a configuration by all of its fields, so an instance
+// is never reused for a changed key, model or setting.
+func configKey(config *ProviderConfig) string {
+	encoded, _ := json.Marshal(config)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// ErrUnsupportedProvider is returned for provider types the factory does not
+// build, including the mock provider unless it is enabled.
+var ErrUnsupportedProvider = errors.New("unsupported provider")
+
+// Validate checks a provider configuration before it is stored.
+func (f *Factory) Validate(config *ProviderConfig) error {
+	switch strings.ToLower(config.ProviderName) {
+	case "claude", "anthropic", "openai", "azure_openai", "azureopenai", "cohere", "ollama", "google", "gemini":
+		return nil
+	case "mock":
+		if !f.MockProviders {
+			return ErrUnsupportedProvider
+		}
+		return providers.ValidateMockBaseURL(config.BaseURL, f.MockFixturesDir)
+	default:
+		return ErrUnsupportedProvider
+	}
+}
+
+// ValidateSafetySettings checks provider safety settings before they are
+// stored.
+func ValidateSafetySettings(settings map[string]string) error {
+	return providers.ValidateGeminiSafetySettings(settings)
+}
@@ -0,0 +1,73 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"message-flow/backend/internal/llm/providers"
+)
+
+func TestFactoryMockProviderNeedsFlag(t *testing.T) {
+	config := &ProviderConfig{ID: 1, ProviderName: "mock", ModelName: "mock-1", BaseURL: "mock://"}
+	factory := NewFactory(nil)
+	if provider := factory.CreateProvider(config); provider != nil {
+		t.Fatal("mock provider built while disabled")
+	}
+	if err := factory.Validate(config); !errors.Is(err, ErrUnsupportedProvider) {
+		t.Fatalf("validate = %v, want unsupported", err)
+	}
+
+	factory.MockProviders = true
+	if provider := factory.CreateProvider(config); provider == nil {
+		t.Fatal("mock provider not built while enabled")
+	}
+	if err := factory.Validate(&ProviderConfig{ProviderName: "mock", BaseURL: "mock://?fixtures=/etc/passwd"}); !errors.Is(err, providers.ErrMockFixturesPath) {
+		t.Fatalf("validate = %v, want fixtures path error", err)
+	}
+	if err := factory.Validate(&ProviderConfig{ProviderName: "openai"}); err != nil {
+		t.Fatalf("validate openai: %v", err)
+	}
+}
+
+func TestFactoryRebuildsChangedProvider(t *testing.T) {
+	factory := NewFactory(nil)
+	config := &ProviderConfig{ID: 7, ProviderName: "gemini", APIKey: "old", ModelName: "gemini-2.0-flash"}
+	first := factory.CreateProvider(config)
+	if again := factory.CreateProvider(&ProviderConfig{ID: 7, ProviderName: "gemini", APIKey: "old", ModelName: "gemini-2.0-flash"}); again != first {
+		t.Fatal("unchanged configuration not reused")
+	}
+
+	updated := &ProviderConfig{ID: 7, ProviderName: "gemini", APIKey: "new", ModelName: "gemini-2.0-flash", SafetySettings: map[string]string{"HARM_CATEGORY_HARASSMENT": "BLOCK_NONE"}}
+	provider := factory.CreateProvider(updated)
+	if provider == first {
+		t.Fatal("changed configuration reused the old instance")
+	}
+	if got := provider.GetConfig(); got.APIKey != "new" || got.SafetySettings["HARM_CATEGORY_HARASSMENT"] != "BLOCK_NONE" {
+		t.Fatalf("config = %+v", got)
+	}
+
+	factory.Evict(7)
+	if len(factory.instances) != 0 {
+		t.Fatalf("%d instances left after eviction", len(factory.instances))
+	}
+}
+
+func TestRouterEvictServesUpdatedProvider(t *testing.T) {
+	store := newFakeProviderStore()
+	router := NewRouter(NewFactory(nil), store)
+	ctx := context.Background()
+	if _, err := router.GetProvider(ctx, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	store.providers[1] = ProviderConfig{ID: 1, ProviderName: "openai", ModelName: "gpt-4o", Temperature: 0.7}
+
+	router.Evict(1, 1)
+	provider, err := router.GetProvider(ctx, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.GetConfig().Temperature != 0.7 {
+		t.Fatalf("update not applied: %+v", provider.GetConfig())
+	}
+}
@@ -52,6 +52,9 @@ func (h *HealthMonitor) runOnce(ctx context.Context, tenantID int64) {
 			latency = result.Latency
 		}
 		_ = h.Store.InsertHealth(ctx, tenantID, providerID, status, latency, errMsg, nil)
+		if h.Router.Breakers != nil {
+			h.Router.Breakers.RecordHealth(tenantID, providerID, err)
+		}
 		if status == "error" {
 			failures, err := h.Store.RecentHealthFailures(ctx, tenantID, providerID)
 			if err == nil && failures >= 3 {
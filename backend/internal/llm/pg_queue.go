@@ -0,0 +1,292 @@
+package llm
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"message-flow/backend/internal/db"
+)
+
+// PostgresQueue is the Queue backend for deployments without Redis. Jobs live
+// in analysis_jobs and are claimed with FOR UPDATE SKIP LOCKED, so several
+// replicas can share the table. A claimed job is leased until locked_until;
+// an expired lease is treated like a reclaimed Redis entry.
+type PostgresQueue struct {
+	QueuePolicy
+
+	store    *db.Store
+	consumer string
+	pruned   sync.Map
+}
+
+func NewPostgresQueue(store *db.Store) *PostgresQueue {
+	return &PostgresQueue{
+		QueuePolicy: DefaultQueuePolicy(),
+		store:       store,
+		consumer:    consumerName(),
+	}
+}
+
+func (q *PostgresQueue) Enqueue(ctx context.Context, message QueueMessage) error {
+	createdAt := message.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now().UTC()
+	}
+	return q.store.WithTenantConn(ctx, message.TenantID, func(conn *pgxpool.Conn) error {
+		_, err := conn.Exec(ctx, `
+			INSERT INTO analysis_jobs (tenant_id, message_id, content, feature, attempts, last_error, created_at)
+			VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7)
+		`, message.TenantID, message.MessageID, message.Content, message.Feature, message.Attempts, message.LastError, createdAt)
+		return err
+	})
+}
+
+func (q *PostgresQueue) Dequeue(ctx context.Context, tenantID int64, batchSize int) ([]QueueDelivery, error) {
+	deliveries, reclaimed, err := q.claim(ctx, tenantID, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	for _, delivery := range reclaimed {
+		if _, err := q.Retry(ctx, delivery, errVisibilityTimeout); err != nil {
+			return nil, err
+		}
+	}
+	if len(deliveries) == 0 && len(reclaimed) == 0 {
+		q.prune(ctx, tenantID)
+	}
+	return deliveries, nil
+}
+
+// PendingTenants returns the tenants with pending or running jobs.
+func (q *PostgresQueue) PendingTenants(ctx context.Context) ([]int64, error) {
+	rows, err := q.store.Pool.Query(ctx, `SELECT * FROM analysis_job_tenants()`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tenants []int64
+	for rows.Next() {
+		var tenantID int64
+		if err := rows.Scan(&tenantID); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenantID)
+	}
+	return tenants, rows.Err()
+}
+
+func (q *PostgresQueue) claim(ctx context.Context, tenantID int64, batchSize int) ([]QueueDelivery, []QueueDelivery, error) {
+	var deliveries, reclaimed []QueueDelivery
+	err := q.store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			WITH picked AS (
+				SELECT id, status = 'running' AS reclaimed
+				FROM analysis_jobs
+				WHERE tenant_id=$1
+				  AND ((status = 'pending' AND run_at <= NOW()) OR (status = 'running' AND locked_until < NOW()))
+				ORDER BY run_at, id
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			)
+			UPDATE analysis_jobs j
+			SET status = 'running', locked_until = NOW() + make_interval(secs => $3), locked_by = $4, updated_at = NOW()
+			FROM picked
+			WHERE j.id = picked.id
+			RETURNING j.id, COALESCE(j.message_id, 0), j.content, j.feature, j.created_at, j.attempts, COALESCE(j.last_error, ''), picked.reclaimed
+		`, tenantID, batchSize, q.VisibilityTimeout.Seconds(), q.consumer)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			var isReclaimed bool
+			msg := QueueMessage{TenantID: tenantID}
+			if err := rows.Scan(&id, &msg.MessageID, &msg.Content, &msg.Feature, &msg.CreatedAt, &msg.Attempts, &msg.LastError, &isReclaimed); err != nil {
+				return err
+			}
+			delivery := QueueDelivery{ID: fmtInt(id), Message: msg}
+			if isReclaimed {
+				reclaimed = append(reclaimed, delivery)
+			} else {
+				deliveries = append(deliveries, delivery)
+			}
+		}
+		return rows.Err()
+	})
+	return deliveries, reclaimed, err
+}
+
+func (q *PostgresQueue) Ack(ctx context.Context, delivery QueueDelivery) error {
+	id, err := strconv.ParseInt(delivery.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+	// Finished jobs are kept for a day so Stats can report throughput.
+	return q.store.WithTenantConn(ctx, delivery.Message.TenantID, func(conn *pgxpool.Conn) error {
+		_, err := conn.Exec(ctx, `
+			UPDATE analysis_jobs
+			SET status = 'done', locked_until = NULL, locked_by = NULL, updated_at = NOW()
+			WHERE tenant_id=$1 AND id=$2
+		`, delivery.Message.TenantID, id)
+		return err
+	})
+}
+
+// pruneInterval is how often a tenant's finished jobs are deleted; idle
+// workers poll far more often than that.
+const pruneInterval = 10 * time.Minute
+
+func (q *PostgresQueue) prune(ctx context.Context, tenantID int64) {
+	if !q.pruneDue(tenantID, time.Now()) {
+		return
+	}
+	_ = q.store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		_, err := conn.Exec(ctx, `
+			DELETE FROM analysis_jobs
+			WHERE tenant_id=$1 AND status = 'done' AND updated_at < NOW() - INTERVAL '1 day'
+		`, tenantID)
+		return err
+	})
+}
+
+// pruneDue reports whether the tenant's finished jobs are due for deletion
+// and, if so, claims the run, so concurrent workers delete only once.
+func (q *PostgresQueue) pruneDue(tenantID int64, now time.Time) bool {
+	last, loaded := q.pruned.LoadOrStore(tenantID, now)
+	if !loaded {
+		return true
+	}
+	if now.Sub(last.(time.Time)) < pruneInterval {
+		return false
+	}
+	return q.pruned.CompareAndSwap(tenantID, last, now)
+}
+
+func (q *PostgresQueue) Retry(ctx context.Context, delivery QueueDelivery, cause error) (bool, error) {
+	attempts := delivery.Message.Attempts + 1
+	if attempts >= q.MaxAttempts {
+		delivery.Message.Attempts = attempts
+		return true, q.DeadLetter(ctx, delivery, cause)
+	}
+	id, err := strconv.ParseInt(delivery.ID, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	err = q.store.WithTenantConn(ctx, delivery.Message.TenantID, func(conn *pgxpool.Conn) error {
+		_, err := conn.Exec(ctx, `
+			UPDATE analysis_jobs
+			SET status = 'pending', attempts = $3, last_error = $4, run_at = NOW() + make_interval(secs => $5),
+			    locked_until = NULL, locked_by = NULL, updated_at = NOW()
+			WHERE tenant_id=$1 AND id=$2
+		`, delivery.Message.TenantID, id, attempts, errorString(cause), q.Backoff(attempts).Seconds())
+		return err
+	})
+	return false, err
+}
+
+func (q *PostgresQueue) DeadLetter(ctx context.Context, delivery QueueDelivery, cause error) error {
+	id, err := strconv.ParseInt(delivery.ID, 10, 64)
+	if err != nil {
+		return err
+	}
+	return q.store.WithTenantConn(ctx, delivery.Message.TenantID, func(conn *pgxpool.Conn) error {
+		_, err := conn.Exec(ctx, `
+			UPDATE analysis_jobs
+			SET status = 'dead', attempts = $3, last_error = $4, failed_at = NOW(),
+			    locked_until = NULL, locked_by = NULL, updated_at = NOW()
+			WHERE tenant_id=$1 AND id=$2
+		`, delivery.Message.TenantID, id, delivery.Message.Attempts, errorString(cause))
+		return err
+	})
+}
+
+func (q *PostgresQueue) Stats(ctx context.Context, tenantID int64) (QueueStats, error) {
+	stats := QueueStats{Backend: "postgres"}
+	err := q.store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		return conn.QueryRow(ctx, `
+			SELECT COUNT(*) FILTER (WHERE status = 'pending' AND run_at <= NOW()),
+			       COUNT(*) FILTER (WHERE status = 'pending' AND run_at > NOW()),
+			       COUNT(*) FILTER (WHERE status = 'running'),
+			       COUNT(*) FILTER (WHERE status = 'dead'),
+			       COUNT(*) FILTER (WHERE status = 'done' AND updated_at > NOW() - INTERVAL '1 hour'),
+			       MIN(created_at) FILTER (WHERE status IN ('pending', 'running'))
+			FROM analysis_jobs
+			WHERE tenant_id=$1
+		`, tenantID).Scan(&stats.Ready, &stats.Scheduled, &stats.InFlight, &stats.DeadLetters, &stats.ProcessedLastHour, &stats.OldestEnqueuedAt)
+	})
+	return stats, err
+}
+
+func (q *PostgresQueue) DeadLetters(ctx context.Context, tenantID int64, limit int) ([]DeadLetter, error) {
+	letters := []DeadLetter{}
+	err := q.store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			SELECT id, COALESCE(message_id, 0), content, feature, created_at, attempts, COALESCE(last_error, ''), failed_at
+			FROM analysis_jobs
+			WHERE tenant_id=$1 AND status = 'dead'
+			ORDER BY failed_at DESC NULLS LAST, id DESC
+			LIMIT $2
+		`, tenantID, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			letter := DeadLetter{Message: QueueMessage{TenantID: tenantID}}
+			msg := &letter.Message
+			if err := rows.Scan(&id, &msg.MessageID, &msg.Content, &msg.Feature, &msg.CreatedAt, &msg.Attempts, &msg.LastError, &letter.FailedAt); err != nil {
+				return err
+			}
+			letter.ID = fmtInt(id)
+			letter.Error = msg.LastError
+			letters = append(letters, letter)
+		}
+		return rows.Err()
+	})
+	return letters, err
+}
+
+func (q *PostgresQueue) Replay(ctx context.Context, tenantID int64, ids []string) (int, error) {
+	return q.updateDead(ctx, tenantID, ids, `
+		UPDATE analysis_jobs
+		SET status = 'pending', attempts = 0, last_error = NULL, failed_at = NULL, run_at = NOW(), updated_at = NOW()
+		WHERE tenant_id=$1 AND status = 'dead' AND ($2::bigint[] IS NULL OR id = ANY($2))
+	`)
+}
+
+func (q *PostgresQueue) Purge(ctx context.Context, tenantID int64, ids []string) (int, error) {
+	return q.updateDead(ctx, tenantID, ids, `
+		DELETE FROM analysis_jobs
+		WHERE tenant_id=$1 AND status = 'dead' AND ($2::bigint[] IS NULL OR id = ANY($2))
+	`)
+}
+
+func (q *PostgresQueue) updateDead(ctx context.Context, tenantID int64, ids []string, query string) (int, error) {
+	var jobIDs []int64
+	if ids != nil {
+		jobIDs = []int64{}
+		for _, id := range ids {
+			parsed, err := strconv.ParseInt(id, 10, 64)
+			if err != nil {
+				continue
+			}
+			jobIDs = append(jobIDs, parsed)
+		}
+	}
+	var affected int64
+	err := q.store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		tag, err := conn.Exec(ctx, query, tenantID, jobIDs)
+		if err != nil {
+			return err
+		}
+		affected = tag.RowsAffected()
+		return nil
+	})
+	return int(affected), err
+}
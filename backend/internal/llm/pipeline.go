@@ -10,17 +10,34 @@ import (
 	"message-flow/backend/internal/db"
 )
 
-func StoreAnalysis(ctx context.Context, store *db.Store, tenantID, messageID int64, result *AnalysisResult) error {
+// AnalysisSource is the provider and model that produced an analysis. It is
+// stored with the analysis so corrections are attributed to them.
+type AnalysisSource struct {
+	ProviderID int64  `json:"provider_id"`
+	Model      string `json:"model"`
+}
+
+// StoreAnalysis saves a message's analysis and rescores its conversation.
+// source is nil for fallback analyses.
+// The score is derived data, so failing to refresh it does not fail the
+// analysis; it is recomputed with the next one.
+func StoreAnalysis(ctx context.Context, store *db.Store, tenantID, messageID int64, result *AnalysisResult, source *AnalysisSource) error {
 	return store.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
 		var existing string
+		var conversationID int64
 		_ = conn.QueryRow(ctx, `
-			SELECT metadata_json FROM messages WHERE id=$1 AND tenant_id=$2`, messageID, tenantID).Scan(&existing)
+			SELECT COALESCE(metadata_json::TEXT, ''), conversation_id FROM messages WHERE id=$1 AND tenant_id=$2`, messageID, tenantID).Scan(&existing, &conversationID)
 
 		payload := map[string]any{}
 		if existing != "" {
 			_ = json.Unmarshal([]byte(existing), &payload)
 		}
 		payload["analysis"] = result
+		if source != nil {
+			payload["analysis_source"] = source
+		} else {
+			delete(payload, "analysis_source")
+		}
 
 		encoded, err := json.Marshal(payload)
 		if err != nil {
@@ -41,6 +58,9 @@ func StoreAnalysis(ctx context.Context, store *db.Store, tenantID, messageID int
 				return err
 			}
 		}
+		if conversationID != 0 {
+			_, _ = refreshConversationScore(ctx, conn, tenantID, conversationID)
+		}
 		return nil
 	})
 }
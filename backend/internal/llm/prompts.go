@@ -0,0 +1,268 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PromptFeatures are the features whose prompt a tenant can replace.
+var PromptFeatures = []string{FeatureImportanceDetection, FeatureSummarization, FeatureActionExtraction}
+
+// promptCacheTTL bounds how long a replica keeps serving a template after
+// another replica changed it.
+const promptCacheTTL = time.Minute
+
+// PromptTemplate is one version of a tenant's prompt for a feature.
+type PromptTemplate struct {
+	ID          int64     `json:"id"`
+	FeatureName string    `json:"feature_name"`
+	Version     int       `json:"version"`
+	Template    string    `json:"template"`
+	Description string    `json:"description"`
+	CreatedBy   *int64    `json:"created_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// PromptData is what a template can refer to. Message is set for
+// importance_detection and action_extraction, Messages and Transcript (the
+// messages one per line) for summarization. ContactName is empty when the
+// call is not tied to a conversation.
+type PromptData struct {
+	Feature     string
+	Message     string
+	Messages    []string
+	Transcript  string
+	ContactName string
+	Glossary    string
+}
+
+var promptFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// IsPromptFeature reports whether feature accepts tenant prompt templates.
+func IsPromptFeature(feature string) bool {
+	for _, candidate := range PromptFeatures {
+		if candidate == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// SamplePromptData is the data templates are checked against before they are
+// saved, and the default for previews.
+func SamplePromptData(feature string) PromptData {
+	messages := []string{"Hi, the invoice from last week is still unpaid.", "Can you send it again by Friday?"}
+	data := PromptData{
+		Feature:     feature,
+		ContactName: "Jane Doe",
+		Glossary:    "ACME: our largest wholesale customer",
+	}
+	if feature == FeatureSummarization {
+		data.Messages = messages
+		data.Transcript = strings.Join(messages, "\n")
+	} else {
+		data.Message = strings.Join(messages, " ")
+	}
+	return data
+}
+
+// ParsePromptTemplate parses a template for feature and renders it against
+// SamplePromptData, so that unknown fields and templates that leave out the
+// input are rejected when saved rather than when a message arrives.
+func ParsePromptTemplate(feature, text string) (*template.Template, error) {
+	if !IsPromptFeature(feature) {
+		return nil, fmt.Errorf("feature %s does not support prompt templates", feature)
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil, errors.New("template is empty")
+	}
+	tmpl, err := template.New(feature).Option("missingkey=error").Funcs(promptFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	sample := SamplePromptData(feature)
+	rendered, err := executePrompt(tmpl, sample)
+	if err != nil {
+		return nil, err
+	}
+	input := sample.Message
+	if feature == FeatureSummarization {
+		input = sample.Messages[0]
+	}
+	if !strings.Contains(rendered, input) {
+		if feature == FeatureSummarization {
+			return nil, errors.New("template must include the conversation ({{.Transcript}} or {{.Messages}})")
+		}
+		return nil, errors.New("template must include the message ({{.Message}})")
+	}
+	return tmpl, nil
+}
+
+// RenderPrompt parses and renders a template in one step, for previews.
+func RenderPrompt(feature, text string, data PromptData) (string, error) {
+	tmpl, err := ParsePromptTemplate(feature, text)
+	if err != nil {
+		return "", err
+	}
+	data.Feature = feature
+	return executePrompt(tmpl, data)
+}
+
+func executePrompt(tmpl *template.Template, data PromptData) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// ActivePrompt returns the template a feature uses: the pinned version, or
+// the latest one when nothing is pinned. It returns nil when the tenant has
+// no template for the feature, in which case the built-in prompt is used.
+func (s *Store) ActivePrompt(ctx context.Context, tenantID int64, feature string) (*PromptTemplate, error) {
+	var prompt PromptTemplate
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		return conn.QueryRow(ctx, `
+			SELECT t.id, t.feature_name, t.version, t.template, t.description, t.created_by, t.created_at
+			FROM llm_prompt_templates t
+			LEFT JOIN llm_prompt_pins p ON p.tenant_id = t.tenant_id AND p.feature_name = t.feature_name
+			WHERE t.tenant_id=$1 AND t.feature_name=$2 AND (p.version IS NULL OR t.version = p.version)
+			ORDER BY t.version DESC
+			LIMIT 1`, tenantID, feature).Scan(&prompt.ID, &prompt.FeatureName, &prompt.Version, &prompt.Template, &prompt.Description, &prompt.CreatedBy, &prompt.CreatedAt)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &prompt, nil
+}
+
+// PromptGlossary returns the tenant's glossary, empty when none is set.
+func (s *Store) PromptGlossary(ctx context.Context, tenantID int64) (string, error) {
+	var glossary string
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		return conn.QueryRow(ctx, `SELECT glossary FROM llm_prompt_settings WHERE tenant_id=$1`, tenantID).Scan(&glossary)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	return glossary, err
+}
+
+// MessageContactName returns the name of the contact in the conversation a
+// message belongs to, falling back to their number.
+func (s *Store) MessageContactName(ctx context.Context, tenantID, messageID int64) (string, error) {
+	var name string
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		return conn.QueryRow(ctx, `
+			SELECT COALESCE(NULLIF(c.contact_name, ''), c.contact_number)
+			FROM messages m
+			JOIN conversations c ON c.id = m.conversation_id
+			WHERE m.tenant_id=$1 AND m.id=$2`, tenantID, messageID).Scan(&name)
+	})
+	return name, err
+}
+
+// activePrompt is a parsed template ready to render. A nil template means
+// the feature uses the built-in prompt.
+type activePrompt struct {
+	version  int
+	tmpl     *template.Template
+	glossary string
+	expires  time.Time
+}
+
+type promptCacheKey struct {
+	tenantID int64
+	feature  string
+}
+
+type promptCache struct {
+	mu      sync.Mutex
+	entries map[promptCacheKey]activePrompt
+}
+
+// InvalidatePrompts drops the cached templates of a tenant after they change.
+func (s *Service) InvalidatePrompts(tenantID int64) {
+	s.prompts.mu.Lock()
+	defer s.prompts.mu.Unlock()
+	for key := range s.prompts.entries {
+		if key.tenantID == tenantID {
+			delete(s.prompts.entries, key)
+		}
+	}
+}
+
+func (s *Service) activePrompt(ctx context.Context, tenantID int64, feature string) (activePrompt, error) {
+	key := promptCacheKey{tenantID: tenantID, feature: feature}
+	s.prompts.mu.Lock()
+	entry, ok := s.prompts.entries[key]
+	s.prompts.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry, nil
+	}
+
+	entry = activePrompt{expires: time.Now().Add(promptCacheTTL)}
+	prompt, err := s.Store.ActivePrompt(ctx, tenantID, feature)
+	if err != nil {
+		return entry, err
+	}
+	if prompt != nil {
+		if entry.tmpl, err = ParsePromptTemplate(feature, prompt.Template); err != nil {
+			return entry, fmt.Errorf("prompt template version %d: %w", prompt.Version, err)
+		}
+		entry.version = prompt.Version
+		if entry.glossary, err = s.Store.PromptGlossary(ctx, tenantID); err != nil {
+			return entry, err
+		}
+	}
+
+	s.prompts.mu.Lock()
+	if s.prompts.entries == nil {
+		s.prompts.entries = map[promptCacheKey]activePrompt{}
+	}
+	s.prompts.entries[key] = entry
+	s.prompts.mu.Unlock()
+	return entry, nil
+}
+
+// promptOptions renders the tenant's template for feature into the options
+// for the provider call, returning the template version used. When the
+// tenant has no usable template the options are empty with version 0, so a
+// broken template degrades to the built-in prompt instead of failing the
+// call. The contact name is redacted like the message, as it is
+// the contact's number when they have no name.
+func (s *Service) promptOptions(ctx context.Context, tenantID int64, feature string, data PromptData, messageID *int64, redaction *Redaction) (CallOptions, int) {
+	if s.Store == nil || s.Store.DB == nil {
+		return CallOptions{}, 0
+	}
+	prompt, err := s.activePrompt(ctx, tenantID, feature)
+	if err != nil || prompt.tmpl == nil {
+		return CallOptions{}, 0
+	}
+	data.Feature = feature
+	data.Glossary = prompt.glossary
+	if data.ContactName == "" && messageID != nil {
+		data.ContactName, _ = s.Store.MessageContactName(ctx, tenantID, *messageID)
+	}
+	data.ContactName = redaction.Redact(data.ContactName)
+	rendered, err := executePrompt(prompt.tmpl, data)
+	if err != nil {
+		return CallOptions{}, 0
+	}
+	return CallOptions{Prompt: rendered}, prompt.version
+}
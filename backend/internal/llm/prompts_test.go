@@ -0,0 +1,48 @@
+package llm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePromptTemplate(t *testing.T) {
+	valid := "Customer {{.ContactName}} wrote:\n{{.Message}}\nGlossary: {{.Glossary}}\nReturn JSON."
+	if _, err := ParsePromptTemplate(FeatureImportanceDetection, valid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParsePromptTemplate(FeatureSummarization, "Summarize as JSON:\n{{join .Messages \"\\n\"}}"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		feature  string
+		template string
+	}{
+		{"unknown feature", FeatureDailySummary, "{{.Message}}"},
+		{"empty", FeatureImportanceDetection, "  "},
+		{"syntax", FeatureImportanceDetection, "{{.Message"},
+		{"unknown field", FeatureImportanceDetection, "{{.Message}} {{.Customer}}"},
+		{"missing input", FeatureActionExtraction, "Extract actions for {{.ContactName}}"},
+		{"missing transcript", FeatureSummarization, "Summarize {{.Message}}"},
+	}
+	for _, test := range tests {
+		if _, err := ParsePromptTemplate(test.feature, test.template); err == nil {
+			t.Fatalf("%s: expected template to be rejected", test.name)
+		}
+	}
+}
+
+func TestRenderPrompt(t *testing.T) {
+	rendered, err := RenderPrompt(FeatureSummarization, "{{.ContactName}} ({{.Feature}}):\n{{.Transcript}}", PromptData{
+		ContactName: "Ana",
+		Messages:    []string{"Hi, the invoice from last week is still unpaid.", "Paid now"},
+		Transcript:  "Hi, the invoice from last week is still unpaid.\nPaid now",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(rendered, "Ana (summarization):\n") || !strings.HasSuffix(rendered, "Paid now") {
+		t.Fatalf("unexpected prompt %q", rendered)
+	}
+}
@@ -0,0 +1,72 @@
+package providers
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"message-flow/backend/internal/llm/contract"
+)
+
+var answerSchema = &jsonSchema{
+	Type: "object",
+	Properties: map[string]*jsonSchema{
+		"answer":     stringSchema,
+		"found":      {Type: "boolean"},
+		"citations":  {Type: "array", Items: &jsonSchema{Type: "number"}, unwrapKeys: []string{"message_ids"}},
+		"confidence": {Type: "number", Minimum: bound(0), Maximum: bound(1)},
+	},
+	Required: []string{"answer", "citations"},
+}
+
+var geminiAnswerSchema = map[string]any{
+	"type": "OBJECT",
+	"properties": map[string]any{
+		"answer":     map[string]any{"type": "STRING"},
+		"found":      map[string]any{"type": "BOOLEAN"},
+		"citations":  map[string]any{"type": "ARRAY", "items": map[string]any{"type": "INTEGER"}},
+		"confidence": map[string]any{"type": "NUMBER"},
+	},
+	"required": []string{"answer", "found", "citations", "confidence"},
+}
+
+func answerPrompt(request contract.QuestionRequest) string {
+	var b strings.Builder
+	b.WriteString("Answer the question about this WhatsApp conversation using only the messages and notes below. ")
+	b.WriteString("JSON-only response with: answer, found(bool, false when the messages do not contain the answer), ")
+	b.WriteString("citations[] (the message_id of every message the answer relies on, at least one when found), confidence(0-1)\n\n")
+	if request.ContactName != "" {
+		b.WriteString("Contact: " + request.ContactName + "\n\n")
+	}
+	if len(request.Notes) > 0 {
+		b.WriteString("Notes:\n- " + strings.Join(request.Notes, "\n- ") + "\n\n")
+	}
+	b.WriteString("Messages:\n")
+	for _, message := range request.Messages {
+		b.WriteString("[message_id=" + strconv.FormatInt(message.MessageID, 10))
+		if !message.Timestamp.IsZero() {
+			b.WriteString(" " + message.Timestamp.UTC().Format("2006-01-02 15:04"))
+		}
+		b.WriteString("] ")
+		if message.Sender != "" {
+			b.WriteString(message.Sender + ": ")
+		}
+		b.WriteString(message.Content + "\n")
+	}
+	b.WriteString("\nQuestion: " + request.Question)
+	return b.String()
+}
+
+// answerWith runs a question through a provider's completion call and
+// validates the answer.
+func answerWith(ctx context.Context, complete completeFunc, request contract.QuestionRequest) (*contract.AnswerResult, contract.UsageRecord, error) {
+	text, usage, err := complete(ctx, "answer", answerPrompt(request))
+	if err != nil {
+		return nil, usage, err
+	}
+	var parsed contract.AnswerResult
+	if usage, err = decodeOutput(ctx, complete, "answer", answerSchema, text, usage, &parsed); err != nil {
+		return nil, usage, err
+	}
+	return &parsed, usage, nil
+}
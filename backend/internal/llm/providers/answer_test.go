@@ -0,0 +1,50 @@
+package providers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"message-flow/backend/internal/llm/contract"
+)
+
+func TestAnswerWithCoercesCitations(t *testing.T) {
+	request := contract.QuestionRequest{
+		Question: "What did they order?",
+		Messages: []contract.ContextMessage{{MessageID: 41, Sender: "Jane", Timestamp: time.Date(2026, 9, 1, 10, 0, 0, 0, time.UTC), Content: "Two boxes of tea please"}},
+		Notes:    []string{"Action item (open): ship the tea"},
+	}
+	var prompt string
+	complete := func(ctx context.Context, feature, text string) (string, contract.UsageRecord, error) {
+		prompt = text
+		return "```json\n{\"answer\":\"Two boxes of tea.\",\"found\":\"yes\",\"citations\":[\"41\"],\"confidence\":1.5}\n```", contract.UsageRecord{Success: true}, nil
+	}
+	result, _, err := answerWith(context.Background(), complete, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Found || len(result.Citations) != 1 || result.Citations[0] != 41 || result.Confidence != 1 {
+		t.Fatalf("unexpected answer %+v", result)
+	}
+	if !strings.Contains(prompt, "[message_id=41 2026-09-01 10:00] Jane: Two boxes of tea please") || !strings.Contains(prompt, "ship the tea") {
+		t.Fatalf("unexpected prompt %q", prompt)
+	}
+}
+
+func TestMockAnswerCitesMatchingMessages(t *testing.T) {
+	provider := newTestMock(t, "mock://")
+	result, _, err := provider.Answer(context.Background(), contract.QuestionRequest{
+		Question: "Which invoice number was disputed?",
+		Messages: []contract.ContextMessage{
+			{MessageID: 1, Content: "Good morning"},
+			{MessageID: 2, Content: "Invoice 1042 is wrong, I dispute it"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Found || len(result.Citations) != 1 || result.Citations[0] != 2 {
+		t.Fatalf("unexpected answer %+v", result)
+	}
+}
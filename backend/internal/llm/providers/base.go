@@ -3,7 +3,11 @@ package providers
 import (
 	"context"
 	"errors"
+	"strings"
+	"sync"
 	"time"
+
+	"message-flow/backend/internal/llm/contract"
 )
 
 type Retrier struct {
@@ -23,6 +27,10 @@ func (r Retrier) Do(ctx context.Context, fn func() error) error {
 	var lastErr error
 	for i := 0; i < attempts; i++ {
 		if err := fn(); err != nil {
+			if isRateLimitError(err) {
+				// Retrying a 429 only burns more quota; let the router fail over.
+				return &contract.RateLimitError{RetryAfter: delay}
+			}
 			lastErr = err
 			select {
 			case <-ctx.Done():
@@ -39,3 +47,90 @@ func (r Retrier) Do(ctx context.Context, fn func() error) error {
 	}
 	return lastErr
 }
+
+func isRateLimitError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, contract.ErrRateLimited) {
+		return true
+	}
+	type statusCoder interface {
+		StatusCode() int
+	}
+	if sc, ok := err.(statusCoder); ok {
+		return sc.StatusCode() == 429
+	}
+	if strings.Contains(err.Error(), "429") || strings.Contains(err.Error(), "rate limit") {
+		return true
+	}
+	return false
+}
+
+// callUsage collects the usage of one provider call across its retries. Each
+// call gets its own, so concurrent calls on a shared provider cannot see each
+// other's numbers.
+type callUsage struct {
+	start  time.Time
+	record contract.UsageRecord
+}
+
+func startCall(feature, model string) *callUsage {
+	return &callUsage{start: time.Now(), record: contract.UsageRecord{Feature: feature, Model: model}}
+}
+
+func (c *callUsage) attempt() {
+	c.record.Attempts++
+}
+
+func (c *callUsage) tokens(input, output int) {
+	c.record.InputTokens = input
+	c.record.OutputTokens = output
+	c.record.TotalTokens = input + output
+}
+
+// finish stamps latency and outcome. status is the HTTP status of the last
+// response, or 0 when none was received.
+func (c *callUsage) finish(err error, status int) contract.UsageRecord {
+	c.record.Latency = time.Since(c.start)
+	c.record.Success = err == nil
+	c.record.ErrorMessage = ""
+	if err != nil {
+		c.record.ErrorMessage = err.Error()
+	}
+	switch {
+	case status != 0:
+		c.record.HTTPStatus = status
+	case err == nil:
+		c.record.HTTPStatus = 200
+	case errors.Is(err, contract.ErrRateLimited):
+		c.record.HTTPStatus = 429
+	}
+	return c.record
+}
+
+// usageTracker aggregates finished calls for GetUsage.
+type usageTracker struct {
+	mu    sync.Mutex
+	stats contract.UsageStats
+}
+
+func (t *usageTracker) add(record contract.UsageRecord, config *contract.ProviderConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats.TotalRequests++
+	if !record.Success {
+		t.stats.FailedRequests++
+		return
+	}
+	t.stats.SuccessfulRequests++
+	t.stats.TotalCost += record.TotalCost(config.CostPer1KInput, config.CostPer1KOutput)
+	t.stats.AverageLatency = averageLatency(t.stats.AverageLatency, record.Latency, t.stats.SuccessfulRequests)
+}
+
+func (t *usageTracker) snapshot() *contract.UsageStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := t.stats
+	return &stats
+}
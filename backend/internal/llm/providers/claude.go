@@ -2,7 +2,6 @@ package providers
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"time"
 
@@ -13,11 +12,10 @@ import (
 )
 
 type ClaudeProvider struct {
-	client     anthropic.Client
-	config     *contract.ProviderConfig
-	retrier    Retrier
-	lastUsage  contract.UsageStats
-	lastRecord contract.UsageRecord
+	client  anthropic.Client
+	config  *contract.ProviderConfig
+	retrier Retrier
+	usage   usageTracker
 }
 
 func NewClaudeProvider(config *contract.ProviderConfig) *ClaudeProvider {
@@ -34,88 +32,74 @@ func (c *ClaudeProvider) Name() string { return "claude" }
 func (c *ClaudeProvider) GetConfig() *contract.ProviderConfig { return c.config }
 
 func (c *ClaudeProvider) GetUsage(ctx context.Context) (*contract.UsageStats, error) {
-	return &c.lastUsage, nil
+	return c.usage.snapshot(), nil
 }
 
-func (c *ClaudeProvider) Analyze(ctx context.Context, message string) (*contract.AnalysisResult, error) {
-	prompt := "Analyze this WhatsApp message JSON-only response with: is_important(bool),\npriority(high|medium|low), reason, has_action(bool), action_required,\nsentiment(positive|neutral|negative), sentiment_score(-1 to 1),\ntopics[], confidence(0-1)\n\nMessage: " + message
-	var response *anthropic.Message
-	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
-	defer cancel()
-	err := c.retrier.Do(ctx, func() error {
-		start := time.Now()
-		result, err := c.client.Messages.New(ctx, anthropic.MessageNewParams{
-			Model:       anthropic.Model(c.config.ModelName),
-			MaxTokens:   int64(c.config.MaxTokens),
-			Temperature: anthropic.Float(c.config.Temperature),
-			Messages: []anthropic.MessageParam{
-				anthropic.NewUserMessage(anthropic.NewTextBlock(prompt)),
-			},
-		})
-		if err != nil {
-			return err
-		}
-		response = result
-		c.captureUsage("analyze", start, result.Usage)
-		return nil
-	})
+func (c *ClaudeProvider) Analyze(ctx context.Context, message string, options contract.CallOptions) (*contract.AnalysisResult, contract.UsageRecord, error) {
+	prompt := analysisPromptFor(options, analyzePrompt(message))
+	text, usage, err := c.complete(ctx, "analyze", prompt)
 	if err != nil {
-		return nil, err
-	}
-	if response == nil || len(response.Content) == 0 {
-		return nil, errors.New("empty response")
+		return nil, usage, err
 	}
-	content := extractJSON(response.Content[0].Text)
 	var parsed contract.AnalysisResult
-	if err := json.Unmarshal([]byte(content), &parsed); err != nil {
-		return nil, err
+	if usage, err = decodeOutput(ctx, c.complete, "analyze", analysisSchema, text, usage, &parsed); err != nil {
+		return nil, usage, err
 	}
-	return &parsed, nil
+	return &parsed, usage, nil
 }
 
-func (c *ClaudeProvider) Summarize(ctx context.Context, messages []string) (*contract.SummaryResult, error) {
-	prompt := "Summarize conversation with: summary, key_points[], action_items[], sentiment, topics[]\n\nMessages: " + joinLines(messages)
-	var response *anthropic.Message
-	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
-	defer cancel()
-	err := c.retrier.Do(ctx, func() error {
-		start := time.Now()
-		result, err := c.client.Messages.New(ctx, anthropic.MessageNewParams{
-			Model:       anthropic.Model(c.config.ModelName),
-			MaxTokens:   int64(c.config.MaxTokens),
-			Temperature: anthropic.Float(c.config.Temperature),
-			Messages: []anthropic.MessageParam{
-				anthropic.NewUserMessage(anthropic.NewTextBlock(prompt)),
-			},
-		})
-		if err != nil {
-			return err
-		}
-		response = result
-		c.captureUsage("summarize", start, result.Usage)
-		return nil
-	})
+func (c *ClaudeProvider) AnalyzeBatch(ctx context.Context, items []contract.BatchItem) (map[int64]*contract.AnalysisResult, contract.UsageRecord, error) {
+	text, usage, err := c.complete(ctx, "analyze_batch", batchAnalysisPrompt(items))
 	if err != nil {
-		return nil, err
+		return nil, usage, err
 	}
-	if response == nil || len(response.Content) == 0 {
-		return nil, errors.New("empty response")
+	results, invalid, err := parseBatchAnalysis(text)
+	usage.ValidationErrors = invalid
+	return results, usage, err
+}
+
+func (c *ClaudeProvider) Summarize(ctx context.Context, messages []string, options contract.CallOptions) (*contract.SummaryResult, contract.UsageRecord, error) {
+	prompt := promptFor(options, summarizePrompt(messages))
+	text, usage, err := c.complete(ctx, "summarize", prompt)
+	if err != nil {
+		return nil, usage, err
 	}
-	content := extractJSON(response.Content[0].Text)
 	var parsed contract.SummaryResult
-	if err := json.Unmarshal([]byte(content), &parsed); err != nil {
-		return nil, err
+	if usage, err = decodeOutput(ctx, c.complete, "summarize", summarySchema, text, usage, &parsed); err != nil {
+		return nil, usage, err
 	}
-	return &parsed, nil
+	return &parsed, usage, nil
+}
+
+func (c *ClaudeProvider) ExtractActions(ctx context.Context, text string, options contract.CallOptions) ([]string, contract.UsageRecord, error) {
+	prompt := promptFor(options, extractActionsPrompt(text))
+	content, usage, err := c.complete(ctx, "extract_actions", prompt)
+	if err != nil {
+		return nil, usage, err
+	}
+	var actions []string
+	usage, err = decodeOutput(ctx, c.complete, "extract_actions", actionsSchema, content, usage, &actions)
+	return actions, usage, err
+}
+
+func (c *ClaudeProvider) Answer(ctx context.Context, request contract.QuestionRequest) (*contract.AnswerResult, contract.UsageRecord, error) {
+	return answerWith(ctx, c.complete, request)
+}
+
+func (c *ClaudeProvider) DraftReply(ctx context.Context, request contract.DraftRequest) (*contract.DraftResult, contract.UsageRecord, error) {
+	return draftWith(ctx, c.complete, request)
 }
 
-func (c *ClaudeProvider) ExtractActions(ctx context.Context, text string) ([]string, error) {
-	prompt := "Extract action items as JSON array of strings\n\nText: " + text
+// complete sends a single-turn prompt and returns the first text block along
+// with the usage of this call.
+func (c *ClaudeProvider) complete(ctx context.Context, feature, prompt string) (string, contract.UsageRecord, error) {
+	call := startCall(feature, c.config.ModelName)
 	var response *anthropic.Message
 	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
+	var lastErr error
 	err := c.retrier.Do(ctx, func() error {
-		start := time.Now()
+		call.attempt()
 		result, err := c.client.Messages.New(ctx, anthropic.MessageNewParams{
 			Model:       anthropic.Model(c.config.ModelName),
 			MaxTokens:   int64(c.config.MaxTokens),
@@ -124,25 +108,36 @@ func (c *ClaudeProvider) ExtractActions(ctx context.Context, text string) ([]str
 				anthropic.NewUserMessage(anthropic.NewTextBlock(prompt)),
 			},
 		})
+		lastErr = err
 		if err != nil {
 			return err
 		}
 		response = result
-		c.captureUsage("extract_actions", start, result.Usage)
 		return nil
 	})
-	if err != nil {
-		return nil, err
+	if response != nil {
+		call.tokens(int(response.Usage.InputTokens), int(response.Usage.OutputTokens))
+		if response.Model != "" {
+			call.record.Model = string(response.Model)
+		}
 	}
-	if response == nil || len(response.Content) == 0 {
-		return nil, errors.New("empty response")
+	if err == nil && len(response.Content) == 0 {
+		err = errors.New("empty response")
 	}
-	content := extractJSON(response.Content[0].Text)
-	var parsed []string
-	if err := json.Unmarshal([]byte(content), &parsed); err != nil {
-		return nil, err
+	usage := call.finish(err, claudeStatus(lastErr))
+	c.usage.add(usage, c.config)
+	if err != nil {
+		return "", usage, err
 	}
-	return parsed, nil
+	return response.Content[0].Text, usage, nil
+}
+
+func claudeStatus(err error) int {
+	var apiErr *anthropic.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
 }
 
 func (c *ClaudeProvider) HealthCheck(ctx context.Context) (*contract.HealthCheckResult, error) {
@@ -175,24 +170,3 @@ func (c *ClaudeProvider) HealthCheck(ctx context.Context) (*contract.HealthCheck
 		Timestamp:     time.Now().UTC(),
 	}, err
 }
-
-func (c *ClaudeProvider) captureUsage(feature string, start time.Time, usage anthropic.Usage) {
-	latency := time.Since(start)
-	record := contract.UsageRecord{
-		InputTokens:  int(usage.InputTokens),
-		OutputTokens: int(usage.OutputTokens),
-		TotalTokens:  int(usage.InputTokens + usage.OutputTokens),
-		Latency:      latency,
-		Success:      true,
-		Feature:      feature,
-	}
-	c.lastRecord = record
-	c.lastUsage.TotalRequests++
-	c.lastUsage.SuccessfulRequests++
-	c.lastUsage.TotalCost += record.TotalCost(c.config.CostPer1KInput, c.config.CostPer1KOutput)
-	c.lastUsage.AverageLatency = averageLatency(c.lastUsage.AverageLatency, latency, c.lastUsage.SuccessfulRequests)
-}
-
-func (c *ClaudeProvider) lastUsageRecord() contract.UsageRecord {
-	return c.lastRecord
-}
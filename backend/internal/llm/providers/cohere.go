@@ -2,7 +2,6 @@ package providers
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"time"
 
@@ -12,11 +11,10 @@ import (
 )
 
 type CohereProvider struct {
-	client     *cohere.Client
-	config     *contract.ProviderConfig
-	retrier    Retrier
-	lastUsage  contract.UsageStats
-	lastRecord contract.UsageRecord
+	client  *cohere.Client
+	config  *contract.ProviderConfig
+	retrier Retrier
+	usage   usageTracker
 }
 
 func NewCohereProvider(config *contract.ProviderConfig) *CohereProvider {
@@ -33,100 +31,125 @@ func (c *CohereProvider) Name() string { return "cohere" }
 func (c *CohereProvider) GetConfig() *contract.ProviderConfig { return c.config }
 
 func (c *CohereProvider) GetUsage(ctx context.Context) (*contract.UsageStats, error) {
-	return &c.lastUsage, nil
+	return c.usage.snapshot(), nil
 }
 
-func (c *CohereProvider) Analyze(ctx context.Context, message string) (*contract.AnalysisResult, error) {
-	if c.client == nil {
-		return nil, errors.New("cohere client not initialized")
+func (c *CohereProvider) Analyze(ctx context.Context, message string, options contract.CallOptions) (*contract.AnalysisResult, contract.UsageRecord, error) {
+	prompt := analysisPromptFor(options, analyzePrompt(message))
+	text, usage, err := c.complete(ctx, "analyze", prompt)
+	if err != nil {
+		return nil, usage, err
 	}
-	prompt := "Analyze this WhatsApp message JSON-only response with: is_important(bool), priority(high|medium|low), reason, has_action(bool), action_required, sentiment(positive|neutral|negative), sentiment_score(-1 to 1), topics[], confidence(0-1).\nMessage: " + message
-	var response *cohere.GenerateResponse
-	ctx, cancel := context.WithTimeout(ctx, 45*time.Second)
-	defer cancel()
+	var parsed contract.AnalysisResult
+	if usage, err = decodeOutput(ctx, c.complete, "analyze", analysisSchema, text, usage, &parsed); err != nil {
+		return nil, usage, err
+	}
+	return &parsed, usage, nil
+}
 
-	err := c.retrier.Do(ctx, func() error {
-		start := time.Now()
-		maxTokens := uint(c.config.MaxTokens)
-		temperature := c.config.Temperature
-		result, err := c.client.Generate(cohere.GenerateOptions{
-			Model:       c.config.ModelName,
-			Prompt:      prompt,
-			MaxTokens:   &maxTokens,
-			Temperature: &temperature,
-		})
-		if err != nil {
-			return err
-		}
-		response = result
-		c.captureUsage("analyze", start)
-		return nil
-	})
+func (c *CohereProvider) AnalyzeBatch(ctx context.Context, items []contract.BatchItem) (map[int64]*contract.AnalysisResult, contract.UsageRecord, error) {
+	text, usage, err := c.complete(ctx, "analyze_batch", batchAnalysisPrompt(items))
 	if err != nil {
-		return nil, err
+		return nil, usage, err
 	}
-	if response == nil || len(response.Generations) == 0 {
-		return nil, errors.New("empty response")
+	results, invalid, err := parseBatchAnalysis(text)
+	usage.ValidationErrors = invalid
+	return results, usage, err
+}
+
+func (c *CohereProvider) Summarize(ctx context.Context, messages []string, options contract.CallOptions) (*contract.SummaryResult, contract.UsageRecord, error) {
+	prompt := promptFor(options, summarizePrompt(messages))
+	text, usage, err := c.complete(ctx, "summarize", prompt)
+	if err != nil {
+		return nil, usage, err
 	}
-	content := extractJSON(response.Generations[0].Text)
-	var parsed contract.AnalysisResult
-	if err := json.Unmarshal([]byte(content), &parsed); err != nil {
-		return nil, err
+	var parsed contract.SummaryResult
+	if usage, err = decodeOutput(ctx, c.complete, "summarize", summarySchema, text, usage, &parsed); err != nil {
+		return nil, usage, err
 	}
-	return &parsed, nil
+	return &parsed, usage, nil
 }
 
-func (c *CohereProvider) Summarize(ctx context.Context, messages []string) (*contract.SummaryResult, error) {
-	if c.client == nil {
-		return nil, errors.New("cohere client not initialized")
+func (c *CohereProvider) ExtractActions(ctx context.Context, text string, options contract.CallOptions) ([]string, contract.UsageRecord, error) {
+	prompt := promptFor(options, extractActionsPrompt(text))
+	content, usage, err := c.complete(ctx, "extract_actions", prompt)
+	if err != nil {
+		return nil, usage, err
 	}
-	prompt := "Summarize conversation with: summary, key_points[], action_items[], sentiment, topics[]\nMessages: " + joinLines(messages)
-	var response *cohere.GenerateResponse
-	ctx, cancel := context.WithTimeout(ctx, 45*time.Second)
-	defer cancel()
+	var actions []string
+	usage, err = decodeOutput(ctx, c.complete, "extract_actions", actionsSchema, content, usage, &actions)
+	return actions, usage, err
+}
+
+func (c *CohereProvider) Answer(ctx context.Context, request contract.QuestionRequest) (*contract.AnswerResult, contract.UsageRecord, error) {
+	return answerWith(ctx, c.complete, request)
+}
+
+func (c *CohereProvider) DraftReply(ctx context.Context, request contract.DraftRequest) (*contract.DraftResult, contract.UsageRecord, error) {
+	return draftWith(ctx, c.complete, request)
+}
+
+// cohereEmbeddingModel is the model used for embeddings. The multilingual
+// model suits WhatsApp traffic, which is rarely English only.
+const cohereEmbeddingModel = "embed-multilingual-v2.0"
 
+// Embed returns one vector per text. The embed API does not report token
+// counts, so they are estimated from the input.
+func (c *CohereProvider) Embed(ctx context.Context, texts []string) (*contract.Embeddings, contract.UsageRecord, error) {
+	call := startCall("embed", cohereEmbeddingModel)
+	if c.client == nil {
+		err := errors.New("cohere client not initialized")
+		return nil, call.finish(err, 0), err
+	}
+	var response *cohere.EmbedResponse
+	var lastErr error
 	err := c.retrier.Do(ctx, func() error {
-		start := time.Now()
-		maxTokens := uint(c.config.MaxTokens)
-		temperature := c.config.Temperature
-		result, err := c.client.Generate(cohere.GenerateOptions{
-			Model:       c.config.ModelName,
-			Prompt:      prompt,
-			MaxTokens:   &maxTokens,
-			Temperature: &temperature,
+		call.attempt()
+		result, err := c.client.Embed(cohere.EmbedOptions{
+			Model:    cohereEmbeddingModel,
+			Texts:    texts,
+			Truncate: "END",
 		})
+		lastErr = err
 		if err != nil {
 			return err
 		}
 		response = result
-		c.captureUsage("summarize", start)
 		return nil
 	})
-	if err != nil {
-		return nil, err
-	}
-	if response == nil || len(response.Generations) == 0 {
-		return nil, errors.New("empty response")
+	var embeddings *contract.Embeddings
+	if err == nil {
+		call.tokens(estimateTokens(joinLines(texts)), 0)
+		embeddings = &contract.Embeddings{Model: cohereEmbeddingModel, Vectors: make([][]float32, 0, len(response.Embeddings))}
+		for _, values := range response.Embeddings {
+			embeddings.Vectors = append(embeddings.Vectors, toFloat32(values))
+		}
+		err = checkEmbeddings(embeddings, len(texts))
 	}
-	content := extractJSON(response.Generations[0].Text)
-	var parsed contract.SummaryResult
-	if err := json.Unmarshal([]byte(content), &parsed); err != nil {
-		return nil, err
+	usage := call.finish(err, cohereStatus(lastErr))
+	c.usage.add(usage, c.config)
+	if err != nil {
+		return nil, usage, err
 	}
-	return &parsed, nil
+	return embeddings, usage, nil
 }
 
-func (c *CohereProvider) ExtractActions(ctx context.Context, text string) ([]string, error) {
+// complete runs a generation and returns the first result with the usage of
+// this call. The generate API does not report token counts, so they are
+// estimated from the prompt and output.
+func (c *CohereProvider) complete(ctx context.Context, feature, prompt string) (string, contract.UsageRecord, error) {
+	call := startCall(feature, c.config.ModelName)
 	if c.client == nil {
-		return nil, errors.New("cohere client not initialized")
+		err := errors.New("cohere client not initialized")
+		return "", call.finish(err, 0), err
 	}
-	prompt := "Extract action items as JSON array of strings\nText: " + text
 	var response *cohere.GenerateResponse
 	ctx, cancel := context.WithTimeout(ctx, 45*time.Second)
 	defer cancel()
 
+	var lastErr error
 	err := c.retrier.Do(ctx, func() error {
-		start := time.Now()
+		call.attempt()
 		maxTokens := uint(c.config.MaxTokens)
 		temperature := c.config.Temperature
 		result, err := c.client.Generate(cohere.GenerateOptions{
@@ -135,25 +158,35 @@ func (c *CohereProvider) ExtractActions(ctx context.Context, text string) ([]str
 			MaxTokens:   &maxTokens,
 			Temperature: &temperature,
 		})
+		lastErr = err
 		if err != nil {
 			return err
 		}
 		response = result
-		c.captureUsage("extract_actions", start)
 		return nil
 	})
-	if err != nil {
-		return nil, err
+	text := ""
+	if err == nil {
+		if len(response.Generations) == 0 {
+			err = errors.New("empty response")
+		} else {
+			text = response.Generations[0].Text
+		}
 	}
-	if response == nil || len(response.Generations) == 0 {
-		return nil, errors.New("empty response")
+	if response != nil {
+		call.tokens(estimateTokens(prompt), estimateTokens(text))
 	}
-	content := extractJSON(response.Generations[0].Text)
-	var parsed []string
-	if err := json.Unmarshal([]byte(content), &parsed); err != nil {
-		return nil, err
+	usage := call.finish(err, cohereStatus(lastErr))
+	c.usage.add(usage, c.config)
+	return text, usage, err
+}
+
+func cohereStatus(err error) int {
+	var apiErr *cohere.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
 	}
-	return parsed, nil
+	return 0
 }
 
 func (c *CohereProvider) HealthCheck(ctx context.Context) (*contract.HealthCheckResult, error) {
@@ -194,24 +227,3 @@ func (c *CohereProvider) HealthCheck(ctx context.Context) (*contract.HealthCheck
 		Timestamp:     time.Now().UTC(),
 	}, err
 }
-
-func (c *CohereProvider) captureUsage(feature string, start time.Time) {
-	latency := time.Since(start)
-	record := contract.UsageRecord{
-		InputTokens:  0,
-		OutputTokens: 0,
-		TotalTokens:  0,
-		Latency:      latency,
-		Success:      true,
-		Feature:      feature,
-	}
-	c.lastRecord = record
-	c.lastUsage.TotalRequests++
-	c.lastUsage.SuccessfulRequests++
-	c.lastUsage.TotalCost += record.TotalCost(c.config.CostPer1KInput, c.config.CostPer1KOutput)
-	c.lastUsage.AverageLatency = averageLatency(c.lastUsage.AverageLatency, latency, c.lastUsage.SuccessfulRequests)
-}
-
-func (c *CohereProvider) lastUsageRecord() contract.UsageRecord {
-	return c.lastRecord
-}
@@ -0,0 +1,119 @@
+package providers
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"message-flow/backend/internal/llm/contract"
+)
+
+var draftSchema = &jsonSchema{
+	Type: "object",
+	Properties: map[string]*jsonSchema{
+		"suggestions": stringListSchema,
+	},
+	Required: []string{"suggestions"},
+}
+
+var geminiDraftSchema = map[string]any{
+	"type": "OBJECT",
+	"properties": map[string]any{
+		"suggestions": map[string]any{"type": "ARRAY", "items": map[string]any{"type": "STRING"}},
+	},
+	"required": []string{"suggestions"},
+}
+
+// rewriteInstructions says what each rewrite mode asks of the model.
+var rewriteInstructions = map[string]string{
+	contract.RewriteShorter:    "Make it shorter while keeping everything the customer needs to know.",
+	contract.RewriteFriendlier: "Make it warmer and friendlier without changing what it says.",
+	contract.RewriteFormal:     "Make it more formal and professional without changing what it says.",
+	contract.RewriteTranslate:  "Translate it, keeping the meaning and tone.",
+}
+
+func draftPrompt(request contract.DraftRequest) string {
+	count := request.Count
+	if count <= 0 {
+		count = 1
+	}
+	var b strings.Builder
+	if request.Draft != "" {
+		b.WriteString("Rewrite the agent's draft reply in this WhatsApp conversation. ")
+		b.WriteString(rewriteInstructions[request.Rewrite])
+		if request.Rewrite == contract.RewriteTranslate && request.Language != "" {
+			b.WriteString(" Target language: " + request.Language + ".")
+		}
+		b.WriteString(" ")
+	} else {
+		b.WriteString("Suggest replies the agent could send next in this WhatsApp conversation. ")
+		b.WriteString("Answer the customer's latest messages and do not promise anything the conversation does not support. ")
+	}
+	b.WriteString("JSON-only response with: suggestions[] (" + strconv.Itoa(count) + " distinct reply texts, best first, ready to send)\n\n")
+	if request.Tone != "" {
+		b.WriteString("Tone: " + request.Tone + "\n")
+	}
+	if request.Guidelines != "" {
+		b.WriteString("Guidelines:\n" + request.Guidelines + "\n")
+	}
+	if request.ContactName != "" {
+		b.WriteString("Contact: " + request.ContactName + "\n")
+	}
+	if analysis := request.Analysis; analysis != nil {
+		b.WriteString("Latest analysis: priority " + analysis.Priority + ", sentiment " + analysis.Sentiment)
+		if analysis.ActionRequired != "" {
+			b.WriteString(", action required: " + analysis.ActionRequired)
+		}
+		if len(analysis.Topics) > 0 {
+			b.WriteString(", topics: " + strings.Join(analysis.Topics, ", "))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\nMessages:\n")
+	for _, message := range request.History {
+		if !message.Timestamp.IsZero() {
+			b.WriteString("[" + message.Timestamp.UTC().Format("2006-01-02 15:04") + "] ")
+		}
+		if message.Sender != "" {
+			b.WriteString(message.Sender + ": ")
+		}
+		b.WriteString(message.Content + "\n")
+	}
+	if request.Draft != "" {
+		b.WriteString("\nDraft:\n" + request.Draft)
+	}
+	return b.String()
+}
+
+// draftWith runs a draft request through a provider's completion call and
+// validates the suggestions. Blank and repeated suggestions are dropped and
+// at most Count are kept.
+func draftWith(ctx context.Context, complete completeFunc, request contract.DraftRequest) (*contract.DraftResult, contract.UsageRecord, error) {
+	text, usage, err := complete(ctx, "draft_reply", draftPrompt(request))
+	if err != nil {
+		return nil, usage, err
+	}
+	var parsed contract.DraftResult
+	if usage, err = decodeOutput(ctx, complete, "draft_reply", draftSchema, text, usage, &parsed); err != nil {
+		return nil, usage, err
+	}
+	parsed.Suggestions = cleanSuggestions(parsed.Suggestions, request.Count)
+	return &parsed, usage, nil
+}
+
+func cleanSuggestions(suggestions []string, limit int) []string {
+	cleaned := []string{}
+	seen := map[string]bool{}
+	for _, suggestion := range suggestions {
+		suggestion = strings.TrimSpace(suggestion)
+		if suggestion == "" || seen[suggestion] {
+			continue
+		}
+		seen[suggestion] = true
+		cleaned = append(cleaned, suggestion)
+	}
+	if limit > 0 && len(cleaned) > limit {
+		cleaned = cleaned[:limit]
+	}
+	return cleaned
+}
@@ -0,0 +1,67 @@
+package providers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"message-flow/backend/internal/llm/contract"
+)
+
+func TestDraftWithCleansSuggestions(t *testing.T) {
+	request := contract.DraftRequest{
+		ContactName: "Jane",
+		History:     []contract.ContextMessage{{Sender: "Jane", Content: "Where is my order?"}},
+		Analysis:    &contract.AnalysisResult{Priority: "high", Sentiment: "negative"},
+		Tone:        "warm, first name basis",
+		Count:       2,
+	}
+	var prompt string
+	complete := func(ctx context.Context, feature, text string) (string, contract.UsageRecord, error) {
+		prompt = text
+		return `{"suggestions":["It ships today.","  It ships today. ","","Sorry, checking now.","Third one"]}`, contract.UsageRecord{Success: true}, nil
+	}
+	result, _, err := draftWith(context.Background(), complete, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Suggestions) != 2 || result.Suggestions[0] != "It ships today." || result.Suggestions[1] != "Sorry, checking now." {
+		t.Fatalf("unexpected suggestions %q", result.Suggestions)
+	}
+	for _, want := range []string{"Tone: warm, first name basis", "priority high, sentiment negative", "Jane: Where is my order?", "2 distinct reply texts"} {
+		if !strings.Contains(prompt, want) {
+			t.Fatalf("prompt misses %q: %q", want, prompt)
+		}
+	}
+}
+
+func TestDraftPromptRewrite(t *testing.T) {
+	prompt := draftPrompt(contract.DraftRequest{Draft: "ok will send tmrw", Rewrite: contract.RewriteTranslate, Language: "German"})
+	if !strings.Contains(prompt, "Translate it") || !strings.Contains(prompt, "Target language: German.") || !strings.HasSuffix(prompt, "Draft:\nok will send tmrw") {
+		t.Fatalf("unexpected prompt %q", prompt)
+	}
+}
+
+func TestMockDraftReply(t *testing.T) {
+	provider := newTestMock(t, "mock://")
+	result, _, err := provider.DraftReply(context.Background(), contract.DraftRequest{
+		ContactName: "Jane",
+		History:     []contract.ContextMessage{{Content: "This is broken again"}},
+		Analysis:    &contract.AnalysisResult{Sentiment: "negative"},
+		Count:       3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Suggestions) != 3 || !strings.HasPrefix(result.Suggestions[0], "Hi Jane, sorry") {
+		t.Fatalf("unexpected suggestions %q", result.Suggestions)
+	}
+
+	result, _, err = provider.DraftReply(context.Background(), contract.DraftRequest{Draft: "We will ship it today. Tracking follows.", Rewrite: contract.RewriteShorter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Suggestions) != 1 || result.Suggestions[0] != "We will ship it today" {
+		t.Fatalf("unexpected rewrite %q", result.Suggestions)
+	}
+}
@@ -0,0 +1,413 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"message-flow/backend/internal/llm/contract"
+)
+
+const defaultGeminiURL = "https://generativelanguage.googleapis.com/v1beta"
+
+// DefaultGeminiSafetySettings only blocks content Gemini rates as highly
+// likely harmful, so blunt customer messages can still be analyzed.
+var DefaultGeminiSafetySettings = map[string]string{
+	"HARM_CATEGORY_HARASSMENT":        "BLOCK_ONLY_HIGH",
+	"HARM_CATEGORY_HATE_SPEECH":       "BLOCK_ONLY_HIGH",
+	"HARM_CATEGORY_SEXUALLY_EXPLICIT": "BLOCK_ONLY_HIGH",
+	"HARM_CATEGORY_DANGEROUS_CONTENT": "BLOCK_ONLY_HIGH",
+}
+
+var geminiSafetyThresholds = map[string]bool{
+	"BLOCK_NONE":             true,
+	"BLOCK_ONLY_HIGH":        true,
+	"BLOCK_MEDIUM_AND_ABOVE": true,
+	"BLOCK_LOW_AND_ABOVE":    true,
+	"OFF":                    true,
+}
+
+var geminiSafetyCategories = map[string]bool{
+	"HARM_CATEGORY_HARASSMENT":        true,
+	"HARM_CATEGORY_HATE_SPEECH":       true,
+	"HARM_CATEGORY_SEXUALLY_EXPLICIT": true,
+	"HARM_CATEGORY_DANGEROUS_CONTENT": true,
+	"HARM_CATEGORY_CIVIC_INTEGRITY":   true,
+}
+
+// ValidateGeminiSafetySettings checks a category -> threshold map against the
+// values the API accepts.
+func ValidateGeminiSafetySettings(settings map[string]string) error {
+	for category, threshold := range settings {
+		if !geminiSafetyCategories[category] {
+			return fmt.Errorf("unknown safety category %q", category)
+		}
+		if !geminiSafetyThresholds[threshold] {
+			return fmt.Errorf("unknown safety threshold %q for %s", threshold, category)
+		}
+	}
+	return nil
+}
+
+// GeminiProvider calls the Gemini generateContent REST API directly. Every
+// feature sends a response schema so replies are always JSON.
+type GeminiProvider struct {
+	client  *http.Client
+	baseURL string
+	config  *contract.ProviderConfig
+	retrier Retrier
+	usage   usageTracker
+}
+
+func NewGeminiProvider(config *contract.ProviderConfig) *GeminiProvider {
+	baseURL := strings.TrimRight(config.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = defaultGeminiURL
+	}
+	return &GeminiProvider{
+		client:  &http.Client{},
+		baseURL: baseURL,
+		config:  config,
+		retrier: Retrier{Attempts: 3, Delay: 400 * time.Millisecond},
+	}
+}
+
+func (g *GeminiProvider) Name() string { return "gemini" }
+
+func (g *GeminiProvider) GetConfig() *contract.ProviderConfig { return g.config }
+
+func (g *GeminiProvider) GetUsage(ctx context.Context) (*contract.UsageStats, error) {
+	return g.usage.snapshot(), nil
+}
+
+var (
+	geminiAnalysisProperties = map[string]any{
+		"is_important":    map[string]any{"type": "BOOLEAN"},
+		"priority":        map[string]any{"type": "STRING", "enum": []string{"high", "medium", "low"}},
+		"reason":          map[string]any{"type": "STRING"},
+		"has_action":      map[string]any{"type": "BOOLEAN"},
+		"action_required": map[string]any{"type": "STRING"},
+		"sentiment":       map[string]any{"type": "STRING", "enum": []string{"positive", "neutral", "negative"}},
+		"sentiment_score": map[string]any{"type": "NUMBER"},
+		"topics":          map[string]any{"type": "ARRAY", "items": map[string]any{"type": "STRING"}},
+		"confidence":      map[string]any{"type": "NUMBER"},
+	}
+	geminiAnalysisRequired = []string{"is_important", "priority", "reason", "has_action", "sentiment", "sentiment_score", "topics", "confidence"}
+
+	geminiAnalysisSchema = map[string]any{
+		"type":       "OBJECT",
+		"properties": geminiAnalysisProperties,
+		"required":   geminiAnalysisRequired,
+	}
+
+	geminiSummarySchema = map[string]any{
+		"type": "OBJECT",
+		"properties": map[string]any{
+			"summary":      map[string]any{"type": "STRING"},
+			"key_points":   map[string]any{"type": "ARRAY", "items": map[string]any{"type": "STRING"}},
+			"action_items": map[string]any{"type": "ARRAY", "items": map[string]any{"type": "STRING"}},
+			"sentiment":    map[string]any{"type": "STRING"},
+			"topics":       map[string]any{"type": "ARRAY", "items": map[string]any{"type": "STRING"}},
+		},
+		"required": []string{"summary", "key_points", "action_items", "sentiment", "topics"},
+	}
+
+	geminiActionsSchema = map[string]any{
+		"type":  "ARRAY",
+		"items": map[string]any{"type": "STRING"},
+	}
+)
+
+func geminiBatchSchema() map[string]any {
+	properties := map[string]any{"message_id": map[string]any{"type": "INTEGER"}}
+	for name, schema := range geminiAnalysisProperties {
+		properties[name] = schema
+	}
+	return map[string]any{
+		"type": "OBJECT",
+		"properties": map[string]any{
+			"results": map[string]any{
+				"type": "ARRAY",
+				"items": map[string]any{
+					"type":       "OBJECT",
+					"properties": properties,
+					"required":   append([]string{"message_id"}, geminiAnalysisRequired...),
+				},
+			},
+		},
+		"required": []string{"results"},
+	}
+}
+
+func (g *GeminiProvider) Analyze(ctx context.Context, message string, options contract.CallOptions) (*contract.AnalysisResult, contract.UsageRecord, error) {
+	prompt := analysisPromptFor(options, "Analyze this WhatsApp message: decide whether it is important, its priority, the reason, whether it needs an action and which, its sentiment with a score from -1 to 1, its topics and your confidence from 0 to 1.\n\nMessage: "+message)
+	text, usage, err := g.complete(ctx, "analyze", prompt, geminiAnalysisSchema)
+	if err != nil {
+		return nil, usage, err
+	}
+	var parsed contract.AnalysisResult
+	if usage, err = decodeOutput(ctx, g.completeWith(geminiAnalysisSchema), "analyze", analysisSchema, text, usage, &parsed); err != nil {
+		return nil, usage, err
+	}
+	return &parsed, usage, nil
+}
+
+func (g *GeminiProvider) AnalyzeBatch(ctx context.Context, items []contract.BatchItem) (map[int64]*contract.AnalysisResult, contract.UsageRecord, error) {
+	text, usage, err := g.complete(ctx, "analyze_batch", batchAnalysisPrompt(items), geminiBatchSchema())
+	if err != nil {
+		return nil, usage, err
+	}
+	results, invalid, err := parseBatchAnalysis(text)
+	usage.ValidationErrors = invalid
+	return results, usage, err
+}
+
+func (g *GeminiProvider) Summarize(ctx context.Context, messages []string, options contract.CallOptions) (*contract.SummaryResult, contract.UsageRecord, error) {
+	prompt := promptFor(options, "Summarize this conversation with a summary, key points, action items, overall sentiment and topics.\n\nMessages: "+joinLines(messages))
+	text, usage, err := g.complete(ctx, "summarize", prompt, geminiSummarySchema)
+	if err != nil {
+		return nil, usage, err
+	}
+	var parsed contract.SummaryResult
+	if usage, err = decodeOutput(ctx, g.completeWith(geminiSummarySchema), "summarize", summarySchema, text, usage, &parsed); err != nil {
+		return nil, usage, err
+	}
+	return &parsed, usage, nil
+}
+
+func (g *GeminiProvider) ExtractActions(ctx context.Context, text string, options contract.CallOptions) ([]string, contract.UsageRecord, error) {
+	prompt := promptFor(options, "Extract the action items from this text.\n\nText: "+text)
+	content, usage, err := g.complete(ctx, "extract_actions", prompt, geminiActionsSchema)
+	if err != nil {
+		return nil, usage, err
+	}
+	var actions []string
+	usage, err = decodeOutput(ctx, g.completeWith(geminiActionsSchema), "extract_actions", actionsSchema, content, usage, &actions)
+	return actions, usage, err
+}
+
+func (g *GeminiProvider) Answer(ctx context.Context, request contract.QuestionRequest) (*contract.AnswerResult, contract.UsageRecord, error) {
+	return answerWith(ctx, g.completeWith(geminiAnswerSchema), request)
+}
+
+func (g *GeminiProvider) DraftReply(ctx context.Context, request contract.DraftRequest) (*contract.DraftResult, contract.UsageRecord, error) {
+	return draftWith(ctx, g.completeWith(geminiDraftSchema), request)
+}
+
+// completeWith binds a response schema for repair requests.
+func (g *GeminiProvider) completeWith(schema map[string]any) completeFunc {
+	return func(ctx context.Context, feature, prompt string) (string, contract.UsageRecord, error) {
+		return g.complete(ctx, feature, prompt, schema)
+	}
+}
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiSafetySetting struct {
+	Category  string `json:"category"`
+	Threshold string `json:"threshold"`
+}
+
+type geminiRequest struct {
+	Contents         []geminiContent       `json:"contents"`
+	SafetySettings   []geminiSafetySetting `json:"safetySettings,omitempty"`
+	GenerationConfig map[string]any        `json:"generationConfig"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		ThoughtsTokenCount   int `json:"thoughtsTokenCount"`
+		TotalTokenCount      int `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
+	ModelVersion string `json:"modelVersion"`
+}
+
+// geminiError is a non-2xx response from the Gemini API.
+type geminiError struct {
+	Status  int
+	Message string
+}
+
+func (e *geminiError) Error() string {
+	return fmt.Sprintf("gemini: %d %s", e.Status, e.Message)
+}
+
+func (e *geminiError) StatusCode() int { return e.Status }
+
+func (g *GeminiProvider) safetySettings() []geminiSafetySetting {
+	configured := g.config.SafetySettings
+	if len(configured) == 0 {
+		configured = DefaultGeminiSafetySettings
+	}
+	settings := make([]geminiSafetySetting, 0, len(configured))
+	for category, threshold := range configured {
+		settings = append(settings, geminiSafetySetting{Category: category, Threshold: threshold})
+	}
+	return settings
+}
+
+func (g *GeminiProvider) modelPath() string {
+	return "/models/" + url.PathEscape(strings.TrimPrefix(g.config.ModelName, "models/"))
+}
+
+// complete runs generateContent with the given response schema and returns
+// the reply text along with the usage of this call.
+func (g *GeminiProvider) complete(ctx context.Context, feature, prompt string, schema map[string]any) (string, contract.UsageRecord, error) {
+	call := startCall(feature, g.config.ModelName)
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	generation := map[string]any{
+		"temperature":      g.config.Temperature,
+		"responseMimeType": "application/json",
+		"responseSchema":   schema,
+	}
+	if g.config.MaxTokens > 0 {
+		generation["maxOutputTokens"] = g.config.MaxTokens
+	}
+	request := geminiRequest{
+		Contents:         []geminiContent{{Role: "user", Parts: []geminiPart{{Text: prompt}}}},
+		SafetySettings:   g.safetySettings(),
+		GenerationConfig: generation,
+	}
+	var response geminiResponse
+	var lastErr error
+	err := g.retrier.Do(ctx, func() error {
+		call.attempt()
+		lastErr = g.do(ctx, http.MethodPost, g.modelPath()+":generateContent", request, &response)
+		return lastErr
+	})
+	text := ""
+	if err == nil {
+		usage := response.UsageMetadata
+		// Thinking tokens are billed as output.
+		call.tokens(usage.PromptTokenCount, usage.CandidatesTokenCount+usage.ThoughtsTokenCount)
+		if usage.TotalTokenCount > 0 {
+			call.record.TotalTokens = usage.TotalTokenCount
+		}
+		if response.ModelVersion != "" {
+			call.record.Model = response.ModelVersion
+		}
+		text, err = response.text()
+	}
+	usage := call.finish(err, geminiStatus(lastErr))
+	g.usage.add(usage, g.config)
+	return text, usage, err
+}
+
+func (r *geminiResponse) text() (string, error) {
+	if r.PromptFeedback.BlockReason != "" {
+		return "", fmt.Errorf("gemini blocked the prompt: %s", r.PromptFeedback.BlockReason)
+	}
+	if len(r.Candidates) == 0 {
+		return "", errors.New("empty response")
+	}
+	candidate := r.Candidates[0]
+	var b strings.Builder
+	for _, part := range candidate.Content.Parts {
+		b.WriteString(part.Text)
+	}
+	if b.Len() == 0 {
+		if candidate.FinishReason != "" && candidate.FinishReason != "STOP" {
+			return "", fmt.Errorf("gemini returned no content: %s", candidate.FinishReason)
+		}
+		return "", errors.New("empty response")
+	}
+	return b.String(), nil
+}
+
+func (g *GeminiProvider) do(ctx context.Context, method, path string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, g.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("x-goog-api-key", g.config.APIKey)
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		message := strings.TrimSpace(string(raw))
+		if json.Unmarshal(raw, &apiErr) == nil && apiErr.Error.Message != "" {
+			message = apiErr.Error.Message
+		}
+		return &geminiError{Status: resp.StatusCode, Message: message}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func geminiStatus(err error) int {
+	var apiErr *geminiError
+	if errors.As(err, &apiErr) {
+		return apiErr.Status
+	}
+	return 0
+}
+
+// HealthCheck fetches the model's metadata, which checks the key and the
+// model name without spending tokens.
+func (g *GeminiProvider) HealthCheck(ctx context.Context) (*contract.HealthCheckResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	var model struct {
+		Name string `json:"name"`
+	}
+	err := g.do(ctx, http.MethodGet, g.modelPath(), nil, &model)
+	latency := time.Since(start)
+	status := "ok"
+	msg := ""
+	if err != nil {
+		status = "error"
+		msg = err.Error()
+	}
+	return &contract.HealthCheckResult{
+		Status:        status,
+		Latency:       latency,
+		EstimatedCost: 0,
+		ErrorMessage:  msg,
+		Timestamp:     time.Now().UTC(),
+	}, err
+}
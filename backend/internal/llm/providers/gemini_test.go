@@ -0,0 +1,173 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"message-flow/backend/internal/llm/contract"
+)
+
+type fakeGemini struct {
+	t        *testing.T
+	reply    string
+	status   int
+	response map[string]any
+	last     geminiRequest
+}
+
+func (f *fakeGemini) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("x-goog-api-key") != "test-key" {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"error":{"code":403,"message":"API key not valid","status":"PERMISSION_DENIED"}}`))
+		return
+	}
+	switch r.URL.Path {
+	case "/models/gemini-2.0-flash":
+		_, _ = w.Write([]byte(`{"name":"models/gemini-2.0-flash"}`))
+	case "/models/gemini-2.0-flash:generateContent":
+		if err := json.NewDecoder(r.Body).Decode(&f.last); err != nil {
+			f.t.Errorf("decode request: %v", err)
+		}
+		if f.status != 0 {
+			w.WriteHeader(f.status)
+			_, _ = w.Write([]byte(`{"error":{"code":500,"message":"internal","status":"INTERNAL"}}`))
+			return
+		}
+		response := f.response
+		if response == nil {
+			response = map[string]any{
+				"candidates": []any{map[string]any{
+					"content":      map[string]any{"role": "model", "parts": []any{map[string]any{"text": f.reply}}},
+					"finishReason": "STOP",
+				}},
+				"usageMetadata": map[string]any{"promptTokenCount": 30, "candidatesTokenCount": 12, "thoughtsTokenCount": 3, "totalTokenCount": 45},
+				"modelVersion":  "gemini-2.0-flash-001",
+			}
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestGemini(t *testing.T, fake *fakeGemini) *GeminiProvider {
+	t.Helper()
+	fake.t = t
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	provider := NewGeminiProvider(&contract.ProviderConfig{
+		ProviderName: "gemini",
+		APIKey:       "test-key",
+		ModelName:    "gemini-2.0-flash",
+		BaseURL:      server.URL,
+		MaxTokens:    256,
+	})
+	provider.retrier.Delay = time.Millisecond
+	return provider
+}
+
+func TestGeminiAnalyze(t *testing.T) {
+	fake := &fakeGemini{reply: `{"is_important":true,"priority":"medium","sentiment":"negative","topics":["delivery"]}`}
+	provider := newTestGemini(t, fake)
+
+	result, usage, err := provider.Analyze(context.Background(), "where is my order?", contract.CallOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.IsImportant || result.Priority != "medium" || result.Sentiment != "negative" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if usage.InputTokens != 30 || usage.OutputTokens != 15 || usage.TotalTokens != 45 || usage.Model != "gemini-2.0-flash-001" || usage.HTTPStatus != 200 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+
+	generation := fake.last.GenerationConfig
+	if generation["responseMimeType"] != "application/json" || generation["responseSchema"] == nil {
+		t.Fatalf("expected a JSON response schema, got %+v", generation)
+	}
+	if len(fake.last.SafetySettings) != len(DefaultGeminiSafetySettings) {
+		t.Fatalf("expected default safety settings, got %+v", fake.last.SafetySettings)
+	}
+}
+
+func TestGeminiUsesConfiguredSafetySettings(t *testing.T) {
+	fake := &fakeGemini{reply: `["call the supplier"]`}
+	provider := newTestGemini(t, fake)
+	provider.config.SafetySettings = map[string]string{"HARM_CATEGORY_HARASSMENT": "BLOCK_NONE"}
+
+	actions, _, err := provider.ExtractActions(context.Background(), "call the supplier", contract.CallOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 1 {
+		t.Fatalf("unexpected actions %v", actions)
+	}
+	settings := fake.last.SafetySettings
+	if len(settings) != 1 || settings[0].Category != "HARM_CATEGORY_HARASSMENT" || settings[0].Threshold != "BLOCK_NONE" {
+		t.Fatalf("unexpected safety settings %+v", settings)
+	}
+}
+
+func TestGeminiBlockedResponse(t *testing.T) {
+	fake := &fakeGemini{response: map[string]any{
+		"promptFeedback": map[string]any{"blockReason": "SAFETY"},
+		"usageMetadata":  map[string]any{"promptTokenCount": 8, "totalTokenCount": 8},
+	}}
+	_, usage, err := newTestGemini(t, fake).Summarize(context.Background(), []string{"..."}, contract.CallOptions{})
+	if err == nil {
+		t.Fatal("expected blocked prompt to fail")
+	}
+	if usage.Success || usage.InputTokens != 8 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+}
+
+func TestGeminiErrorStatus(t *testing.T) {
+	provider := newTestGemini(t, &fakeGemini{status: http.StatusInternalServerError})
+	_, usage, err := provider.Analyze(context.Background(), "hi", contract.CallOptions{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if usage.HTTPStatus != http.StatusInternalServerError || usage.Attempts != 3 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+}
+
+func TestGeminiHealthCheck(t *testing.T) {
+	provider := newTestGemini(t, &fakeGemini{})
+	if result, err := provider.HealthCheck(context.Background()); err != nil || result.Status != "ok" {
+		t.Fatalf("expected healthy, got %+v %v", result, err)
+	}
+	provider.config.APIKey = "wrong"
+	if result, err := provider.HealthCheck(context.Background()); err == nil || result.Status != "error" {
+		t.Fatalf("expected bad key to fail, got %+v", result)
+	}
+}
+
+func TestValidateGeminiSafetySettings(t *testing.T) {
+	if err := ValidateGeminiSafetySettings(map[string]string{"HARM_CATEGORY_HATE_SPEECH": "BLOCK_LOW_AND_ABOVE"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateGeminiSafetySettings(map[string]string{"HARM_CATEGORY_HATE_SPEECH": "SOMETIMES"}); err == nil {
+		t.Fatal("expected unknown threshold to fail")
+	}
+	if err := ValidateGeminiSafetySettings(map[string]string{"violence": "BLOCK_NONE"}); err == nil {
+		t.Fatal("expected unknown category to fail")
+	}
+}
+
+func TestGeminiSendsPromptOverride(t *testing.T) {
+	fake := &fakeGemini{reply: `{"is_important":false,"priority":"low"}`}
+	provider := newTestGemini(t, fake)
+
+	if _, _, err := provider.Analyze(context.Background(), "hi", contract.CallOptions{Prompt: "custom prompt for hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.last.Contents) != 1 || len(fake.last.Contents[0].Parts) != 1 || fake.last.Contents[0].Parts[0].Text != "custom prompt for hi" {
+		t.Fatalf("expected the override to be sent, got %+v", fake.last.Contents)
+	}
+}
@@ -1,8 +1,13 @@
 package providers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"strings"
 	"time"
+
+	"message-flow/backend/internal/llm/contract"
 )
 
 func joinLines(messages []string) string {
@@ -23,6 +28,37 @@ func averageLatency(current time.Duration, new time.Duration, count int64) time.
 	return time.Duration(((current * time.Duration(count-1)) + new) / time.Duration(count))
 }
 
+// estimateTokens approximates a token count for APIs that do not report
+// usage, at roughly four characters per token.
+func estimateTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	return (len(text) + 3) / 4
+}
+
+func toFloat32(values []float64) []float32 {
+	vector := make([]float32, len(values))
+	for i, value := range values {
+		vector[i] = float32(value)
+	}
+	return vector
+}
+
+// checkEmbeddings rejects a response that does not have one non-empty vector
+// per input.
+func checkEmbeddings(embeddings *contract.Embeddings, count int) error {
+	if len(embeddings.Vectors) != count {
+		return fmt.Errorf("expected %d embeddings, got %d", count, len(embeddings.Vectors))
+	}
+	for i, vector := range embeddings.Vectors {
+		if len(vector) == 0 {
+			return fmt.Errorf("missing embedding for input %d", i)
+		}
+	}
+	return nil
+}
+
 func extractJSON(text string) string {
 	start := strings.IndexAny(text, "{[")
 	end := strings.LastIndexAny(text, "}]")
@@ -31,3 +67,46 @@ func extractJSON(text string) string {
 	}
 	return text[start : end+1]
 }
+
+func batchAnalysisPrompt(items []contract.BatchItem) string {
+	payload, _ := json.Marshal(items)
+	return "Analyze each WhatsApp message below. JSON-only response: {\"results\": [...]} with one entry per message, each with: message_id, is_important(bool),\npriority(high|medium|low), reason, has_action(bool), action_required,\nsentiment(positive|neutral|negative), sentiment_score(-1 to 1),\ntopics[], confidence(0-1)\n\nMessages: " + string(payload)
+}
+
+// parseBatchAnalysis splits a batch response back into results keyed by
+// message_id. It accepts the {"results": [...]} object or a bare array.
+// Entries that fail validation are left out, so the caller analyzes those
+// messages on their own, and counted in the returned number.
+func parseBatchAnalysis(text string) (map[int64]*contract.AnalysisResult, int, error) {
+	value, err := decodeJSONValue(text)
+	if err != nil {
+		return nil, 0, err
+	}
+	entries, ok := value.([]any)
+	if !ok {
+		if object, isObject := value.(map[string]any); isObject {
+			entries, ok = object["results"].([]any)
+		}
+	}
+	if !ok || len(entries) == 0 {
+		return nil, 0, errors.New("empty batch response")
+	}
+	results := make(map[int64]*contract.AnalysisResult, len(entries))
+	invalid := 0
+	for _, entry := range entries {
+		object, _ := entry.(map[string]any)
+		id, ok := object["message_id"].(float64)
+		var problems []string
+		coerced := analysisSchema.coerce(entry, "", &problems)
+		var result contract.AnalysisResult
+		if !ok || len(problems) > 0 || assignOutput(coerced, &result) != nil {
+			invalid++
+			continue
+		}
+		results[int64(id)] = &result
+	}
+	if len(results) == 0 {
+		return nil, invalid, &contract.ValidationError{Problems: []string{"no valid entries in batch response"}}
+	}
+	return results, invalid, nil
+}
@@ -15,3 +15,16 @@ func TestExtractJSON(t *testing.T) {
 		t.Fatalf("unexpected output for array")
 	}
 }
+
+func TestParseBatchAnalysis(t *testing.T) {
+	results, invalid, err := parseBatchAnalysis("```json\n{\"results\":[{\"message_id\":7,\"is_important\":true,\"priority\":\"high\"},{\"message_id\":9,\"priority\":\"low\"}]}\n```")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 2 || invalid != 0 || !results[7].IsImportant || results[9].Priority != "low" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if _, _, err := parseBatchAnalysis("{\"results\":[]}"); err == nil {
+		t.Fatalf("expected error for empty results")
+	}
+}
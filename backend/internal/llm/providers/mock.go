@@ -0,0 +1,702 @@
+package providers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"message-flow/backend/internal/llm/contract"
+)
+
+// MockProvider answers without any network access. Results are derived from
+// the message text, so the same input always gives the same output. It is
+// configured through the provider's base URL, e.g.
+//
+//	mock://?fixtures=demo.json&latency=200ms&jitter=50ms&failure_rate=0.1&input_tokens=120&output_tokens=40
+//
+// failure_status picks the simulated HTTP status (503 by default; 429 is
+// reported as a rate limit) and seed changes which messages fail. Fixtures
+// are read from the fixtures directory only, as tenants set base URLs.
+type MockProvider struct {
+	config   *contract.ProviderConfig
+	settings mockSettings
+	fixtures mockFixtureFile
+	loadErr  error
+	usage    usageTracker
+}
+
+type mockSettings struct {
+	latency       time.Duration
+	jitter        time.Duration
+	failureRate   float64
+	failureStatus int
+	inputTokens   int
+	outputTokens  int
+	seed          string
+	fixturesPath  string
+}
+
+// mockFixtureFile is the JSON fixture format. Fixtures are keyed by
+// MockMessageHash of the input; rules are tried in order when no fixture
+// matches.
+type mockFixtureFile struct {
+	Fixtures map[string]mockResponse `json:"fixtures"`
+	Rules    []mockRule              `json:"rules"`
+}
+
+type mockRule struct {
+	Contains []string `json:"contains"`
+	Pattern  string   `json:"pattern"`
+	mockResponse
+
+	pattern *regexp.Regexp
+}
+
+type mockResponse struct {
+	Analysis *contract.AnalysisResult `json:"analysis"`
+	Summary  *contract.SummaryResult  `json:"summary"`
+	Actions  []string                 `json:"actions"`
+	Answer   *contract.AnswerResult   `json:"answer"`
+	Draft    *contract.DraftResult    `json:"draft"`
+	// Error makes the call fail with this message.
+	Error string `json:"error"`
+}
+
+// MockMessageHash is the fixture key for an input: the hex SHA-256 of the
+// trimmed text. Summaries hash their messages joined with newlines.
+func MockMessageHash(text string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(text)))
+	return hex.EncodeToString(sum[:])
+}
+
+// ErrMockFixturesPath is returned for fixtures outside the fixtures
+// directory, or any fixtures when there is no such directory.
+var ErrMockFixturesPath = errors.New("mock: fixtures must be a file in the fixtures directory")
+
+// NewMockProvider creates a mock provider whose fixtures are resolved in
+// fixturesDir; an empty fixturesDir disables fixtures.
+func NewMockProvider(config *contract.ProviderConfig, fixturesDir string) *MockProvider {
+	provider := &MockProvider{config: config}
+	provider.settings, provider.loadErr = parseMockSettings(config.BaseURL)
+	if provider.loadErr == nil && provider.settings.fixturesPath != "" {
+		var path string
+		path, provider.loadErr = resolveMockFixtures(fixturesDir, provider.settings.fixturesPath)
+		if provider.loadErr == nil {
+			provider.fixtures, provider.loadErr = loadMockFixtures(path, provider.settings.fixturesPath)
+		}
+	}
+	return provider
+}
+
+// ValidateMockBaseURL checks mock settings before they are stored, including
+// that fixtures resolve inside fixturesDir.
+func ValidateMockBaseURL(raw, fixturesDir string) error {
+	settings, err := parseMockSettings(raw)
+	if err != nil {
+		return err
+	}
+	if settings.fixturesPath == "" {
+		return nil
+	}
+	_, err = resolveMockFixtures(fixturesDir, settings.fixturesPath)
+	return err
+}
+
+// resolveMockFixtures returns the fixtures path within dir. Relative names
+// are taken from dir; absolute paths must lie inside it.
+func resolveMockFixtures(dir, name string) (string, error) {
+	if dir == "" {
+		return "", ErrMockFixturesPath
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", ErrMockFixturesPath
+	}
+	path := filepath.Clean(name)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	prefix := dir
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	if !strings.HasPrefix(path, prefix) {
+		return "", ErrMockFixturesPath
+	}
+	return path, nil
+}
+
+func parseMockSettings(raw string) (mockSettings, error) {
+	settings := mockSettings{failureStatus: 503}
+	if raw == "" {
+		return settings, nil
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return settings, fmt.Errorf("mock: invalid base url: %w", err)
+	}
+	query := parsed.Query()
+	duration := func(key string, target *time.Duration) {
+		if value := query.Get(key); value != "" && err == nil {
+			*target, err = time.ParseDuration(value)
+		}
+	}
+	integer := func(key string, target *int) {
+		if value := query.Get(key); value != "" && err == nil {
+			*target, err = strconv.Atoi(value)
+		}
+	}
+	duration("latency", &settings.latency)
+	duration("jitter", &settings.jitter)
+	integer("failure_status", &settings.failureStatus)
+	integer("input_tokens", &settings.inputTokens)
+	integer("output_tokens", &settings.outputTokens)
+	if value := query.Get("failure_rate"); value != "" && err == nil {
+		settings.failureRate, err = strconv.ParseFloat(value, 64)
+	}
+	if err != nil {
+		return settings, fmt.Errorf("mock: invalid setting: %w", err)
+	}
+	settings.seed = query.Get("seed")
+	settings.fixturesPath = query.Get("fixtures")
+	return settings, nil
+}
+
+// loadMockFixtures reads the fixtures at path. Errors name the fixtures as
+// configured and leave out the file's contents.
+func loadMockFixtures(path, name string) (mockFixtureFile, error) {
+	var file mockFixtureFile
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return file, fmt.Errorf("mock: cannot read fixtures %q", name)
+	}
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return file, fmt.Errorf("mock: fixtures %q are not valid JSON", name)
+	}
+	for i := range file.Rules {
+		if file.Rules[i].Pattern == "" {
+			continue
+		}
+		pattern, err := regexp.Compile("(?i)" + file.Rules[i].Pattern)
+		if err != nil {
+			return file, fmt.Errorf("mock: rule %d: %w", i, err)
+		}
+		file.Rules[i].pattern = pattern
+	}
+	return file, nil
+}
+
+func (m *MockProvider) Name() string { return "mock" }
+
+func (m *MockProvider) GetConfig() *contract.ProviderConfig { return m.config }
+
+func (m *MockProvider) GetUsage(ctx context.Context) (*contract.UsageStats, error) {
+	return m.usage.snapshot(), nil
+}
+
+func (m *MockProvider) Analyze(ctx context.Context, message string, _ contract.CallOptions) (*contract.AnalysisResult, contract.UsageRecord, error) {
+	var result *contract.AnalysisResult
+	usage, err := m.call(ctx, "analyze", message, func(response mockResponse) (any, error) {
+		result = response.Analysis
+		if result == nil {
+			result = mockAnalysis(message)
+		}
+		return result, nil
+	})
+	if err != nil {
+		return nil, usage, err
+	}
+	return result, usage, nil
+}
+
+func (m *MockProvider) AnalyzeBatch(ctx context.Context, items []contract.BatchItem) (map[int64]*contract.AnalysisResult, contract.UsageRecord, error) {
+	payload, _ := json.Marshal(items)
+	results := make(map[int64]*contract.AnalysisResult, len(items))
+	usage, err := m.call(ctx, "analyze_batch", string(payload), func(mockResponse) (any, error) {
+		for _, item := range items {
+			response, err := m.lookup(item.Content)
+			if err != nil {
+				return nil, err
+			}
+			result := response.Analysis
+			if result == nil {
+				result = mockAnalysis(item.Content)
+			}
+			results[item.MessageID] = result
+		}
+		return results, nil
+	})
+	if err != nil {
+		return nil, usage, err
+	}
+	return results, usage, nil
+}
+
+func (m *MockProvider) Summarize(ctx context.Context, messages []string, _ contract.CallOptions) (*contract.SummaryResult, contract.UsageRecord, error) {
+	var result *contract.SummaryResult
+	usage, err := m.call(ctx, "summarize", joinLines(messages), func(response mockResponse) (any, error) {
+		result = response.Summary
+		if result == nil {
+			result = mockSummary(messages)
+		}
+		return result, nil
+	})
+	if err != nil {
+		return nil, usage, err
+	}
+	return result, usage, nil
+}
+
+func (m *MockProvider) ExtractActions(ctx context.Context, text string, _ contract.CallOptions) ([]string, contract.UsageRecord, error) {
+	var result []string
+	usage, err := m.call(ctx, "extract_actions", text, func(response mockResponse) (any, error) {
+		result = response.Actions
+		if result == nil {
+			result = mockActions(text)
+		}
+		return result, nil
+	})
+	if err != nil {
+		return nil, usage, err
+	}
+	return result, usage, nil
+}
+
+// Answer cites the messages sharing the most words with the question and
+// quotes the best of them. Fixtures and rules are matched on the question.
+func (m *MockProvider) Answer(ctx context.Context, request contract.QuestionRequest) (*contract.AnswerResult, contract.UsageRecord, error) {
+	var result *contract.AnswerResult
+	usage, err := m.call(ctx, "answer", request.Question, func(response mockResponse) (any, error) {
+		result = response.Answer
+		if result == nil {
+			result = mockAnswer(request)
+		}
+		return result, nil
+	})
+	if err != nil {
+		return nil, usage, err
+	}
+	return result, usage, nil
+}
+
+// DraftReply answers the latest message from canned phrases, or applies the
+// rewrite mode to the draft mechanically. Fixtures and rules are matched on
+// the draft, or on the latest message when there is none.
+func (m *MockProvider) DraftReply(ctx context.Context, request contract.DraftRequest) (*contract.DraftResult, contract.UsageRecord, error) {
+	input := request.Draft
+	if input == "" && len(request.History) > 0 {
+		input = request.History[len(request.History)-1].Content
+	}
+	var result *contract.DraftResult
+	usage, err := m.call(ctx, "draft_reply", input, func(response mockResponse) (any, error) {
+		result = response.Draft
+		if result == nil {
+			result = mockDraft(request)
+		}
+		return result, nil
+	})
+	if err != nil {
+		return nil, usage, err
+	}
+	return result, usage, nil
+}
+
+// mockEmbeddingDimensions is the size of mock vectors.
+const mockEmbeddingDimensions = 256
+
+// Embed hashes the words of each text into a fixed-size vector, so texts that
+// share words are close. Fixtures and rules apply as for other calls, which
+// allows simulating failures.
+func (m *MockProvider) Embed(ctx context.Context, texts []string) (*contract.Embeddings, contract.UsageRecord, error) {
+	embeddings := &contract.Embeddings{Model: fmt.Sprintf("mock-embed-%d", mockEmbeddingDimensions)}
+	usage, err := m.call(ctx, "embed", joinLines(texts), func(mockResponse) (any, error) {
+		for _, text := range texts {
+			if _, err := m.lookup(text); err != nil {
+				return nil, err
+			}
+			embeddings.Vectors = append(embeddings.Vectors, mockEmbedding(text))
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, usage, err
+	}
+	usage.Model = embeddings.Model
+	return embeddings, usage, nil
+}
+
+// call simulates latency, failures and token usage around build, which
+// produces the response from the matching fixture or rule.
+func (m *MockProvider) call(ctx context.Context, feature, input string, build func(mockResponse) (any, error)) (contract.UsageRecord, error) {
+	call := startCall(feature, m.config.ModelName)
+	call.attempt()
+	output, status, err := m.respond(ctx, input, build)
+	if status != 0 {
+		// The simulated server answered, so the prompt was consumed.
+		in, out := m.settings.inputTokens, 0
+		if in == 0 {
+			in = estimateTokens(input)
+		}
+		if err == nil && feature != "embed" {
+			out = m.settings.outputTokens
+			if out == 0 {
+				out = estimateTokens(output)
+			}
+		}
+		call.tokens(in, out)
+	}
+	usage := call.finish(err, status)
+	m.usage.add(usage, m.config)
+	return usage, err
+}
+
+func (m *MockProvider) respond(ctx context.Context, input string, build func(mockResponse) (any, error)) (string, int, error) {
+	if m.loadErr != nil {
+		return "", 0, m.loadErr
+	}
+	if err := m.sleep(ctx, input); err != nil {
+		return "", 0, err
+	}
+	if m.fails(input) {
+		if m.settings.failureStatus == 429 {
+			return "", 429, &contract.RateLimitError{Provider: "mock", RetryAfter: time.Second}
+		}
+		return "", m.settings.failureStatus, fmt.Errorf("mock: simulated failure (status %d)", m.settings.failureStatus)
+	}
+	response, err := m.lookup(input)
+	if err != nil {
+		return "", 500, err
+	}
+	result, err := build(response)
+	if err != nil {
+		return "", 500, err
+	}
+	encoded, _ := json.Marshal(result)
+	return string(encoded), 200, nil
+}
+
+func (m *MockProvider) lookup(input string) (mockResponse, error) {
+	response, ok := m.fixtures.Fixtures[MockMessageHash(input)]
+	if !ok {
+		lower := strings.ToLower(input)
+		for _, rule := range m.fixtures.Rules {
+			if rule.matches(lower) {
+				response = rule.mockResponse
+				break
+			}
+		}
+	}
+	if response.Error != "" {
+		return response, errors.New(response.Error)
+	}
+	return response.clone(), nil
+}
+
+// clone copies the response deeply. Callers change results in place, e.g.
+// when restoring redacted PII, so fixtures are never handed out directly.
+func (r mockResponse) clone() mockResponse {
+	var copied mockResponse
+	encoded, _ := json.Marshal(r)
+	_ = json.Unmarshal(encoded, &copied)
+	return copied
+}
+
+func (r mockRule) matches(lower string) bool {
+	if r.pattern != nil && r.pattern.MatchString(lower) {
+		return true
+	}
+	for _, word := range r.Contains {
+		if word != "" && strings.Contains(lower, strings.ToLower(word)) {
+			return true
+		}
+	}
+	return false
+}
+
+// fraction maps the input to a stable number in [0, 1).
+func (m *MockProvider) fraction(salt, input string) float64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(m.settings.seed + salt + input))
+	return float64(h.Sum64()%1_000_000) / 1_000_000
+}
+
+func (m *MockProvider) fails(input string) bool {
+	return m.settings.failureRate > 0 && m.fraction("fail:", input) < m.settings.failureRate
+}
+
+func (m *MockProvider) sleep(ctx context.Context, input string) error {
+	delay := m.settings.latency
+	if m.settings.jitter > 0 {
+		delay += time.Duration(m.fraction("jitter:", input) * float64(m.settings.jitter))
+	}
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (m *MockProvider) HealthCheck(ctx context.Context) (*contract.HealthCheckResult, error) {
+	start := time.Now()
+	err := m.loadErr
+	if err == nil {
+		err = m.sleep(ctx, "health")
+	}
+	if err == nil && m.settings.failureRate >= 1 {
+		err = errors.New("mock: simulated failure")
+	}
+	status := "ok"
+	msg := ""
+	if err != nil {
+		status = "error"
+		msg = err.Error()
+	}
+	return &contract.HealthCheckResult{
+		Status:        status,
+		Latency:       time.Since(start),
+		EstimatedCost: 0,
+		ErrorMessage:  msg,
+		Timestamp:     time.Now().UTC(),
+	}, err
+}
+
+var mockTopics = map[string][]string{
+	"billing":  {"invoice", "payment", "refund", "price", "bill"},
+	"delivery": {"delivery", "shipping", "order", "package", "tracking"},
+	"meeting":  {"meeting", "call", "schedule", "tomorrow", "calendar"},
+	"support":  {"issue", "problem", "broken", "error", "help"},
+}
+
+func mockAnalysis(message string) *contract.AnalysisResult {
+	text := strings.ToLower(message)
+	result := &contract.AnalysisResult{
+		Priority:   "low",
+		Reason:     "no urgent signals",
+		Sentiment:  "neutral",
+		Topics:     []string{},
+		Confidence: 0.9,
+	}
+	switch {
+	case mockContains(text, "urgent", "asap", "immediately", "deadline"):
+		result.IsImportant = true
+		result.Priority = "high"
+		result.Reason = "urgent wording"
+	case strings.Count(message, "!") >= 2 || mockContains(text, "important"):
+		result.IsImportant = true
+		result.Priority = "medium"
+		result.Reason = "emphasis"
+	}
+	if strings.Contains(message, "?") || mockContains(text, "please", "can you", "could you", "need") {
+		result.HasAction = true
+		result.ActionRequired = "reply to the sender"
+	}
+	switch {
+	case mockContains(text, "angry", "upset", "frustrated", "terrible", "issue", "problem"):
+		result.Sentiment = "negative"
+		result.SentimentScore = -0.6
+	case mockContains(text, "thanks", "thank you", "great", "happy", "love"):
+		result.Sentiment = "positive"
+		result.SentimentScore = 0.6
+	}
+	for _, topic := range []string{"billing", "delivery", "meeting", "support"} {
+		if mockContains(text, mockTopics[topic]...) {
+			result.Topics = append(result.Topics, topic)
+		}
+	}
+	return result
+}
+
+var mockWord = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// mockEmbedding is a unit vector of hashed word counts.
+func mockEmbedding(text string) []float32 {
+	vector := make([]float32, mockEmbeddingDimensions)
+	for _, word := range mockWord.FindAllString(strings.ToLower(text), -1) {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(word))
+		sum := h.Sum32()
+		weight := float32(1)
+		if sum&1 == 1 {
+			weight = -1
+		}
+		vector[(sum>>1)%mockEmbeddingDimensions] += weight
+	}
+	var norm float64
+	for _, value := range vector {
+		norm += float64(value * value)
+	}
+	if norm > 0 {
+		scale := float32(1 / math.Sqrt(norm))
+		for i := range vector {
+			vector[i] *= scale
+		}
+	}
+	return vector
+}
+
+func mockSummary(messages []string) *contract.SummaryResult {
+	result := &contract.SummaryResult{
+		Summary:     fmt.Sprintf("Conversation of %d messages.", len(messages)),
+		KeyPoints:   []string{},
+		ActionItems: []string{},
+		Sentiment:   "neutral",
+		Topics:      []string{},
+	}
+	positive, negative := 0, 0
+	topics := map[string]bool{}
+	for i, message := range messages {
+		analysis := mockAnalysis(message)
+		if i < 3 {
+			result.KeyPoints = append(result.KeyPoints, mockTruncate(message, 80))
+		}
+		result.ActionItems = append(result.ActionItems, mockActions(message)...)
+		switch analysis.Sentiment {
+		case "positive":
+			positive++
+		case "negative":
+			negative++
+		}
+		for _, topic := range analysis.Topics {
+			if !topics[topic] {
+				topics[topic] = true
+				result.Topics = append(result.Topics, topic)
+			}
+		}
+	}
+	if positive > negative {
+		result.Sentiment = "positive"
+	} else if negative > positive {
+		result.Sentiment = "negative"
+	}
+	return result
+}
+
+func mockAnswer(request contract.QuestionRequest) *contract.AnswerResult {
+	terms := map[string]bool{}
+	for _, word := range mockWord.FindAllString(strings.ToLower(request.Question), -1) {
+		if len(word) > 3 {
+			terms[word] = true
+		}
+	}
+	type scored struct {
+		message contract.ContextMessage
+		score   int
+	}
+	var matches []scored
+	for _, message := range request.Messages {
+		seen := map[string]bool{}
+		for _, word := range mockWord.FindAllString(strings.ToLower(message.Content), -1) {
+			if terms[word] {
+				seen[word] = true
+			}
+		}
+		if len(seen) > 0 {
+			matches = append(matches, scored{message: message, score: len(seen)})
+		}
+	}
+	if len(matches) == 0 {
+		return &contract.AnswerResult{Answer: "The conversation does not say.", Citations: []int64{}, Confidence: 0.2}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	if len(matches) > 3 {
+		matches = matches[:3]
+	}
+	result := &contract.AnswerResult{
+		Answer:     "According to the conversation: " + mockTruncate(matches[0].message.Content, 200),
+		Found:      true,
+		Confidence: 0.7,
+	}
+	for _, match := range matches {
+		result.Citations = append(result.Citations, match.message.MessageID)
+	}
+	return result
+}
+
+func mockDraft(request contract.DraftRequest) *contract.DraftResult {
+	count := request.Count
+	if count <= 0 {
+		count = 1
+	}
+	if request.Draft != "" {
+		draft := strings.TrimSpace(request.Draft)
+		var rewritten string
+		switch request.Rewrite {
+		case contract.RewriteShorter:
+			rewritten = strings.TrimSpace(mockSentenceEnd.Split(draft, 2)[0])
+		case contract.RewriteFriendlier:
+			rewritten = "Hi! " + draft + " Thanks so much!"
+		case contract.RewriteFormal:
+			rewritten = "Dear customer, " + draft + " Kind regards."
+		case contract.RewriteTranslate:
+			rewritten = "[" + request.Language + "] " + draft
+		default:
+			rewritten = draft
+		}
+		return &contract.DraftResult{Suggestions: []string{rewritten}}
+	}
+	name := request.ContactName
+	if name == "" {
+		name = "there"
+	}
+	opening := "Hi " + name + ", thanks for your message."
+	if request.Analysis != nil && request.Analysis.Sentiment == "negative" {
+		opening = "Hi " + name + ", sorry for the trouble."
+	}
+	candidates := []string{
+		opening + " We're looking into it and will get back to you shortly.",
+		opening + " Could you share a few more details so we can help?",
+		opening + " Noted, we'll take care of it.",
+	}
+	if count < len(candidates) {
+		candidates = candidates[:count]
+	}
+	return &contract.DraftResult{Suggestions: candidates}
+}
+
+var mockSentenceEnd = regexp.MustCompile(`[.!?\n]+`)
+
+func mockActions(text string) []string {
+	actions := []string{}
+	for _, sentence := range mockSentenceEnd.Split(text, -1) {
+		sentence = strings.TrimSpace(sentence)
+		if sentence != "" && mockContains(strings.ToLower(sentence), "please", "can you", "could you", "need to", "todo", "remember to") {
+			actions = append(actions, sentence)
+		}
+	}
+	return actions
+}
+
+func mockContains(text string, words ...string) bool {
+	for _, word := range words {
+		if strings.Contains(text, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func mockTruncate(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	return strings.ToValidUTF8(text[:limit], "") + "…"
+}
@@ -0,0 +1,231 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"message-flow/backend/internal/llm/contract"
+)
+
+func newTestMock(t *testing.T, baseURL string) *MockProvider {
+	t.Helper()
+	return newTestMockIn(t, baseURL, "")
+}
+
+func newTestMockIn(t *testing.T, baseURL, fixturesDir string) *MockProvider {
+	t.Helper()
+	provider := NewMockProvider(&contract.ProviderConfig{ProviderName: "mock", ModelName: "mock-1", BaseURL: baseURL, CostPer1KInput: 0.001, CostPer1KOutput: 0.002}, fixturesDir)
+	if provider.loadErr != nil {
+		t.Fatal(provider.loadErr)
+	}
+	return provider
+}
+
+func TestMockIsDeterministic(t *testing.T) {
+	provider := newTestMock(t, "mock://")
+	first, usage, err := provider.Analyze(context.Background(), "URGENT: the invoice payment failed, can you check?", contract.CallOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, _ := provider.Analyze(context.Background(), "URGENT: the invoice payment failed, can you check?", contract.CallOptions{})
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("expected identical results, got %+v and %+v", first, second)
+	}
+	if !first.IsImportant || first.Priority != "high" || !first.HasAction || len(first.Topics) != 1 || first.Topics[0] != "billing" {
+		t.Fatalf("unexpected analysis %+v", first)
+	}
+	if usage.InputTokens == 0 || usage.OutputTokens == 0 || usage.HTTPStatus != 200 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+
+	summary, _, err := provider.Summarize(context.Background(), []string{"Thanks for the delivery!", "Please send the tracking number."}, contract.CallOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.ActionItems) != 1 || summary.ActionItems[0] != "Please send the tracking number" {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+}
+
+func TestMockFixturesAndRules(t *testing.T) {
+	fixtures := fmt.Sprintf(`{
+		"fixtures": {%q: {"analysis": {"is_important": true, "priority": "high", "reason": "fixture"}}},
+		"rules": [
+			{"contains": ["refund"], "analysis": {"priority": "medium", "reason": "rule"}, "actions": ["issue refund"]},
+			{"pattern": "^boom", "error": "upstream exploded"}
+		]
+	}`, MockMessageHash("  hello there "))
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "mock.json"), []byte(fixtures), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	provider := newTestMockIn(t, "mock://?fixtures=mock.json&input_tokens=100&output_tokens=20", dir)
+
+	result, usage, err := provider.Analyze(context.Background(), "hello there", contract.CallOptions{})
+	if err != nil || result.Reason != "fixture" {
+		t.Fatalf("expected fixture result, got %+v %v", result, err)
+	}
+	if usage.InputTokens != 100 || usage.OutputTokens != 20 {
+		t.Fatalf("expected configured tokens, got %+v", usage)
+	}
+	if result, _, _ := provider.Analyze(context.Background(), "I want a REFUND", contract.CallOptions{}); result.Reason != "rule" {
+		t.Fatalf("expected rule result, got %+v", result)
+	}
+	if actions, _, _ := provider.ExtractActions(context.Background(), "refund please", contract.CallOptions{}); !reflect.DeepEqual(actions, []string{"issue refund"}) {
+		t.Fatalf("unexpected actions %v", actions)
+	}
+	if _, usage, err := provider.Analyze(context.Background(), "Boom goes the server", contract.CallOptions{}); err == nil || usage.Success {
+		t.Fatalf("expected rule error, got %+v", usage)
+	}
+}
+
+func TestMockFixturesAreCopied(t *testing.T) {
+	fixtures := `{"rules": [{"contains": ["refund"],
+		"analysis": {"priority": "medium", "action_required": "call [PHONE_1]", "topics": ["billing"]},
+		"actions": ["refund [EMAIL_1]"],
+		"draft": {"suggestions": ["one", "two"]}}]}`
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "mock.json"), []byte(fixtures), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	provider := newTestMockIn(t, "mock://?fixtures=mock.json", dir)
+	ctx := context.Background()
+
+	first, _, err := provider.Analyze(ctx, "refund please", contract.CallOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.ActionRequired = "call +1 555 0100"
+	first.Topics[0] = "changed"
+	if second, _, _ := provider.Analyze(ctx, "refund please", contract.CallOptions{}); second.ActionRequired != "call [PHONE_1]" || second.Topics[0] != "billing" {
+		t.Fatalf("fixture changed through a returned result: %+v", second)
+	}
+
+	batch, _, err := provider.AnalyzeBatch(ctx, []contract.BatchItem{{MessageID: 1, Content: "refund"}, {MessageID: 2, Content: "refund now"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch[1] == batch[2] {
+		t.Fatal("expected separate results for messages matching the same rule")
+	}
+
+	actions, _, _ := provider.ExtractActions(ctx, "refund", contract.CallOptions{})
+	actions[0] = "refund jane@example.com"
+	if again, _, _ := provider.ExtractActions(ctx, "refund", contract.CallOptions{}); again[0] != "refund [EMAIL_1]" {
+		t.Fatalf("fixture actions changed: %v", again)
+	}
+
+	draft, _, _ := provider.DraftReply(ctx, contract.DraftRequest{Draft: "refund"})
+	draft.Suggestions = draft.Suggestions[:1]
+	draft.Suggestions[0] = "changed"
+	if again, _, _ := provider.DraftReply(ctx, contract.DraftRequest{Draft: "refund"}); !reflect.DeepEqual(again.Suggestions, []string{"one", "two"}) {
+		t.Fatalf("fixture draft changed: %v", again.Suggestions)
+	}
+}
+
+func TestMockFailureRate(t *testing.T) {
+	always := newTestMock(t, "mock://?failure_rate=1&failure_status=500")
+	if _, usage, err := always.Analyze(context.Background(), "hi", contract.CallOptions{}); err == nil || usage.HTTPStatus != 500 || usage.OutputTokens != 0 {
+		t.Fatalf("expected simulated failure, got %+v %v", usage, err)
+	}
+	limited := newTestMock(t, "mock://?failure_rate=1&failure_status=429")
+	if _, _, err := limited.Analyze(context.Background(), "hi", contract.CallOptions{}); !errors.Is(err, contract.ErrRateLimited) {
+		t.Fatalf("expected rate limit error, got %v", err)
+	}
+
+	half := newTestMock(t, "mock://?failure_rate=0.5&seed=demo")
+	failures := 0
+	for i := 0; i < 200; i++ {
+		message := fmt.Sprintf("message %d", i)
+		_, _, first := half.Analyze(context.Background(), message, contract.CallOptions{})
+		_, _, second := half.Analyze(context.Background(), message, contract.CallOptions{})
+		if (first == nil) != (second == nil) {
+			t.Fatalf("failure for %q is not deterministic", message)
+		}
+		if first != nil {
+			failures++
+		}
+	}
+	if failures < 60 || failures > 140 {
+		t.Fatalf("expected about half to fail, got %d of 200", failures)
+	}
+}
+
+func TestMockLatencyHonoursContext(t *testing.T) {
+	provider := newTestMock(t, "mock://?latency=1s")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := provider.Analyze(ctx, "hi", contract.CallOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestMockInvalidSettings(t *testing.T) {
+	provider := NewMockProvider(&contract.ProviderConfig{ProviderName: "mock", BaseURL: "mock://?latency=soon"}, "")
+	if _, err := provider.HealthCheck(context.Background()); err == nil {
+		t.Fatal("expected invalid settings to fail the health check")
+	}
+}
+
+func TestMockFixturesStayInDirectory(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(t.TempDir(), "secret.json")
+	if err := os.WriteFile(secret, []byte("top secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, fixtures := range []string{secret, "../" + filepath.Base(filepath.Dir(secret)) + "/secret.json", "/etc/passwd"} {
+		if err := ValidateMockBaseURL("mock://?fixtures="+fixtures, dir); !errors.Is(err, ErrMockFixturesPath) {
+			t.Fatalf("fixtures %s accepted: %v", fixtures, err)
+		}
+		provider := NewMockProvider(&contract.ProviderConfig{ProviderName: "mock", BaseURL: "mock://?fixtures=" + fixtures}, dir)
+		if _, err := provider.HealthCheck(context.Background()); !errors.Is(err, ErrMockFixturesPath) {
+			t.Fatalf("fixtures %s read: %v", fixtures, err)
+		}
+	}
+	if err := ValidateMockBaseURL("mock://?fixtures="+secret, ""); !errors.Is(err, ErrMockFixturesPath) {
+		t.Fatalf("fixtures accepted without a fixtures directory: %v", err)
+	}
+
+	// Errors for files inside the directory do not echo their contents.
+	if err := os.WriteFile(filepath.Join(dir, "broken.json"), []byte("top secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	provider := NewMockProvider(&contract.ProviderConfig{ProviderName: "mock", BaseURL: "mock://?fixtures=broken.json"}, dir)
+	if _, err := provider.HealthCheck(context.Background()); err == nil || strings.Contains(err.Error(), "secret") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := ValidateMockBaseURL("mock://?fixtures="+filepath.Join(dir, "broken.json"), dir); err != nil {
+		t.Fatalf("absolute path inside the directory rejected: %v", err)
+	}
+}
+
+func TestMockEmbed(t *testing.T) {
+	provider := newTestMock(t, "mock://")
+	embeddings, usage, err := provider.Embed(context.Background(), []string{"Where is my invoice?", "where is the INVOICE", "see you at the meeting"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(embeddings.Vectors) != 3 || len(embeddings.Vectors[0]) != mockEmbeddingDimensions || embeddings.Model != usage.Model {
+		t.Fatalf("unexpected embeddings %d vectors, model %q", len(embeddings.Vectors), embeddings.Model)
+	}
+	dot := func(a, b []float32) float32 {
+		var sum float32
+		for i := range a {
+			sum += a[i] * b[i]
+		}
+		return sum
+	}
+	if similar, other := dot(embeddings.Vectors[0], embeddings.Vectors[1]), dot(embeddings.Vectors[0], embeddings.Vectors[2]); similar <= other {
+		t.Fatalf("expected shared words to be closer, got %f and %f", similar, other)
+	}
+	if usage.OutputTokens != 0 || usage.InputTokens == 0 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+}
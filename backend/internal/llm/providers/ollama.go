@@ -0,0 +1,330 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"message-flow/backend/internal/llm/contract"
+)
+
+const defaultOllamaURL = "http://localhost:11434"
+
+// OllamaProvider talks to a local Ollama server over its native HTTP API, for
+// sites that cannot reach hosted models. Local models cost nothing per token,
+// so the provider works on a copy of its configuration without rates and
+// usage is recorded at zero cost.
+type OllamaProvider struct {
+	client  *http.Client
+	baseURL string
+	config  *contract.ProviderConfig
+	retrier Retrier
+	usage   usageTracker
+}
+
+func NewOllamaProvider(config *contract.ProviderConfig) *OllamaProvider {
+	local := *config
+	local.CostPer1KInput = 0
+	local.CostPer1KOutput = 0
+	config = &local
+	baseURL := strings.TrimRight(config.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = defaultOllamaURL
+	}
+	return &OllamaProvider{
+		client:  &http.Client{},
+		baseURL: baseURL,
+		config:  config,
+		retrier: Retrier{Attempts: 2, Delay: 300 * time.Millisecond},
+	}
+}
+
+func (o *OllamaProvider) Name() string { return "ollama" }
+
+func (o *OllamaProvider) GetConfig() *contract.ProviderConfig { return o.config }
+
+func (o *OllamaProvider) GetUsage(ctx context.Context) (*contract.UsageStats, error) {
+	return o.usage.snapshot(), nil
+}
+
+func (o *OllamaProvider) Analyze(ctx context.Context, message string, options contract.CallOptions) (*contract.AnalysisResult, contract.UsageRecord, error) {
+	prompt := analysisPromptFor(options, analyzePrompt(message))
+	text, usage, err := o.complete(ctx, "analyze", prompt)
+	if err != nil {
+		return nil, usage, err
+	}
+	var parsed contract.AnalysisResult
+	if usage, err = decodeOutput(ctx, o.complete, "analyze", analysisSchema, text, usage, &parsed); err != nil {
+		return nil, usage, err
+	}
+	return &parsed, usage, nil
+}
+
+func (o *OllamaProvider) AnalyzeBatch(ctx context.Context, items []contract.BatchItem) (map[int64]*contract.AnalysisResult, contract.UsageRecord, error) {
+	text, usage, err := o.complete(ctx, "analyze_batch", batchAnalysisPrompt(items))
+	if err != nil {
+		return nil, usage, err
+	}
+	results, invalid, err := parseBatchAnalysis(text)
+	usage.ValidationErrors = invalid
+	return results, usage, err
+}
+
+func (o *OllamaProvider) Summarize(ctx context.Context, messages []string, options contract.CallOptions) (*contract.SummaryResult, contract.UsageRecord, error) {
+	prompt := promptFor(options, summarizePrompt(messages))
+	text, usage, err := o.complete(ctx, "summarize", prompt)
+	if err != nil {
+		return nil, usage, err
+	}
+	var parsed contract.SummaryResult
+	if usage, err = decodeOutput(ctx, o.complete, "summarize", summarySchema, text, usage, &parsed); err != nil {
+		return nil, usage, err
+	}
+	return &parsed, usage, nil
+}
+
+func (o *OllamaProvider) ExtractActions(ctx context.Context, text string, options contract.CallOptions) ([]string, contract.UsageRecord, error) {
+	// JSON mode always yields an object, so the list is wrapped.
+	prompt := promptFor(options, "Extract action items as JSON object {\"action_items\": [strings]}\n\nText: "+text)
+	content, usage, err := o.complete(ctx, "extract_actions", prompt)
+	if err != nil {
+		return nil, usage, err
+	}
+	var actions []string
+	usage, err = decodeOutput(ctx, o.complete, "extract_actions", actionsSchema, content, usage, &actions)
+	return actions, usage, err
+}
+
+func (o *OllamaProvider) Answer(ctx context.Context, request contract.QuestionRequest) (*contract.AnswerResult, contract.UsageRecord, error) {
+	return answerWith(ctx, o.complete, request)
+}
+
+func (o *OllamaProvider) DraftReply(ctx context.Context, request contract.DraftRequest) (*contract.DraftResult, contract.UsageRecord, error) {
+	return draftWith(ctx, o.complete, request)
+}
+
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Format   string          `json:"format,omitempty"`
+	Stream   bool            `json:"stream"`
+	Options  map[string]any  `json:"options,omitempty"`
+}
+
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ollamaChatResponse struct {
+	Model           string        `json:"model"`
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+}
+
+// ollamaError is a non-2xx response from the Ollama API.
+type ollamaError struct {
+	Status  int
+	Message string
+}
+
+func (e *ollamaError) Error() string {
+	return fmt.Sprintf("ollama: %d %s", e.Status, e.Message)
+}
+
+func (e *ollamaError) StatusCode() int { return e.Status }
+
+// complete sends a single-turn chat in JSON mode and returns the reply along
+// with the usage of this call.
+func (o *OllamaProvider) complete(ctx context.Context, feature, prompt string) (string, contract.UsageRecord, error) {
+	call := startCall(feature, o.config.ModelName)
+	// Local models are slower than hosted ones, especially on first load.
+	ctx, cancel := context.WithTimeout(ctx, 120*time.Second)
+	defer cancel()
+
+	options := map[string]any{"temperature": o.config.Temperature}
+	if o.config.MaxTokens > 0 {
+		options["num_predict"] = o.config.MaxTokens
+	}
+	request := ollamaChatRequest{
+		Model:    o.config.ModelName,
+		Messages: []ollamaMessage{{Role: "user", Content: prompt}},
+		Format:   "json",
+		Options:  options,
+	}
+	var response ollamaChatResponse
+	var lastErr error
+	err := o.retrier.Do(ctx, func() error {
+		call.attempt()
+		lastErr = o.do(ctx, http.MethodPost, "/api/chat", request, &response)
+		return lastErr
+	})
+	if err == nil {
+		call.tokens(response.PromptEvalCount, response.EvalCount)
+		if response.Model != "" {
+			call.record.Model = response.Model
+		}
+		if strings.TrimSpace(response.Message.Content) == "" {
+			err = errors.New("empty response")
+		}
+	}
+	usage := call.finish(err, ollamaStatus(lastErr))
+	o.usage.add(usage, o.config)
+	if err != nil {
+		return "", usage, err
+	}
+	return response.Message.Content, usage, nil
+}
+
+// ollamaEmbeddingModel is used for embeddings; it has to be pulled on the
+// server like any other model.
+const ollamaEmbeddingModel = "nomic-embed-text"
+
+type ollamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type ollamaEmbedResponse struct {
+	Model           string      `json:"model"`
+	Embeddings      [][]float64 `json:"embeddings"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+}
+
+// Embed returns one vector per text from the server's /api/embed endpoint.
+func (o *OllamaProvider) Embed(ctx context.Context, texts []string) (*contract.Embeddings, contract.UsageRecord, error) {
+	call := startCall("embed", ollamaEmbeddingModel)
+	ctx, cancel := context.WithTimeout(ctx, 120*time.Second)
+	defer cancel()
+
+	var response ollamaEmbedResponse
+	var lastErr error
+	err := o.retrier.Do(ctx, func() error {
+		call.attempt()
+		lastErr = o.do(ctx, http.MethodPost, "/api/embed", ollamaEmbedRequest{Model: ollamaEmbeddingModel, Input: texts}, &response)
+		return lastErr
+	})
+	var embeddings *contract.Embeddings
+	if err == nil {
+		call.tokens(response.PromptEvalCount, 0)
+		embeddings = &contract.Embeddings{Model: ollamaEmbeddingModel, Vectors: make([][]float32, 0, len(response.Embeddings))}
+		for _, values := range response.Embeddings {
+			embeddings.Vectors = append(embeddings.Vectors, toFloat32(values))
+		}
+		err = checkEmbeddings(embeddings, len(texts))
+	}
+	usage := call.finish(err, ollamaStatus(lastErr))
+	o.usage.add(usage, o.config)
+	if err != nil {
+		return nil, usage, err
+	}
+	return embeddings, usage, nil
+}
+
+func (o *OllamaProvider) do(ctx context.Context, method, path string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, o.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if o.config.APIKey != "" {
+		// Ollama itself has no auth, but it is often run behind a proxy.
+		req.Header.Set("Authorization", "Bearer "+o.config.APIKey)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		message := strings.TrimSpace(string(raw))
+		if json.Unmarshal(raw, &apiErr) == nil && apiErr.Error != "" {
+			message = apiErr.Error
+		}
+		return &ollamaError{Status: resp.StatusCode, Message: message}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func ollamaStatus(err error) int {
+	var apiErr *ollamaError
+	if errors.As(err, &apiErr) {
+		return apiErr.Status
+	}
+	return 0
+}
+
+// HealthCheck lists the local models and fails when the configured model has
+// not been pulled, without running a generation.
+func (o *OllamaProvider) HealthCheck(ctx context.Context) (*contract.HealthCheckResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	var tags struct {
+		Models []struct {
+			Name  string `json:"name"`
+			Model string `json:"model"`
+		} `json:"models"`
+	}
+	err := o.do(ctx, http.MethodGet, "/api/tags", nil, &tags)
+	if err == nil && o.config.ModelName != "" {
+		found := false
+		for _, model := range tags.Models {
+			if ollamaModelMatches(o.config.ModelName, model.Name) || ollamaModelMatches(o.config.ModelName, model.Model) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			err = fmt.Errorf("model %s is not available on the ollama server", o.config.ModelName)
+		}
+	}
+	latency := time.Since(start)
+	status := "ok"
+	msg := ""
+	if err != nil {
+		status = "error"
+		msg = err.Error()
+	}
+	return &contract.HealthCheckResult{
+		Status:        status,
+		Latency:       latency,
+		EstimatedCost: 0,
+		ErrorMessage:  msg,
+		Timestamp:     time.Now().UTC(),
+	}, err
+}
+
+// ollamaModelMatches treats "llama3" and "llama3:latest" as the same model.
+func ollamaModelMatches(configured, available string) bool {
+	if configured == available {
+		return true
+	}
+	if !strings.Contains(configured, ":") {
+		return configured+":latest" == available
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"message-flow/backend/internal/llm/contract"
+)
+
+func newOllamaServer(t *testing.T, reply string, status int) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			_, _ = w.Write([]byte(`{"models":[{"name":"llama3.1:latest","model":"llama3.1:latest"}]}`))
+		case "/api/chat":
+			var req ollamaChatRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Errorf("decode request: %v", err)
+			}
+			if req.Format != "json" || req.Stream || req.Model != "llama3.1" || len(req.Messages) != 1 {
+				t.Errorf("unexpected request %+v", req)
+			}
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte(`{"error":"model is loading"}`))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"model":             "llama3.1:latest",
+				"message":           map[string]string{"role": "assistant", "content": reply},
+				"done":              true,
+				"prompt_eval_count": 42,
+				"eval_count":        7,
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestOllama(url string) *OllamaProvider {
+	provider := NewOllamaProvider(&contract.ProviderConfig{
+		ProviderName:    "ollama",
+		ModelName:       "llama3.1",
+		BaseURL:         url,
+		MaxTokens:       256,
+		CostPer1KInput:  0.5,
+		CostPer1KOutput: 0.5,
+	})
+	provider.retrier.Delay = time.Millisecond
+	return provider
+}
+
+func TestOllamaAnalyze(t *testing.T) {
+	server := newOllamaServer(t, `{"is_important":true,"priority":"high","topics":["billing"]}`, http.StatusOK)
+	provider := newTestOllama(server.URL)
+
+	result, usage, err := provider.Analyze(context.Background(), "invoice overdue", contract.CallOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.IsImportant || result.Priority != "high" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if usage.InputTokens != 42 || usage.OutputTokens != 7 || usage.Model != "llama3.1:latest" || usage.HTTPStatus != 200 || !usage.Success {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+	config := provider.GetConfig()
+	if cost := usage.TotalCost(config.CostPer1KInput, config.CostPer1KOutput); cost != 0 {
+		t.Fatalf("expected zero cost, got %f", cost)
+	}
+}
+
+func TestOllamaLeavesConfigAlone(t *testing.T) {
+	config := &contract.ProviderConfig{ProviderName: "ollama", ModelName: "llama3.1", CostPer1KInput: 0.5, CostPer1KOutput: 0.25}
+	provider := NewOllamaProvider(config)
+	if config.CostPer1KInput != 0.5 || config.CostPer1KOutput != 0.25 {
+		t.Fatalf("caller's config was changed: %+v", config)
+	}
+	if own := provider.GetConfig(); own.CostPer1KInput != 0 || own.CostPer1KOutput != 0 {
+		t.Fatalf("expected zero rates, got %+v", own)
+	}
+}
+
+func TestOllamaExtractActionsUnwrapsObject(t *testing.T) {
+	server := newOllamaServer(t, `{"action_items":["send invoice","call back"]}`, http.StatusOK)
+	actions, _, err := newTestOllama(server.URL).ExtractActions(context.Background(), "please send the invoice and call me back", contract.CallOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 2 || actions[0] != "send invoice" {
+		t.Fatalf("unexpected actions %v", actions)
+	}
+}
+
+func TestOllamaErrorStatus(t *testing.T) {
+	server := newOllamaServer(t, "", http.StatusServiceUnavailable)
+	provider := newTestOllama(server.URL)
+
+	_, usage, err := provider.Summarize(context.Background(), []string{"hello"}, contract.CallOptions{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if usage.Success || usage.HTTPStatus != http.StatusServiceUnavailable || usage.Attempts != 2 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+	if stats, _ := provider.GetUsage(context.Background()); stats.FailedRequests != 1 {
+		t.Fatalf("expected one failed request, got %+v", stats)
+	}
+}
+
+func TestOllamaHealthCheck(t *testing.T) {
+	server := newOllamaServer(t, "", http.StatusOK)
+
+	result, err := newTestOllama(server.URL).HealthCheck(context.Background())
+	if err != nil || result.Status != "ok" {
+		t.Fatalf("expected healthy, got %+v %v", result, err)
+	}
+
+	missing := newTestOllama(server.URL)
+	missing.config.ModelName = "mistral"
+	result, err = missing.HealthCheck(context.Background())
+	if err == nil || result.Status != "error" {
+		t.Fatalf("expected missing model to fail, got %+v", result)
+	}
+}
@@ -2,7 +2,6 @@ package providers
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -16,11 +15,10 @@ import (
 )
 
 type OpenAIProvider struct {
-	client     openai.Client
-	config     *contract.ProviderConfig
-	retrier    Retrier
-	lastUsage  contract.UsageStats
-	lastRecord contract.UsageRecord
+	client  openai.Client
+	config  *contract.ProviderConfig
+	retrier Retrier
+	usage   usageTracker
 }
 
 func NewOpenAIProvider(config *contract.ProviderConfig) *OpenAIProvider {
@@ -37,105 +35,129 @@ func (o *OpenAIProvider) Name() string { return o.config.ProviderName }
 func (o *OpenAIProvider) GetConfig() *contract.ProviderConfig { return o.config }
 
 func (o *OpenAIProvider) GetUsage(ctx context.Context) (*contract.UsageStats, error) {
-	return &o.lastUsage, nil
+	return o.usage.snapshot(), nil
 }
 
-func (o *OpenAIProvider) Analyze(ctx context.Context, message string) (*contract.AnalysisResult, error) {
-	prompt := "Analyze this WhatsApp message JSON-only response with: is_important(bool),\npriority(high|medium|low), reason, has_action(bool), action_required,\nsentiment(positive|neutral|negative), sentiment_score(-1 to 1),\ntopics[], confidence(0-1)\n\nMessage: " + message
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
+func (o *OpenAIProvider) Analyze(ctx context.Context, message string, options contract.CallOptions) (*contract.AnalysisResult, contract.UsageRecord, error) {
+	prompt := analysisPromptFor(options, analyzePrompt(message))
+	content, usage, err := o.complete(ctx, "analyze", prompt)
+	if err != nil {
+		return nil, usage, err
+	}
+	var parsed contract.AnalysisResult
+	if usage, err = decodeOutput(ctx, o.complete, "analyze", analysisSchema, content, usage, &parsed); err != nil {
+		return nil, usage, err
+	}
+	return &parsed, usage, nil
+}
 
-	start := time.Now()
-	var resp *openai.ChatCompletion
-	err := o.retrier.Do(ctx, func() error {
-		format := shared.NewResponseFormatJSONObjectParam()
-		result, err := o.client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-			Model:       shared.ChatModel(o.effectiveModel()),
-			Temperature: openai.Float(o.config.Temperature),
-			MaxTokens:   openai.Int(int64(o.config.MaxTokens)),
-			ResponseFormat: openai.ChatCompletionNewParamsResponseFormatUnion{
-				OfJSONObject: &format,
-			},
-			Messages: []openai.ChatCompletionMessageParamUnion{
-				userMessage(prompt),
-			},
-		})
-		if err != nil {
-			if isRateLimitError(err) {
-				return err
-			}
-			return err
-		}
-		resp = result
-		return nil
-	})
+func (o *OpenAIProvider) AnalyzeBatch(ctx context.Context, items []contract.BatchItem) (map[int64]*contract.AnalysisResult, contract.UsageRecord, error) {
+	content, usage, err := o.complete(ctx, "analyze_batch", batchAnalysisPrompt(items))
 	if err != nil {
-		return nil, err
+		return nil, usage, err
 	}
-	o.captureUsage("analyze", start, resp.Usage)
-	if len(resp.Choices) == 0 {
-		return nil, errors.New("empty response")
+	results, invalid, err := parseBatchAnalysis(content)
+	usage.ValidationErrors = invalid
+	return results, usage, err
+}
+
+func (o *OpenAIProvider) Summarize(ctx context.Context, messages []string, options contract.CallOptions) (*contract.SummaryResult, contract.UsageRecord, error) {
+	prompt := promptFor(options, summarizePrompt(messages))
+	content, usage, err := o.complete(ctx, "summarize", prompt)
+	if err != nil {
+		return nil, usage, err
 	}
-	content := resp.Choices[0].Message.Content
-	var parsed contract.AnalysisResult
-	if err := json.Unmarshal([]byte(content), &parsed); err != nil {
-		return nil, err
+	var parsed contract.SummaryResult
+	if usage, err = decodeOutput(ctx, o.complete, "summarize", summarySchema, content, usage, &parsed); err != nil {
+		return nil, usage, err
+	}
+	return &parsed, usage, nil
+}
+
+func (o *OpenAIProvider) ExtractActions(ctx context.Context, text string, options contract.CallOptions) ([]string, contract.UsageRecord, error) {
+	prompt := promptFor(options, "Extract action items as JSON object with actions array of strings\n\nText: "+text)
+	content, usage, err := o.complete(ctx, "extract_actions", prompt)
+	if err != nil {
+		return nil, usage, err
 	}
-	return &parsed, nil
+	var actions []string
+	usage, err = decodeOutput(ctx, o.complete, "extract_actions", actionsSchema, content, usage, &actions)
+	return actions, usage, err
 }
 
-func (o *OpenAIProvider) Summarize(ctx context.Context, messages []string) (*contract.SummaryResult, error) {
-	prompt := "Summarize conversation with: summary, key_points[], action_items[], sentiment, topics[]\n\nMessages: " + joinLines(messages)
+func (o *OpenAIProvider) Answer(ctx context.Context, request contract.QuestionRequest) (*contract.AnswerResult, contract.UsageRecord, error) {
+	return answerWith(ctx, o.complete, request)
+}
+
+func (o *OpenAIProvider) DraftReply(ctx context.Context, request contract.DraftRequest) (*contract.DraftResult, contract.UsageRecord, error) {
+	return draftWith(ctx, o.complete, request)
+}
+
+// openAIEmbeddingModel is the model used for embeddings, whatever chat model
+// the provider is configured with.
+const openAIEmbeddingModel = openai.EmbeddingModelTextEmbedding3Small
+
+// Embed returns one vector per text. Azure deployments serve a single model,
+// so they have no embeddings unless configured as a separate provider.
+func (o *OpenAIProvider) Embed(ctx context.Context, texts []string) (*contract.Embeddings, contract.UsageRecord, error) {
+	if o.config.ProviderName == "azure_openai" || o.config.AzureEndpoint != "" {
+		return nil, contract.UsageRecord{}, contract.ErrEmbedUnsupported
+	}
+	call := startCall("embed", string(openAIEmbeddingModel))
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	start := time.Now()
-	var resp *openai.ChatCompletion
+	var resp *openai.CreateEmbeddingResponse
+	var lastErr error
 	err := o.retrier.Do(ctx, func() error {
-		format := shared.NewResponseFormatJSONObjectParam()
-		result, err := o.client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-			Model:       shared.ChatModel(o.effectiveModel()),
-			Temperature: openai.Float(o.config.Temperature),
-			MaxTokens:   openai.Int(int64(o.config.MaxTokens)),
-			ResponseFormat: openai.ChatCompletionNewParamsResponseFormatUnion{
-				OfJSONObject: &format,
-			},
-			Messages: []openai.ChatCompletionMessageParamUnion{
-				userMessage(prompt),
-			},
+		call.attempt()
+		result, err := o.client.Embeddings.New(ctx, openai.EmbeddingNewParams{
+			Model: openAIEmbeddingModel,
+			Input: openai.EmbeddingNewParamsInputUnion{OfArrayOfStrings: texts},
 		})
+		lastErr = err
 		if err != nil {
-			if isRateLimitError(err) {
-				return err
-			}
 			return err
 		}
 		resp = result
 		return nil
 	})
-	if err != nil {
-		return nil, err
-	}
-	o.captureUsage("summarize", start, resp.Usage)
-	if len(resp.Choices) == 0 {
-		return nil, errors.New("empty response")
+	var embeddings *contract.Embeddings
+	if resp != nil {
+		call.tokens(int(resp.Usage.PromptTokens), 0)
+		if resp.Model != "" {
+			call.record.Model = resp.Model
+		}
+		vectors := make([][]float32, len(texts))
+		for _, item := range resp.Data {
+			if item.Index >= 0 && int(item.Index) < len(vectors) {
+				vectors[item.Index] = toFloat32(item.Embedding)
+			}
+		}
+		embeddings = &contract.Embeddings{Model: call.record.Model, Vectors: vectors}
+		if err == nil {
+			err = checkEmbeddings(embeddings, len(texts))
+		}
 	}
-	content := resp.Choices[0].Message.Content
-	var parsed contract.SummaryResult
-	if err := json.Unmarshal([]byte(content), &parsed); err != nil {
-		return nil, err
+	usage := call.finish(err, openAIStatus(lastErr))
+	o.usage.add(usage, o.config)
+	if err != nil {
+		return nil, usage, err
 	}
-	return &parsed, nil
+	return embeddings, usage, nil
 }
 
-func (o *OpenAIProvider) ExtractActions(ctx context.Context, text string) ([]string, error) {
-	prompt := "Extract action items as JSON object with actions array of strings\n\nText: " + text
+// complete sends a single-turn prompt in JSON mode and returns the first
+// choice along with the usage of this call.
+func (o *OpenAIProvider) complete(ctx context.Context, feature, prompt string) (string, contract.UsageRecord, error) {
+	call := startCall(feature, o.effectiveModel())
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	start := time.Now()
 	var resp *openai.ChatCompletion
+	var lastErr error
 	err := o.retrier.Do(ctx, func() error {
+		call.attempt()
 		format := shared.NewResponseFormatJSONObjectParam()
 		result, err := o.client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
 			Model:       shared.ChatModel(o.effectiveModel()),
@@ -148,30 +170,36 @@ func (o *OpenAIProvider) ExtractActions(ctx context.Context, text string) ([]str
 				userMessage(prompt),
 			},
 		})
+		lastErr = err
 		if err != nil {
-			if isRateLimitError(err) {
-				return err
-			}
 			return err
 		}
 		resp = result
 		return nil
 	})
-	if err != nil {
-		return nil, err
+	if resp != nil {
+		call.tokens(int(resp.Usage.PromptTokens), int(resp.Usage.CompletionTokens))
+		if resp.Model != "" {
+			call.record.Model = resp.Model
+		}
 	}
-	o.captureUsage("extract_actions", start, resp.Usage)
-	if len(resp.Choices) == 0 {
-		return nil, errors.New("empty response")
+	if err == nil && len(resp.Choices) == 0 {
+		err = errors.New("empty response")
 	}
-	content := resp.Choices[0].Message.Content
-	var payload struct {
-		Actions []string `json:"actions"`
+	usage := call.finish(err, openAIStatus(lastErr))
+	o.usage.add(usage, o.config)
+	if err != nil {
+		return "", usage, err
 	}
-	if err := json.Unmarshal([]byte(content), &payload); err != nil {
-		return nil, err
+	return resp.Choices[0].Message.Content, usage, nil
+}
+
+func openAIStatus(err error) int {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
 	}
-	return payload.Actions, nil
+	return 0
 }
 
 func (o *OpenAIProvider) HealthCheck(ctx context.Context) (*contract.HealthCheckResult, error) {
@@ -203,43 +231,6 @@ func (o *OpenAIProvider) HealthCheck(ctx context.Context) (*contract.HealthCheck
 	}, err
 }
 
-func (o *OpenAIProvider) captureUsage(feature string, start time.Time, usage openai.CompletionUsage) {
-	latency := time.Since(start)
-	record := contract.UsageRecord{
-		InputTokens:  int(usage.PromptTokens),
-		OutputTokens: int(usage.CompletionTokens),
-		TotalTokens:  int(usage.TotalTokens),
-		Latency:      latency,
-		Success:      true,
-		Feature:      feature,
-	}
-	o.lastRecord = record
-	o.lastUsage.TotalRequests++
-	o.lastUsage.SuccessfulRequests++
-	o.lastUsage.TotalCost += record.TotalCost(o.config.CostPer1KInput, o.config.CostPer1KOutput)
-	o.lastUsage.AverageLatency = averageLatency(o.lastUsage.AverageLatency, latency, o.lastUsage.SuccessfulRequests)
-}
-
-func (o *OpenAIProvider) lastUsageRecord() contract.UsageRecord {
-	return o.lastRecord
-}
-
-func isRateLimitError(err error) bool {
-	if err == nil {
-		return false
-	}
-	type statusCoder interface {
-		StatusCode() int
-	}
-	if sc, ok := err.(statusCoder); ok {
-		return sc.StatusCode() == 429
-	}
-	if strings.Contains(err.Error(), "429") || strings.Contains(err.Error(), "rate limit") {
-		return true
-	}
-	return false
-}
-
 func userMessage(content string) openai.ChatCompletionMessageParamUnion {
 	return openai.ChatCompletionMessageParamUnion{
 		OfUser: &openai.ChatCompletionUserMessageParam{
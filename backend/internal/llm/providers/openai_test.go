@@ -0,0 +1,85 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+
+	"message-flow/backend/internal/llm/contract"
+)
+
+func TestOpenAIUsageIsPerCall(t *testing.T) {
+	marker := regexp.MustCompile(`n=(\d+)`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		n, _ := strconv.Atoi(marker.FindStringSubmatch(body.Messages[0].Content)[1])
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"x","object":"chat.completion","model":"gpt-test","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"{\"is_important\":true,\"priority\":\"high\"}"}}],"usage":{"prompt_tokens":%d,"completion_tokens":%d,"total_tokens":%d}}`, n, n*2, n*3)
+	}))
+	defer server.Close()
+
+	provider := NewOpenAIProvider(&contract.ProviderConfig{ProviderName: "openai", APIKey: "test", ModelName: "gpt-test", BaseURL: server.URL, MaxTokens: 100})
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 1; i <= 20; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			result, usage, err := provider.Analyze(context.Background(), fmt.Sprintf("n=%d", n), contract.CallOptions{})
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !result.IsImportant || usage.InputTokens != n || usage.OutputTokens != n*2 || usage.Attempts != 1 || usage.HTTPStatus != 200 || usage.Model != "gpt-test" {
+				errs <- fmt.Errorf("call %d got usage %+v", n, usage)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if stats, _ := provider.GetUsage(context.Background()); stats.SuccessfulRequests != 20 {
+		t.Fatalf("expected 20 successful requests, got %d", stats.SuccessfulRequests)
+	}
+}
+
+func TestOpenAIEmbedOrdersByIndex(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/embeddings" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"object":"list","model":"text-embedding-3-small","data":[{"object":"embedding","index":1,"embedding":[0,1]},{"object":"embedding","index":0,"embedding":[1,0]}],"usage":{"prompt_tokens":6,"total_tokens":6}}`)
+	}))
+	defer server.Close()
+
+	provider := NewOpenAIProvider(&contract.ProviderConfig{ProviderName: "openai", APIKey: "test", ModelName: "gpt-test", BaseURL: server.URL})
+	embeddings, usage, err := provider.Embed(context.Background(), []string{"first", "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if embeddings.Model != "text-embedding-3-small" || embeddings.Vectors[0][0] != 1 || embeddings.Vectors[1][1] != 1 {
+		t.Fatalf("unexpected embeddings %+v", embeddings)
+	}
+	if usage.InputTokens != 6 || usage.Feature != "embed" {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+
+	azure := NewOpenAIProvider(&contract.ProviderConfig{ProviderName: "azure_openai", APIKey: "test", ModelName: "gpt-4o", AzureEndpoint: server.URL})
+	if _, _, err := azure.Embed(context.Background(), []string{"x"}); err != contract.ErrEmbedUnsupported {
+		t.Fatalf("expected ErrEmbedUnsupported, got %v", err)
+	}
+}
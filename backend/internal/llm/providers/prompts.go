@@ -0,0 +1,54 @@
+package providers
+
+import (
+	"encoding/json"
+	"strings"
+
+	"message-flow/backend/internal/llm/contract"
+)
+
+// Built-in prompts shared by the text-completion providers. A tenant's prompt
+// template, when set, replaces them through contract.CallOptions.
+
+func analyzePrompt(message string) string {
+	return "Analyze this WhatsApp message JSON-only response with: is_important(bool),\npriority(high|medium|low), reason, has_action(bool), action_required,\nsentiment(positive|neutral|negative), sentiment_score(-1 to 1),\ntopics[], confidence(0-1)\n\nMessage: " + message
+}
+
+func summarizePrompt(messages []string) string {
+	return "Summarize conversation with: summary, key_points[], action_items[], sentiment, topics[]\n\nMessages: " + joinLines(messages)
+}
+
+func extractActionsPrompt(text string) string {
+	return "Extract action items as JSON array of strings\n\nText: " + text
+}
+
+// promptFor returns the tenant's rendered prompt from options, or builtin.
+func promptFor(options contract.CallOptions, builtin string) string {
+	if options.Prompt != "" {
+		return options.Prompt
+	}
+	return builtin
+}
+
+// analysisPromptFor is promptFor for Analyze calls, preceded by the labelled
+// examples from options.
+func analysisPromptFor(options contract.CallOptions, builtin string) string {
+	prompt := promptFor(options, builtin)
+	examples := options.Examples
+	if len(examples) == 0 {
+		return prompt
+	}
+	var b strings.Builder
+	b.WriteString("Examples labelled by the team's agents. Follow their judgement for similar messages.\n\n")
+	for _, example := range examples {
+		labels, _ := json.Marshal(map[string]any{
+			"is_important": example.IsImportant,
+			"priority":     example.Priority,
+			"sentiment":    example.Sentiment,
+			"topics":       example.Topics,
+		})
+		b.WriteString("Message: " + example.Message + "\nLabels: " + string(labels) + "\n\n")
+	}
+	b.WriteString(prompt)
+	return b.String()
+}
@@ -0,0 +1,27 @@
+package providers
+
+import (
+	"strings"
+	"testing"
+
+	"message-flow/backend/internal/llm/contract"
+)
+
+func TestAnalysisPromptIncludesExamples(t *testing.T) {
+	if got := analysisPromptFor(contract.CallOptions{}, analyzePrompt("hi")); got != analyzePrompt("hi") {
+		t.Fatalf("prompt changed without examples: %q", got)
+	}
+
+	prompt := analysisPromptFor(contract.CallOptions{
+		Prompt:   "Tenant prompt: hi",
+		Examples: []contract.AnalysisExample{{Message: "where is my refund", IsImportant: true, Priority: "high", Sentiment: "negative", Topics: []string{"refund"}}},
+	}, analyzePrompt("hi"))
+	for _, want := range []string{"Message: where is my refund", `"priority":"high"`, `"is_important":true`} {
+		if !strings.Contains(prompt, want) {
+			t.Fatalf("prompt misses %q:\n%s", want, prompt)
+		}
+	}
+	if !strings.HasSuffix(prompt, "Tenant prompt: hi") {
+		t.Fatalf("examples should precede the tenant prompt:\n%s", prompt)
+	}
+}
@@ -0,0 +1,318 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"message-flow/backend/internal/llm/contract"
+)
+
+// jsonSchema is the subset of JSON Schema used to check model output. Values
+// that are close enough are coerced rather than rejected: enums are matched
+// case-insensitively and through aliases, numbers are clamped to their range,
+// and scalars are converted between strings, numbers and booleans.
+type jsonSchema struct {
+	Type       string                 `json:"type"`
+	Enum       []string               `json:"enum,omitempty"`
+	Minimum    *float64               `json:"minimum,omitempty"`
+	Maximum    *float64               `json:"maximum,omitempty"`
+	Properties map[string]*jsonSchema `json:"properties,omitempty"`
+	Required   []string               `json:"required,omitempty"`
+	Items      *jsonSchema            `json:"items,omitempty"`
+
+	// aliases maps other spellings models use onto enum values.
+	aliases map[string]string
+	// unwrapKeys are object keys an array may arrive under, for APIs whose
+	// JSON mode only returns objects.
+	unwrapKeys []string
+}
+
+func bound(value float64) *float64 { return &value }
+
+var stringSchema = &jsonSchema{Type: "string"}
+
+var stringListSchema = &jsonSchema{Type: "array", Items: stringSchema}
+
+var analysisSchema = &jsonSchema{
+	Type: "object",
+	Properties: map[string]*jsonSchema{
+		"is_important": {Type: "boolean"},
+		"priority": {Type: "string", Enum: []string{"high", "medium", "low"}, aliases: map[string]string{
+			"urgent": "high", "critical": "high", "normal": "medium", "moderate": "medium", "none": "low", "minor": "low",
+		}},
+		"reason":          stringSchema,
+		"has_action":      {Type: "boolean"},
+		"action_required": stringSchema,
+		"sentiment": {Type: "string", Enum: []string{"positive", "neutral", "negative"}, aliases: map[string]string{
+			"mixed": "neutral",
+		}},
+		"sentiment_score": {Type: "number", Minimum: bound(-1), Maximum: bound(1)},
+		"topics":          stringListSchema,
+		"confidence":      {Type: "number", Minimum: bound(0), Maximum: bound(1)},
+	},
+	Required: []string{"priority"},
+}
+
+var summarySchema = &jsonSchema{
+	Type: "object",
+	Properties: map[string]*jsonSchema{
+		"summary":      stringSchema,
+		"key_points":   stringListSchema,
+		"action_items": stringListSchema,
+		"sentiment":    analysisSchema.Properties["sentiment"],
+		"topics":       stringListSchema,
+	},
+	Required: []string{"summary"},
+}
+
+var actionsSchema = &jsonSchema{Type: "array", Items: stringSchema, unwrapKeys: []string{"actions", "action_items"}}
+
+// maxRepairEcho bounds how much of an invalid answer is sent back for repair.
+const maxRepairEcho = 4000
+
+// completeFunc sends a prompt to the provider that produced the output being
+// checked.
+type completeFunc func(ctx context.Context, feature, prompt string) (string, contract.UsageRecord, error)
+
+// decodeOutput validates a model answer against schema and decodes it into
+// out. An answer that cannot be coerced is sent back once, with the problems
+// found, for the model to correct; if the correction fails too the call
+// fails with a *contract.ValidationError so the next provider can be tried.
+// The returned usage covers both calls.
+func decodeOutput(ctx context.Context, complete completeFunc, feature string, schema *jsonSchema, text string, usage contract.UsageRecord, out any) (contract.UsageRecord, error) {
+	value, problems := validateOutput(schema, text)
+	if problems == nil {
+		return usage, assignOutput(value, out)
+	}
+	usage.ValidationErrors++
+	repaired, repairUsage, err := complete(ctx, feature+"_repair", repairPrompt(schema, text, problems))
+	usage = mergeUsage(usage, repairUsage)
+	if err != nil {
+		return usage, err
+	}
+	if value, problems = validateOutput(schema, repaired); problems != nil {
+		usage.ValidationErrors++
+		return usage, &contract.ValidationError{Problems: problems}
+	}
+	usage.Repaired = true
+	return usage, assignOutput(value, out)
+}
+
+func assignOutput(value any, out any) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, out)
+}
+
+// mergeUsage adds a follow-up call to the usage of the first one; the
+// outcome is the follow-up's.
+func mergeUsage(first, second contract.UsageRecord) contract.UsageRecord {
+	first.InputTokens += second.InputTokens
+	first.OutputTokens += second.OutputTokens
+	first.TotalTokens += second.TotalTokens
+	first.Latency += second.Latency
+	first.Attempts += second.Attempts
+	first.HTTPStatus = second.HTTPStatus
+	first.Success = second.Success
+	first.ErrorMessage = second.ErrorMessage
+	if first.Model == "" {
+		first.Model = second.Model
+	}
+	return first
+}
+
+func repairPrompt(schema *jsonSchema, text string, problems []string) string {
+	encoded, _ := json.Marshal(schema)
+	if len(text) > maxRepairEcho {
+		text = strings.ToValidUTF8(text[:maxRepairEcho], "")
+	}
+	return "Your previous answer did not match the required JSON format.\n\nProblems:\n- " + strings.Join(problems, "\n- ") +
+		"\n\nRequired JSON Schema:\n" + string(encoded) +
+		"\n\nPrevious answer:\n" + text +
+		"\n\nReply with only the corrected JSON."
+}
+
+// validateOutput parses text and coerces it to schema. It returns the
+// problems that could not be coerced, or nil when the value is usable.
+func validateOutput(schema *jsonSchema, text string) (any, []string) {
+	value, err := decodeJSONValue(text)
+	if err != nil {
+		return nil, []string{"response is not valid JSON"}
+	}
+	var problems []string
+	value = schema.coerce(value, "", &problems)
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return value, nil
+}
+
+// decodeJSONValue finds the JSON value in a model answer, skipping markdown
+// fences and any prose around it.
+func decodeJSONValue(text string) (any, error) {
+	trimmed := strings.TrimSpace(text)
+	if strings.HasPrefix(trimmed, "```") {
+		trimmed = strings.TrimPrefix(trimmed, "```")
+		if newline := strings.IndexByte(trimmed, '\n'); newline >= 0 {
+			// Drop the info string, e.g. ```json.
+			trimmed = trimmed[newline+1:]
+		}
+		trimmed = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(trimmed), "```"))
+	}
+	var value any
+	if err := json.Unmarshal([]byte(extractJSON(trimmed)), &value); err == nil {
+		return value, nil
+	}
+	for i := 0; i < len(trimmed); i++ {
+		if trimmed[i] != '{' && trimmed[i] != '[' {
+			continue
+		}
+		if err := json.NewDecoder(strings.NewReader(trimmed[i:])).Decode(&value); err == nil {
+			return value, nil
+		}
+	}
+	return nil, errors.New("no JSON value found")
+}
+
+func (s *jsonSchema) coerce(value any, path string, problems *[]string) any {
+	fail := func(format string, args ...any) any {
+		name := path
+		if name == "" {
+			name = "response"
+		}
+		*problems = append(*problems, name+": "+fmt.Sprintf(format, args...))
+		return nil
+	}
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fail("expected an object")
+		}
+		result := make(map[string]any, len(s.Properties))
+		for _, name := range s.Required {
+			if field, ok := object[name]; !ok || field == nil {
+				fail("%s is required", name)
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			field, ok := object[name]
+			if !ok || field == nil {
+				continue
+			}
+			if coerced := s.Properties[name].coerce(field, joinPath(path, name), problems); coerced != nil {
+				result[name] = coerced
+			}
+		}
+		return result
+	case "array":
+		if object, ok := value.(map[string]any); ok {
+			for _, key := range s.unwrapKeys {
+				if inner, ok := object[key]; ok {
+					value = inner
+					break
+				}
+			}
+		}
+		switch v := value.(type) {
+		case nil:
+			return []any{}
+		case []any:
+			result := make([]any, 0, len(v))
+			for i, item := range v {
+				if coerced := s.Items.coerce(item, fmt.Sprintf("%s[%d]", path, i), problems); coerced != nil {
+					result = append(result, coerced)
+				}
+			}
+			return result
+		case string:
+			// A single item where a list was expected.
+			if v == "" {
+				return []any{}
+			}
+			return []any{s.Items.coerce(v, path+"[0]", problems)}
+		}
+		return fail("expected an array")
+	case "string":
+		var text string
+		switch v := value.(type) {
+		case string:
+			text = v
+		case float64, bool:
+			text = fmt.Sprint(v)
+		default:
+			return fail("expected a string")
+		}
+		if len(s.Enum) == 0 {
+			return text
+		}
+		normalized := strings.ToLower(strings.TrimSpace(text))
+		if alias, ok := s.aliases[normalized]; ok {
+			normalized = alias
+		}
+		for _, option := range s.Enum {
+			if option == normalized {
+				return option
+			}
+		}
+		return fail("%q is not one of %s", text, strings.Join(s.Enum, ", "))
+	case "number":
+		var number float64
+		switch v := value.(type) {
+		case float64:
+			number = v
+		case string:
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return fail("%q is not a number", v)
+			}
+			number = parsed
+		default:
+			return fail("expected a number")
+		}
+		if s.Minimum != nil && number < *s.Minimum {
+			number = *s.Minimum
+		}
+		if s.Maximum != nil && number > *s.Maximum {
+			number = *s.Maximum
+		}
+		return number
+	case "boolean":
+		switch v := value.(type) {
+		case bool:
+			return v
+		case float64:
+			if v == 0 || v == 1 {
+				return v == 1
+			}
+		case string:
+			switch strings.ToLower(strings.TrimSpace(v)) {
+			case "true", "yes", "y", "1":
+				return true
+			case "false", "no", "n", "0":
+				return false
+			}
+		}
+		return fail("expected a boolean")
+	}
+	return value
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
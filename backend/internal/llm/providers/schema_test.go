@@ -0,0 +1,90 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"message-flow/backend/internal/llm/contract"
+)
+
+func TestValidateOutputCoerces(t *testing.T) {
+	text := "Sure! Here is the analysis:\n```json\n{\"is_important\":\"yes\",\"priority\":\" URGENT \",\"sentiment\":\"Mixed\",\"sentiment_score\":-3,\"confidence\":\"0.8\",\"topics\":\"billing\",\"extra\":1}\n```"
+	value, problems := validateOutput(analysisSchema, text)
+	if problems != nil {
+		t.Fatalf("unexpected problems %v", problems)
+	}
+	var result contract.AnalysisResult
+	if err := assignOutput(value, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.IsImportant || result.Priority != "high" || result.Sentiment != "neutral" || result.SentimentScore != -1 || result.Confidence != 0.8 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if len(result.Topics) != 1 || result.Topics[0] != "billing" {
+		t.Fatalf("unexpected topics %v", result.Topics)
+	}
+}
+
+func TestValidateOutputRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema *jsonSchema
+		text   string
+	}{
+		{"not json", analysisSchema, "I think it is important"},
+		{"bad enum", analysisSchema, `{"priority":"asap"}`},
+		{"missing required", summarySchema, `{"key_points":[]}`},
+		{"wrong type", analysisSchema, `{"priority":"low","has_action":"maybe"}`},
+		{"object item", actionsSchema, `[{"task":"call"}]`},
+	}
+	for _, test := range tests {
+		if _, problems := validateOutput(test.schema, test.text); problems == nil {
+			t.Fatalf("%s: expected problems", test.name)
+		}
+	}
+}
+
+func TestValidateOutputUnwrapsActions(t *testing.T) {
+	value, problems := validateOutput(actionsSchema, `{"action_items":["call back"]}`)
+	if problems != nil {
+		t.Fatalf("unexpected problems %v", problems)
+	}
+	var actions []string
+	if err := assignOutput(value, &actions); err != nil || len(actions) != 1 || actions[0] != "call back" {
+		t.Fatalf("unexpected actions %v %v", actions, err)
+	}
+}
+
+func TestDecodeOutputRepairsOnce(t *testing.T) {
+	var prompts []string
+	reply := `{"priority":"low","reason":"fixed"}`
+	complete := func(ctx context.Context, feature, prompt string) (string, contract.UsageRecord, error) {
+		prompts = append(prompts, feature+": "+prompt)
+		return reply, contract.UsageRecord{InputTokens: 50, OutputTokens: 10, TotalTokens: 60, Attempts: 1, Success: true, HTTPStatus: 200}, nil
+	}
+	first := contract.UsageRecord{InputTokens: 20, OutputTokens: 5, TotalTokens: 25, Attempts: 1, Success: true, HTTPStatus: 200}
+
+	var result contract.AnalysisResult
+	usage, err := decodeOutput(context.Background(), complete, "analyze", analysisSchema, `{"priority":"asap"}`, first, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Reason != "fixed" || len(prompts) != 1 || !strings.HasPrefix(prompts[0], "analyze_repair: ") || !strings.Contains(prompts[0], `"asap"`) {
+		t.Fatalf("unexpected repair %+v %v", result, prompts)
+	}
+	if usage.ValidationErrors != 1 || !usage.Repaired || usage.TotalTokens != 85 || usage.Attempts != 2 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+
+	reply = "still not json"
+	usage, err = decodeOutput(context.Background(), complete, "analyze", analysisSchema, `{"priority":"asap"}`, first, &result)
+	var validationErr *contract.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+	if len(prompts) != 2 || usage.ValidationErrors != 2 || usage.Repaired {
+		t.Fatalf("expected a single repair attempt, got %d prompts and %+v", len(prompts), usage)
+	}
+}
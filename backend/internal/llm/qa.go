@@ -0,0 +1,398 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// maxQAMessages caps the messages sent with a question.
+	maxQAMessages = 30
+	// qaRecentMessages are always included, as questions often refer to
+	// what was said last.
+	qaRecentMessages = 8
+	// maxQAKeywordScan bounds the keyword matches ranked for a question.
+	maxQAKeywordScan = 500
+)
+
+// ErrEmptyConversation is returned for questions about a conversation
+// without messages.
+var ErrEmptyConversation = errors.New("conversation has no messages")
+
+// ConversationAnswer is an answer to a question about a conversation.
+// Citations only hold IDs of messages that were sent to the model, and
+// Sources are those messages. Retrieval says how the messages were found:
+// "semantic", "keyword" or both.
+type ConversationAnswer struct {
+	Answer     string           `json:"answer"`
+	Found      bool             `json:"found"`
+	Citations  []int64          `json:"citations"`
+	Confidence float64          `json:"confidence"`
+	Sources    []ContextMessage `json:"sources"`
+	Retrieval  []string         `json:"retrieval"`
+}
+
+// AskConversation answers a question from the messages of one conversation
+// that match it by meaning or by keyword, its latest messages, its open
+// action items and its latest summaries. An answer that claims to be found
+// without citing any of the given messages is rejected and the next provider
+// is tried.
+func (s *Service) AskConversation(ctx context.Context, tenantID, conversationID, providerID int64, question string) (*ConversationAnswer, error) {
+	request := QuestionRequest{Question: question}
+	answer := &ConversationAnswer{Citations: []int64{}, Sources: []ContextMessage{}, Retrieval: []string{}}
+
+	var ranked [][]ContextMessage
+	if embeddings, _, err := s.Embed(ctx, tenantID, []string{question}, nil, FeatureConversationQA); err == nil {
+		matches, err := s.Store.SearchEmbeddings(ctx, tenantID, conversationID, embeddings.Model, embeddings.Vectors[0], maxQAMessages/2)
+		var contactNumber, contactName string
+		if err == nil && len(matches) > 0 {
+			contactNumber, contactName, err = s.Store.conversationContact(ctx, tenantID, conversationID)
+		}
+		if err == nil && len(matches) > 0 {
+			semantic := make([]ContextMessage, 0, len(matches))
+			for _, match := range matches {
+				sender := SenderName(match.Sender, contactNumber, contactName)
+				semantic = append(semantic, ContextMessage{MessageID: match.MessageID, Sender: sender, Timestamp: match.Timestamp, Content: match.Content})
+			}
+			ranked = append(ranked, semantic)
+			answer.Retrieval = append(answer.Retrieval, "semantic")
+		}
+	}
+	if terms := questionTerms(question); len(terms) > 0 {
+		keyword, err := s.Store.KeywordMessages(ctx, tenantID, conversationID, terms, maxQAMessages/2)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyword) > 0 {
+			ranked = append(ranked, keyword)
+			answer.Retrieval = append(answer.Retrieval, "keyword")
+		}
+	}
+	recent, err := s.Store.RecentMessages(ctx, tenantID, conversationID, qaRecentMessages)
+	if err != nil {
+		return nil, err
+	}
+	if len(recent) == 0 {
+		return nil, ErrEmptyConversation
+	}
+	ranked = append(ranked, recent)
+	request.Messages = mergeContext(ranked, maxQAMessages)
+
+	if request.Notes, err = s.Store.ConversationNotes(ctx, tenantID, conversationID); err != nil {
+		return nil, err
+	}
+	if request.ContactName, err = s.Store.ConversationContactName(ctx, tenantID, conversationID); err != nil {
+		return nil, err
+	}
+
+	known := make(map[int64]ContextMessage, len(request.Messages))
+	for _, message := range request.Messages {
+		known[message.MessageID] = message
+	}
+	redaction, err := s.redaction(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	request = redactQuestion(redaction, request)
+	var result *AnswerResult
+	err = s.runFeature(ctx, tenantID, FeatureConversationQA, providerID, nil, FeatureConversationQA, nil, func(provider Provider) (UsageRecord, error) {
+		answerer, ok := provider.(QuestionAnswerer)
+		if !ok {
+			return UsageRecord{}, ErrAnswerUnsupported
+		}
+		var usage UsageRecord
+		var err error
+		result, usage, err = answerer.Answer(ctx, request)
+		if err != nil {
+			return usage, err
+		}
+		result.Citations = knownCitations(result.Citations, known)
+		if len(result.Citations) > 0 {
+			result.Found = true
+		}
+		if result.Found && len(result.Citations) == 0 {
+			usage.ValidationErrors++
+			return usage, &ValidationError{Problems: []string{"answer cites no message of the conversation"}}
+		}
+		return usage, nil
+	})
+	s.logRedaction(ctx, tenantID, nil, FeatureConversationQA, redaction)
+	if err != nil {
+		return nil, err
+	}
+
+	answer.Answer = redaction.Restore(result.Answer)
+	answer.Found = result.Found
+	answer.Confidence = result.Confidence
+	answer.Citations = result.Citations
+	for _, id := range result.Citations {
+		answer.Sources = append(answer.Sources, known[id])
+	}
+	return answer, nil
+}
+
+// redactQuestion returns a copy of request with its text redacted. Message
+// IDs are kept, so citations still refer to the stored messages.
+func redactQuestion(redaction *Redaction, request QuestionRequest) QuestionRequest {
+	if redaction == nil {
+		return request
+	}
+	request.Question = redaction.Redact(request.Question)
+	request.ContactName = redaction.Redact(request.ContactName)
+	request.Notes = redaction.RedactAll(request.Notes)
+	messages := make([]ContextMessage, len(request.Messages))
+	for i, message := range request.Messages {
+		message.Sender = redaction.Redact(message.Sender)
+		message.Content = redaction.Redact(message.Content)
+		messages[i] = message
+	}
+	request.Messages = messages
+	return request
+}
+
+// knownCitations drops duplicates and IDs of messages the model was not
+// given.
+func knownCitations(citations []int64, known map[int64]ContextMessage) []int64 {
+	valid := []int64{}
+	seen := map[int64]bool{}
+	for _, id := range citations {
+		if _, ok := known[id]; ok && !seen[id] {
+			seen[id] = true
+			valid = append(valid, id)
+		}
+	}
+	return valid
+}
+
+// mergeContext takes messages from the ranked lists in turn, best first,
+// until limit is reached, and returns them in chronological order.
+func mergeContext(ranked [][]ContextMessage, limit int) []ContextMessage {
+	seen := map[int64]bool{}
+	merged := []ContextMessage{}
+	for i := 0; len(merged) < limit; i++ {
+		added := false
+		for _, list := range ranked {
+			if i >= len(list) {
+				continue
+			}
+			added = true
+			if message := list[i]; !seen[message.MessageID] && len(merged) < limit {
+				seen[message.MessageID] = true
+				merged = append(merged, message)
+			}
+		}
+		if !added {
+			break
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Timestamp.Before(merged[j].Timestamp) })
+	return merged
+}
+
+var questionWord = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// questionStopWords are left out of keyword retrieval.
+var questionStopWords = map[string]bool{
+	"what": true, "when": true, "where": true, "which": true, "who": true, "whom": true, "whose": true, "why": true, "how": true,
+	"did": true, "does": true, "the": true, "and": true, "for": true, "from": true, "this": true, "that": true, "with": true,
+	"about": true, "last": true, "they": true, "them": true, "their": true, "have": true, "has": true, "was": true, "were": true,
+	"customer": true, "conversation": true, "message": true, "messages": true, "any": true, "you": true, "are": true,
+}
+
+// questionTerms returns the distinct words of a question worth searching
+// for.
+func questionTerms(question string) []string {
+	var terms []string
+	seen := map[string]bool{}
+	for _, word := range questionWord.FindAllString(strings.ToLower(question), -1) {
+		if len([]rune(word)) < 3 || questionStopWords[word] || seen[word] {
+			continue
+		}
+		seen[word] = true
+		terms = append(terms, word)
+	}
+	return terms
+}
+
+// KeywordMessages returns up to limit messages of a conversation containing
+// the most of terms, newest first among equals.
+func (s *Store) KeywordMessages(ctx context.Context, tenantID, conversationID int64, terms []string, limit int) ([]ContextMessage, error) {
+	patterns := make([]string, len(terms))
+	for i, term := range terms {
+		patterns[i] = "%" + escapeLike(term) + "%"
+	}
+	type scored struct {
+		message ContextMessage
+		hits    int
+	}
+	var candidates []scored
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			SELECT `+contextMessageColumns+` FROM messages m
+			JOIN conversations c ON c.id = m.conversation_id AND c.tenant_id = m.tenant_id
+			WHERE m.tenant_id=$1 AND m.conversation_id=$2 AND m.content ILIKE ANY($3)
+			ORDER BY m.timestamp DESC
+			LIMIT $4`, tenantID, conversationID, patterns, maxQAKeywordScan)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var candidate scored
+			message := &candidate.message
+			if err := scanContextMessage(rows, message); err != nil {
+				return err
+			}
+			lower := strings.ToLower(message.Content)
+			for _, term := range terms {
+				if strings.Contains(lower, term) {
+					candidate.hits++
+				}
+			}
+			candidates = append(candidates, candidate)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].hits > candidates[j].hits })
+	messages := make([]ContextMessage, 0, limit)
+	for _, candidate := range candidates {
+		if len(messages) == limit {
+			break
+		}
+		messages = append(messages, candidate.message)
+	}
+	return messages, nil
+}
+
+// contextMessageColumns are what scanContextMessage reads, from messages m
+// joined with their conversation c.
+const contextMessageColumns = `m.id, m.sender, m.content, m.timestamp, c.contact_number, COALESCE(c.contact_name, '')`
+
+// scanContextMessage reads a message for a prompt, with its sender shown by
+// name as in transcripts.
+func scanContextMessage(row pgx.Row, message *ContextMessage) error {
+	var contactNumber, contactName string
+	if err := row.Scan(&message.MessageID, &message.Sender, &message.Content, &message.Timestamp, &contactNumber, &contactName); err != nil {
+		return err
+	}
+	message.Sender = SenderName(message.Sender, contactNumber, contactName)
+	return nil
+}
+
+// RecentMessages returns the last limit messages of a conversation.
+func (s *Store) RecentMessages(ctx context.Context, tenantID, conversationID int64, limit int) ([]ContextMessage, error) {
+	messages := []ContextMessage{}
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			SELECT `+contextMessageColumns+` FROM messages m
+			JOIN conversations c ON c.id = m.conversation_id AND c.tenant_id = m.tenant_id
+			WHERE m.tenant_id=$1 AND m.conversation_id=$2
+			ORDER BY m.timestamp DESC
+			LIMIT $3`, tenantID, conversationID, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var message ContextMessage
+			if err := scanContextMessage(rows, &message); err != nil {
+				return err
+			}
+			messages = append(messages, message)
+		}
+		return rows.Err()
+	})
+	return messages, err
+}
+
+// ConversationNotes returns the action items and the latest summaries of a
+// conversation as short lines for a prompt.
+func (s *Store) ConversationNotes(ctx context.Context, tenantID, conversationID int64) ([]string, error) {
+	notes := []string{}
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			SELECT description, status, due_date FROM action_items
+			WHERE tenant_id=$1 AND conversation_id=$2
+			ORDER BY created_at DESC
+			LIMIT 20`, tenantID, conversationID)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var description, status string
+			var due *time.Time
+			if err := rows.Scan(&description, &status, &due); err != nil {
+				rows.Close()
+				return err
+			}
+			note := fmt.Sprintf("Action item (%s): %s", status, description)
+			if due != nil {
+				note += ", due " + due.Format("2006-01-02")
+			}
+			notes = append(notes, note)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		rows, err = conn.Query(ctx, `
+			SELECT summary_text, created_at FROM daily_summaries
+			WHERE tenant_id=$1 AND conversation_id=$2
+			ORDER BY created_at DESC
+			LIMIT 3`, tenantID, conversationID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var summary string
+			var createdAt time.Time
+			if err := rows.Scan(&summary, &createdAt); err != nil {
+				return err
+			}
+			notes = append(notes, fmt.Sprintf("Summary from %s: %s", createdAt.UTC().Format("2006-01-02"), summary))
+		}
+		return rows.Err()
+	})
+	return notes, err
+}
+
+// ConversationContactName returns the contact's name, falling back to their
+// number.
+func (s *Store) ConversationContactName(ctx context.Context, tenantID, conversationID int64) (string, error) {
+	var name string
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		return conn.QueryRow(ctx, `
+			SELECT COALESCE(NULLIF(contact_name, ''), contact_number) FROM conversations
+			WHERE tenant_id=$1 AND id=$2`, tenantID, conversationID).Scan(&name)
+	})
+	return name, err
+}
+
+// conversationContact returns the contact number and stored name of a
+// conversation; the name may be empty.
+func (s *Store) conversationContact(ctx context.Context, tenantID, conversationID int64) (string, string, error) {
+	var number, name string
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		return conn.QueryRow(ctx, `
+			SELECT contact_number, COALESCE(contact_name, '') FROM conversations
+			WHERE tenant_id=$1 AND id=$2`, tenantID, conversationID).Scan(&number, &name)
+	})
+	return number, name, err
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
@@ -0,0 +1,41 @@
+package llm
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQuestionTerms(t *testing.T) {
+	got := questionTerms("What did the customer order last month? Order size?")
+	want := []string{"order", "month", "size"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestMergeContext(t *testing.T) {
+	at := func(id int64) ContextMessage {
+		return ContextMessage{MessageID: id, Timestamp: time.Unix(id, 0)}
+	}
+	merged := mergeContext([][]ContextMessage{
+		{at(5), at(1), at(3)},
+		{at(1), at(4)},
+		{at(9), at(8)},
+	}, 5)
+	ids := []int64{}
+	for _, message := range merged {
+		ids = append(ids, message.MessageID)
+	}
+	// Rank 0 of every list first (5, 1, 9), then rank 1 (4, 8) until full.
+	if want := []int64{1, 4, 5, 8, 9}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("expected %v, got %v", want, ids)
+	}
+}
+
+func TestKnownCitations(t *testing.T) {
+	known := map[int64]ContextMessage{1: {}, 2: {}}
+	if got := knownCitations([]int64{2, 7, 2, 1}, known); !reflect.DeepEqual(got, []int64{2, 1}) {
+		t.Fatalf("unexpected citations %v", got)
+	}
+}
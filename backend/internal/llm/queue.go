@@ -2,18 +2,35 @@ package llm
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
-	redis "github.com/redis/go-redis/v9"
-
 	"message-flow/backend/internal/db"
 	"message-flow/backend/internal/realtime"
 )
 
-type Queue struct {
-	client *redis.Client
+// Queue is the at-least-once analysis queue shared by the Redis Streams and
+// Postgres backends. Every delivery returned by Dequeue must be finished with
+// Ack, Retry or DeadLetter; deliveries that are not finished within the
+// visibility timeout are handed out again as a failed attempt.
+type Queue interface {
+	Enqueue(ctx context.Context, message QueueMessage) error
+	// Dequeue returns up to batchSize deliveries that are ready for the
+	// tenant without waiting for new ones.
+	Dequeue(ctx context.Context, tenantID int64, batchSize int) ([]QueueDelivery, error)
+	Ack(ctx context.Context, delivery QueueDelivery) error
+	// Retry schedules the delivery again after a backoff, or dead-letters it
+	// once MaxAttempts is reached. It reports whether it was dead-lettered.
+	Retry(ctx context.Context, delivery QueueDelivery, cause error) (bool, error)
+	DeadLetter(ctx context.Context, delivery QueueDelivery, cause error) error
+
+	Stats(ctx context.Context, tenantID int64) (QueueStats, error)
+	DeadLetters(ctx context.Context, tenantID int64, limit int) ([]DeadLetter, error)
+	// Replay puts dead letters back on the queue with their attempts reset,
+	// and Purge deletes them. A nil ids slice means every dead letter.
+	Replay(ctx context.Context, tenantID int64, ids []string) (int, error)
+	Purge(ctx context.Context, tenantID int64, ids []string) (int, error)
 }
 
 type QueueMessage struct {
@@ -22,97 +39,234 @@ type QueueMessage struct {
 	Content   string    `json:"content"`
 	Feature   string    `json:"feature"`
 	CreatedAt time.Time `json:"created_at"`
+	Attempts  int       `json:"attempts,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
 }
 
-func NewQueue(redisURL string) (*Queue, error) {
-	opt, err := redis.ParseURL(redisURL)
-	if err != nil {
-		return nil, err
-	}
-	client := redis.NewClient(opt)
-	return &Queue{client: client}, nil
+// QueueDelivery is a message handed to a worker.
+type QueueDelivery struct {
+	ID      string
+	Message QueueMessage
 }
 
-func (q *Queue) Enqueue(ctx context.Context, message QueueMessage) error {
-	payload, err := json.Marshal(message)
-	if err != nil {
-		return err
-	}
-	return q.client.LPush(ctx, queueKey(message.TenantID), payload).Err()
+type QueueStats struct {
+	Backend           string     `json:"backend"`
+	Ready             int64      `json:"ready"`
+	Scheduled         int64      `json:"scheduled"`
+	InFlight          int64      `json:"in_flight"`
+	DeadLetters       int64      `json:"dead_letters"`
+	ProcessedLastHour int64      `json:"processed_last_hour"`
+	OldestEnqueuedAt  *time.Time `json:"oldest_enqueued_at"`
 }
 
-func (q *Queue) DequeueBatch(ctx context.Context, tenantID int64, batchSize int) ([][]byte, error) {
-	key := queueKey(tenantID)
-	var items [][]byte
-	for i := 0; i < batchSize; i++ {
-		item, err := q.client.RPop(ctx, key).Bytes()
-		if err == redis.Nil {
-			break
-		}
-		if err != nil {
-			return items, err
-		}
-		items = append(items, item)
+// Depth counts messages waiting to be processed, including those waiting
+// out a retry backoff.
+func (s QueueStats) Depth() int64 {
+	return s.Ready + s.Scheduled
+}
+
+type DeadLetter struct {
+	ID       string       `json:"id"`
+	Message  QueueMessage `json:"message"`
+	Error    string       `json:"error"`
+	FailedAt *time.Time   `json:"failed_at"`
+	// Payload holds the raw entry when it could not be decoded.
+	Payload string `json:"payload,omitempty"`
+}
+
+var errVisibilityTimeout = errors.New("visibility timeout expired before ack")
+
+// QueuePolicy holds the retry settings both backends share.
+type QueuePolicy struct {
+	MaxAttempts       int
+	VisibilityTimeout time.Duration
+	RetryBackoff      time.Duration
+	MaxBackoff        time.Duration
+}
+
+func DefaultQueuePolicy() QueuePolicy {
+	return QueuePolicy{
+		MaxAttempts:       5,
+		VisibilityTimeout: 5 * time.Minute,
+		RetryBackoff:      5 * time.Second,
+		MaxBackoff:        5 * time.Minute,
 	}
-	return items, nil
 }
 
-func queueKey(tenantID int64) string {
-	return "llm:queue:" + fmtInt(tenantID)
+// Backoff is the delay before the given attempt is retried, doubling from
+// RetryBackoff up to MaxBackoff.
+func (p QueuePolicy) Backoff(attempt int) time.Duration {
+	delay := p.RetryBackoff
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return delay
 }
 
 func fmtInt(value int64) string {
 	return fmt.Sprintf("%d", value)
 }
 
+const (
+	// analysisTimeout bounds one provider analysis.
+	analysisTimeout = 2 * time.Minute
+	// DefaultWorkerTimeout bounds one Handle call, a batch and the analyses
+	// it falls back to together. It stays below the default visibility
+	// timeout so deliveries are not handed out again while being analyzed.
+	DefaultWorkerTimeout = 4 * time.Minute
+)
+
+// Worker analyzes queued messages and stores the results. WorkerScheduler
+// runs it from a shared pool. Timeout, DefaultWorkerTimeout when zero, must
+// be shorter than the queue's visibility timeout.
 type Worker struct {
-	Queue     *Queue
-	Service   *Service
-	DB        *db.Store
-	Hub       *realtime.Hub
-	BatchSize int
+	Queue   Queue
+	Service *Service
+	DB      *db.Store
+	Hub     *realtime.Hub
+	Timeout time.Duration
+}
+
+// Handle processes one delivery, or a micro-batch of deliveries from the same
+// tenant.
+func (w *Worker) Handle(ctx context.Context, deliveries []QueueDelivery) {
+	if deliveries[0].Message.Feature == QueueFeatureEmbedding {
+		w.embed(ctx, deliveries)
+		return
+	}
+	timeout := w.Timeout
+	if timeout <= 0 {
+		timeout = DefaultWorkerTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	if len(deliveries) == 1 {
+		w.process(ctx, deliveries[0], deadline)
+		return
+	}
+	w.processBatch(ctx, deliveries, deadline)
 }
 
-func (w *Worker) Start(ctx context.Context, tenantID int64) {
-	batch := w.BatchSize
-	if batch <= 0 {
-		batch = 100
+// analysisContext bounds one analysis by analysisTimeout and by the
+// deadline of the whole Handle call.
+func analysisContext(ctx context.Context, deadline time.Time) (context.Context, context.CancelFunc) {
+	if limit := time.Now().Add(analysisTimeout); limit.Before(deadline) {
+		deadline = limit
 	}
+	return context.WithDeadline(ctx, deadline)
+}
 
-	for {
-		select {
-		case <-ctx.Done():
+// process analyzes one delivery. Past the deadline it fails, and so is
+// retried, instead of outliving its visibility timeout.
+func (w *Worker) process(ctx context.Context, delivery QueueDelivery, deadline time.Time) {
+	msg := delivery.Message
+	ctxTimeout, cancel := analysisContext(ctx, deadline)
+	result, source, err := w.Service.Analyze(ctxTimeout, msg.TenantID, 0, msg.Content, &msg.MessageID)
+	cancel()
+	if errors.Is(err, ErrNoProviders) {
+		// Nothing to retry against; label the message with keywords.
+		result, source, err = fallbackAnalysis(msg.Content), nil, nil
+	}
+	if ctx.Err() != nil {
+		// Shutting down: leave the entry pending so it is reclaimed.
+		return
+	}
+	if err == nil {
+		if err = w.complete(ctx, delivery, result, source); err == nil {
 			return
-		default:
 		}
+	}
+	w.fail(ctx, delivery, err)
+}
 
-		items, err := w.Queue.DequeueBatch(ctx, tenantID, batch)
-		if err != nil {
-			time.Sleep(2 * time.Second)
-			continue
+// processBatch sends the batch in one prompt. Messages the provider left out
+// of its answer, or the whole batch when the call fails, are analyzed one by
+// one instead, within the same deadline. A rate-limited batch is retried
+// later instead, as single calls would only spend more of the quota.
+func (w *Worker) processBatch(ctx context.Context, deliveries []QueueDelivery, deadline time.Time) {
+	items := make([]BatchItem, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		items = append(items, BatchItem{MessageID: delivery.Message.MessageID, Content: delivery.Message.Content})
+	}
+	ctxTimeout, cancel := analysisContext(ctx, deadline)
+	results, source, err := w.Service.AnalyzeBatch(ctxTimeout, deliveries[0].Message.TenantID, items)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+	if errors.Is(err, ErrRateLimited) {
+		for _, delivery := range deliveries {
+			w.fail(ctx, delivery, err)
 		}
-		if len(items) == 0 {
-			time.Sleep(500 * time.Millisecond)
+		return
+	}
+	for _, delivery := range deliveries {
+		result, ok := results[delivery.Message.MessageID]
+		if err != nil || !ok || result == nil {
+			w.process(ctx, delivery, deadline)
 			continue
 		}
+		if storeErr := w.complete(ctx, delivery, result, source); storeErr != nil {
+			w.fail(ctx, delivery, storeErr)
+		}
+	}
+}
 
-		for _, raw := range items {
-			var msg QueueMessage
-			if err := json.Unmarshal(raw, &msg); err != nil {
-				continue
-			}
-			ctxTimeout, cancel := context.WithTimeout(ctx, 2*time.Minute)
-			result, err := w.Service.AnalyzeWithFallback(ctxTimeout, msg.TenantID, msg.Content, &msg.MessageID)
-			cancel()
-			if err == nil {
-				_ = StoreAnalysis(ctx, w.DB, msg.TenantID, msg.MessageID, result)
-				if w.Hub != nil {
-					w.Hub.Broadcast(msg.TenantID, map[string]any{
-						"type":       "message.analysis",
-						"message_id": msg.MessageID,
-					})
-				}
-			}
+// embed stores vectors for the deliveries' messages in one provider call.
+// Tenants without a provider that can embed simply get no vectors.
+func (w *Worker) embed(ctx context.Context, deliveries []QueueDelivery) {
+	items := make([]BatchItem, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		items = append(items, BatchItem{MessageID: delivery.Message.MessageID, Content: delivery.Message.Content})
+	}
+	ctxTimeout, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	err := w.Service.EmbedMessages(ctxTimeout, deliveries[0].Message.TenantID, items)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+	if errors.Is(err, ErrEmbedUnsupported) || errors.Is(err, ErrNoProviders) {
+		err = nil
+	}
+	for _, delivery := range deliveries {
+		if err == nil {
+			_ = w.Queue.Ack(ctx, delivery)
+			continue
 		}
+		w.fail(ctx, delivery, err)
+	}
+}
+
+func (w *Worker) complete(ctx context.Context, delivery QueueDelivery, result *AnalysisResult, source *AnalysisSource) error {
+	msg := delivery.Message
+	if err := StoreAnalysis(ctx, w.DB, msg.TenantID, msg.MessageID, result, source); err != nil {
+		return err
+	}
+	_ = w.Queue.Ack(ctx, delivery)
+	w.broadcast(msg)
+	return nil
+}
+
+func (w *Worker) fail(ctx context.Context, delivery QueueDelivery, err error) {
+	msg := delivery.Message
+	dead, retryErr := w.Queue.Retry(ctx, delivery, err)
+	if retryErr != nil || !dead || msg.Feature == QueueFeatureEmbedding {
+		return
+	}
+	// Out of attempts: keep the keyword labels until the dead letter is
+	// replayed.
+	if StoreAnalysis(ctx, w.DB, msg.TenantID, msg.MessageID, fallbackAnalysis(msg.Content), nil) == nil {
+		w.broadcast(msg)
+	}
+}
+
+func (w *Worker) broadcast(msg QueueMessage) {
+	if w.Hub != nil {
+		w.Hub.Broadcast(msg.TenantID, map[string]any{
+			"type":       "message.analysis",
+			"message_id": msg.MessageID,
+		})
 	}
 }
@@ -0,0 +1,99 @@
+package llm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQueuePolicyBackoffDoublesUpToMax(t *testing.T) {
+	policy := QueuePolicy{RetryBackoff: 5 * time.Second, MaxBackoff: time.Minute}
+	want := []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute}
+	for i, expected := range want {
+		if got := policy.Backoff(i + 1); got != expected {
+			t.Fatalf("attempt %d: expected %s, got %s", i+1, expected, got)
+		}
+	}
+}
+
+func TestAnalysisContextKeepsWithinWorkerDeadline(t *testing.T) {
+	if DefaultWorkerTimeout >= DefaultQueuePolicy().VisibilityTimeout {
+		t.Fatal("the worker deadline must end before deliveries are handed out again")
+	}
+	ctx := context.Background()
+	ctxTimeout, cancel := analysisContext(ctx, time.Now().Add(time.Hour))
+	defer cancel()
+	if deadline, _ := ctxTimeout.Deadline(); time.Until(deadline) > analysisTimeout {
+		t.Fatalf("one analysis may run until %s", deadline)
+	}
+	// Fallbacks late in a batch only get what is left of the deadline.
+	near := time.Now().Add(10 * time.Second)
+	late, cancelLate := analysisContext(ctx, near)
+	defer cancelLate()
+	if deadline, _ := late.Deadline(); !deadline.Equal(near) {
+		t.Fatalf("deadline = %s, want %s", deadline, near)
+	}
+}
+
+// retryQueue records the deliveries handed back for a retry.
+type retryQueue struct {
+	Queue
+	retried []int64
+}
+
+func (q *retryQueue) Retry(ctx context.Context, delivery QueueDelivery, cause error) (bool, error) {
+	q.retried = append(q.retried, delivery.Message.MessageID)
+	return false, nil
+}
+
+func TestRateLimitedBatchIsRetriedWithoutSingleCalls(t *testing.T) {
+	config := ProviderConfig{ID: 5, ProviderName: "mock", ModelName: "mock-1", BaseURL: "mock://?failure_rate=1&failure_status=429"}
+	store := &fakeProviderStore{providers: map[int64]ProviderConfig{5: config}, defaultID: 5}
+	factory := NewFactory(nil)
+	factory.MockProviders = true
+	queue := &retryQueue{}
+	worker := &Worker{Queue: queue, Service: &Service{Router: NewRouter(factory, store)}}
+
+	worker.Handle(context.Background(), []QueueDelivery{
+		{Message: QueueMessage{TenantID: 1, MessageID: 1, Content: "first"}},
+		{Message: QueueMessage{TenantID: 1, MessageID: 2, Content: "second"}},
+	})
+	if len(queue.retried) != 2 {
+		t.Fatalf("expected both deliveries to be retried, got %v", queue.retried)
+	}
+	usage, _ := factory.CreateProvider(&config).GetUsage(context.Background())
+	if usage.TotalRequests != 1 {
+		t.Fatalf("expected only the batch call, got %d calls", usage.TotalRequests)
+	}
+}
+
+func TestPostgresQueuePrunesOncePerInterval(t *testing.T) {
+	queue := &PostgresQueue{}
+	now := time.Now()
+	if !queue.pruneDue(1, now) {
+		t.Fatal("expected the first empty poll to prune")
+	}
+	if queue.pruneDue(1, now.Add(time.Second)) {
+		t.Fatal("expected later polls to skip pruning")
+	}
+	if !queue.pruneDue(2, now) {
+		t.Fatal("expected tenants to be pruned independently")
+	}
+	if !queue.pruneDue(1, now.Add(pruneInterval)) || queue.pruneDue(1, now.Add(pruneInterval)) {
+		t.Fatal("expected one prune per interval")
+	}
+}
+
+func TestRetryMembersKeepEqualPayloadsApart(t *testing.T) {
+	payload := []byte(`{"tenant_id":1,"content":"a|b"}`)
+	first, second := retryMember("1700000000000-0", payload), retryMember("1700000000001-0", payload)
+	if first == second {
+		t.Fatal("expected deliveries with equal payloads to get distinct members")
+	}
+	if got := retryPayload(first); got != string(payload) {
+		t.Fatalf("retryPayload = %q", got)
+	}
+	if got := retryPayload(string(payload)); got != string(payload) {
+		t.Fatalf("expected bare payloads to be read as they are, got %q", got)
+	}
+}
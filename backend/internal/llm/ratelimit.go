@@ -0,0 +1,206 @@
+package llm
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// RateLimiter hands out per-key tokens from a bucket holding perMinute tokens
+// that refills continuously. Take reports whether a token was acquired and,
+// if not, how long until the next one is available.
+type RateLimiter interface {
+	Take(ctx context.Context, key string, perMinute int) (bool, time.Duration, error)
+}
+
+type LocalRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewLocalRateLimiter() *LocalRateLimiter {
+	return &LocalRateLimiter{buckets: map[string]*tokenBucket{}}
+}
+
+func (l *LocalRateLimiter) Take(ctx context.Context, key string, perMinute int) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.take(key, perMinute, time.Now())
+}
+
+func (l *LocalRateLimiter) take(key string, perMinute int, now time.Time) (bool, time.Duration, error) {
+	capacity := float64(perMinute)
+	rate := capacity / float64(time.Minute)
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, last: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(capacity, bucket.tokens+float64(now.Sub(bucket.last))*rate)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0, nil
+	}
+	return false, time.Duration(math.Ceil((1 - bucket.tokens) / rate)), nil
+}
+
+// tokenBucketScript keeps the bucket in a Redis hash and uses the server clock
+// so every replica sees the same refill.
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = capacity / 60000
+local clock = redis.call('TIME')
+local now = tonumber(clock[1]) * 1000 + math.floor(tonumber(clock[2]) / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+  tokens = capacity
+  ts = now
+end
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  wait = math.ceil((1 - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], 60000)
+return {allowed, wait}
+`)
+
+// RedisRateLimiter shares buckets across replicas. When Redis is unreachable
+// it degrades to a per-process bucket rather than blocking calls.
+type RedisRateLimiter struct {
+	client *redis.Client
+	local  *LocalRateLimiter
+}
+
+func NewRedisRateLimiter(redisURL string) (*RedisRateLimiter, error) {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisRateLimiter{client: redis.NewClient(opt), local: NewLocalRateLimiter()}, nil
+}
+
+func (l *RedisRateLimiter) Take(ctx context.Context, key string, perMinute int) (bool, time.Duration, error) {
+	values, err := tokenBucketScript.Run(ctx, l.client, []string{"llm:ratelimit:" + key}, perMinute).Int64Slice()
+	if err != nil || len(values) != 2 {
+		return l.local.Take(ctx, key, perMinute)
+	}
+	return values[0] == 1, time.Duration(values[1]) * time.Millisecond, nil
+}
+
+// rateLimitedProvider holds calls until the provider's MaxRequestsPerMinute
+// quota has a token, waiting at most maxWait before failing with a
+// RateLimitError so the router can move on to the next provider.
+type rateLimitedProvider struct {
+	Provider
+	limiter RateLimiter
+	maxWait time.Duration
+}
+
+func newRateLimitedProvider(provider Provider, limiter RateLimiter) *rateLimitedProvider {
+	return &rateLimitedProvider{Provider: provider, limiter: limiter, maxWait: 2 * time.Second}
+}
+
+func (p *rateLimitedProvider) acquire(ctx context.Context) error {
+	config := p.GetConfig()
+	if config.MaxRequestsPerMinute <= 0 {
+		return nil
+	}
+	key := fmtInt(config.ID)
+	deadline := time.Now().Add(p.maxWait)
+	for {
+		ok, wait, err := p.limiter.Take(ctx, key, config.MaxRequestsPerMinute)
+		if err != nil || ok {
+			return nil
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return &RateLimitError{Provider: p.Name(), RetryAfter: wait}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (p *rateLimitedProvider) Analyze(ctx context.Context, message string, options CallOptions) (*AnalysisResult, UsageRecord, error) {
+	if err := p.acquire(ctx); err != nil {
+		return nil, UsageRecord{}, err
+	}
+	return p.Provider.Analyze(ctx, message, options)
+}
+
+func (p *rateLimitedProvider) Summarize(ctx context.Context, messages []string, options CallOptions) (*SummaryResult, UsageRecord, error) {
+	if err := p.acquire(ctx); err != nil {
+		return nil, UsageRecord{}, err
+	}
+	return p.Provider.Summarize(ctx, messages, options)
+}
+
+func (p *rateLimitedProvider) ExtractActions(ctx context.Context, text string, options CallOptions) ([]string, UsageRecord, error) {
+	if err := p.acquire(ctx); err != nil {
+		return nil, UsageRecord{}, err
+	}
+	return p.Provider.ExtractActions(ctx, text, options)
+}
+
+func (p *rateLimitedProvider) AnalyzeBatch(ctx context.Context, items []BatchItem) (map[int64]*AnalysisResult, UsageRecord, error) {
+	batcher, ok := p.Provider.(BatchAnalyzer)
+	if !ok {
+		return nil, UsageRecord{}, ErrBatchUnsupported
+	}
+	if err := p.acquire(ctx); err != nil {
+		return nil, UsageRecord{}, err
+	}
+	return batcher.AnalyzeBatch(ctx, items)
+}
+
+func (p *rateLimitedProvider) Embed(ctx context.Context, texts []string) (*Embeddings, UsageRecord, error) {
+	embedder, ok := p.Provider.(Embedder)
+	if !ok {
+		return nil, UsageRecord{}, ErrEmbedUnsupported
+	}
+	if err := p.acquire(ctx); err != nil {
+		return nil, UsageRecord{}, err
+	}
+	return embedder.Embed(ctx, texts)
+}
+
+func (p *rateLimitedProvider) Answer(ctx context.Context, request QuestionRequest) (*AnswerResult, UsageRecord, error) {
+	answerer, ok := p.Provider.(QuestionAnswerer)
+	if !ok {
+		return nil, UsageRecord{}, ErrAnswerUnsupported
+	}
+	if err := p.acquire(ctx); err != nil {
+		return nil, UsageRecord{}, err
+	}
+	return answerer.Answer(ctx, request)
+}
+
+func (p *rateLimitedProvider) DraftReply(ctx context.Context, request DraftRequest) (*DraftResult, UsageRecord, error) {
+	drafter, ok := p.Provider.(ReplyDrafter)
+	if !ok {
+		return nil, UsageRecord{}, ErrDraftUnsupported
+	}
+	if err := p.acquire(ctx); err != nil {
+		return nil, UsageRecord{}, err
+	}
+	return drafter.DraftReply(ctx, request)
+}
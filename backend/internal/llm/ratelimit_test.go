@@ -0,0 +1,61 @@
+package llm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLocalRateLimiterRefills(t *testing.T) {
+	limiter := NewLocalRateLimiter()
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := limiter.take("provider", 2, now); !ok {
+			t.Fatalf("expected token %d", i)
+		}
+	}
+	ok, wait, _ := limiter.take("provider", 2, now)
+	if ok {
+		t.Fatalf("expected bucket to be empty")
+	}
+	if wait <= 0 || wait > 30*time.Second {
+		t.Fatalf("unexpected wait %s", wait)
+	}
+	if ok, _, _ := limiter.take("provider", 2, now.Add(wait)); !ok {
+		t.Fatalf("expected token after refill")
+	}
+}
+
+func TestRateLimitedProviderKeepsUsage(t *testing.T) {
+	factory := NewFactory(nil)
+	factory.MockProviders = true
+	provider := factory.CreateProvider(&ProviderConfig{ID: 9, ProviderName: "mock", ModelName: "mock-1", BaseURL: "mock://?input_tokens=30&output_tokens=7", MaxRequestsPerMinute: 600})
+	if _, ok := provider.(*rateLimitedProvider); !ok {
+		t.Fatalf("expected the factory to wrap providers, got %T", provider)
+	}
+	ctx := context.Background()
+	check := func(call string, usage UsageRecord, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", call, err)
+		}
+		if usage.InputTokens != 30 || usage.Model != "mock-1" || usage.Attempts != 1 {
+			t.Fatalf("%s: usage lost in the wrapper: %+v", call, usage)
+		}
+	}
+	_, usage, err := provider.Analyze(ctx, "hello", CallOptions{})
+	check("analyze", usage, err)
+	_, usage, err = provider.Summarize(ctx, []string{"hello"}, CallOptions{})
+	check("summarize", usage, err)
+	_, usage, err = provider.ExtractActions(ctx, "please call", CallOptions{})
+	check("extract_actions", usage, err)
+	_, usage, err = provider.(BatchAnalyzer).AnalyzeBatch(ctx, []BatchItem{{MessageID: 1, Content: "hello"}})
+	check("analyze_batch", usage, err)
+	_, usage, err = provider.(QuestionAnswerer).Answer(ctx, QuestionRequest{Question: "what?"})
+	check("answer", usage, err)
+	_, usage, err = provider.(ReplyDrafter).DraftReply(ctx, DraftRequest{Draft: "hi"})
+	check("draft_reply", usage, err)
+	if _, usage, err = provider.(Embedder).Embed(ctx, []string{"hello"}); err != nil || usage.InputTokens != 30 {
+		t.Fatalf("embed: usage lost in the wrapper: %+v %v", usage, err)
+	}
+}
@@ -0,0 +1,433 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Built-in PII detectors.
+const (
+	DetectorPhone   = "phone"
+	DetectorEmail   = "email"
+	DetectorCard    = "card"
+	DetectorAddress = "address"
+)
+
+// RedactionDetectors are the built-in detectors, in the order they run.
+// Cards run before phones so a card number is not taken for a phone number.
+var RedactionDetectors = []string{DetectorEmail, DetectorCard, DetectorPhone, DetectorAddress}
+
+const (
+	// redactorCacheTTL bounds how long a replica redacts with settings
+	// another replica changed.
+	redactorCacheTTL = time.Minute
+	// MaxCustomPatterns caps a tenant's own patterns.
+	MaxCustomPatterns = 20
+)
+
+// RedactionSettings configure a tenant's redaction. Detectors name the
+// built-in detectors to run; CustomPatterns add the tenant's own. With
+// RestoreOutputs the original values are put back into summaries, action
+// items, answers and reply drafts.
+type RedactionSettings struct {
+	Enabled        bool            `json:"enabled"`
+	Detectors      []string        `json:"detectors"`
+	CustomPatterns []CustomPattern `json:"custom_patterns"`
+	RestoreOutputs bool            `json:"restore_outputs"`
+}
+
+// CustomPattern is a tenant regular expression (RE2 syntax). Matches are
+// replaced with placeholders named after Name.
+type CustomPattern struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+// DefaultRedactionSettings are used for tenants that saved none: redaction
+// off, and all built-in detectors once it is turned on.
+func DefaultRedactionSettings() RedactionSettings {
+	return RedactionSettings{Detectors: append([]string(nil), RedactionDetectors...), CustomPatterns: []CustomPattern{}, RestoreOutputs: true}
+}
+
+type detector struct {
+	kind    string
+	pattern *regexp.Regexp
+	// valid filters out matches the pattern cannot rule out by itself.
+	valid func(string) bool
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	cardPattern  = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+	phonePattern = regexp.MustCompile(`(?:\+|\b)\d(?:[ ().-]{0,2}\d){7,14}\b`)
+	// datePattern matches dates the phone pattern would otherwise take.
+	datePattern    = regexp.MustCompile(`^(?:\d{4}[./-]\d{1,2}[./-]\d{1,2}|\d{1,2}[./-]\d{1,2}[./-]\d{2,4})$`)
+	addressPattern = regexp.MustCompile(`(?i)\b\d{1,5}[a-z]?\s+(?:[a-zà-ÿ'.-]+\s+){1,4}(?:street|st|avenue|ave|road|rd|boulevard|blvd|lane|ln|drive|dr|court|ct|way|place|pl|square|sq|terrace)\b\.?` +
+		`|\b[a-zäöüß-]+(?:straße|strasse|str\.|weg|gasse|platz|allee)\s+\d{1,4}[a-z]?\b`)
+	placeholderPattern = regexp.MustCompile(`\[[A-Z0-9_]+_\d+\]`)
+	patternName        = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+)
+
+var builtinDetectors = map[string]detector{
+	DetectorEmail:   {kind: DetectorEmail, pattern: emailPattern},
+	DetectorCard:    {kind: DetectorCard, pattern: cardPattern, valid: luhnValid},
+	DetectorPhone:   {kind: DetectorPhone, pattern: phonePattern, valid: phoneLike},
+	DetectorAddress: {kind: DetectorAddress, pattern: addressPattern},
+}
+
+// Redactor replaces personal data in text sent to providers. A nil Redactor
+// redacts nothing.
+type Redactor struct {
+	detectors []detector
+	restore   bool
+}
+
+// NewRedactor compiles settings. It returns nil when redaction is off.
+func NewRedactor(settings RedactionSettings) (*Redactor, error) {
+	if err := ValidateRedactionSettings(settings); err != nil {
+		return nil, err
+	}
+	if !settings.Enabled {
+		return nil, nil
+	}
+	redactor := &Redactor{restore: settings.RestoreOutputs}
+	// Tenant patterns run first: they are usually more specific, such as
+	// customer or order numbers that would otherwise pass for phones.
+	for _, custom := range settings.CustomPatterns {
+		redactor.detectors = append(redactor.detectors, detector{kind: custom.Name, pattern: regexp.MustCompile(custom.Pattern)})
+	}
+	enabled := map[string]bool{}
+	for _, name := range settings.Detectors {
+		enabled[name] = true
+	}
+	for _, name := range RedactionDetectors {
+		if enabled[name] {
+			redactor.detectors = append(redactor.detectors, builtinDetectors[name])
+		}
+	}
+	return redactor, nil
+}
+
+// ValidateRedactionSettings checks detector names and custom patterns.
+func ValidateRedactionSettings(settings RedactionSettings) error {
+	for _, name := range settings.Detectors {
+		if _, ok := builtinDetectors[name]; !ok {
+			return fmt.Errorf("unknown detector %q", name)
+		}
+	}
+	if len(settings.CustomPatterns) > MaxCustomPatterns {
+		return fmt.Errorf("at most %d custom patterns are allowed", MaxCustomPatterns)
+	}
+	seen := map[string]bool{}
+	for _, custom := range settings.CustomPatterns {
+		if !patternName.MatchString(custom.Name) {
+			return fmt.Errorf("pattern name %q must be lower case letters, digits and underscores", custom.Name)
+		}
+		if _, ok := builtinDetectors[custom.Name]; ok || seen[custom.Name] {
+			return fmt.Errorf("pattern name %q is already used", custom.Name)
+		}
+		seen[custom.Name] = true
+		pattern, err := regexp.Compile(custom.Pattern)
+		if err != nil {
+			return fmt.Errorf("pattern %s: %w", custom.Name, err)
+		}
+		if pattern.MatchString("") {
+			return fmt.Errorf("pattern %s matches empty text", custom.Name)
+		}
+	}
+	return nil
+}
+
+// Start begins the redaction of one call.
+func (r *Redactor) Start() *Redaction {
+	if r == nil {
+		return nil
+	}
+	return &Redaction{redactor: r, values: map[string]string{}, placeholders: map[string]string{}, counts: map[string]int{}}
+}
+
+// Redaction tracks the placeholders of one call. The same value gets the
+// same placeholder everywhere in the call, e.g. [EMAIL_1], so the model can
+// still tell values apart. A nil Redaction leaves text unchanged.
+type Redaction struct {
+	redactor     *Redactor
+	values       map[string]string // placeholder → original
+	placeholders map[string]string // kind and original → placeholder
+	counts       map[string]int    // kind → distinct values
+}
+
+// Redact replaces the personal data in text with placeholders.
+func (r *Redaction) Redact(text string) string {
+	if r == nil || text == "" {
+		return text
+	}
+	for _, detector := range r.redactor.detectors {
+		text = replaceOutsidePlaceholders(text, detector.pattern, func(match string) string {
+			if detector.valid != nil && !detector.valid(match) {
+				return match
+			}
+			return r.placeholder(detector.kind, match)
+		})
+	}
+	return text
+}
+
+// RedactAll returns the texts redacted.
+func (r *Redaction) RedactAll(texts []string) []string {
+	if r == nil {
+		return texts
+	}
+	redacted := make([]string, len(texts))
+	for i, text := range texts {
+		redacted[i] = r.Redact(text)
+	}
+	return redacted
+}
+
+func (r *Redaction) placeholder(kind, value string) string {
+	key := kind + "\x00" + value
+	if placeholder, ok := r.placeholders[key]; ok {
+		return placeholder
+	}
+	r.counts[kind]++
+	placeholder := "[" + strings.ToUpper(kind) + "_" + strconv.Itoa(r.counts[kind]) + "]"
+	r.placeholders[key] = placeholder
+	r.values[placeholder] = value
+	return placeholder
+}
+
+// Restore puts the original values back into model output, unless the
+// tenant keeps placeholders in outputs. Placeholders the model made up are
+// left as they are.
+func (r *Redaction) Restore(text string) string {
+	if r == nil || !r.redactor.restore || len(r.values) == 0 {
+		return text
+	}
+	return placeholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+		if value, ok := r.values[placeholder]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+// RestoreAll restores each text in place.
+func (r *Redaction) RestoreAll(texts []string) {
+	for i, text := range texts {
+		texts[i] = r.Restore(text)
+	}
+}
+
+// RestoreSummary restores the free text of a summary. Sentiment and topics
+// are labels and stay as they are.
+func (r *Redaction) RestoreSummary(summary *SummaryResult) {
+	if summary == nil {
+		return
+	}
+	summary.Summary = r.Restore(summary.Summary)
+	r.RestoreAll(summary.KeyPoints)
+	r.RestoreAll(summary.ActionItems)
+}
+
+// Counts returns how many distinct values of each kind were redacted.
+func (r *Redaction) Counts() map[string]int {
+	counts := map[string]int{}
+	if r != nil {
+		for kind, count := range r.counts {
+			counts[kind] = count
+		}
+	}
+	return counts
+}
+
+// Placeholders returns the placeholders used, sorted.
+func (r *Redaction) Placeholders() []string {
+	placeholders := []string{}
+	if r != nil {
+		for placeholder := range r.values {
+			placeholders = append(placeholders, placeholder)
+		}
+		sort.Strings(placeholders)
+	}
+	return placeholders
+}
+
+// replaceOutsidePlaceholders replaces matches of pattern, skipping those that
+// overlap a placeholder an earlier detector inserted.
+func replaceOutsidePlaceholders(text string, pattern *regexp.Regexp, replace func(string) string) string {
+	protected := placeholderPattern.FindAllStringIndex(text, -1)
+	var b strings.Builder
+	last := 0
+	for _, match := range pattern.FindAllStringIndex(text, -1) {
+		if overlaps(match, protected) {
+			continue
+		}
+		b.WriteString(text[last:match[0]])
+		b.WriteString(replace(text[match[0]:match[1]]))
+		last = match[1]
+	}
+	if last == 0 {
+		return text
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+func overlaps(span []int, spans [][]int) bool {
+	for _, other := range spans {
+		if span[0] < other[1] && other[0] < span[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// luhnValid reports whether the digits of value pass the Luhn check, which
+// every payment card number does.
+func luhnValid(value string) bool {
+	sum, digits := 0, 0
+	for i := len(value) - 1; i >= 0; i-- {
+		c := value[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
+
+// phoneLike rejects dates, which have as many digits as a short phone
+// number.
+func phoneLike(value string) bool {
+	return !datePattern.MatchString(value)
+}
+
+type redactorEntry struct {
+	redactor *Redactor
+	expires  time.Time
+}
+
+type redactorCache struct {
+	mu      sync.Mutex
+	entries map[int64]redactorEntry
+}
+
+// InvalidateRedaction drops the cached redaction settings of a tenant after
+// they change.
+func (s *Service) InvalidateRedaction(tenantID int64) {
+	s.redactors.mu.Lock()
+	defer s.redactors.mu.Unlock()
+	delete(s.redactors.entries, tenantID)
+}
+
+// redaction starts the redaction of a call for the tenant. Settings that
+// cannot be loaded fail the call rather than send data unredacted.
+func (s *Service) redaction(ctx context.Context, tenantID int64) (*Redaction, error) {
+	if s.Store == nil || s.Store.DB == nil {
+		return nil, nil
+	}
+	s.redactors.mu.Lock()
+	entry, ok := s.redactors.entries[tenantID]
+	s.redactors.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.redactor.Start(), nil
+	}
+
+	settings, err := s.Store.RedactionSettings(ctx, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("load redaction settings: %w", err)
+	}
+	redactor, err := NewRedactor(settings)
+	if err != nil {
+		return nil, fmt.Errorf("redaction settings: %w", err)
+	}
+	s.redactors.mu.Lock()
+	if s.redactors.entries == nil {
+		s.redactors.entries = map[int64]redactorEntry{}
+	}
+	s.redactors.entries[tenantID] = redactorEntry{redactor: redactor, expires: time.Now().Add(redactorCacheTTL)}
+	s.redactors.mu.Unlock()
+	return redactor.Start(), nil
+}
+
+// logRedaction records what a call redacted. Only kinds, counts and
+// placeholders are kept, never the values.
+func (s *Service) logRedaction(ctx context.Context, tenantID int64, messageID *int64, usageFeature string, redaction *Redaction) {
+	if redaction == nil || len(redaction.values) == 0 {
+		return
+	}
+	_ = s.Store.InsertRedaction(ctx, tenantID, messageID, usageFeature, redaction.Counts(), redaction.Placeholders())
+}
+
+// RedactionSettings returns the tenant's settings, or the defaults when none
+// are saved.
+func (s *Store) RedactionSettings(ctx context.Context, tenantID int64) (RedactionSettings, error) {
+	settings := DefaultRedactionSettings()
+	var custom []byte
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		return conn.QueryRow(ctx, `
+			SELECT enabled, detectors, custom_patterns, restore_outputs
+			FROM llm_redaction_settings WHERE tenant_id=$1`, tenantID).Scan(&settings.Enabled, &settings.Detectors, &custom, &settings.RestoreOutputs)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return DefaultRedactionSettings(), nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	if err := json.Unmarshal(custom, &settings.CustomPatterns); err != nil {
+		return settings, err
+	}
+	return settings, nil
+}
+
+// SaveRedactionSettings stores the tenant's settings.
+func (s *Store) SaveRedactionSettings(ctx context.Context, tenantID int64, settings RedactionSettings) error {
+	custom, err := json.Marshal(settings.CustomPatterns)
+	if err != nil {
+		return err
+	}
+	return s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		_, err := conn.Exec(ctx, `
+			INSERT INTO llm_redaction_settings (tenant_id, enabled, detectors, custom_patterns, restore_outputs, updated_at)
+			VALUES ($1, $2, $3, $4, $5, NOW())
+			ON CONFLICT (tenant_id) DO UPDATE
+			SET enabled=EXCLUDED.enabled, detectors=EXCLUDED.detectors, custom_patterns=EXCLUDED.custom_patterns,
+				restore_outputs=EXCLUDED.restore_outputs, updated_at=EXCLUDED.updated_at`,
+			tenantID, settings.Enabled, settings.Detectors, custom, settings.RestoreOutputs)
+		return err
+	})
+}
+
+// InsertRedaction logs what one call redacted.
+func (s *Store) InsertRedaction(ctx context.Context, tenantID int64, messageID *int64, feature string, counts map[string]int, placeholders []string) error {
+	encoded, err := json.Marshal(counts)
+	if err != nil {
+		return err
+	}
+	return s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		_, err := conn.Exec(ctx, `
+			INSERT INTO llm_redaction_logs (tenant_id, message_id, feature_used, counts, placeholders, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6)`, tenantID, messageID, feature, encoded, placeholders, time.Now().UTC())
+		return err
+	})
+}
@@ -0,0 +1,103 @@
+package llm
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestRedaction(t *testing.T, settings RedactionSettings) *Redaction {
+	t.Helper()
+	settings.Enabled = true
+	redactor, err := NewRedactor(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return redactor.Start()
+}
+
+func TestRedactBuiltinDetectors(t *testing.T) {
+	redaction := newTestRedaction(t, DefaultRedactionSettings())
+	text := "Mail jane.doe@example.com or call +49 170 1234567. Card 4111 1111 1111 1111 expires 2027-01-31. " +
+		"Ship to 221B Baker Street, invoice 1042, again to jane.doe@example.com."
+	redacted := redaction.Redact(text)
+
+	for _, leaked := range []string{"jane.doe@example.com", "170 1234567", "4111", "Baker Street"} {
+		if strings.Contains(redacted, leaked) {
+			t.Fatalf("%q leaked: %q", leaked, redacted)
+		}
+	}
+	for _, kept := range []string{"2027-01-31", "invoice 1042", "[EMAIL_1]", "[PHONE_1]", "[CARD_1]", "[ADDRESS_1]"} {
+		if !strings.Contains(redacted, kept) {
+			t.Fatalf("%q missing: %q", kept, redacted)
+		}
+	}
+	if strings.Contains(redacted, "[EMAIL_2]") {
+		t.Fatalf("repeated value got a second placeholder: %q", redacted)
+	}
+	if got := redaction.Restore(redacted); got != text {
+		t.Fatalf("restored %q, expected %q", got, text)
+	}
+	counts := redaction.Counts()
+	if counts[DetectorEmail] != 1 || counts[DetectorPhone] != 1 || counts[DetectorCard] != 1 || counts[DetectorAddress] != 1 {
+		t.Fatalf("unexpected counts %v", counts)
+	}
+}
+
+func TestRedactCustomPatternsAndCardCheck(t *testing.T) {
+	settings := RedactionSettings{
+		Detectors:      []string{DetectorCard},
+		CustomPatterns: []CustomPattern{{Name: "customer_id", Pattern: `CUST-\d{6}`}},
+	}
+	redaction := newTestRedaction(t, settings)
+	redacted := redaction.Redact("CUST-123456 paid with 4111 1111 1111 1112")
+	// The card number fails the Luhn check and the phone detector is off.
+	if redacted != "[CUSTOMER_ID_1] paid with 4111 1111 1111 1112" {
+		t.Fatalf("unexpected redaction %q", redacted)
+	}
+}
+
+func TestRestoreKeepsUnknownPlaceholders(t *testing.T) {
+	redaction := newTestRedaction(t, DefaultRedactionSettings())
+	redaction.Redact("write to jane@example.com")
+	summary := &SummaryResult{Summary: "Reply to [EMAIL_1] and [EMAIL_7]", ActionItems: []string{"Email [EMAIL_1]"}, Topics: []string{"[EMAIL_1]"}}
+	redaction.RestoreSummary(summary)
+	if summary.Summary != "Reply to jane@example.com and [EMAIL_7]" || summary.ActionItems[0] != "Email jane@example.com" || summary.Topics[0] != "[EMAIL_1]" {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+
+	settings := DefaultRedactionSettings()
+	settings.RestoreOutputs = false
+	kept := newTestRedaction(t, settings)
+	kept.Redact("write to jane@example.com")
+	if got := kept.Restore("Email [EMAIL_1]"); got != "Email [EMAIL_1]" {
+		t.Fatalf("restored although outputs keep placeholders: %q", got)
+	}
+}
+
+func TestNilRedactionPassesThrough(t *testing.T) {
+	redactor, err := NewRedactor(DefaultRedactionSettings())
+	if err != nil || redactor != nil {
+		t.Fatalf("disabled settings gave %v, %v", redactor, err)
+	}
+	redaction := redactor.Start()
+	if got := redaction.Redact("jane@example.com"); got != "jane@example.com" {
+		t.Fatalf("nil redaction changed text: %q", got)
+	}
+	if got := redaction.Restore("[EMAIL_1]"); got != "[EMAIL_1]" {
+		t.Fatalf("nil redaction restored: %q", got)
+	}
+}
+
+func TestValidateRedactionSettings(t *testing.T) {
+	for name, settings := range map[string]RedactionSettings{
+		"unknown detector": {Detectors: []string{"ssn"}},
+		"bad name":         {CustomPatterns: []CustomPattern{{Name: "Order No", Pattern: `\d+`}}},
+		"builtin name":     {CustomPatterns: []CustomPattern{{Name: "email", Pattern: `\d+`}}},
+		"invalid regex":    {CustomPatterns: []CustomPattern{{Name: "order", Pattern: `(`}}},
+		"matches empty":    {CustomPatterns: []CustomPattern{{Name: "order", Pattern: `\d*`}}},
+	} {
+		if ValidateRedactionSettings(settings) == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,460 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+const queueGroup = "llm-workers"
+
+// RedisQueue is an at-least-once analysis queue on Redis Streams. Each tenant has a
+// stream read through a consumer group; entries stay pending until acked, and
+// entries left pending longer than VisibilityTimeout (a crashed worker) are
+// reclaimed with XAUTOCLAIM. Failed entries are retried with exponential
+// backoff through a sorted set and moved to a dead-letter stream after
+// MaxAttempts.
+type RedisQueue struct {
+	QueuePolicy
+	DeadLetterMaxLen int64
+
+	client   *redis.Client
+	consumer string
+	groups   sync.Map
+}
+
+func NewRedisQueue(redisURL string) (*RedisQueue, error) {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opt)
+	return &RedisQueue{
+		QueuePolicy:      DefaultQueuePolicy(),
+		DeadLetterMaxLen: 10000,
+		client:           client,
+		consumer:         consumerName(),
+	}, nil
+}
+
+func (q *RedisQueue) Enqueue(ctx context.Context, message QueueMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if err := q.ensureGroup(ctx, message.TenantID); err != nil {
+		return err
+	}
+	return q.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: streamKey(message.TenantID),
+		Values: map[string]any{"payload": payload},
+	}).Err()
+}
+
+// promoteScript moves retries whose backoff has elapsed back onto the stream,
+// along with anything still sitting in the old LPUSH list. Retry members are
+// unwrapped as in retryPayload.
+var promoteScript = redis.NewScript(`
+local moved = 0
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+for _, member in ipairs(due) do
+  local payload = member
+  if string.sub(member, 1, 1) ~= '{' then
+    payload = string.sub(member, string.find(member, '|', 1, true) + 1)
+  end
+  redis.call('XADD', KEYS[2], '*', 'payload', payload)
+  redis.call('ZREM', KEYS[1], member)
+  moved = moved + 1
+end
+for i = 1, tonumber(ARGV[2]) do
+  local payload = redis.call('RPOP', KEYS[3])
+  if not payload then break end
+  redis.call('XADD', KEYS[2], '*', 'payload', payload)
+  moved = moved + 1
+end
+return moved
+`)
+
+func (q *RedisQueue) Dequeue(ctx context.Context, tenantID int64, batchSize int) ([]QueueDelivery, error) {
+	if err := q.ensureGroup(ctx, tenantID); err != nil {
+		return nil, err
+	}
+	stream := streamKey(tenantID)
+	keys := []string{retryKey(tenantID), stream, queueKey(tenantID)}
+	if err := promoteScript.Run(ctx, q.client, keys, time.Now().UnixMilli(), batchSize).Err(); err != nil && err != redis.Nil {
+		return nil, q.checkGroup(tenantID, err)
+	}
+
+	claimed, _, err := q.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   stream,
+		Group:    queueGroup,
+		MinIdle:  q.VisibilityTimeout,
+		Start:    "0-0",
+		Count:    int64(batchSize),
+		Consumer: q.consumer,
+	}).Result()
+	if err != nil {
+		return nil, q.checkGroup(tenantID, err)
+	}
+	for _, entry := range claimed {
+		// An entry that outlived its visibility timeout counts as a failed
+		// attempt so a message that keeps killing workers ends up dead-lettered.
+		if delivery, ok := q.decode(ctx, tenantID, entry); ok {
+			if _, err := q.Retry(ctx, delivery, errVisibilityTimeout); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	streams, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    queueGroup,
+		Consumer: q.consumer,
+		Streams:  []string{stream, ">"},
+		Count:    int64(batchSize),
+		Block:    -1,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, q.checkGroup(tenantID, err)
+	}
+	var deliveries []QueueDelivery
+	for _, s := range streams {
+		for _, entry := range s.Messages {
+			if delivery, ok := q.decode(ctx, tenantID, entry); ok {
+				deliveries = append(deliveries, delivery)
+			}
+		}
+	}
+	return deliveries, nil
+}
+
+func (q *RedisQueue) Ack(ctx context.Context, delivery QueueDelivery) error {
+	tenantID := delivery.Message.TenantID
+	counter := processedKey(tenantID, time.Now())
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		q.remove(ctx, pipe, tenantID, delivery.ID)
+		pipe.Incr(ctx, counter)
+		pipe.Expire(ctx, counter, 2*time.Hour)
+		return nil
+	})
+	return err
+}
+
+// Retry schedules the delivery again after a backoff, or dead-letters it once
+// MaxAttempts is reached. It reports whether the message was dead-lettered.
+func (q *RedisQueue) Retry(ctx context.Context, delivery QueueDelivery, cause error) (bool, error) {
+	msg := delivery.Message
+	msg.Attempts++
+	msg.LastError = errorString(cause)
+	if msg.Attempts >= q.MaxAttempts {
+		delivery.Message = msg
+		return true, q.DeadLetter(ctx, delivery, cause)
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return false, err
+	}
+	due := time.Now().Add(q.Backoff(msg.Attempts))
+	_, err = q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, retryKey(msg.TenantID), redis.Z{Score: float64(due.UnixMilli()), Member: retryMember(delivery.ID, payload)})
+		q.remove(ctx, pipe, msg.TenantID, delivery.ID)
+		return nil
+	})
+	return false, err
+}
+
+func (q *RedisQueue) DeadLetter(ctx context.Context, delivery QueueDelivery, cause error) error {
+	msg := delivery.Message
+	msg.LastError = errorString(cause)
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return q.deadLetterPayload(ctx, msg.TenantID, delivery.ID, payload, msg.LastError)
+}
+
+func (q *RedisQueue) deadLetterPayload(ctx context.Context, tenantID int64, id string, payload []byte, reason string) error {
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: deadLetterKey(tenantID),
+			MaxLen: q.DeadLetterMaxLen,
+			Approx: true,
+			Values: map[string]any{
+				"payload":   payload,
+				"error":     reason,
+				"failed_at": time.Now().UTC().Format(time.RFC3339),
+			},
+		})
+		q.remove(ctx, pipe, tenantID, id)
+		return nil
+	})
+	return err
+}
+
+func (q *RedisQueue) remove(ctx context.Context, pipe redis.Pipeliner, tenantID int64, id string) {
+	stream := streamKey(tenantID)
+	pipe.XAck(ctx, stream, queueGroup, id)
+	pipe.XDel(ctx, stream, id)
+}
+
+// decode turns a stream entry into a delivery. Entries that cannot be decoded
+// will never succeed, so they go straight to the dead-letter stream.
+func (q *RedisQueue) decode(ctx context.Context, tenantID int64, entry redis.XMessage) (QueueDelivery, bool) {
+	raw, _ := entry.Values["payload"].(string)
+	var msg QueueMessage
+	if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+		_ = q.deadLetterPayload(ctx, tenantID, entry.ID, []byte(raw), "decode: "+err.Error())
+		return QueueDelivery{}, false
+	}
+	msg.TenantID = tenantID
+	return QueueDelivery{ID: entry.ID, Message: msg}, true
+}
+
+func (q *RedisQueue) ensureGroup(ctx context.Context, tenantID int64) error {
+	if _, ok := q.groups.Load(tenantID); ok {
+		return nil
+	}
+	err := q.client.XGroupCreateMkStream(ctx, streamKey(tenantID), queueGroup, "0").Err()
+	if err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	q.groups.Store(tenantID, true)
+	return nil
+}
+
+// checkGroup forgets the consumer group when Redis reports it missing (for
+// example after the stream key was deleted) so the next call recreates it.
+func (q *RedisQueue) checkGroup(tenantID int64, err error) error {
+	if strings.Contains(err.Error(), "NOGROUP") {
+		q.groups.Delete(tenantID)
+	}
+	return err
+}
+
+func (q *RedisQueue) Stats(ctx context.Context, tenantID int64) (QueueStats, error) {
+	stats := QueueStats{Backend: "redis"}
+	if err := q.ensureGroup(ctx, tenantID); err != nil {
+		return stats, err
+	}
+	now := time.Now()
+	counters := make([]string, 0, 60)
+	for i := 0; i < 60; i++ {
+		counters = append(counters, processedKey(tenantID, now.Add(-time.Duration(i)*time.Minute)))
+	}
+
+	pipe := q.client.Pipeline()
+	length := pipe.XLen(ctx, streamKey(tenantID))
+	pending := pipe.XPending(ctx, streamKey(tenantID), queueGroup)
+	scheduled := pipe.ZCard(ctx, retryKey(tenantID))
+	legacy := pipe.LLen(ctx, queueKey(tenantID))
+	dead := pipe.XLen(ctx, deadLetterKey(tenantID))
+	processed := pipe.MGet(ctx, counters...)
+	oldest := pipe.XRangeN(ctx, streamKey(tenantID), "-", "+", 1)
+	nextRetry := pipe.ZRange(ctx, retryKey(tenantID), 0, 0)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return stats, q.checkGroup(tenantID, err)
+	}
+
+	stats.InFlight = pending.Val().Count
+	stats.Ready = length.Val() - stats.InFlight + legacy.Val()
+	stats.Scheduled = scheduled.Val()
+	stats.DeadLetters = dead.Val()
+	for _, value := range processed.Val() {
+		if text, ok := value.(string); ok {
+			count, _ := strconv.ParseInt(text, 10, 64)
+			stats.ProcessedLastHour += count
+		}
+	}
+	var candidates []string
+	for _, entry := range oldest.Val() {
+		raw, _ := entry.Values["payload"].(string)
+		candidates = append(candidates, raw)
+	}
+	for _, member := range nextRetry.Val() {
+		candidates = append(candidates, retryPayload(member))
+	}
+	for _, raw := range candidates {
+		var msg QueueMessage
+		if json.Unmarshal([]byte(raw), &msg) != nil || msg.CreatedAt.IsZero() {
+			continue
+		}
+		if stats.OldestEnqueuedAt == nil || msg.CreatedAt.Before(*stats.OldestEnqueuedAt) {
+			createdAt := msg.CreatedAt
+			stats.OldestEnqueuedAt = &createdAt
+		}
+	}
+	return stats, nil
+}
+
+func (q *RedisQueue) DeadLetters(ctx context.Context, tenantID int64, limit int) ([]DeadLetter, error) {
+	entries, err := q.client.XRevRangeN(ctx, deadLetterKey(tenantID), "+", "-", int64(limit)).Result()
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]DeadLetter, 0, len(entries))
+	for _, entry := range entries {
+		letters = append(letters, parseDeadLetter(tenantID, entry))
+	}
+	return letters, nil
+}
+
+func parseDeadLetter(tenantID int64, entry redis.XMessage) DeadLetter {
+	letter := DeadLetter{ID: entry.ID}
+	letter.Error, _ = entry.Values["error"].(string)
+	if value, ok := entry.Values["failed_at"].(string); ok {
+		if failedAt, err := time.Parse(time.RFC3339, value); err == nil {
+			letter.FailedAt = &failedAt
+		}
+	}
+	raw, _ := entry.Values["payload"].(string)
+	if err := json.Unmarshal([]byte(raw), &letter.Message); err != nil {
+		letter.Payload = raw
+	}
+	letter.Message.TenantID = tenantID
+	return letter
+}
+
+func (q *RedisQueue) Replay(ctx context.Context, tenantID int64, ids []string) (int, error) {
+	if err := q.ensureGroup(ctx, tenantID); err != nil {
+		return 0, err
+	}
+	replayed := 0
+	err := q.eachDeadLetter(ctx, tenantID, ids, func(entry redis.XMessage) error {
+		letter := parseDeadLetter(tenantID, entry)
+		if letter.Payload != "" {
+			// Undecodable entries can only be purged.
+			return nil
+		}
+		msg := letter.Message
+		msg.Attempts = 0
+		msg.LastError = ""
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		_, err = q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.XAdd(ctx, &redis.XAddArgs{Stream: streamKey(tenantID), Values: map[string]any{"payload": payload}})
+			pipe.XDel(ctx, deadLetterKey(tenantID), entry.ID)
+			return nil
+		})
+		if err == nil {
+			replayed++
+		}
+		return err
+	})
+	return replayed, err
+}
+
+func (q *RedisQueue) Purge(ctx context.Context, tenantID int64, ids []string) (int, error) {
+	key := deadLetterKey(tenantID)
+	if ids == nil {
+		var count *redis.IntCmd
+		_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			count = pipe.XLen(ctx, key)
+			pipe.Del(ctx, key)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		return int(count.Val()), nil
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	deleted, err := q.client.XDel(ctx, key, ids...).Result()
+	return int(deleted), err
+}
+
+// eachDeadLetter visits the given dead letters, or all of them in pages when
+// ids is nil.
+func (q *RedisQueue) eachDeadLetter(ctx context.Context, tenantID int64, ids []string, fn func(redis.XMessage) error) error {
+	key := deadLetterKey(tenantID)
+	if ids != nil {
+		for _, id := range ids {
+			entries, err := q.client.XRange(ctx, key, id, id).Result()
+			if err != nil {
+				return err
+			}
+			for _, entry := range entries {
+				if err := fn(entry); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	start := "-"
+	for {
+		entries, err := q.client.XRangeN(ctx, key, start, "+", 100).Result()
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		for _, entry := range entries {
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		start = "(" + entries[len(entries)-1].ID
+	}
+}
+
+func streamKey(tenantID int64) string {
+	return "llm:stream:" + fmtInt(tenantID)
+}
+
+// retryMember is the retry set member of a delivery: its ID and payload, so
+// deliveries with equal payloads are scheduled separately.
+func retryMember(id string, payload []byte) string {
+	return id + "|" + string(payload)
+}
+
+// retryPayload returns the payload of a retry set member. Members scheduled
+// before they carried an ID are bare JSON payloads.
+func retryPayload(member string) string {
+	if strings.HasPrefix(member, "{") {
+		return member
+	}
+	if _, payload, ok := strings.Cut(member, "|"); ok {
+		return payload
+	}
+	return member
+}
+
+func retryKey(tenantID int64) string {
+	return "llm:retry:" + fmtInt(tenantID)
+}
+
+func processedKey(tenantID int64, at time.Time) string {
+	return "llm:processed:" + fmtInt(tenantID) + ":" + fmtInt(at.Unix()/60)
+}
+
+func deadLetterKey(tenantID int64) string {
+	return "llm:dead:" + fmtInt(tenantID)
+}
+
+// queueKey is the list used before the queue moved to streams. Leftover
+// entries are drained into the stream by Dequeue.
+func queueKey(tenantID int64) string {
+	return "llm:queue:" + fmtInt(tenantID)
+}
+
+func consumerName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "worker"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
@@ -8,16 +8,23 @@ import (
 	"time"
 )
 
+// ErrNoProviders means the tenant has no usable provider configured for a
+// feature, as opposed to configured providers that are currently failing.
+var ErrNoProviders = errors.New("no providers available")
+
 type Router struct {
-	factory *Factory
-	cache   *cache
-	db      ProviderStore
+	factory  *Factory
+	cache    *cache
+	db       ProviderStore
+	Breakers *CircuitBreakers
 }
 
 type ProviderStore interface {
 	ListProviders(ctx context.Context, tenantID int64) ([]ProviderConfig, error)
 	GetDefaultProvider(ctx context.Context, tenantID int64) (*ProviderConfig, error)
 	GetProviderByID(ctx context.Context, tenantID int64, providerID int64) (*ProviderConfig, error)
+	ListFeatureProviders(ctx context.Context, tenantID int64, feature string) ([]ProviderConfig, error)
+	ListFallbackProviders(ctx context.Context, tenantID int64) ([]ProviderConfig, error)
 }
 
 type cachedProvider struct {
@@ -46,6 +53,12 @@ func (c *cache) get(key string) (Provider, bool) {
 	return item.provider, true
 }
 
+func (c *cache) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.items, key)
+}
+
 func (c *cache) set(key string, provider Provider) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -56,6 +69,19 @@ func NewRouter(factory *Factory, store ProviderStore) *Router {
 	return &Router{factory: factory, cache: newCache(5 * time.Minute), db: store}
 }
 
+// Evict forgets a provider that was changed or deleted, and the tenant's
+// default provider, which it may have been or become.
+func (r *Router) Evict(tenantID, providerID int64) {
+	r.cache.delete(cacheKey(tenantID, providerID))
+	r.cache.delete(cacheKey(tenantID, 0))
+	r.factory.Evict(providerID)
+}
+
+// ValidateProvider checks a provider configuration before it is stored.
+func (r *Router) ValidateProvider(config *ProviderConfig) error {
+	return r.factory.Validate(config)
+}
+
 func (r *Router) GetProvider(ctx context.Context, tenantID, providerID int64) (Provider, error) {
 	key := cacheKey(tenantID, providerID)
 	if provider, ok := r.cache.get(key); ok {
@@ -101,29 +127,68 @@ func (r *Router) GetDefaultProvider(ctx context.Context, tenantID int64) (Provid
 }
 
 func (r *Router) GetProviderForFeature(ctx context.Context, tenantID int64, feature string) (Provider, error) {
-	provider, err := r.GetDefaultProvider(ctx, tenantID)
+	chain, err := r.ProvidersForFeature(ctx, tenantID, feature, 0)
 	if err != nil {
 		return nil, err
 	}
-	return provider, nil
+	return chain[0], nil
 }
 
-func (r *Router) AnalyzeWithFallback(ctx context.Context, tenantID int64, message string) (*AnalysisResult, Provider, int64, error) {
-	configs, err := r.db.ListProviders(ctx, tenantID)
+// ProvidersForFeature returns the providers to try for a feature, in order:
+// the preferred provider when one is given, the providers assigned to the
+// feature by priority (or the default provider when none are assigned), and
+// finally the tenant's fallback providers. Providers with an open circuit are
+// left out.
+func (r *Router) ProvidersForFeature(ctx context.Context, tenantID int64, feature string, preferredID int64) ([]Provider, error) {
+	var chain []Provider
+	seen := map[int64]bool{}
+	add := func(provider Provider) {
+		id := provider.GetConfig().ID
+		if seen[id] {
+			return
+		}
+		seen[id] = true
+		if r.Breakers != nil && !r.Breakers.Available(tenantID, id) {
+			return
+		}
+		chain = append(chain, provider)
+	}
+	addConfigs := func(configs []ProviderConfig) {
+		for i := range configs {
+			if provider := r.factory.CreateProvider(&configs[i]); provider != nil {
+				add(provider)
+			}
+		}
+	}
+
+	if preferredID != 0 {
+		if provider, err := r.GetProvider(ctx, tenantID, preferredID); err == nil {
+			add(provider)
+		}
+	}
+	assigned, err := r.db.ListFeatureProviders(ctx, tenantID, feature)
 	if err != nil {
-		return fallbackAnalysis(message), nil, 0, err
+		return nil, err
 	}
-	for _, cfg := range configs {
-		provider := r.factory.CreateProvider(&cfg)
-		if provider == nil {
-			continue
+	addConfigs(assigned)
+	if len(assigned) == 0 {
+		if provider, err := r.GetDefaultProvider(ctx, tenantID); err == nil {
+			add(provider)
 		}
-		result, err := provider.Analyze(ctx, message)
-		if err == nil {
-			return result, provider, cfg.ID, nil
+	}
+	fallbacks, err := r.db.ListFallbackProviders(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	addConfigs(fallbacks)
+
+	if len(chain) == 0 {
+		if len(seen) > 0 {
+			return nil, errors.New("all provider circuits are open")
 		}
+		return nil, ErrNoProviders
 	}
-	return fallbackAnalysis(message), nil, 0, errors.New("all providers failed")
+	return chain, nil
 }
 
 func cacheKey(tenantID, providerID int64) string {
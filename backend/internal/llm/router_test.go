@@ -0,0 +1,117 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type fakeProviderStore struct {
+	providers map[int64]ProviderConfig
+	defaultID int64
+	assigned  map[string][]int64
+	fallbacks []int64
+}
+
+func (f *fakeProviderStore) configs(ids []int64) []ProviderConfig {
+	var configs []ProviderConfig
+	for _, id := range ids {
+		configs = append(configs, f.providers[id])
+	}
+	return configs
+}
+
+func (f *fakeProviderStore) ListProviders(ctx context.Context, tenantID int64) ([]ProviderConfig, error) {
+	var configs []ProviderConfig
+	for _, cfg := range f.providers {
+		configs = append(configs, cfg)
+	}
+	return configs, nil
+}
+
+func (f *fakeProviderStore) GetDefaultProvider(ctx context.Context, tenantID int64) (*ProviderConfig, error) {
+	cfg, ok := f.providers[f.defaultID]
+	if !ok {
+		return nil, errors.New("no default")
+	}
+	return &cfg, nil
+}
+
+func (f *fakeProviderStore) GetProviderByID(ctx context.Context, tenantID, providerID int64) (*ProviderConfig, error) {
+	cfg, ok := f.providers[providerID]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return &cfg, nil
+}
+
+func (f *fakeProviderStore) ListFeatureProviders(ctx context.Context, tenantID int64, feature string) ([]ProviderConfig, error) {
+	return f.configs(f.assigned[feature]), nil
+}
+
+func (f *fakeProviderStore) ListFallbackProviders(ctx context.Context, tenantID int64) ([]ProviderConfig, error) {
+	return f.configs(f.fallbacks), nil
+}
+
+func newFakeProviderStore() *fakeProviderStore {
+	return &fakeProviderStore{
+		providers: map[int64]ProviderConfig{
+			1: {ID: 1, ProviderName: "openai", ModelName: "gpt-4o"},
+			2: {ID: 2, ProviderName: "claude", ModelName: "claude-3-5-sonnet"},
+			3: {ID: 3, ProviderName: "cohere", ModelName: "command-r-plus"},
+			4: {ID: 4, ProviderName: "openai", ModelName: "gpt-4o"},
+		},
+		defaultID: 1,
+		assigned: map[string][]int64{
+			FeatureSummarization: {3, 2},
+		},
+		fallbacks: []int64{4, 2},
+	}
+}
+
+func chainIDs(chain []Provider) []int64 {
+	ids := make([]int64, 0, len(chain))
+	for _, provider := range chain {
+		ids = append(ids, provider.GetConfig().ID)
+	}
+	return ids
+}
+
+func assertIDs(t *testing.T, got, want []int64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+}
+
+func TestProvidersForFeatureUsesAssignmentsThenFallbacks(t *testing.T) {
+	router := NewRouter(NewFactory(nil), newFakeProviderStore())
+	chain, err := router.ProvidersForFeature(context.Background(), 1, FeatureSummarization, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertIDs(t, chainIDs(chain), []int64{3, 2, 4})
+}
+
+func TestProvidersForFeatureUsesDefaultWhenUnassigned(t *testing.T) {
+	router := NewRouter(NewFactory(nil), newFakeProviderStore())
+	chain, err := router.ProvidersForFeature(context.Background(), 1, FeatureImportanceDetection, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertIDs(t, chainIDs(chain), []int64{1, 4, 2})
+}
+
+func TestProvidersForFeaturePreferredFirst(t *testing.T) {
+	router := NewRouter(NewFactory(nil), newFakeProviderStore())
+	chain, err := router.ProvidersForFeature(context.Background(), 1, FeatureSummarization, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertIDs(t, chainIDs(chain), []int64{2, 3, 4})
+}
@@ -0,0 +1,385 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// scoreAnalyses is how many of the latest analyzed inbound messages the
+	// priority and sentiment factors look at.
+	scoreAnalyses = 10
+	// scoreExplainMessages are the latest messages sent with an explanation
+	// request.
+	scoreExplainMessages = 12
+	// ScoreMaxAge is how long a score of a conversation that is waiting for
+	// a reply is used before it is recomputed, as the wait keeps growing.
+	// staleScoreRefreshes bounds the scores recomputed for one listing, and
+	// StaleScoreTimeout the time a listing spends on them.
+	ScoreMaxAge         = 15 * time.Minute
+	staleScoreRefreshes = 20
+	StaleScoreTimeout   = time.Second
+)
+
+// outboundSenders are the senders of messages that are not from the
+// contact: agents' replies, the tenant's own WhatsApp messages and system
+// messages. It is written as a SQL list for sender IN and NOT IN.
+const outboundSenders = `('agent', 'me', 'system')`
+
+// Points each factor can add to a conversation score; they sum to 100.
+const (
+	priorityPoints       = 30
+	sentimentPoints      = 15
+	sentimentTrendPoints = 10
+	unansweredPoints     = 20
+	actionItemPoints     = 15
+	replyAgePoints       = 10
+)
+
+// Where a factor reaches its full points.
+const (
+	unansweredCap = 5
+	actionItemCap = 3
+	replyAgeCap   = 48 * time.Hour
+)
+
+// ScoreAnalysis is the part of a message analysis a score uses.
+type ScoreAnalysis struct {
+	Priority       string
+	Sentiment      string
+	SentimentScore float64
+}
+
+// ScoreSignals are what a conversation score is computed from. Analyses are
+// those of the latest inbound messages, newest first. Unanswered counts the
+// inbound messages since the last reply and WaitingSince is the first of
+// them; LastReplyAt is nil when nobody has replied yet.
+type ScoreSignals struct {
+	Analyses        []ScoreAnalysis
+	Unanswered      int
+	WaitingSince    *time.Time
+	LastReplyAt     *time.Time
+	OpenActionItems int
+}
+
+// ScoreFactor is one signal's part of a score: its value and the points it
+// added out of Max.
+type ScoreFactor struct {
+	Name   string  `json:"name"`
+	Value  float64 `json:"value"`
+	Points float64 `json:"points"`
+	Max    float64 `json:"max"`
+}
+
+// ConversationScore rates from 0 to 100 how urgently a conversation needs
+// attention. Explanation is a model's explanation of this score, if one was
+// asked for since it was computed.
+type ConversationScore struct {
+	ConversationID int64         `json:"conversation_id"`
+	Score          float64       `json:"score"`
+	Factors        []ScoreFactor `json:"factors"`
+	Explanation    *string       `json:"explanation"`
+	ScoredAt       time.Time     `json:"scored_at"`
+}
+
+// ScoreConversation combines the signals into a score:
+//   - priority: the recency-weighted priority of the latest analyses, high
+//     counting 1, medium 0.5 and low 0;
+//   - sentiment: how negative the newer half of the analyses is;
+//   - sentiment_trend: how far it fell compared with the older half;
+//   - unanswered: inbound messages since the last reply;
+//   - open_action_items: action items not done;
+//   - last_reply_age: hours since the last reply, or since the first
+//     message when nobody replied, counted only while messages wait.
+func ScoreConversation(signals ScoreSignals, now time.Time) ConversationScore {
+	var priority, weights float64
+	for i, analysis := range signals.Analyses {
+		weight := math.Pow(0.8, float64(i))
+		priority += priorityWeight(analysis.Priority) * weight
+		weights += weight
+	}
+	if weights > 0 {
+		priority /= weights
+	}
+
+	var recent, earlier, trend float64
+	half := (len(signals.Analyses) + 1) / 2
+	for i, analysis := range signals.Analyses {
+		value := sentimentValue(analysis.Sentiment, analysis.SentimentScore)
+		if i < half {
+			recent += value
+		} else {
+			earlier += value
+		}
+	}
+	if half > 0 {
+		recent /= float64(half)
+	}
+	if rest := len(signals.Analyses) - half; rest > 0 {
+		earlier /= float64(rest)
+		trend = recent - earlier
+	}
+
+	var replyAge float64
+	if signals.Unanswered > 0 {
+		since := signals.LastReplyAt
+		if since == nil {
+			since = signals.WaitingSince
+		}
+		if since != nil && now.After(*since) {
+			replyAge = now.Sub(*since).Hours()
+		}
+	}
+
+	factors := []ScoreFactor{
+		{Name: "priority", Value: priority, Points: priorityPoints * priority, Max: priorityPoints},
+		{Name: "sentiment", Value: recent, Points: sentimentPoints * clamp01(-recent), Max: sentimentPoints},
+		{Name: "sentiment_trend", Value: trend, Points: sentimentTrendPoints * clamp01(-trend/2), Max: sentimentTrendPoints},
+		{Name: "unanswered", Value: float64(signals.Unanswered), Points: unansweredPoints * clamp01(float64(signals.Unanswered)/unansweredCap), Max: unansweredPoints},
+		{Name: "open_action_items", Value: float64(signals.OpenActionItems), Points: actionItemPoints * clamp01(float64(signals.OpenActionItems)/actionItemCap), Max: actionItemPoints},
+		{Name: "last_reply_age", Value: replyAge, Points: replyAgePoints * clamp01(replyAge/replyAgeCap.Hours()), Max: replyAgePoints},
+	}
+	var score float64
+	for i := range factors {
+		factors[i].Value = round1(factors[i].Value)
+		factors[i].Points = round1(factors[i].Points)
+		score += factors[i].Points
+	}
+	return ConversationScore{Score: round1(math.Min(score, 100)), Factors: factors, ScoredAt: now}
+}
+
+func priorityWeight(priority string) float64 {
+	switch strings.ToLower(priority) {
+	case "high":
+		return 1
+	case "medium":
+		return 0.5
+	default:
+		return 0
+	}
+}
+
+func clamp01(value float64) float64 {
+	return math.Max(0, math.Min(1, value))
+}
+
+func round1(value float64) float64 {
+	return math.Round(value*10) / 10
+}
+
+// RefreshConversationScore recomputes and stores the score of a
+// conversation. A missing conversation is pgx.ErrNoRows.
+func (s *Store) RefreshConversationScore(ctx context.Context, tenantID, conversationID int64) (*ConversationScore, error) {
+	var score *ConversationScore
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		var err error
+		score, err = refreshConversationScore(ctx, conn, tenantID, conversationID)
+		return err
+	})
+	return score, err
+}
+
+// refreshConversationScore scores a conversation from its stored messages
+// and action items. An explanation is kept only while the score it
+// explains stays the same.
+func refreshConversationScore(ctx context.Context, conn *pgxpool.Conn, tenantID, conversationID int64) (*ConversationScore, error) {
+	// Postgres keeps microseconds; saveScoreExplanation matches on scored_at.
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	var signals ScoreSignals
+	if err := conn.QueryRow(ctx, `
+		SELECT (SELECT MAX(timestamp) FROM messages
+		        WHERE tenant_id=$1 AND conversation_id=$2 AND sender IN `+outboundSenders+`),
+		       (SELECT COUNT(*) FROM action_items
+		        WHERE tenant_id=$1 AND conversation_id=$2 AND status NOT IN ('done', 'completed'))
+		FROM conversations WHERE tenant_id=$1 AND id=$2`, tenantID, conversationID).Scan(&signals.LastReplyAt, &signals.OpenActionItems); err != nil {
+		return nil, err
+	}
+	if err := conn.QueryRow(ctx, `
+		SELECT COUNT(*), MIN(timestamp) FROM messages
+		WHERE tenant_id=$1 AND conversation_id=$2 AND sender NOT IN `+outboundSenders+`
+		  AND timestamp > COALESCE($3::TIMESTAMPTZ, '-infinity')`, tenantID, conversationID, signals.LastReplyAt).Scan(&signals.Unanswered, &signals.WaitingSince); err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.Query(ctx, `
+		SELECT metadata_json->'analysis' FROM messages
+		WHERE tenant_id=$1 AND conversation_id=$2 AND sender NOT IN `+outboundSenders+` AND metadata_json ? 'analysis'
+		ORDER BY timestamp DESC
+		LIMIT $3`, tenantID, conversationID, scoreAnalyses)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		var analysis AnalysisResult
+		if json.Unmarshal(raw, &analysis) == nil {
+			signals.Analyses = append(signals.Analyses, ScoreAnalysis{Priority: analysis.Priority, Sentiment: analysis.Sentiment, SentimentScore: analysis.SentimentScore})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	score := ScoreConversation(signals, now)
+	score.ConversationID = conversationID
+	factors, err := json.Marshal(score.Factors)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.QueryRow(ctx, `
+		UPDATE conversations
+		SET score_explanation = CASE WHEN score IS DISTINCT FROM $3 THEN NULL ELSE score_explanation END,
+		    score=$3, score_factors=$4, scored_at=$5
+		WHERE tenant_id=$1 AND id=$2
+		RETURNING score_explanation`, tenantID, conversationID, score.Score, string(factors), now).Scan(&score.Explanation); err != nil {
+		return nil, err
+	}
+	return &score, nil
+}
+
+// RefreshStaleScores recomputes the scores of the tenant's conversations
+// that are waiting for a reply and were scored more than ScoreMaxAge ago,
+// the oldest first.
+func (s *Store) RefreshStaleScores(ctx context.Context, tenantID int64) error {
+	return s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			SELECT id FROM conversations
+			WHERE tenant_id=$1 AND scored_at < $2
+			  AND jsonb_path_exists(score_factors, '$[*] ? (@.name == "unanswered" && @.value > 0)')
+			ORDER BY scored_at ASC
+			LIMIT $3`, tenantID, time.Now().UTC().Add(-ScoreMaxAge), staleScoreRefreshes)
+		if err != nil {
+			return err
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if _, err := refreshConversationScore(ctx, conn, tenantID, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ConversationScore returns the stored score of a conversation, computing
+// it first when it was never scored or is older than ScoreMaxAge. A missing
+// conversation is pgx.ErrNoRows.
+func (s *Store) ConversationScore(ctx context.Context, tenantID, conversationID int64) (*ConversationScore, error) {
+	var score *float64
+	var factors []byte
+	var explanation *string
+	var scoredAt *time.Time
+	if err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		return conn.QueryRow(ctx, `
+			SELECT score, score_factors, score_explanation, scored_at FROM conversations
+			WHERE tenant_id=$1 AND id=$2`, tenantID, conversationID).Scan(&score, &factors, &explanation, &scoredAt)
+	}); err != nil {
+		return nil, err
+	}
+	if score == nil || scoredAt == nil || time.Since(*scoredAt) > ScoreMaxAge {
+		return s.RefreshConversationScore(ctx, tenantID, conversationID)
+	}
+	result := &ConversationScore{ConversationID: conversationID, Score: *score, Factors: []ScoreFactor{}, Explanation: explanation, ScoredAt: *scoredAt}
+	if len(factors) > 0 {
+		_ = json.Unmarshal(factors, &result.Factors)
+	}
+	return result, nil
+}
+
+// saveScoreExplanation stores an explanation unless the score was
+// recomputed in the meantime.
+func (s *Store) saveScoreExplanation(ctx context.Context, tenantID int64, score *ConversationScore, explanation string) error {
+	return s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		_, err := conn.Exec(ctx, `
+			UPDATE conversations SET score_explanation=$3
+			WHERE tenant_id=$1 AND id=$2 AND scored_at=$4`, tenantID, score.ConversationID, explanation, score.ScoredAt)
+		return err
+	})
+}
+
+// ExplainConversationScore recomputes the score of a conversation and asks
+// a model to explain it from the score's factors, the latest messages and
+// what is known about the conversation. Providers that cannot answer
+// questions are skipped.
+func (s *Service) ExplainConversationScore(ctx context.Context, tenantID, conversationID, providerID int64) (*ConversationScore, error) {
+	score, err := s.Store.RefreshConversationScore(ctx, tenantID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	request := QuestionRequest{
+		Question: fmt.Sprintf("This conversation has an attention score of %.0f out of 100; higher means it needs attention sooner. "+
+			"In two or three sentences, explain to a support agent what drives the score and what to do next.", score.Score),
+	}
+	if request.Messages, err = s.Store.RecentMessages(ctx, tenantID, conversationID, scoreExplainMessages); err != nil {
+		return nil, err
+	}
+	if len(request.Messages) == 0 {
+		return nil, ErrEmptyConversation
+	}
+	for _, factor := range score.Factors {
+		request.Notes = append(request.Notes, fmt.Sprintf("Score factor %s: value %g, %g of %g points", factor.Name, factor.Value, factor.Points, factor.Max))
+	}
+	notes, err := s.Store.ConversationNotes(ctx, tenantID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	request.Notes = append(request.Notes, notes...)
+	if request.ContactName, err = s.Store.ConversationContactName(ctx, tenantID, conversationID); err != nil {
+		return nil, err
+	}
+
+	redaction, err := s.redaction(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	request = redactQuestion(redaction, request)
+	var result *AnswerResult
+	err = s.runFeature(ctx, tenantID, FeatureConversationScoring, providerID, nil, FeatureConversationScoring, nil, func(provider Provider) (UsageRecord, error) {
+		answerer, ok := provider.(QuestionAnswerer)
+		if !ok {
+			return UsageRecord{}, ErrAnswerUnsupported
+		}
+		var usage UsageRecord
+		var err error
+		result, usage, err = answerer.Answer(ctx, request)
+		if err == nil && strings.TrimSpace(result.Answer) == "" {
+			usage.ValidationErrors++
+			err = &ValidationError{Problems: []string{"explanation is empty"}}
+		}
+		return usage, err
+	})
+	s.logRedaction(ctx, tenantID, nil, FeatureConversationScoring, redaction)
+	if err != nil {
+		return nil, err
+	}
+
+	explanation := strings.TrimSpace(redaction.Restore(result.Answer))
+	if err := s.Store.saveScoreExplanation(ctx, tenantID, score, explanation); err != nil {
+		return nil, err
+	}
+	score.Explanation = &explanation
+	return score, nil
+}
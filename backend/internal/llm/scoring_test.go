@@ -0,0 +1,89 @@
+package llm
+
+import (
+	"testing"
+	"time"
+)
+
+func scoreFactor(t *testing.T, score ConversationScore, name string) ScoreFactor {
+	t.Helper()
+	for _, factor := range score.Factors {
+		if factor.Name == name {
+			return factor
+		}
+	}
+	t.Fatalf("factor %s missing: %+v", name, score.Factors)
+	return ScoreFactor{}
+}
+
+func TestScoreConversationQuiet(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if score := ScoreConversation(ScoreSignals{}, now); score.Score != 0 || len(score.Factors) != 6 {
+		t.Fatalf("empty conversation scored %+v", score)
+	}
+
+	replied := now.Add(-96 * time.Hour)
+	score := ScoreConversation(ScoreSignals{
+		Analyses:    []ScoreAnalysis{{Priority: "low", Sentiment: "positive"}, {Priority: "low", Sentiment: "neutral"}},
+		LastReplyAt: &replied,
+	}, now)
+	// An old reply does not count while nobody is waiting for the next one.
+	if score.Score != 0 {
+		t.Fatalf("answered low priority conversation scored %v: %+v", score.Score, score.Factors)
+	}
+}
+
+func TestScoreConversationNeedsAttention(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	replied := now.Add(-72 * time.Hour)
+	score := ScoreConversation(ScoreSignals{
+		Analyses: []ScoreAnalysis{
+			{Priority: "high", Sentiment: "negative", SentimentScore: -0.9},
+			{Priority: "HIGH", Sentiment: "negative", SentimentScore: -0.7},
+			{Priority: "medium", Sentiment: "neutral"},
+			{Priority: "low", Sentiment: "positive", SentimentScore: 0.8},
+		},
+		Unanswered:      6,
+		LastReplyAt:     &replied,
+		OpenActionItems: 3,
+	}, now)
+
+	// Priority weights newer analyses more: (1 + 0.8 + 0.5*0.64) / 2.952.
+	if factor := scoreFactor(t, score, "priority"); factor.Value != 0.7 || factor.Points != 21.5 {
+		t.Fatalf("priority factor = %+v", factor)
+	}
+	if factor := scoreFactor(t, score, "sentiment"); factor.Value != -0.8 || factor.Points != 12 {
+		t.Fatalf("sentiment factor = %+v", factor)
+	}
+	// From 0.4 in the older half to -0.8 in the newer one.
+	if factor := scoreFactor(t, score, "sentiment_trend"); factor.Value != -1.2 || factor.Points != 6 {
+		t.Fatalf("sentiment trend factor = %+v", factor)
+	}
+	if factor := scoreFactor(t, score, "unanswered"); factor.Points != factor.Max {
+		t.Fatalf("unanswered factor should be capped: %+v", factor)
+	}
+	if factor := scoreFactor(t, score, "open_action_items"); factor.Points != factor.Max {
+		t.Fatalf("action item factor should be capped: %+v", factor)
+	}
+	if factor := scoreFactor(t, score, "last_reply_age"); factor.Value != 72 || factor.Points != factor.Max {
+		t.Fatalf("reply age factor = %+v", factor)
+	}
+	if score.Score != 84.5 {
+		t.Fatalf("score = %v, want 84.5", score.Score)
+	}
+}
+
+func TestScoreConversationWaitingWithoutReply(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	waiting := now.Add(-12 * time.Hour)
+	score := ScoreConversation(ScoreSignals{Unanswered: 1, WaitingSince: &waiting}, now)
+	if factor := scoreFactor(t, score, "last_reply_age"); factor.Value != 12 || factor.Points != 2.5 {
+		t.Fatalf("reply age factor = %+v", factor)
+	}
+	if factor := scoreFactor(t, score, "unanswered"); factor.Points != 4 {
+		t.Fatalf("unanswered factor = %+v", factor)
+	}
+	if score.Score != 6.5 {
+		t.Fatalf("score = %v, want 6.5", score.Score)
+	}
+}
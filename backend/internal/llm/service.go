@@ -2,86 +2,348 @@ package llm
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	FeatureImportanceDetection = "importance_detection"
+	FeatureSummarization       = "summarization"
+	FeatureActionExtraction    = "action_extraction"
+	FeatureDailySummary        = "daily_summary"
+	FeatureConversationScoring = "conversation_scoring"
+	FeatureSemanticSearch      = "semantic_search"
+	FeatureConversationQA      = "conversation_qa"
+	FeatureReplyDrafting       = "reply_drafting"
+)
+
 type Service struct {
 	Router *Router
 	Store  *Store
-}
+	Budget *BudgetGuard
+	// SummaryChunkTokens caps the size of one summarization window; zero
+	// uses DefaultSummaryChunkTokens.
+	SummaryChunkTokens int
+	// Cache serves repeated analyses of the same content; nil disables it.
+	// Entries live for CacheTTL, or DefaultCacheTTL when zero.
+	Cache    ResponseCache
+	CacheTTL time.Duration
 
-type usageAware interface {
-	lastUsageRecord() UsageRecord
+	prompts        promptCache
+	redactors      redactorCache
+	shadowConfigs  shadowConfigCache
+	shadowInflight atomic.Int32
+	fewShot        fewShotCache
 }
 
 func NewService(router *Router, store *Store) *Service {
 	return &Service{Router: router, Store: store}
 }
 
-func (s *Service) Analyze(ctx context.Context, tenantID, providerID int64, message string, messageID *int64) (*AnalysisResult, error) {
-	provider, err := s.Router.GetProvider(ctx, tenantID, providerID)
+// Analyze classifies a message and returns the provider that did. Personal
+// data is redacted before the prompt is built; placeholders are restored in
+// action_required only, as the other fields are labels.
+func (s *Service) Analyze(ctx context.Context, tenantID, providerID int64, message string, messageID *int64) (*AnalysisResult, *AnalysisSource, error) {
+	redaction, err := s.redaction(ctx, tenantID)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	redacted := redaction.Redact(message)
+	var result *AnalysisResult
+	var source *AnalysisSource
+	options, version := s.promptOptions(ctx, tenantID, FeatureImportanceDetection, PromptData{Message: redacted}, messageID, redaction)
+	// Examples are redacted after the message so its placeholders, and with
+	// them the cache key, do not depend on the examples.
+	examples := s.fewShotExamples(ctx, tenantID, messageID)
+	for i := range examples {
+		examples[i].Message = redaction.Redact(examples[i].Message)
+	}
+	options.Examples = examples
+	cached := &cachedCall{
+		feature:       FeatureImportanceDetection,
+		promptVersion: version,
+		examples:      examplesFingerprint(examples),
+		prompt:        promptFingerprint(options.Prompt),
+		content:       message,
+		load:          func(raw []byte) error { return json.Unmarshal(raw, &result) },
+		store:         func() ([]byte, error) { return json.Marshal(result) },
+		hit: func(config *ProviderConfig, record UsageRecord) {
+			source = &AnalysisSource{ProviderID: config.ID, Model: record.Model}
+		},
+	}
+	var served shadowRun
+	err = s.runFeature(ctx, tenantID, FeatureImportanceDetection, providerID, messageID, "analyze", cached, func(provider Provider) (UsageRecord, error) {
+		var usage UsageRecord
+		var err error
+		start := time.Now()
+		result, usage, err = provider.Analyze(ctx, redacted, options)
+		usage.PromptVersion = version
+		if err == nil {
+			served.primary, served.usage = provider, completeUsage(usage, start, nil, "analyze")
+			source = &AnalysisSource{ProviderID: provider.GetConfig().ID, Model: usage.Model}
+		}
+		return usage, err
+	})
+	s.logRedaction(ctx, tenantID, messageID, "analyze", redaction)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Cached results were already compared when they were first produced.
+	if served.primary != nil {
+		s.shadowAnalysis(ctx, tenantID, messageID, "analyze", redacted, options, version, result, served)
+	}
+	result.ActionRequired = redaction.Restore(result.ActionRequired)
+	return result, source, nil
+}
+
+// AnalyzeWithFallback is Analyze falling back to keyword rules, with a nil
+// source, when no provider succeeds.
+func (s *Service) AnalyzeWithFallback(ctx context.Context, tenantID int64, message string, messageID *int64) (*AnalysisResult, *AnalysisSource, error) {
+	result, source, err := s.Analyze(ctx, tenantID, 0, message, messageID)
+	if err != nil {
+		return fallbackAnalysis(message), nil, nil
 	}
-	start := time.Now()
-	result, err := provider.Analyze(ctx, message)
-	record := usageFromProvider(provider, start, err, "analyze")
-	_ = s.Store.InsertUsage(ctx, tenantID, providerID, messageID, record, provider.GetConfig().CostPer1KInput, provider.GetConfig().CostPer1KOutput)
-	return result, err
+	return result, source, nil
 }
 
-func (s *Service) AnalyzeWithFallback(ctx context.Context, tenantID int64, message string, messageID *int64) (*AnalysisResult, error) {
-	result, provider, providerID, err := s.Router.AnalyzeWithFallback(ctx, tenantID, message)
-	if provider != nil {
-		record := usageFromProvider(provider, time.Now(), err, "analyze")
-		_ = s.Store.InsertUsage(ctx, tenantID, providerID, messageID, record, provider.GetConfig().CostPer1KInput, provider.GetConfig().CostPer1KOutput)
+// AnalyzeBatch analyzes several messages in one provider call and returns the
+// results keyed by message ID, with the provider that produced them.
+// Providers that cannot batch are skipped;
+// ErrBatchUnsupported means no provider in the chain could. Tenants with their
+// own importance prompt or with few-shot corrections always get
+// ErrBatchUnsupported, as the batch prompt cannot carry a per-message
+// template or examples.
+func (s *Service) AnalyzeBatch(ctx context.Context, tenantID int64, items []BatchItem) (map[int64]*AnalysisResult, *AnalysisSource, error) {
+	if s.Store != nil && s.Store.DB != nil {
+		if prompt, err := s.activePrompt(ctx, tenantID, FeatureImportanceDetection); err == nil && prompt.tmpl != nil {
+			return nil, nil, ErrBatchUnsupported
+		}
+		if len(s.fewShotExamples(ctx, tenantID, nil)) > 0 {
+			return nil, nil, ErrBatchUnsupported
+		}
+	}
+	redaction, err := s.redaction(ctx, tenantID)
+	if err != nil {
+		return nil, nil, err
 	}
-	if err != nil && result != nil {
-		return result, nil
+	redacted := make([]BatchItem, len(items))
+	for i, item := range items {
+		redacted[i] = BatchItem{MessageID: item.MessageID, Content: redaction.Redact(item.Content)}
+	}
+	var results map[int64]*AnalysisResult
+	var source *AnalysisSource
+	var served shadowRun
+	err = s.runFeature(ctx, tenantID, FeatureImportanceDetection, 0, nil, "analyze_batch", nil, func(provider Provider) (UsageRecord, error) {
+		batcher, ok := provider.(BatchAnalyzer)
+		if !ok {
+			return UsageRecord{}, ErrBatchUnsupported
+		}
+		var usage UsageRecord
+		var err error
+		start := time.Now()
+		results, usage, err = batcher.AnalyzeBatch(ctx, redacted)
+		if err == nil {
+			served.primary, served.usage = provider, completeUsage(usage, start, nil, "analyze_batch")
+			source = &AnalysisSource{ProviderID: provider.GetConfig().ID, Model: usage.Model}
+		}
+		return usage, err
+	})
+	s.logRedaction(ctx, tenantID, nil, "analyze_batch", redaction)
+	if err != nil {
+		return nil, nil, err
 	}
-	return result, err
+	if served.primary != nil && len(redacted) > 0 {
+		// Each message is shadowed on its own, against an even share of the
+		// batch's latency and cost.
+		share := splitUsage(served.usage, len(redacted))
+		for _, item := range redacted {
+			if result, ok := results[item.MessageID]; ok {
+				messageID := item.MessageID
+				s.shadowAnalysis(ctx, tenantID, &messageID, "analyze_batch", item.Content, CallOptions{}, 0, result, shadowRun{primary: served.primary, usage: share})
+			}
+		}
+	}
+	for _, result := range results {
+		result.ActionRequired = redaction.Restore(result.ActionRequired)
+	}
+	return results, source, nil
 }
 
 func (s *Service) Summarize(ctx context.Context, tenantID, providerID int64, messages []string) (*SummaryResult, error) {
-	provider, err := s.Router.GetProvider(ctx, tenantID, providerID)
+	return s.summarize(ctx, tenantID, providerID, FeatureSummarization, messages, "", "summarize")
+}
+
+// summarize runs one summarization call on the providers of feature. The
+// tenant's summarization prompt is used whatever the feature.
+func (s *Service) summarize(ctx context.Context, tenantID, providerID int64, feature string, messages []string, contactName, usageFeature string) (*SummaryResult, error) {
+	redaction, err := s.redaction(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	messages = redaction.RedactAll(messages)
+	var result *SummaryResult
+	data := PromptData{Messages: messages, Transcript: strings.Join(messages, "\n"), ContactName: contactName}
+	options, version := s.promptOptions(ctx, tenantID, FeatureSummarization, data, nil, redaction)
+	var served shadowRun
+	err = s.runFeature(ctx, tenantID, feature, providerID, nil, usageFeature, nil, func(provider Provider) (UsageRecord, error) {
+		var usage UsageRecord
+		var err error
+		start := time.Now()
+		result, usage, err = provider.Summarize(ctx, messages, options)
+		usage.PromptVersion = version
+		if err == nil {
+			served.primary, served.usage = provider, completeUsage(usage, start, nil, usageFeature)
+		}
+		return usage, err
+	})
+	s.logRedaction(ctx, tenantID, nil, usageFeature, redaction)
 	if err != nil {
 		return nil, err
 	}
-	start := time.Now()
-	result, err := provider.Summarize(ctx, messages)
-	record := usageFromProvider(provider, start, err, "summarize")
-	_ = s.Store.InsertUsage(ctx, tenantID, providerID, nil, record, provider.GetConfig().CostPer1KInput, provider.GetConfig().CostPer1KOutput)
-	return result, err
+	if served.primary != nil {
+		s.shadowSummary(ctx, tenantID, usageFeature, messages, options, version, result, served)
+	}
+	redaction.RestoreSummary(result)
+	return result, nil
 }
 
 func (s *Service) ExtractActions(ctx context.Context, tenantID, providerID int64, text string) ([]string, error) {
-	provider, err := s.Router.GetProvider(ctx, tenantID, providerID)
+	redaction, err := s.redaction(ctx, tenantID)
 	if err != nil {
 		return nil, err
 	}
-	start := time.Now()
-	result, err := provider.ExtractActions(ctx, text)
-	record := usageFromProvider(provider, start, err, "extract_actions")
-	_ = s.Store.InsertUsage(ctx, tenantID, providerID, nil, record, provider.GetConfig().CostPer1KInput, provider.GetConfig().CostPer1KOutput)
-	return result, err
+	text = redaction.Redact(text)
+	var result []string
+	options, version := s.promptOptions(ctx, tenantID, FeatureActionExtraction, PromptData{Message: text}, nil, redaction)
+	err = s.runFeature(ctx, tenantID, FeatureActionExtraction, providerID, nil, "extract_actions", nil, func(provider Provider) (UsageRecord, error) {
+		var usage UsageRecord
+		var err error
+		result, usage, err = provider.ExtractActions(ctx, text, options)
+		usage.PromptVersion = version
+		return usage, err
+	})
+	s.logRedaction(ctx, tenantID, nil, "extract_actions", redaction)
+	if err != nil {
+		return nil, err
+	}
+	redaction.RestoreAll(result)
+	return result, nil
 }
 
-func usageFromProvider(provider Provider, start time.Time, err error, feature string) UsageRecord {
-	if aware, ok := provider.(usageAware); ok {
-		record := aware.lastUsageRecord()
-		record.Feature = feature
-		if err != nil {
-			record.Success = false
-			record.ErrorMessage = err.Error()
+// usageLogTimeout bounds logging a call that outlived its context.
+const usageLogTimeout = 2 * time.Second
+
+// runFeature walks the provider chain for a feature until a call succeeds,
+// logging usage for every attempt. When cached is set, a cached result for a
+// provider is used instead of calling it.
+func (s *Service) runFeature(ctx context.Context, tenantID int64, feature string, providerID int64, messageID *int64, usageFeature string, cached *cachedCall, call func(Provider) (UsageRecord, error)) error {
+	chain, err := s.Router.ProvidersForFeature(ctx, tenantID, feature, providerID)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, provider := range chain {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		config := provider.GetConfig()
+		if record, ok := s.cacheLookup(ctx, tenantID, cached, config); ok {
+			// Hits are logged at no cost so the savings show up in analytics,
+			// and say nothing about the provider's health or budget.
+			record = completeUsage(record, time.Now(), nil, usageFeature)
+			if cached.hit != nil {
+				cached.hit(config, record)
+			}
+			s.logUsage(ctx, tenantID, config, messageID, record)
+			return nil
+		}
+		if s.Budget != nil {
+			if err := s.Budget.Allow(ctx, tenantID, config.ID); err != nil {
+				lastErr = err
+				continue
+			}
 		}
-		if record.InputTokens == 0 && record.OutputTokens == 0 {
-			record.Latency = time.Since(start)
+		breakers := s.Router.Breakers
+		if breakers != nil && !breakers.Allow(tenantID, config.ID) {
+			lastErr = circuitOpenError{providerID: config.ID}
+			continue
 		}
-		return record
+		start := time.Now()
+		record, err := call(provider)
+		// A deadline that passed while the provider was working counts against
+		// it, as hung providers are what the breaker is for; one that passed
+		// before the provider was reached is like a cancellation.
+		stopped := errors.Is(ctx.Err(), context.Canceled) || (ctx.Err() != nil && record.Attempts == 0)
+		if errors.Is(err, ErrRateLimited) || unsupported(err) || stopped {
+			// Throttled, unsupported or cancelled calls say nothing about the
+			// provider's health and were never served, so they are not logged.
+			if breakers != nil {
+				breakers.Release(tenantID, config.ID)
+			}
+			lastErr = err
+			continue
+		}
+		if breakers != nil {
+			if err == nil {
+				breakers.Success(tenantID, config.ID)
+			} else {
+				breakers.Failure(tenantID, config.ID, err)
+			}
+		}
+		record = completeUsage(record, start, err, usageFeature)
+		logCtx := ctx
+		if ctx.Err() != nil {
+			// The call ran out of time, which ends the chain; it is still logged.
+			var cancel context.CancelFunc
+			logCtx, cancel = context.WithTimeout(context.WithoutCancel(ctx), usageLogTimeout)
+			defer cancel()
+		}
+		s.logUsage(logCtx, tenantID, config, messageID, record)
+		if s.Budget != nil {
+			s.Budget.Record(logCtx, tenantID, config.ID, record.TotalCost(config.CostPer1KInput, config.CostPer1KOutput))
+		}
+		if err == nil {
+			s.cacheStore(ctx, tenantID, cached, config, record)
+			return nil
+		}
+		lastErr = err
+	}
+	return lastErr
+}
+
+// logUsage logs a call, unless the service runs without a database.
+func (s *Service) logUsage(ctx context.Context, tenantID int64, config *ProviderConfig, messageID *int64, record UsageRecord) {
+	if s.Store == nil || s.Store.DB == nil {
+		return
+	}
+	_ = s.Store.InsertUsage(ctx, tenantID, config.ID, messageID, record, config.CostPer1KInput, config.CostPer1KOutput)
+}
+
+// unsupported reports whether a provider lacks the optional capability a
+// call needs.
+func unsupported(err error) bool {
+	return errors.Is(err, ErrBatchUnsupported) || errors.Is(err, ErrEmbedUnsupported) || errors.Is(err, ErrAnswerUnsupported) ||
+		errors.Is(err, ErrDraftUnsupported)
+}
+
+// completeUsage fills in what the provider could not know about the call:
+// the feature it served and, for errors raised before a response, the
+// outcome and latency.
+func completeUsage(record UsageRecord, start time.Time, err error, feature string) UsageRecord {
+	record.Feature = feature
+	if record.Latency == 0 {
+		record.Latency = time.Since(start)
+	}
+	if err != nil {
+		record.Success = false
+		record.ErrorMessage = err.Error()
+	} else {
+		record.Success = true
 	}
-	latency := time.Since(start)
-	return UsageRecord{Latency: latency, Success: err == nil, ErrorMessage: errorString(err), Feature: feature}
+	return record
 }
 
 func errorString(err error) string {
@@ -0,0 +1,420 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ShadowFeatures are the features whose outputs can be compared, and so
+// shadowed.
+var ShadowFeatures = []string{FeatureImportanceDetection, FeatureSummarization}
+
+const (
+	// shadowConfigTTL bounds how long a replica uses shadow settings another
+	// replica changed.
+	shadowConfigTTL = time.Minute
+	// maxShadowInflight caps concurrent shadow calls per replica; samples
+	// beyond it are dropped rather than queued.
+	maxShadowInflight = 16
+	shadowTimeout     = 2 * time.Minute
+)
+
+// IsShadowFeature reports whether feature can be shadowed.
+func IsShadowFeature(feature string) bool {
+	for _, candidate := range ShadowFeatures {
+		if candidate == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// ShadowConfig sends SamplePercent of a feature's successful calls to a
+// second provider as well. Its results are stored for comparison and never
+// returned to callers.
+type ShadowConfig struct {
+	FeatureName   string    `json:"feature_name"`
+	ProviderID    int64     `json:"provider_id"`
+	SamplePercent float64   `json:"sample_percent"`
+	Enabled       bool      `json:"enabled"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// ShadowAgreement measures how close a shadow output is to the production
+// one. Fields a feature's output does not have are nil: summaries have no
+// priority or importance. SentimentDelta is the absolute difference of the
+// sentiments on a -1 to 1 scale; TopicOverlap is the Jaccard index of the
+// topics, 1 when both have none.
+type ShadowAgreement struct {
+	PriorityMatch   *bool    `json:"priority_match"`
+	ImportanceMatch *bool    `json:"importance_match"`
+	SentimentDelta  *float64 `json:"sentiment_delta"`
+	TopicOverlap    *float64 `json:"topic_overlap"`
+}
+
+// ShadowResult is one production call and its shadow. Outputs are stored as
+// the providers returned them, so with redaction on they hold placeholders.
+type ShadowResult struct {
+	ID                int64           `json:"id"`
+	FeatureName       string          `json:"feature_name"`
+	MessageID         *int64          `json:"message_id"`
+	PrimaryProviderID int64           `json:"primary_provider_id"`
+	ShadowProviderID  int64           `json:"shadow_provider_id"`
+	PrimaryOutput     json.RawMessage `json:"primary_output"`
+	ShadowOutput      json.RawMessage `json:"shadow_output"`
+	PrimaryLatencyMs  int64           `json:"primary_latency_ms"`
+	ShadowLatencyMs   int64           `json:"shadow_latency_ms"`
+	PrimaryCost       float64         `json:"primary_cost"`
+	ShadowCost        float64         `json:"shadow_cost"`
+	ShadowError       *string         `json:"shadow_error"`
+	ShadowAgreement
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// shadowRun describes the production call a shadow is compared with.
+type shadowRun struct {
+	feature      string
+	usageFeature string
+	messageID    *int64
+	primary      Provider
+	usage        UsageRecord
+	output       any
+	// call repeats the production call on the shadow provider; compare
+	// measures its output against the production output.
+	call    func(ctx context.Context, provider Provider) (any, UsageRecord, error)
+	compare func(output any) ShadowAgreement
+}
+
+type shadowConfigEntry struct {
+	configs map[string]ShadowConfig
+	expires time.Time
+}
+
+type shadowConfigCache struct {
+	mu      sync.Mutex
+	entries map[int64]shadowConfigEntry
+}
+
+// InvalidateShadows drops the cached shadow settings of a tenant after they
+// change.
+func (s *Service) InvalidateShadows(tenantID int64) {
+	s.shadowConfigs.mu.Lock()
+	defer s.shadowConfigs.mu.Unlock()
+	delete(s.shadowConfigs.entries, tenantID)
+}
+
+func (s *Service) shadowConfig(ctx context.Context, tenantID int64, feature string) (ShadowConfig, bool) {
+	s.shadowConfigs.mu.Lock()
+	entry, ok := s.shadowConfigs.entries[tenantID]
+	s.shadowConfigs.mu.Unlock()
+	if !ok || time.Now().After(entry.expires) {
+		configs, err := s.Store.ShadowConfigs(ctx, tenantID)
+		if err != nil {
+			return ShadowConfig{}, false
+		}
+		entry = shadowConfigEntry{configs: map[string]ShadowConfig{}, expires: time.Now().Add(shadowConfigTTL)}
+		for _, config := range configs {
+			entry.configs[config.FeatureName] = config
+		}
+		s.shadowConfigs.mu.Lock()
+		if s.shadowConfigs.entries == nil {
+			s.shadowConfigs.entries = map[int64]shadowConfigEntry{}
+		}
+		s.shadowConfigs.entries[tenantID] = entry
+		s.shadowConfigs.mu.Unlock()
+	}
+	config, ok := entry.configs[feature]
+	return config, ok && config.Enabled
+}
+
+// shadow repeats a sampled production call on the feature's shadow provider
+// in the background. It never delays or changes the production result, and
+// the shadow call skips the circuit breakers so its failures cannot reroute
+// production traffic. It is logged and budgeted like any other call.
+func (s *Service) shadow(ctx context.Context, tenantID int64, run shadowRun) {
+	if s.Store == nil || s.Store.DB == nil || s.Router == nil || run.primary == nil {
+		return
+	}
+	config, ok := s.shadowConfig(ctx, tenantID, run.feature)
+	primaryConfig := run.primary.GetConfig()
+	if !ok || config.ProviderID == primaryConfig.ID || rand.Float64()*100 >= config.SamplePercent {
+		return
+	}
+	if s.shadowInflight.Add(1) > maxShadowInflight {
+		s.shadowInflight.Add(-1)
+		return
+	}
+	primaryOutput, err := json.Marshal(run.output)
+	if err != nil {
+		s.shadowInflight.Add(-1)
+		return
+	}
+	result := ShadowResult{
+		FeatureName:       run.feature,
+		MessageID:         run.messageID,
+		PrimaryProviderID: primaryConfig.ID,
+		ShadowProviderID:  config.ProviderID,
+		PrimaryOutput:     primaryOutput,
+		PrimaryLatencyMs:  run.usage.Latency.Milliseconds(),
+		PrimaryCost:       run.usage.TotalCost(primaryConfig.CostPer1KInput, primaryConfig.CostPer1KOutput),
+	}
+
+	go func() {
+		defer s.shadowInflight.Add(-1)
+		// The shadow outlives the request, but keeps its values.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shadowTimeout)
+		defer cancel()
+		provider, err := s.Router.GetProvider(ctx, tenantID, config.ProviderID)
+		if err != nil {
+			return
+		}
+		shadowConfig := provider.GetConfig()
+		if s.Budget != nil && s.Budget.Allow(ctx, tenantID, shadowConfig.ID) != nil {
+			return
+		}
+		start := time.Now()
+		output, record, err := run.call(ctx, provider)
+		record = completeUsage(record, start, err, "shadow_"+run.usageFeature)
+		cost := record.TotalCost(shadowConfig.CostPer1KInput, shadowConfig.CostPer1KOutput)
+		_ = s.Store.InsertUsage(ctx, tenantID, shadowConfig.ID, run.messageID, record, shadowConfig.CostPer1KInput, shadowConfig.CostPer1KOutput)
+		if s.Budget != nil {
+			s.Budget.Record(ctx, tenantID, shadowConfig.ID, cost)
+		}
+		result.ShadowLatencyMs = record.Latency.Milliseconds()
+		result.ShadowCost = cost
+		if err != nil {
+			message := err.Error()
+			result.ShadowError = &message
+		} else {
+			result.ShadowOutput, _ = json.Marshal(output)
+			result.ShadowAgreement = run.compare(output)
+		}
+		_ = s.Store.InsertShadowResult(ctx, tenantID, result)
+	}()
+}
+
+// shadowAnalysis shadows an analysis served by run.primary. The shadow gets
+// the same, already redacted, message and options.
+func (s *Service) shadowAnalysis(ctx context.Context, tenantID int64, messageID *int64, usageFeature, message string, options CallOptions, version int, result *AnalysisResult, run shadowRun) {
+	// The caller restores placeholders in the result afterwards, so the
+	// comparison works on a copy.
+	primary := *result
+	primary.Topics = append([]string(nil), result.Topics...)
+	run.feature, run.usageFeature, run.messageID, run.output = FeatureImportanceDetection, usageFeature, messageID, &primary
+	run.call = func(ctx context.Context, provider Provider) (any, UsageRecord, error) {
+		output, usage, err := provider.Analyze(ctx, message, options)
+		usage.PromptVersion = version
+		return output, usage, err
+	}
+	run.compare = func(output any) ShadowAgreement {
+		return compareAnalyses(&primary, output.(*AnalysisResult))
+	}
+	s.shadow(ctx, tenantID, run)
+}
+
+// shadowSummary shadows a summary served by run.primary.
+func (s *Service) shadowSummary(ctx context.Context, tenantID int64, usageFeature string, messages []string, options CallOptions, version int, result *SummaryResult, run shadowRun) {
+	primary := SummaryResult{Sentiment: result.Sentiment, Topics: append([]string(nil), result.Topics...)}
+	run.feature, run.usageFeature, run.output = FeatureSummarization, usageFeature, result
+	run.call = func(ctx context.Context, provider Provider) (any, UsageRecord, error) {
+		output, usage, err := provider.Summarize(ctx, messages, options)
+		usage.PromptVersion = version
+		return output, usage, err
+	}
+	run.compare = func(output any) ShadowAgreement {
+		return compareSummaries(&primary, output.(*SummaryResult))
+	}
+	s.shadow(ctx, tenantID, run)
+}
+
+// splitUsage is an even share of a batch call's tokens and latency.
+func splitUsage(record UsageRecord, parts int) UsageRecord {
+	record.InputTokens /= parts
+	record.OutputTokens /= parts
+	record.TotalTokens /= parts
+	record.Latency /= time.Duration(parts)
+	return record
+}
+
+// compareAnalyses measures a shadow analysis against the production one.
+func compareAnalyses(primary, shadow *AnalysisResult) ShadowAgreement {
+	if primary == nil || shadow == nil {
+		return ShadowAgreement{}
+	}
+	priority := strings.EqualFold(primary.Priority, shadow.Priority)
+	importance := primary.IsImportant == shadow.IsImportant
+	delta := math.Abs(sentimentValue(primary.Sentiment, primary.SentimentScore) - sentimentValue(shadow.Sentiment, shadow.SentimentScore))
+	overlap := topicOverlap(primary.Topics, shadow.Topics)
+	return ShadowAgreement{PriorityMatch: &priority, ImportanceMatch: &importance, SentimentDelta: &delta, TopicOverlap: &overlap}
+}
+
+// compareSummaries measures a shadow summary against the production one.
+func compareSummaries(primary, shadow *SummaryResult) ShadowAgreement {
+	if primary == nil || shadow == nil {
+		return ShadowAgreement{}
+	}
+	delta := math.Abs(sentimentValue(primary.Sentiment, 0) - sentimentValue(shadow.Sentiment, 0))
+	overlap := topicOverlap(primary.Topics, shadow.Topics)
+	return ShadowAgreement{SentimentDelta: &delta, TopicOverlap: &overlap}
+}
+
+// sentimentValue places a sentiment on a -1 to 1 scale. The score is used
+// when the model gave one; otherwise the label decides.
+func sentimentValue(label string, score float64) float64 {
+	if score != 0 {
+		return score
+	}
+	switch strings.ToLower(label) {
+	case "positive":
+		return 1
+	case "negative":
+		return -1
+	}
+	return 0
+}
+
+// topicOverlap is the Jaccard index of two topic lists, ignoring case.
+func topicOverlap(a, b []string) float64 {
+	set := func(topics []string) map[string]bool {
+		result := map[string]bool{}
+		for _, topic := range topics {
+			if topic = strings.ToLower(strings.TrimSpace(topic)); topic != "" {
+				result[topic] = true
+			}
+		}
+		return result
+	}
+	left, right := set(a), set(b)
+	if len(left) == 0 && len(right) == 0 {
+		return 1
+	}
+	shared := 0
+	for topic := range left {
+		if right[topic] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(left)+len(right)-shared)
+}
+
+const shadowConfigColumns = `feature_name, provider_id, sample_percent, enabled, updated_at`
+
+// ShadowConfigs returns the tenant's shadow settings by feature.
+func (s *Store) ShadowConfigs(ctx context.Context, tenantID int64) ([]ShadowConfig, error) {
+	configs := []ShadowConfig{}
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			SELECT `+shadowConfigColumns+` FROM llm_shadow_configs
+			WHERE tenant_id=$1
+			ORDER BY feature_name`, tenantID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var config ShadowConfig
+			if err := rows.Scan(&config.FeatureName, &config.ProviderID, &config.SamplePercent, &config.Enabled, &config.UpdatedAt); err != nil {
+				return err
+			}
+			configs = append(configs, config)
+		}
+		return rows.Err()
+	})
+	return configs, err
+}
+
+// SaveShadowConfig creates or replaces the shadow settings of a feature.
+func (s *Store) SaveShadowConfig(ctx context.Context, tenantID int64, config *ShadowConfig) error {
+	return s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		return conn.QueryRow(ctx, `
+			INSERT INTO llm_shadow_configs (tenant_id, feature_name, provider_id, sample_percent, enabled, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
+			ON CONFLICT (tenant_id, feature_name) DO UPDATE
+			SET provider_id=EXCLUDED.provider_id, sample_percent=EXCLUDED.sample_percent, enabled=EXCLUDED.enabled, updated_at=EXCLUDED.updated_at
+			RETURNING updated_at`,
+			tenantID, config.FeatureName, config.ProviderID, config.SamplePercent, config.Enabled).Scan(&config.UpdatedAt)
+	})
+}
+
+// DeleteShadowConfig stops shadowing a feature. Stored results are kept. A
+// feature without settings is pgx.ErrNoRows.
+func (s *Store) DeleteShadowConfig(ctx context.Context, tenantID int64, feature string) error {
+	return s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		tag, err := conn.Exec(ctx, `DELETE FROM llm_shadow_configs WHERE tenant_id=$1 AND feature_name=$2`, tenantID, feature)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return pgx.ErrNoRows
+		}
+		return nil
+	})
+}
+
+// InsertShadowResult stores a production output and its shadow.
+func (s *Store) InsertShadowResult(ctx context.Context, tenantID int64, result ShadowResult) error {
+	return s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		_, err := conn.Exec(ctx, `
+			INSERT INTO llm_shadow_results (tenant_id, feature_name, message_id, primary_provider_id, shadow_provider_id,
+				primary_output, shadow_output, primary_latency_ms, shadow_latency_ms, primary_cost, shadow_cost, shadow_error,
+				priority_match, importance_match, sentiment_delta, topic_overlap, created_at)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17)`,
+			tenantID, result.FeatureName, result.MessageID, result.PrimaryProviderID, result.ShadowProviderID,
+			result.PrimaryOutput, nullJSON(result.ShadowOutput), result.PrimaryLatencyMs, result.ShadowLatencyMs, result.PrimaryCost, result.ShadowCost, result.ShadowError,
+			result.PriorityMatch, result.ImportanceMatch, result.SentimentDelta, result.TopicOverlap, time.Now().UTC())
+		return err
+	})
+}
+
+const shadowResultColumns = `id, feature_name, message_id, primary_provider_id, shadow_provider_id, primary_output, shadow_output,
+	primary_latency_ms, shadow_latency_ms, primary_cost, shadow_cost, shadow_error, priority_match, importance_match,
+	sentiment_delta, topic_overlap, created_at`
+
+// ShadowResults returns the latest shadow results, for one feature unless
+// feature is empty.
+func (s *Store) ShadowResults(ctx context.Context, tenantID int64, feature string, limit int) ([]ShadowResult, error) {
+	results := []ShadowResult{}
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			SELECT `+shadowResultColumns+` FROM llm_shadow_results
+			WHERE tenant_id=$1 AND ($2 = '' OR feature_name = $2)
+			ORDER BY created_at DESC
+			LIMIT $3`, tenantID, feature, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var result ShadowResult
+			var primaryOutput, shadowOutput []byte
+			if err := rows.Scan(&result.ID, &result.FeatureName, &result.MessageID, &result.PrimaryProviderID, &result.ShadowProviderID,
+				&primaryOutput, &shadowOutput, &result.PrimaryLatencyMs, &result.ShadowLatencyMs, &result.PrimaryCost, &result.ShadowCost,
+				&result.ShadowError, &result.PriorityMatch, &result.ImportanceMatch, &result.SentimentDelta, &result.TopicOverlap, &result.CreatedAt); err != nil {
+				return err
+			}
+			result.PrimaryOutput = primaryOutput
+			if shadowOutput != nil {
+				result.ShadowOutput = shadowOutput
+			}
+			results = append(results, result)
+		}
+		return rows.Err()
+	})
+	return results, err
+}
+
+// nullJSON stores an empty value as NULL rather than invalid JSON.
+func nullJSON(value json.RawMessage) any {
+	if len(value) == 0 {
+		return nil
+	}
+	return []byte(value)
+}
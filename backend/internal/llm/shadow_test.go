@@ -0,0 +1,53 @@
+package llm
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCompareAnalyses(t *testing.T) {
+	primary := &AnalysisResult{IsImportant: true, Priority: "high", Sentiment: "negative", SentimentScore: -0.8, Topics: []string{"Billing", "refund"}}
+	shadow := &AnalysisResult{IsImportant: true, Priority: "HIGH", Sentiment: "neutral", Topics: []string{"billing ", "delivery"}}
+
+	agreement := compareAnalyses(primary, shadow)
+	if agreement.PriorityMatch == nil || !*agreement.PriorityMatch {
+		t.Fatalf("priority should match ignoring case: %+v", agreement)
+	}
+	if agreement.ImportanceMatch == nil || !*agreement.ImportanceMatch {
+		t.Fatalf("importance should match: %+v", agreement)
+	}
+	// The shadow gave no score, so its neutral label counts as 0.
+	if agreement.SentimentDelta == nil || math.Abs(*agreement.SentimentDelta-0.8) > 1e-9 {
+		t.Fatalf("sentiment delta = %v, want 0.8", agreement.SentimentDelta)
+	}
+	if agreement.TopicOverlap == nil || math.Abs(*agreement.TopicOverlap-1.0/3) > 1e-9 {
+		t.Fatalf("topic overlap = %v, want 1/3", agreement.TopicOverlap)
+	}
+
+	shadow.IsImportant, shadow.Priority = false, "low"
+	agreement = compareAnalyses(primary, shadow)
+	if *agreement.PriorityMatch || *agreement.ImportanceMatch {
+		t.Fatalf("disagreement not detected: %+v", agreement)
+	}
+}
+
+func TestCompareSummariesHasNoLabels(t *testing.T) {
+	agreement := compareSummaries(&SummaryResult{Sentiment: "positive"}, &SummaryResult{Sentiment: "negative"})
+	if agreement.PriorityMatch != nil || agreement.ImportanceMatch != nil {
+		t.Fatalf("summaries have no priority or importance: %+v", agreement)
+	}
+	if *agreement.SentimentDelta != 2 {
+		t.Fatalf("sentiment delta = %v, want 2", *agreement.SentimentDelta)
+	}
+	// Neither summary named a topic, which is agreement.
+	if *agreement.TopicOverlap != 1 {
+		t.Fatalf("topic overlap = %v, want 1", *agreement.TopicOverlap)
+	}
+}
+
+func TestSplitUsage(t *testing.T) {
+	share := splitUsage(UsageRecord{InputTokens: 300, OutputTokens: 90, TotalTokens: 390, Latency: 900}, 3)
+	if share.InputTokens != 100 || share.OutputTokens != 30 || share.TotalTokens != 130 || share.Latency != 300 {
+		t.Fatalf("unexpected share: %+v", share)
+	}
+}
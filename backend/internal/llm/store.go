@@ -2,6 +2,7 @@ package llm
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -13,22 +14,50 @@ import (
 type Store struct {
 	DB        *db.Store
 	MasterKey string
+
+	vectorMu        sync.Mutex
+	vectorColumn    bool
+	vectorCheckedAt time.Time
 }
 
 func NewStore(store *db.Store, masterKey string) *Store {
 	return &Store{DB: store, MasterKey: masterKey}
 }
 
+const providerColumns = `p.id, p.provider_name, p.api_key, p.model_name,
+	COALESCE(p.base_url, ''), COALESCE(p.azure_endpoint, ''), COALESCE(p.azure_deployment, ''), COALESCE(p.azure_api_version, ''),
+	p.temperature, p.max_tokens, p.cost_per_1k_input, p.cost_per_1k_output, p.max_requests_per_minute,
+	COALESCE(p.safety_settings, '{}'::jsonb)`
+
 func (s *Store) ListProviders(ctx context.Context, tenantID int64) ([]ProviderConfig, error) {
+	return s.queryProviders(ctx, tenantID, `
+		SELECT `+providerColumns+`
+		FROM llm_providers p
+		WHERE p.tenant_id=$1 AND p.is_active=TRUE
+		ORDER BY p.is_default DESC, p.id ASC`, tenantID)
+}
+
+func (s *Store) ListFeatureProviders(ctx context.Context, tenantID int64, feature string) ([]ProviderConfig, error) {
+	return s.queryProviders(ctx, tenantID, `
+		SELECT `+providerColumns+`
+		FROM llm_feature_assignments a
+		JOIN llm_providers p ON p.id = a.provider_id
+		WHERE a.tenant_id=$1 AND a.feature_name=$2 AND p.is_active=TRUE
+		ORDER BY a.priority ASC, p.id ASC`, tenantID, feature)
+}
+
+func (s *Store) ListFallbackProviders(ctx context.Context, tenantID int64) ([]ProviderConfig, error) {
+	return s.queryProviders(ctx, tenantID, `
+		SELECT `+providerColumns+`
+		FROM llm_providers p
+		WHERE p.tenant_id=$1 AND p.is_fallback=TRUE AND p.is_active=TRUE
+		ORDER BY p.id ASC`, tenantID)
+}
+
+func (s *Store) queryProviders(ctx context.Context, tenantID int64, query string, args ...any) ([]ProviderConfig, error) {
 	var configs []ProviderConfig
 	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
-		rows, err := conn.Query(ctx, `
-			SELECT id, provider_name, api_key, model_name, 
-				COALESCE(base_url, ''), COALESCE(azure_endpoint, ''), COALESCE(azure_deployment, ''), COALESCE(azure_api_version, ''),
-				temperature, max_tokens, cost_per_1k_input, cost_per_1k_output, max_requests_per_minute
-			FROM llm_providers
-			WHERE tenant_id=$1 AND is_active=TRUE
-			ORDER BY is_default DESC, id ASC`, tenantID)
+		rows, err := conn.Query(ctx, query, args...)
 		if err != nil {
 			return err
 		}
@@ -36,7 +65,7 @@ func (s *Store) ListProviders(ctx context.Context, tenantID int64) ([]ProviderCo
 
 		for rows.Next() {
 			var cfg ProviderConfig
-			if err := rows.Scan(&cfg.ID, &cfg.ProviderName, &cfg.APIKey, &cfg.ModelName, &cfg.BaseURL, &cfg.AzureEndpoint, &cfg.AzureDeployment, &cfg.AzureAPIVersion, &cfg.Temperature, &cfg.MaxTokens, &cfg.CostPer1KInput, &cfg.CostPer1KOutput, &cfg.MaxRequestsPerMinute); err != nil {
+			if err := rows.Scan(&cfg.ID, &cfg.ProviderName, &cfg.APIKey, &cfg.ModelName, &cfg.BaseURL, &cfg.AzureEndpoint, &cfg.AzureDeployment, &cfg.AzureAPIVersion, &cfg.Temperature, &cfg.MaxTokens, &cfg.CostPer1KInput, &cfg.CostPer1KOutput, &cfg.MaxRequestsPerMinute, &cfg.SafetySettings); err != nil {
 				return err
 			}
 			if decrypted, err := crypto.Decrypt(s.MasterKey, cfg.APIKey); err == nil {
@@ -55,11 +84,12 @@ func (s *Store) GetDefaultProvider(ctx context.Context, tenantID int64) (*Provid
 		row := conn.QueryRow(ctx, `
 			SELECT id, provider_name, api_key, model_name,
 				COALESCE(base_url, ''), COALESCE(azure_endpoint, ''), COALESCE(azure_deployment, ''), COALESCE(azure_api_version, ''),
-				temperature, max_tokens, cost_per_1k_input, cost_per_1k_output, max_requests_per_minute
+				temperature, max_tokens, cost_per_1k_input, cost_per_1k_output, max_requests_per_minute,
+				COALESCE(safety_settings, '{}'::jsonb)
 			FROM llm_providers
 			WHERE tenant_id=$1 AND is_default=TRUE AND is_active=TRUE
 			LIMIT 1`, tenantID)
-		if err := row.Scan(&cfg.ID, &cfg.ProviderName, &cfg.APIKey, &cfg.ModelName, &cfg.BaseURL, &cfg.AzureEndpoint, &cfg.AzureDeployment, &cfg.AzureAPIVersion, &cfg.Temperature, &cfg.MaxTokens, &cfg.CostPer1KInput, &cfg.CostPer1KOutput, &cfg.MaxRequestsPerMinute); err != nil {
+		if err := row.Scan(&cfg.ID, &cfg.ProviderName, &cfg.APIKey, &cfg.ModelName, &cfg.BaseURL, &cfg.AzureEndpoint, &cfg.AzureDeployment, &cfg.AzureAPIVersion, &cfg.Temperature, &cfg.MaxTokens, &cfg.CostPer1KInput, &cfg.CostPer1KOutput, &cfg.MaxRequestsPerMinute, &cfg.SafetySettings); err != nil {
 			return err
 		}
 		if decrypted, err := crypto.Decrypt(s.MasterKey, cfg.APIKey); err == nil {
@@ -79,10 +109,11 @@ func (s *Store) GetProviderByID(ctx context.Context, tenantID int64, providerID
 		row := conn.QueryRow(ctx, `
 			SELECT id, provider_name, api_key, model_name,
 				COALESCE(base_url, ''), COALESCE(azure_endpoint, ''), COALESCE(azure_deployment, ''), COALESCE(azure_api_version, ''),
-				temperature, max_tokens, cost_per_1k_input, cost_per_1k_output, max_requests_per_minute
+				temperature, max_tokens, cost_per_1k_input, cost_per_1k_output, max_requests_per_minute,
+				COALESCE(safety_settings, '{}'::jsonb)
 			FROM llm_providers
 			WHERE tenant_id=$1 AND id=$2`, tenantID, providerID)
-		if err := row.Scan(&cfg.ID, &cfg.ProviderName, &cfg.APIKey, &cfg.ModelName, &cfg.BaseURL, &cfg.AzureEndpoint, &cfg.AzureDeployment, &cfg.AzureAPIVersion, &cfg.Temperature, &cfg.MaxTokens, &cfg.CostPer1KInput, &cfg.CostPer1KOutput, &cfg.MaxRequestsPerMinute); err != nil {
+		if err := row.Scan(&cfg.ID, &cfg.ProviderName, &cfg.APIKey, &cfg.ModelName, &cfg.BaseURL, &cfg.AzureEndpoint, &cfg.AzureDeployment, &cfg.AzureAPIVersion, &cfg.Temperature, &cfg.MaxTokens, &cfg.CostPer1KInput, &cfg.CostPer1KOutput, &cfg.MaxRequestsPerMinute, &cfg.SafetySettings); err != nil {
 			return err
 		}
 		if decrypted, err := crypto.Decrypt(s.MasterKey, cfg.APIKey); err == nil {
@@ -96,13 +127,21 @@ func (s *Store) GetProviderByID(ctx context.Context, tenantID int64, providerID
 	return &cfg, nil
 }
 
+// InsertUsage logs a call. Cache hits are logged without tokens or cost,
+// with those of the original call as saved_tokens and saved_cost.
 func (s *Store) InsertUsage(ctx context.Context, tenantID, providerID int64, messageID *int64, record UsageRecord, costIn, costOut float64) error {
+	savedCost, savedTokens := 0.0, 0
+	if record.CacheHit {
+		savedCost, savedTokens = record.TotalCost(costIn, costOut), record.TotalTokens
+		record.InputTokens, record.OutputTokens, record.TotalTokens = 0, 0, 0
+	}
 	return s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
 		_, err := conn.Exec(ctx, `
-			INSERT INTO llm_usage_logs (tenant_id, provider_id, message_id, input_tokens, output_tokens, total_tokens, input_cost, output_cost, total_cost, response_time_ms, success, error_message, feature_used, created_at)
-			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14)`,
+			INSERT INTO llm_usage_logs (tenant_id, provider_id, message_id, input_tokens, output_tokens, total_tokens, input_cost, output_cost, total_cost, response_time_ms, success, error_message, feature_used, model_name, attempts, http_status_code, prompt_version, validation_errors, repaired, cache_hit, saved_cost, saved_tokens, created_at)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,NULLIF($14, ''),$15,NULLIF($16, 0),NULLIF($17, 0),$18,$19,$20,$21,$22,$23)`,
 			tenantID, providerID, messageID, record.InputTokens, record.OutputTokens, record.TotalTokens,
-			record.InputCost(costIn), record.OutputCost(costOut), record.TotalCost(costIn, costOut), record.Latency.Milliseconds(), record.Success, record.ErrorMessage, record.Feature, time.Now().UTC())
+			record.InputCost(costIn), record.OutputCost(costOut), record.TotalCost(costIn, costOut), record.Latency.Milliseconds(), record.Success, record.ErrorMessage, record.Feature,
+			record.Model, record.Attempts, record.HTTPStatus, record.PromptVersion, record.ValidationErrors, record.Repaired, record.CacheHit, savedCost, savedTokens, time.Now().UTC())
 		return err
 	})
 }
@@ -179,3 +218,48 @@ func (s *Store) SetProviderHealth(ctx context.Context, tenantID, providerID int6
 		return err
 	})
 }
+
+// ProviderBudgets returns each provider's caps alongside its spend for the
+// current UTC month and request count for the current UTC day. Cache hits
+// never reach the provider, so they are left out of the request count.
+func (s *Store) ProviderBudgets(ctx context.Context, tenantID int64, now time.Time) ([]ProviderBudget, error) {
+	now = now.UTC()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	var budgets []ProviderBudget
+	err := s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		rows, err := conn.Query(ctx, `
+			SELECT p.id, p.provider_name, COALESCE(p.monthly_budget, 0), p.max_requests_per_day,
+			       COALESCE(SUM(l.total_cost), 0),
+			       COUNT(l.id) FILTER (WHERE l.created_at >= $3 AND NOT l.cache_hit)
+			FROM llm_providers p
+			LEFT JOIN llm_usage_logs l ON l.provider_id = p.id AND l.created_at >= $2
+			WHERE p.tenant_id=$1
+			GROUP BY p.id, p.provider_name, p.monthly_budget, p.max_requests_per_day
+			ORDER BY p.id ASC`, tenantID, monthStart, dayStart)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var budget ProviderBudget
+			if err := rows.Scan(&budget.ProviderID, &budget.Provider, &budget.MonthlyBudget, &budget.MaxRequestsPerDay, &budget.MonthSpent, &budget.RequestsToday); err != nil {
+				return err
+			}
+			budgets = append(budgets, budget)
+		}
+		return rows.Err()
+	})
+	return budgets, err
+}
+
+func (s *Store) NotifyAdmins(ctx context.Context, tenantID int64, notifType, content string) error {
+	return s.DB.WithTenantConn(ctx, tenantID, func(conn *pgxpool.Conn) error {
+		_, err := conn.Exec(ctx, `
+			INSERT INTO notifications (tenant_id, user_id, type, content, read, created_at)
+			SELECT tenant_id, user_id, $2, $3, FALSE, $4
+			FROM users_extended
+			WHERE tenant_id=$1 AND role IN ('owner', 'admin')`, tenantID, notifType, content, time.Now().UTC())
+		return err
+	})
+}
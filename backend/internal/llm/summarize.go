@@ -0,0 +1,182 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultSummaryChunkTokens caps the messages sent in one summarization
+// prompt, even when the model's context window is much larger.
+const DefaultSummaryChunkTokens = 6000
+
+// TranscriptMessage is one message of a conversation to summarize.
+type TranscriptMessage struct {
+	Sender    string
+	Timestamp time.Time
+	Content   string
+}
+
+// Line renders the message as "[2006-01-02 15:04] Sender: content", leaving
+// out whatever is unknown.
+func (m TranscriptMessage) Line() string {
+	var b strings.Builder
+	if !m.Timestamp.IsZero() {
+		b.WriteString("[" + m.Timestamp.UTC().Format("2006-01-02 15:04") + "] ")
+	}
+	if m.Sender != "" {
+		b.WriteString(m.Sender + ": ")
+	}
+	b.WriteString(m.Content)
+	return b.String()
+}
+
+// SenderName returns how a stored message sender is shown to a model: the
+// tenant's own messages as "Agent", the contact's as contactName and anyone
+// else, e.g. a group member, by the stored WhatsApp JID. contactNumber is the
+// conversation's contact_number, the user part of the contact's JID.
+func SenderName(sender, contactNumber, contactName string) string {
+	switch sender {
+	case "agent", "me":
+		return "Agent"
+	case "system":
+		return "System"
+	}
+	if contactName != "" && contactNumber != "" && jidUser(sender) == contactNumber {
+		return contactName
+	}
+	return sender
+}
+
+// jidUser is the user part of a JID such as 4915…:3@s.whatsapp.net.
+func jidUser(jid string) string {
+	if i := strings.IndexAny(jid, ".:@"); i >= 0 {
+		return jid[:i]
+	}
+	return jid
+}
+
+// SummarizeTranscript summarizes a conversation of any length. Messages that
+// fit the token budget of every provider in the chain are summarized in one
+// call; longer transcripts are split into windows that are summarized on
+// their own (map) and then summarized together (reduce). contactName is passed
+// to tenant prompt templates and may be empty.
+func (s *Service) SummarizeTranscript(ctx context.Context, tenantID, providerID int64, contactName string, messages []TranscriptMessage) (*SummaryResult, error) {
+	return s.summarizeTranscript(ctx, tenantID, providerID, FeatureSummarization, contactName, messages)
+}
+
+// summarizeTranscript is SummarizeTranscript with the calls routed to the
+// providers of feature. Other features than summarization log their usage
+// under their own name, e.g. daily_summary_map.
+func (s *Service) summarizeTranscript(ctx context.Context, tenantID, providerID int64, feature, contactName string, messages []TranscriptMessage) (*SummaryResult, error) {
+	chain, err := s.Router.ProvidersForFeature(ctx, tenantID, feature, providerID)
+	if err != nil {
+		return nil, err
+	}
+	limit := s.SummaryChunkTokens
+	if limit <= 0 {
+		limit = DefaultSummaryChunkTokens
+	}
+	// A fallback may serve any window, so size windows for the smallest
+	// budget and the most pessimistic tokenizer in the chain.
+	budget := 0
+	configs := make([]*ProviderConfig, 0, len(chain))
+	for _, provider := range chain {
+		config := provider.GetConfig()
+		configs = append(configs, config)
+		if b := promptBudget(config, limit); budget == 0 || b < budget {
+			budget = b
+		}
+	}
+	estimate := func(text string) int {
+		tokens := 0
+		for _, config := range configs {
+			if t := EstimateTokens(config, text); t > tokens {
+				tokens = t
+			}
+		}
+		return tokens
+	}
+
+	lines := make([]string, 0, len(messages))
+	for _, message := range messages {
+		lines = append(lines, message.Line())
+	}
+	return mapReduceSummary(ctx, lines, budget, estimate, func(ctx context.Context, lines []string, usageFeature string) (*SummaryResult, error) {
+		if feature != FeatureSummarization {
+			usageFeature = strings.Replace(usageFeature, "summarize", feature, 1)
+		}
+		return s.summarize(ctx, tenantID, providerID, feature, lines, contactName, usageFeature)
+	})
+}
+
+type summarizeFunc func(ctx context.Context, lines []string, usageFeature string) (*SummaryResult, error)
+
+func mapReduceSummary(ctx context.Context, lines []string, budget int, estimate func(string) int, summarize summarizeFunc) (*SummaryResult, error) {
+	chunks := chunkLines(lines, budget, budget, estimate)
+	if len(chunks) == 1 {
+		return summarize(ctx, chunks[0], "summarize")
+	}
+	for {
+		partials := make([]string, 0, len(chunks))
+		for i, chunk := range chunks {
+			result, err := summarize(ctx, chunk, "summarize_map")
+			if err != nil {
+				return nil, err
+			}
+			partials = append(partials, partialSummaryLine(i+1, len(chunks), result))
+		}
+		// Partials are capped at half the budget so every reduce window holds
+		// at least two of them and the rounds converge.
+		chunks = chunkLines(partials, budget, budget/2-1, estimate)
+		if len(chunks) == 1 {
+			return summarize(ctx, chunks[0], "summarize_reduce")
+		}
+	}
+}
+
+// chunkLines packs lines in order into windows of at most budget tokens.
+// Lines longer than maxLine tokens are truncated.
+func chunkLines(lines []string, budget, maxLine int, estimate func(string) int) [][]string {
+	chunks := [][]string{}
+	var current []string
+	used := 0
+	for _, line := range lines {
+		line = truncateTokens(line, maxLine, estimate)
+		tokens := estimate(line) + 1
+		if len(current) > 0 && used+tokens > budget {
+			chunks = append(chunks, current)
+			current, used = nil, 0
+		}
+		current = append(current, line)
+		used += tokens
+	}
+	if len(current) > 0 || len(chunks) == 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+func truncateTokens(line string, maxTokens int, estimate func(string) int) string {
+	tokens := estimate(line)
+	if tokens <= maxTokens {
+		return line
+	}
+	cut := len(line) * maxTokens / tokens
+	for cut > 0 && estimate(line[:cut]+"…") > maxTokens {
+		cut = cut * 9 / 10
+	}
+	return strings.ToValidUTF8(line[:cut], "") + "…"
+}
+
+func partialSummaryLine(part, total int, result *SummaryResult) string {
+	line := fmt.Sprintf("Summary of part %d of %d: %s", part, total, result.Summary)
+	if len(result.KeyPoints) > 0 {
+		line += " Key points: " + strings.Join(result.KeyPoints, "; ") + "."
+	}
+	if len(result.ActionItems) > 0 {
+		line += " Action items: " + strings.Join(result.ActionItems, "; ") + "."
+	}
+	return line
+}
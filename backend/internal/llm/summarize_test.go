@@ -0,0 +1,102 @@
+package llm
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTranscriptMessageLine(t *testing.T) {
+	message := TranscriptMessage{Sender: "Ana", Timestamp: time.Date(2024, 3, 1, 9, 5, 0, 0, time.UTC), Content: "hi"}
+	if got := message.Line(); got != "[2024-03-01 09:05] Ana: hi" {
+		t.Fatalf("unexpected line %q", got)
+	}
+	if got := (TranscriptMessage{Content: "hi"}).Line(); got != "hi" {
+		t.Fatalf("unexpected line %q", got)
+	}
+}
+
+func TestSenderNameInTranscript(t *testing.T) {
+	stored := []TranscriptMessage{
+		{Sender: "4915112345678:3@s.whatsapp.net", Content: "Where is my order?"},
+		{Sender: "me", Content: "It ships today."},
+		{Sender: "agent", Content: "Tracking follows."},
+		{Sender: "4917000000000@s.whatsapp.net", Content: "Me too?"},
+	}
+	var lines []string
+	for _, message := range stored {
+		message.Sender = SenderName(message.Sender, "4915112345678", "Ana Silva")
+		lines = append(lines, message.Line())
+	}
+	want := []string{
+		"Ana Silva: Where is my order?",
+		"Agent: It ships today.",
+		"Agent: Tracking follows.",
+		"4917000000000@s.whatsapp.net: Me too?",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected transcript:\n%s", strings.Join(lines, "\n"))
+	}
+	if got := SenderName("4915112345678@s.whatsapp.net", "4915112345678", ""); got != "4915112345678@s.whatsapp.net" {
+		t.Fatalf("expected the JID without a contact name, got %q", got)
+	}
+}
+
+func TestEstimateTokensPerProvider(t *testing.T) {
+	text := strings.Repeat("a", 700)
+	claude := EstimateTokens(&ProviderConfig{ProviderName: "claude"}, text)
+	openai := EstimateTokens(&ProviderConfig{ProviderName: "openai"}, text)
+	if claude <= openai {
+		t.Fatalf("expected claude estimate %d above openai estimate %d", claude, openai)
+	}
+	small := promptBudget(&ProviderConfig{ProviderName: "openai", ModelName: "gpt-4", MaxTokens: 1024}, 100000)
+	large := promptBudget(&ProviderConfig{ProviderName: "openai", ModelName: "gpt-4o", MaxTokens: 1024}, 100000)
+	if small >= large || large != 100000 {
+		t.Fatalf("unexpected budgets %d and %d", small, large)
+	}
+}
+
+func TestChunkLines(t *testing.T) {
+	estimate := func(text string) int { return len(text) }
+	chunks := chunkLines([]string{"aaaa", "bbbb", "cccc", strings.Repeat("d", 30)}, 10, 10, estimate)
+	if len(chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %v", chunks)
+	}
+	if len(chunks[0]) != 2 || estimate(chunks[2][0]) > 10 {
+		t.Fatalf("unexpected chunks %v", chunks)
+	}
+}
+
+func TestMapReduceSummary(t *testing.T) {
+	estimate := func(text string) int { return len(text) / 4 }
+	lines := make([]string, 40)
+	for i := range lines {
+		lines[i] = strings.Repeat("x", 200)
+	}
+	stages := map[string]int{}
+	result, err := mapReduceSummary(context.Background(), lines, 200, estimate, func(ctx context.Context, lines []string, usageFeature string) (*SummaryResult, error) {
+		stages[usageFeature]++
+		for _, line := range lines {
+			if estimate(line) > 200 {
+				t.Fatalf("line over budget: %d tokens", estimate(line))
+			}
+		}
+		return &SummaryResult{Summary: strings.Repeat("s", 300), ActionItems: []string{"follow up"}}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result == nil || stages["summarize_map"] < 10 || stages["summarize_reduce"] != 1 || stages["summarize"] != 0 {
+		t.Fatalf("unexpected stages %v", stages)
+	}
+
+	stages = map[string]int{}
+	_, _ = mapReduceSummary(context.Background(), lines[:2], 200, estimate, func(ctx context.Context, lines []string, usageFeature string) (*SummaryResult, error) {
+		stages[usageFeature]++
+		return &SummaryResult{}, nil
+	})
+	if stages["summarize"] != 1 || len(stages) != 1 {
+		t.Fatalf("short transcript should be summarized in one call, got %v", stages)
+	}
+}
@@ -0,0 +1,62 @@
+package llm
+
+import "strings"
+
+// tokenProfile is a rough tokenizer model for a provider: how many characters
+// make up a token and how large the model's context window is.
+type tokenProfile struct {
+	charsPerToken float64
+	contextWindow int
+}
+
+func profileFor(config *ProviderConfig) tokenProfile {
+	model := strings.ToLower(config.ModelName)
+	switch strings.ToLower(config.ProviderName) {
+	case "claude", "anthropic":
+		return tokenProfile{charsPerToken: 3.5, contextWindow: 200000}
+	case "openai", "azure_openai", "azureopenai":
+		switch {
+		case strings.HasPrefix(model, "gpt-3.5"):
+			return tokenProfile{charsPerToken: 4, contextWindow: 16000}
+		case model == "gpt-4" || strings.HasPrefix(model, "gpt-4-0"):
+			return tokenProfile{charsPerToken: 4, contextWindow: 8000}
+		}
+		return tokenProfile{charsPerToken: 4, contextWindow: 128000}
+	case "cohere":
+		if strings.HasPrefix(model, "command-r") {
+			return tokenProfile{charsPerToken: 4, contextWindow: 128000}
+		}
+		return tokenProfile{charsPerToken: 4, contextWindow: 4000}
+	case "ollama":
+		// Ollama runs models with a small context unless num_ctx is raised.
+		return tokenProfile{charsPerToken: 3.5, contextWindow: 4096}
+	case "google", "gemini":
+		return tokenProfile{charsPerToken: 4, contextWindow: 1000000}
+	}
+	return tokenProfile{charsPerToken: 4, contextWindow: 8000}
+}
+
+// EstimateTokens approximates how many input tokens the provider will count
+// for text. It errs on the high side so prompts stay within budget.
+func EstimateTokens(config *ProviderConfig, text string) int {
+	if text == "" {
+		return 0
+	}
+	profile := profileFor(config)
+	return int(float64(len(text))/profile.charsPerToken) + 1
+}
+
+// promptBudget is how many tokens of messages fit in one prompt for the
+// provider, leaving room for the instructions and the response. It is capped
+// at limit when limit is positive.
+func promptBudget(config *ProviderConfig, limit int) int {
+	const instructionTokens = 200
+	budget := profileFor(config).contextWindow - config.MaxTokens - instructionTokens
+	if limit > 0 && budget > limit {
+		budget = limit
+	}
+	if budget < 500 {
+		budget = 500
+	}
+	return budget
+}
@@ -15,3 +15,50 @@ type HealthCheckResult = contract.HealthCheckResult
 type UsageStats = contract.UsageStats
 
 type UsageRecord = contract.UsageRecord
+
+var ErrRateLimited = contract.ErrRateLimited
+
+type RateLimitError = contract.RateLimitError
+
+type BatchItem = contract.BatchItem
+
+type BatchAnalyzer = contract.BatchAnalyzer
+
+var ErrBatchUnsupported = contract.ErrBatchUnsupported
+
+type Embeddings = contract.Embeddings
+
+type Embedder = contract.Embedder
+
+var ErrEmbedUnsupported = contract.ErrEmbedUnsupported
+
+type ContextMessage = contract.ContextMessage
+
+type QuestionRequest = contract.QuestionRequest
+
+type AnswerResult = contract.AnswerResult
+
+type QuestionAnswerer = contract.QuestionAnswerer
+
+var ErrAnswerUnsupported = contract.ErrAnswerUnsupported
+
+type ValidationError = contract.ValidationError
+
+type DraftRequest = contract.DraftRequest
+
+type DraftResult = contract.DraftResult
+
+type ReplyDrafter = contract.ReplyDrafter
+
+var ErrDraftUnsupported = contract.ErrDraftUnsupported
+
+const (
+	RewriteShorter    = contract.RewriteShorter
+	RewriteFriendlier = contract.RewriteFriendlier
+	RewriteFormal     = contract.RewriteFormal
+	RewriteTranslate  = contract.RewriteTranslate
+)
+
+type AnalysisExample = contract.AnalysisExample
+
+type CallOptions = contract.CallOptions
@@ -3,38 +3,190 @@ package llm
 import (
 	"context"
 	"sync"
+	"time"
 
 	"message-flow/backend/internal/db"
 	"message-flow/backend/internal/realtime"
 )
 
+// WorkerScheduler runs a shared pool of Concurrency workers for every tenant.
+// A dispatcher visits the active tenants round-robin and takes at most Quantum
+// messages from each per round, so a tenant with a large backlog cannot starve
+// the others. With MicroBatchSize > 1, short messages (up to
+// MicroBatchMaxChars) from the same tenant are analyzed in one prompt.
 type WorkerScheduler struct {
+	Concurrency        int
+	Quantum            int
+	MicroBatchSize     int
+	MicroBatchMaxChars int
+	IdleWait           time.Duration
+
+	queue  Queue
+	worker *Worker
+
 	mu      sync.Mutex
-	queue   *Queue
-	service *Service
-	store   *db.Store
-	hub     *realtime.Hub
-	workers map[int64]context.CancelFunc
+	tenants []int64
+	active  map[int64]bool
+	started bool
+	wake    chan struct{}
 }
 
-func NewWorkerScheduler(queue *Queue, service *Service, store *db.Store, hub *realtime.Hub) *WorkerScheduler {
+func NewWorkerScheduler(queue Queue, service *Service, store *db.Store, hub *realtime.Hub) *WorkerScheduler {
 	return &WorkerScheduler{
-		queue:   queue,
-		service: service,
-		store:   store,
-		hub:     hub,
-		workers: map[int64]context.CancelFunc{},
+		Concurrency:        4,
+		Quantum:            10,
+		MicroBatchMaxChars: 280,
+		IdleWait:           time.Second,
+		queue:              queue,
+		worker:             &Worker{Queue: queue, Service: service, DB: store, Hub: hub},
+		active:             map[int64]bool{},
+		wake:               make(chan struct{}, 1),
 	}
 }
 
+// EnsureTenant adds the tenant to the dispatch rotation, starting the pool on
+// first use.
 func (s *WorkerScheduler) EnsureTenant(ctx context.Context, tenantID int64) {
+	s.mu.Lock()
+	if !s.active[tenantID] {
+		s.active[tenantID] = true
+		s.tenants = append(s.tenants, tenantID)
+	}
+	start := !s.started
+	s.started = true
+	s.mu.Unlock()
+
+	if start {
+		go s.run(ctx)
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// pendingTenantLister is implemented by queues that can list the tenants
+// with unfinished jobs.
+type pendingTenantLister interface {
+	PendingTenants(ctx context.Context) ([]int64, error)
+}
+
+// Resume adds the tenants with unfinished jobs to the rotation after a
+// restart; otherwise a tenant only rejoins when its WhatsApp session does.
+func (s *WorkerScheduler) Resume(ctx context.Context) error {
+	lister, ok := s.queue.(pendingTenantLister)
+	if !ok {
+		return nil
+	}
+	tenants, err := lister.PendingTenants(ctx)
+	if err != nil {
+		return err
+	}
+	for _, tenantID := range tenants {
+		s.EnsureTenant(ctx, tenantID)
+	}
+	return nil
+}
+
+func (s *WorkerScheduler) run(ctx context.Context) {
+	concurrency := s.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	jobs := make(chan []QueueDelivery)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				s.worker.Handle(ctx, job)
+			}
+		}()
+	}
+	defer func() {
+		close(jobs)
+		wg.Wait()
+	}()
+
+	for {
+		dispatched := 0
+		for _, tenantID := range s.snapshot() {
+			if ctx.Err() != nil {
+				return
+			}
+			deliveries, err := s.queue.Dequeue(ctx, tenantID, s.quantum())
+			if err != nil {
+				continue
+			}
+			// The channel is unbuffered, so a round only moves on once
+			// workers are free; undispatched deliveries are reclaimed after
+			// the visibility timeout if we stop here.
+			for _, job := range s.group(deliveries) {
+				select {
+				case jobs <- job:
+					dispatched++
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		if dispatched > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-time.After(s.IdleWait):
+		}
+	}
+}
+
+func (s *WorkerScheduler) snapshot() []int64 {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if _, ok := s.workers[tenantID]; ok {
-		return
+	return append([]int64(nil), s.tenants...)
+}
+
+func (s *WorkerScheduler) quantum() int {
+	if s.Quantum <= 0 {
+		return 1
+	}
+	return s.Quantum
+}
+
+// group splits a tenant's deliveries into jobs. Short messages with a unique
+// message ID are packed into micro-batches and embedding jobs share a single
+// job; everything else runs alone.
+func (s *WorkerScheduler) group(deliveries []QueueDelivery) [][]QueueDelivery {
+	var jobs [][]QueueDelivery
+	var batch, embeddings []QueueDelivery
+	seen := map[int64]bool{}
+	for _, delivery := range deliveries {
+		msg := delivery.Message
+		if msg.Feature == QueueFeatureEmbedding {
+			embeddings = append(embeddings, delivery)
+			continue
+		}
+		batchable := s.MicroBatchSize > 1 && msg.MessageID > 0 && !seen[msg.MessageID] && len(msg.Content) <= s.MicroBatchMaxChars
+		if !batchable {
+			jobs = append(jobs, []QueueDelivery{delivery})
+			continue
+		}
+		seen[msg.MessageID] = true
+		batch = append(batch, delivery)
+		if len(batch) == s.MicroBatchSize {
+			jobs = append(jobs, batch)
+			batch = nil
+			seen = map[int64]bool{}
+		}
+	}
+	if len(batch) > 0 {
+		jobs = append(jobs, batch)
+	}
+	if len(embeddings) > 0 {
+		jobs = append(jobs, embeddings)
 	}
-	workerCtx, cancel := context.WithCancel(ctx)
-	s.workers[tenantID] = cancel
-	worker := &Worker{Queue: s.queue, Service: s.service, DB: s.store, Hub: s.hub, BatchSize: 100}
-	go worker.Start(workerCtx, tenantID)
+	return jobs
 }
@@ -0,0 +1,81 @@
+package llm
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func delivery(messageID int64, content string) QueueDelivery {
+	return QueueDelivery{Message: QueueMessage{TenantID: 1, MessageID: messageID, Content: content}}
+}
+
+func TestWorkerSchedulerGroupsShortMessages(t *testing.T) {
+	s := &WorkerScheduler{MicroBatchSize: 2, MicroBatchMaxChars: 10}
+	jobs := s.group([]QueueDelivery{
+		delivery(1, "hi"),
+		delivery(2, "this one is far too long"),
+		delivery(1, "dup"),
+		delivery(3, "ok"),
+		delivery(4, "yes"),
+	})
+	sizes := []int{}
+	for _, job := range jobs {
+		sizes = append(sizes, len(job))
+	}
+	want := []int{1, 1, 2, 1}
+	if len(sizes) != len(want) {
+		t.Fatalf("expected job sizes %v, got %v", want, sizes)
+	}
+	for i := range want {
+		if sizes[i] != want[i] {
+			t.Fatalf("expected job sizes %v, got %v", want, sizes)
+		}
+	}
+}
+
+func TestWorkerSchedulerWithoutMicroBatch(t *testing.T) {
+	s := &WorkerScheduler{}
+	if jobs := s.group([]QueueDelivery{delivery(1, "a"), delivery(2, "b")}); len(jobs) != 2 {
+		t.Fatalf("expected one job per delivery, got %d", len(jobs))
+	}
+}
+
+func TestWorkerSchedulerGroupsEmbeddings(t *testing.T) {
+	s := &WorkerScheduler{}
+	embed := func(messageID int64) QueueDelivery {
+		d := delivery(messageID, "text")
+		d.Message.Feature = QueueFeatureEmbedding
+		return d
+	}
+	jobs := s.group([]QueueDelivery{embed(1), delivery(1, "a"), embed(2)})
+	if len(jobs) != 2 || len(jobs[0]) != 1 || len(jobs[1]) != 2 || jobs[1][0].Message.Feature != QueueFeatureEmbedding {
+		t.Fatalf("expected one analysis job and one embedding job, got %+v", jobs)
+	}
+}
+
+// pendingQueue reports tenants with unfinished jobs and has nothing ready.
+type pendingQueue struct {
+	Queue
+	tenants []int64
+}
+
+func (q *pendingQueue) PendingTenants(ctx context.Context) ([]int64, error) {
+	return q.tenants, nil
+}
+
+func (q *pendingQueue) Dequeue(ctx context.Context, tenantID int64, batchSize int) ([]QueueDelivery, error) {
+	return nil, nil
+}
+
+func TestWorkerSchedulerResumesPendingTenants(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewWorkerScheduler(&pendingQueue{tenants: []int64{3, 7}}, nil, nil, nil)
+	if err := s.Resume(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.snapshot(); !reflect.DeepEqual(got, []int64{3, 7}) {
+		t.Fatalf("expected tenants with pending jobs in rotation, got %v", got)
+	}
+}
@@ -19,6 +19,8 @@ type Conversation struct {
 	LastMessageAt     *time.Time `json:"last_message_at"`
 	CreatedAt         time.Time  `json:"created_at"`
 	ProfilePictureURL *string    `json:"profile_picture_url"`
+	Score             *float64   `json:"score"`
+	ScoredAt          *time.Time `json:"scored_at"`
 }
 
 type Message struct {
@@ -40,6 +42,7 @@ type DailySummary struct {
 	ConversationID int64     `json:"conversation_id"`
 	SummaryText    string    `json:"summary_text"`
 	KeyPointsJSON  *string   `json:"key_points_json"`
+	SummaryDate    *string   `json:"summary_date"`
 	CreatedAt      time.Time `json:"created_at"`
 }
 
@@ -74,29 +77,30 @@ type UserActivityLog struct {
 }
 
 type LLMProvider struct {
-	ID                   int64      `json:"id"`
-	TenantID             int64      `json:"tenant_id"`
-	ProviderName         string     `json:"provider_name"`
-	APIKey               string     `json:"api_key"`
-	ModelName            string     `json:"model_name"`
-	DisplayName          *string    `json:"display_name"`
-	BaseURL              *string    `json:"base_url"`
-	AzureEndpoint        *string    `json:"azure_endpoint"`
-	AzureDeployment      *string    `json:"azure_deployment"`
-	AzureAPIVersion      *string    `json:"azure_api_version"`
-	Temperature          float64    `json:"temperature"`
-	MaxTokens            int        `json:"max_tokens"`
-	CostPer1KInput       float64    `json:"cost_per_1k_input"`
-	CostPer1KOutput      float64    `json:"cost_per_1k_output"`
-	MaxRequestsPerMinute int        `json:"max_requests_per_minute"`
-	MaxRequestsPerDay    int        `json:"max_requests_per_day"`
-	MonthlyBudget        *float64   `json:"monthly_budget"`
-	IsActive             bool       `json:"is_active"`
-	IsDefault            bool       `json:"is_default"`
-	IsFallback           bool       `json:"is_fallback"`
-	HealthStatus         string     `json:"health_status"`
-	LastHealthCheck      *time.Time `json:"last_health_check"`
-	CreatedAt            time.Time  `json:"created_at"`
+	ID                   int64             `json:"id"`
+	TenantID             int64             `json:"tenant_id"`
+	ProviderName         string            `json:"provider_name"`
+	APIKey               string            `json:"api_key"`
+	ModelName            string            `json:"model_name"`
+	DisplayName          *string           `json:"display_name"`
+	BaseURL              *string           `json:"base_url"`
+	AzureEndpoint        *string           `json:"azure_endpoint"`
+	AzureDeployment      *string           `json:"azure_deployment"`
+	AzureAPIVersion      *string           `json:"azure_api_version"`
+	Temperature          float64           `json:"temperature"`
+	MaxTokens            int               `json:"max_tokens"`
+	CostPer1KInput       float64           `json:"cost_per_1k_input"`
+	CostPer1KOutput      float64           `json:"cost_per_1k_output"`
+	MaxRequestsPerMinute int               `json:"max_requests_per_minute"`
+	MaxRequestsPerDay    int               `json:"max_requests_per_day"`
+	MonthlyBudget        *float64          `json:"monthly_budget"`
+	IsActive             bool              `json:"is_active"`
+	IsDefault            bool              `json:"is_default"`
+	IsFallback           bool              `json:"is_fallback"`
+	HealthStatus         string            `json:"health_status"`
+	LastHealthCheck      *time.Time        `json:"last_health_check"`
+	CreatedAt            time.Time         `json:"created_at"`
+	SafetySettings       map[string]string `json:"safety_settings,omitempty"`
 }
 
 type LLMUsageLog struct {
@@ -115,6 +115,42 @@ func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 		}
+		if len(segments) == 2 && segments[1] == "ask" {
+			if r.Method == http.MethodPost {
+				if id, ok := handlers.ParseID(segments[0]); ok {
+					rt.api.AskConversation(w, r, id)
+					return
+				}
+			}
+		}
+		if len(segments) == 2 && segments[1] == "draft-reply" {
+			if r.Method == http.MethodPost {
+				if id, ok := handlers.ParseID(segments[0]); ok {
+					rt.api.DraftReply(w, r, id)
+					return
+				}
+			}
+		}
+		if len(segments) == 2 && segments[1] == "score" {
+			if id, ok := handlers.ParseID(segments[0]); ok {
+				switch r.Method {
+				case http.MethodGet:
+					rt.api.GetConversationScore(w, r, id)
+					return
+				case http.MethodPost:
+					rt.api.ScoreConversation(w, r, id)
+					return
+				}
+			}
+		}
+	case strings.HasPrefix(path, "/api/v1/reply-drafts/"):
+		segments := strings.Split(strings.TrimPrefix(path, "/api/v1/reply-drafts/"), "/")
+		if len(segments) == 2 && segments[1] == "feedback" && r.Method == http.MethodPost {
+			if id, ok := handlers.ParseID(segments[0]); ok {
+				rt.api.ReplyDraftFeedback(w, r, id)
+				return
+			}
+		}
 	case path == "/api/v1/messages/reply":
 		if r.Method == http.MethodPost {
 			rt.api.ReplyMessage(w, r)
@@ -173,11 +209,35 @@ func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		}
+	case path == "/api/v1/search/semantic":
+		if r.Method == http.MethodGet {
+			rt.api.SemanticSearch(w, r)
+			return
+		}
 	case path == "/api/v1/daily-summary":
 		if r.Method == http.MethodGet {
 			rt.api.GetDailySummary(w, r)
 			return
 		}
+	case path == "/api/v1/daily-summary/history":
+		if r.Method == http.MethodGet {
+			rt.api.ListDailyDigests(w, r)
+			return
+		}
+	case path == "/api/v1/daily-summary/settings":
+		switch r.Method {
+		case http.MethodGet:
+			rt.api.GetDigestSettings(w, r)
+			return
+		case http.MethodPut:
+			rt.api.UpdateDigestSettings(w, r)
+			return
+		}
+	case path == "/api/v1/daily-summary/generate":
+		if r.Method == http.MethodPost {
+			rt.api.GenerateDailyDigest(w, r)
+			return
+		}
 
 	case path == "/api/v1/llm/providers":
 		switch r.Method {
@@ -242,6 +302,75 @@ func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			rt.api.GetUsageByFeature(w, r)
 			return
 		}
+	case path == "/api/v1/llm/analytics/reply-drafts":
+		if r.Method == http.MethodGet {
+			rt.api.GetReplyDraftStats(w, r)
+			return
+		}
+	case path == "/api/v1/llm/analytics/corrections":
+		if r.Method == http.MethodGet {
+			rt.api.GetCorrectionStats(w, r)
+			return
+		}
+	case path == "/api/v1/llm/few-shot":
+		switch r.Method {
+		case http.MethodGet:
+			rt.api.GetFewShotSettings(w, r)
+			return
+		case http.MethodPut:
+			rt.api.UpdateFewShotSettings(w, r)
+			return
+		}
+	case path == "/api/v1/llm/redaction":
+		switch r.Method {
+		case http.MethodGet:
+			rt.api.GetRedactionSettings(w, r)
+			return
+		case http.MethodPut:
+			rt.api.UpdateRedactionSettings(w, r)
+			return
+		}
+	case path == "/api/v1/llm/redaction/preview":
+		if r.Method == http.MethodPost {
+			rt.api.PreviewRedaction(w, r)
+			return
+		}
+	case path == "/api/v1/llm/redaction/logs":
+		if r.Method == http.MethodGet {
+			rt.api.ListRedactionLogs(w, r)
+			return
+		}
+	case path == "/api/v1/llm/shadow":
+		if r.Method == http.MethodGet {
+			rt.api.ListShadowConfigs(w, r)
+			return
+		}
+	case path == "/api/v1/llm/shadow/results":
+		if r.Method == http.MethodGet {
+			rt.api.ListShadowResults(w, r)
+			return
+		}
+	case strings.HasPrefix(path, "/api/v1/llm/shadow/"):
+		feature := strings.TrimPrefix(path, "/api/v1/llm/shadow/")
+		if feature != "" && !strings.Contains(feature, "/") {
+			switch r.Method {
+			case http.MethodPut:
+				rt.api.UpdateShadowConfig(w, r, feature)
+				return
+			case http.MethodDelete:
+				rt.api.DeleteShadowConfig(w, r, feature)
+				return
+			}
+		}
+	case path == "/api/v1/llm/reply-settings":
+		switch r.Method {
+		case http.MethodGet:
+			rt.api.GetReplySettings(w, r)
+			return
+		case http.MethodPut:
+			rt.api.UpdateReplySettings(w, r)
+			return
+		}
 	case path == "/api/v1/llm/health":
 		if r.Method == http.MethodGet {
 			rt.api.GetHealthStatus(w, r)
@@ -277,6 +406,54 @@ func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 		}
+	case path == "/api/v1/llm/prompts":
+		switch r.Method {
+		case http.MethodGet:
+			rt.api.ListPrompts(w, r)
+			return
+		case http.MethodPost:
+			rt.api.CreatePrompt(w, r)
+			return
+		}
+	case path == "/api/v1/llm/prompts/preview":
+		if r.Method == http.MethodPost {
+			rt.api.PreviewPrompt(w, r)
+			return
+		}
+	case path == "/api/v1/llm/prompts/glossary":
+		switch r.Method {
+		case http.MethodGet:
+			rt.api.GetPromptGlossary(w, r)
+			return
+		case http.MethodPut:
+			rt.api.UpdatePromptGlossary(w, r)
+			return
+		}
+	case strings.HasPrefix(path, "/api/v1/llm/prompts/pins/"):
+		feature := strings.TrimPrefix(path, "/api/v1/llm/prompts/pins/")
+		if feature != "" && !strings.Contains(feature, "/") && r.Method == http.MethodDelete {
+			rt.api.UnpinPrompt(w, r, feature)
+			return
+		}
+	case strings.HasPrefix(path, "/api/v1/llm/prompts/"):
+		segments := strings.Split(strings.TrimPrefix(path, "/api/v1/llm/prompts/"), "/")
+		if id, ok := handlers.ParseID(segments[0]); ok {
+			if len(segments) == 2 && segments[1] == "pin" {
+				if r.Method == http.MethodPost {
+					rt.api.PinPrompt(w, r, id)
+					return
+				}
+			} else if len(segments) == 1 {
+				switch r.Method {
+				case http.MethodGet:
+					rt.api.GetPrompt(w, r, id)
+					return
+				case http.MethodDelete:
+					rt.api.DeletePrompt(w, r, id)
+					return
+				}
+			}
+		}
 	case path == "/api/v1/llm/bulk-test":
 		if r.Method == http.MethodPost {
 			rt.api.BulkTestProviders(w, r)
@@ -287,6 +464,38 @@ func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			rt.api.GetRecommendations(w, r)
 			return
 		}
+	case path == "/api/v1/llm/queue":
+		if r.Method == http.MethodGet {
+			rt.api.GetQueueStats(w, r)
+			return
+		}
+	case path == "/api/v1/llm/queue/dead-letters":
+		if r.Method == http.MethodGet {
+			rt.api.ListDeadLetters(w, r)
+			return
+		}
+	case path == "/api/v1/llm/queue/dead-letters/replay":
+		if r.Method == http.MethodPost {
+			rt.api.ReplayDeadLetters(w, r)
+			return
+		}
+	case path == "/api/v1/llm/queue/dead-letters/purge":
+		if r.Method == http.MethodPost {
+			rt.api.PurgeDeadLetters(w, r)
+			return
+		}
+	case strings.HasPrefix(path, "/api/v1/llm/queue/dead-letters/"):
+		segments := strings.Split(strings.TrimPrefix(path, "/api/v1/llm/queue/dead-letters/"), "/")
+		if segments[0] != "" {
+			if len(segments) == 2 && segments[1] == "replay" && r.Method == http.MethodPost {
+				rt.api.ReplayDeadLetter(w, r, segments[0])
+				return
+			}
+			if len(segments) == 1 && r.Method == http.MethodDelete {
+				rt.api.PurgeDeadLetter(w, r, segments[0])
+				return
+			}
+		}
 	case path == "/api/v1/team/users":
 		switch r.Method {
 		case http.MethodPost:
@@ -414,6 +623,16 @@ func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			rt.api.CreateLabel(w, r)
 			return
 		}
+	case strings.HasPrefix(path, "/api/v1/messages/") && strings.HasSuffix(path, "/analysis"):
+		if r.Method == http.MethodPatch {
+			segments := strings.Split(strings.TrimPrefix(path, "/api/v1/messages/"), "/")
+			if len(segments) == 2 {
+				if id, ok := handlers.ParseID(segments[0]); ok {
+					rt.api.CorrectMessageAnalysis(w, r, id)
+					return
+				}
+			}
+		}
 	case strings.HasPrefix(path, "/api/v1/messages/") && strings.HasSuffix(path, "/labels"):
 		if r.Method == http.MethodPost {
 			segments := strings.Split(strings.TrimPrefix(path, "/api/v1/messages/"), "/")
@@ -20,12 +20,14 @@ import (
 )
 
 type Syncer struct {
-	Store *db.Store
-	Queue *llm.Queue
-	Hub   *realtime.Hub
+	Store   *db.Store
+	Queue   llm.Queue
+	Workers *llm.WorkerScheduler
+	Digests *llm.DigestScheduler
+	Hub     *realtime.Hub
 }
 
-func NewSyncer(store *db.Store, queue *llm.Queue, hub *realtime.Hub) *Syncer {
+func NewSyncer(store *db.Store, queue llm.Queue, hub *realtime.Hub) *Syncer {
 	return &Syncer{Store: store, Queue: queue, Hub: hub}
 }
 
@@ -35,6 +37,18 @@ func (s *Syncer) Attach(tenantID int64, client *whatsmeow.Client) {
 		return
 	}
 	log.Printf("[Syncer] Attaching event handler for tenant %d", tenantID)
+	if s.Workers != nil {
+		// Picks up analysis jobs left over from before a restart.
+		s.Workers.EnsureTenant(context.Background(), tenantID)
+	}
+	if s.Digests != nil {
+		// Resumes the tenant's daily digests after a restart.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := s.Digests.Resume(ctx, tenantID); err != nil {
+			log.Printf("[Syncer] Failed to resume daily digests for tenant %d: %v", tenantID, err)
+		}
+		cancel()
+	}
 	client.AddEventHandler(func(evt any) {
 		ctx := context.Background()
 		switch event := evt.(type) {
@@ -98,6 +112,7 @@ func (s *Syncer) handleMessage(ctx context.Context, tenantID int64, client *what
 	}
 
 	// For media-only messages, set a placeholder content
+	hasText := content != ""
 	if content == "" && mediaInfo != nil {
 		content = "[" + mediaInfo.Type + "]"
 	}
@@ -130,6 +145,19 @@ func (s *Syncer) handleMessage(ctx context.Context, tenantID int64, client *what
 			Feature:   "analysis",
 			CreatedAt: time.Now().UTC(),
 		})
+		if hasText {
+			// Media placeholders carry no meaning worth searching for.
+			_ = s.Queue.Enqueue(ctx, llm.QueueMessage{
+				TenantID:  tenantID,
+				MessageID: messageID,
+				Content:   content,
+				Feature:   llm.QueueFeatureEmbedding,
+				CreatedAt: time.Now().UTC(),
+			})
+		}
+		if s.Workers != nil {
+			s.Workers.EnsureTenant(context.Background(), tenantID)
+		}
 	}
 
 	if s.Hub != nil {
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS analysis_jobs (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  message_id BIGINT,
+  content TEXT NOT NULL DEFAULT '',
+  feature TEXT NOT NULL DEFAULT '',
+  status TEXT NOT NULL DEFAULT 'pending',
+  attempts INT NOT NULL DEFAULT 0,
+  last_error TEXT,
+  run_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  locked_until TIMESTAMPTZ,
+  locked_by TEXT,
+  failed_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS analysis_jobs_ready_idx ON analysis_jobs (tenant_id, status, run_at);
+
+ALTER TABLE analysis_jobs ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY tenant_isolation_analysis_jobs ON analysis_jobs
+  USING (tenant_id = current_setting('app.tenant_id')::bigint)
+  WITH CHECK (tenant_id = current_setting('app.tenant_id')::bigint);
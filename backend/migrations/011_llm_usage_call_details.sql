@@ -0,0 +1,3 @@
+ALTER TABLE llm_usage_logs ADD COLUMN IF NOT EXISTS model_name TEXT;
+ALTER TABLE llm_usage_logs ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE llm_usage_logs ADD COLUMN IF NOT EXISTS http_status_code INTEGER;
@@ -0,0 +1 @@
+ALTER TABLE llm_providers ADD COLUMN IF NOT EXISTS safety_settings JSONB;
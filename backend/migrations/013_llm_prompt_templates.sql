@@ -0,0 +1,43 @@
+CREATE TABLE IF NOT EXISTS llm_prompt_templates (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  feature_name TEXT NOT NULL,
+  version INT NOT NULL,
+  template TEXT NOT NULL,
+  description TEXT NOT NULL DEFAULT '',
+  created_by BIGINT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  UNIQUE (tenant_id, feature_name, version)
+);
+
+CREATE TABLE IF NOT EXISTS llm_prompt_pins (
+  tenant_id BIGINT NOT NULL,
+  feature_name TEXT NOT NULL,
+  version INT NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (tenant_id, feature_name)
+);
+
+CREATE TABLE IF NOT EXISTS llm_prompt_settings (
+  tenant_id BIGINT PRIMARY KEY,
+  glossary TEXT NOT NULL DEFAULT '',
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE llm_usage_logs ADD COLUMN IF NOT EXISTS prompt_version INTEGER;
+
+ALTER TABLE llm_prompt_templates ENABLE ROW LEVEL SECURITY;
+ALTER TABLE llm_prompt_pins ENABLE ROW LEVEL SECURITY;
+ALTER TABLE llm_prompt_settings ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY tenant_isolation_llm_prompt_templates ON llm_prompt_templates
+  USING (tenant_id = current_setting('app.tenant_id')::bigint)
+  WITH CHECK (tenant_id = current_setting('app.tenant_id')::bigint);
+
+CREATE POLICY tenant_isolation_llm_prompt_pins ON llm_prompt_pins
+  USING (tenant_id = current_setting('app.tenant_id')::bigint)
+  WITH CHECK (tenant_id = current_setting('app.tenant_id')::bigint);
+
+CREATE POLICY tenant_isolation_llm_prompt_settings ON llm_prompt_settings
+  USING (tenant_id = current_setting('app.tenant_id')::bigint)
+  WITH CHECK (tenant_id = current_setting('app.tenant_id')::bigint);
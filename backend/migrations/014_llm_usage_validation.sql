@@ -0,0 +1,2 @@
+ALTER TABLE llm_usage_logs ADD COLUMN IF NOT EXISTS validation_errors INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE llm_usage_logs ADD COLUMN IF NOT EXISTS repaired BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS message_embeddings (
+  tenant_id BIGINT NOT NULL,
+  message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  provider_id BIGINT,
+  model TEXT NOT NULL,
+  dimensions INT NOT NULL,
+  embedding REAL[] NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (message_id, model)
+);
+
+CREATE INDEX IF NOT EXISTS message_embeddings_tenant_model_idx ON message_embeddings (tenant_id, model, created_at DESC);
+
+-- pgvector is optional: when the extension can be installed, vectors are also
+-- kept in a vector column and ranked in the database. Otherwise search falls
+-- back to ranking the REAL[] column in the application.
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = 'vector') THEN
+    CREATE EXTENSION IF NOT EXISTS vector;
+    ALTER TABLE message_embeddings ADD COLUMN IF NOT EXISTS embedding_vector vector;
+  END IF;
+EXCEPTION WHEN OTHERS THEN
+  RAISE NOTICE 'pgvector not installed: %', SQLERRM;
+END
+$$;
+
+ALTER TABLE message_embeddings ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY tenant_isolation_message_embeddings ON message_embeddings
+  USING (tenant_id = current_setting('app.tenant_id')::bigint)
+  WITH CHECK (tenant_id = current_setting('app.tenant_id')::bigint);
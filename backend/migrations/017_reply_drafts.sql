@@ -0,0 +1,29 @@
+ALTER TABLE llm_prompt_settings ADD COLUMN IF NOT EXISTS reply_tone TEXT NOT NULL DEFAULT '';
+ALTER TABLE llm_prompt_settings ADD COLUMN IF NOT EXISTS reply_guidelines TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS reply_drafts (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  conversation_id BIGINT NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+  provider_id BIGINT,
+  model TEXT NOT NULL DEFAULT '',
+  mode TEXT NOT NULL,
+  rewrite TEXT NOT NULL DEFAULT '',
+  suggestions JSONB NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending',
+  accepted_index INT,
+  final_text TEXT,
+  edited BOOLEAN NOT NULL DEFAULT FALSE,
+  created_by BIGINT,
+  decided_by BIGINT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  decided_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS reply_drafts_tenant_provider_idx ON reply_drafts (tenant_id, provider_id, created_at DESC);
+
+ALTER TABLE reply_drafts ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY tenant_isolation_reply_drafts ON reply_drafts
+  USING (tenant_id = current_setting('app.tenant_id')::bigint)
+  WITH CHECK (tenant_id = current_setting('app.tenant_id')::bigint);
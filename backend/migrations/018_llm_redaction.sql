@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS llm_redaction_settings (
+  tenant_id BIGINT PRIMARY KEY,
+  enabled BOOLEAN NOT NULL DEFAULT FALSE,
+  detectors TEXT[] NOT NULL DEFAULT ARRAY['email', 'card', 'phone', 'address'],
+  custom_patterns JSONB NOT NULL DEFAULT '[]'::jsonb,
+  restore_outputs BOOLEAN NOT NULL DEFAULT TRUE,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- One row per LLM call that redacted something. Values are never stored,
+-- only their kinds and the placeholders that replaced them.
+CREATE TABLE IF NOT EXISTS llm_redaction_logs (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  message_id BIGINT,
+  feature_used TEXT NOT NULL,
+  counts JSONB NOT NULL,
+  placeholders TEXT[] NOT NULL DEFAULT '{}',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS llm_redaction_logs_tenant_created_idx ON llm_redaction_logs (tenant_id, created_at DESC);
+
+ALTER TABLE llm_redaction_settings ENABLE ROW LEVEL SECURITY;
+ALTER TABLE llm_redaction_logs ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY tenant_isolation_llm_redaction_settings ON llm_redaction_settings
+  USING (tenant_id = current_setting('app.tenant_id')::bigint)
+  WITH CHECK (tenant_id = current_setting('app.tenant_id')::bigint);
+
+CREATE POLICY tenant_isolation_llm_redaction_logs ON llm_redaction_logs
+  USING (tenant_id = current_setting('app.tenant_id')::bigint)
+  WITH CHECK (tenant_id = current_setting('app.tenant_id')::bigint);
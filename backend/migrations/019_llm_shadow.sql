@@ -0,0 +1,48 @@
+-- Per feature, a share of production calls is repeated on a second provider
+-- for comparison. Shadow outputs are never returned to callers.
+CREATE TABLE IF NOT EXISTS llm_shadow_configs (
+  tenant_id BIGINT NOT NULL,
+  feature_name TEXT NOT NULL,
+  provider_id BIGINT NOT NULL REFERENCES llm_providers(id) ON DELETE CASCADE,
+  sample_percent DOUBLE PRECISION NOT NULL DEFAULT 0 CHECK (sample_percent >= 0 AND sample_percent <= 100),
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (tenant_id, feature_name)
+);
+
+-- One row per shadowed call. Agreement columns are NULL when the shadow
+-- failed or the feature's output has no such field.
+CREATE TABLE IF NOT EXISTS llm_shadow_results (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  feature_name TEXT NOT NULL,
+  message_id BIGINT,
+  primary_provider_id BIGINT NOT NULL,
+  shadow_provider_id BIGINT NOT NULL,
+  primary_output JSONB NOT NULL,
+  shadow_output JSONB,
+  primary_latency_ms BIGINT NOT NULL DEFAULT 0,
+  shadow_latency_ms BIGINT NOT NULL DEFAULT 0,
+  primary_cost DOUBLE PRECISION NOT NULL DEFAULT 0,
+  shadow_cost DOUBLE PRECISION NOT NULL DEFAULT 0,
+  shadow_error TEXT,
+  priority_match BOOLEAN,
+  importance_match BOOLEAN,
+  sentiment_delta DOUBLE PRECISION,
+  topic_overlap DOUBLE PRECISION,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS llm_shadow_results_tenant_created_idx ON llm_shadow_results (tenant_id, created_at DESC);
+
+ALTER TABLE llm_shadow_configs ENABLE ROW LEVEL SECURITY;
+ALTER TABLE llm_shadow_results ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY tenant_isolation_llm_shadow_configs ON llm_shadow_configs
+  USING (tenant_id = current_setting('app.tenant_id')::bigint)
+  WITH CHECK (tenant_id = current_setting('app.tenant_id')::bigint);
+
+CREATE POLICY tenant_isolation_llm_shadow_results ON llm_shadow_results
+  USING (tenant_id = current_setting('app.tenant_id')::bigint)
+  WITH CHECK (tenant_id = current_setting('app.tenant_id')::bigint);
@@ -0,0 +1,30 @@
+ALTER TABLE llm_prompt_settings ADD COLUMN IF NOT EXISTS few_shot_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE llm_prompt_settings ADD COLUMN IF NOT EXISTS few_shot_examples INTEGER NOT NULL DEFAULT 3;
+
+-- One row per agent correction of a message analysis. original is the
+-- machine analysis before the first correction of the message, and
+-- provider_id the provider that produced it (NULL for fallback and batch
+-- analyses); the label columns hold the message's full labels afterwards.
+CREATE TABLE IF NOT EXISTS analysis_corrections (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  message_id BIGINT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  provider_id BIGINT,
+  model_name TEXT NOT NULL DEFAULT '',
+  original JSONB,
+  is_important BOOLEAN NOT NULL,
+  priority TEXT NOT NULL,
+  sentiment TEXT NOT NULL,
+  topics TEXT[] NOT NULL DEFAULT '{}',
+  corrected_by BIGINT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS analysis_corrections_tenant_created_idx ON analysis_corrections (tenant_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS analysis_corrections_message_idx ON analysis_corrections (tenant_id, message_id, created_at);
+
+ALTER TABLE analysis_corrections ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY tenant_isolation_analysis_corrections ON analysis_corrections
+  USING (tenant_id = current_setting('app.tenant_id')::bigint)
+  WITH CHECK (tenant_id = current_setting('app.tenant_id')::bigint);
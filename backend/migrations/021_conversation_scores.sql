@@ -0,0 +1,9 @@
+-- Attention score of a conversation, 0 to 100, recomputed as its messages
+-- are analyzed. score_factors holds the signals behind it and
+-- score_explanation an optional model explanation of the current score.
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS score DOUBLE PRECISION;
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS score_factors JSONB;
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS score_explanation TEXT;
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS scored_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS conversations_tenant_score_idx ON conversations (tenant_id, score DESC NULLS LAST);
@@ -0,0 +1,43 @@
+-- Conversation summaries made for a daily digest carry the digest's date.
+ALTER TABLE daily_summaries ADD COLUMN IF NOT EXISTS summary_date DATE;
+
+CREATE INDEX IF NOT EXISTS daily_summaries_tenant_date_idx ON daily_summaries (tenant_id, summary_date, conversation_id);
+
+-- When a tenant's daily digest runs, as a local time in its time zone.
+CREATE TABLE IF NOT EXISTS daily_digest_settings (
+  tenant_id BIGINT PRIMARY KEY,
+  enabled BOOLEAN NOT NULL DEFAULT FALSE,
+  local_time TEXT NOT NULL DEFAULT '08:00',
+  timezone TEXT NOT NULL DEFAULT 'UTC',
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- One digest per tenant and local date, covering the day before the run.
+-- digest holds the important messages, new action items, sentiment shifts
+-- and summarized conversations of the period.
+CREATE TABLE IF NOT EXISTS daily_digests (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id BIGINT NOT NULL,
+  digest_date DATE NOT NULL,
+  period_start TIMESTAMPTZ NOT NULL,
+  period_end TIMESTAMPTZ NOT NULL,
+  status TEXT NOT NULL DEFAULT 'running',
+  conversations INTEGER NOT NULL DEFAULT 0,
+  failed_conversations INTEGER NOT NULL DEFAULT 0,
+  digest JSONB,
+  error_message TEXT,
+  started_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  completed_at TIMESTAMPTZ,
+  UNIQUE (tenant_id, digest_date)
+);
+
+ALTER TABLE daily_digest_settings ENABLE ROW LEVEL SECURITY;
+ALTER TABLE daily_digests ENABLE ROW LEVEL SECURITY;
+
+CREATE POLICY tenant_isolation_daily_digest_settings ON daily_digest_settings
+  USING (tenant_id = current_setting('app.tenant_id')::bigint)
+  WITH CHECK (tenant_id = current_setting('app.tenant_id')::bigint);
+
+CREATE POLICY tenant_isolation_daily_digests ON daily_digests
+  USING (tenant_id = current_setting('app.tenant_id')::bigint)
+  WITH CHECK (tenant_id = current_setting('app.tenant_id')::bigint);
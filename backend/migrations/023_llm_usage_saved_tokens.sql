@@ -0,0 +1,6 @@
+-- Cache hits consume no tokens; saved_tokens holds those of the original
+-- call. Hits logged before the column existed carried them as tokens.
+ALTER TABLE llm_usage_logs ADD COLUMN IF NOT EXISTS saved_tokens INTEGER NOT NULL DEFAULT 0;
+UPDATE llm_usage_logs
+SET saved_tokens=total_tokens, input_tokens=0, output_tokens=0, total_tokens=0
+WHERE cache_hit AND total_tokens > 0;
@@ -0,0 +1,7 @@
+-- Workers only serve tenants they were told about, so after a restart they
+-- ask which tenants have unfinished jobs. analysis_jobs is tenant-isolated;
+-- the function runs as its owner to see every tenant.
+CREATE OR REPLACE FUNCTION analysis_job_tenants() RETURNS SETOF BIGINT
+LANGUAGE sql STABLE SECURITY DEFINER SET search_path = public AS $$
+  SELECT DISTINCT tenant_id FROM analysis_jobs WHERE status IN ('pending', 'running')
+$$;
@@ -11,3 +11,18 @@ psql "$DATABASE_URL" -f /migrations/003_phase3_llm_management.sql
 psql "$DATABASE_URL" -f /migrations/004_phase3_llm_provider_fields.sql
 psql "$DATABASE_URL" -f /migrations/005_phase4_team_collaboration.sql
 psql "$DATABASE_URL" -f /migrations/006_llm_provider_endpoints.sql
+psql "$DATABASE_URL" -f /migrations/010_analysis_jobs.sql
+psql "$DATABASE_URL" -f /migrations/011_llm_usage_call_details.sql
+psql "$DATABASE_URL" -f /migrations/012_llm_provider_safety_settings.sql
+psql "$DATABASE_URL" -f /migrations/013_llm_prompt_templates.sql
+psql "$DATABASE_URL" -f /migrations/014_llm_usage_validation.sql
+psql "$DATABASE_URL" -f /migrations/015_llm_usage_cache.sql
+psql "$DATABASE_URL" -f /migrations/016_message_embeddings.sql
+psql "$DATABASE_URL" -f /migrations/017_reply_drafts.sql
+psql "$DATABASE_URL" -f /migrations/018_llm_redaction.sql
+psql "$DATABASE_URL" -f /migrations/019_llm_shadow.sql
+psql "$DATABASE_URL" -f /migrations/020_analysis_corrections.sql
+psql "$DATABASE_URL" -f /migrations/021_conversation_scores.sql
+psql "$DATABASE_URL" -f /migrations/022_daily_digests.sql
+psql "$DATABASE_URL" -f /migrations/023_llm_usage_saved_tokens.sql
+psql "$DATABASE_URL" -f /migrations/024_analysis_job_tenants.sql
@@ -34,7 +34,7 @@ export default function AddProviderModal({ open, onClose, onSubmit, providerMode
   };
 
   const validate = () => {
-    if (!form.api_key) return "API key is required";
+    if (!form.api_key && !["ollama", "mock"].includes(form.provider_name)) return "API key is required";
     if (!form.model_name && form.provider_name !== 'azure_openai') return "Model is required";
     if (form.provider_name === "azure_openai" && !form.azure_endpoint) return "Azure endpoint is required";
     return "";
@@ -90,6 +90,8 @@ export default function AddProviderModal({ open, onClose, onSubmit, providerMode
                 <option value="azure_openai">Azure OpenAI</option>
                 <option value="cohere">Cohere</option>
                 <option value="gemini">Gemini</option>
+                <option value="ollama">Ollama (local)</option>
+                <option value="mock">Mock (offline)</option>
               </select>
             </div>
 
@@ -128,6 +130,20 @@ export default function AddProviderModal({ open, onClose, onSubmit, providerMode
               <input className="form-control" type="number" value={form.max_tokens} onChange={(e) => updateField("max_tokens", Number(e.target.value))} />
             </div>
 
+            {form.provider_name === 'ollama' && (
+              <div className="form-group" style={{ gridColumn: '1 / -1' }}>
+                <label className="form-label">Server URL</label>
+                <input className="form-control" placeholder="http://localhost:11434" value={form.base_url} onChange={(e) => updateField("base_url", e.target.value)} />
+              </div>
+            )}
+
+            {form.provider_name === 'mock' && (
+              <div className="form-group" style={{ gridColumn: '1 / -1' }}>
+                <label className="form-label">Mock Settings</label>
+                <input className="form-control" placeholder="mock://?latency=200ms&failure_rate=0.1" value={form.base_url} onChange={(e) => updateField("base_url", e.target.value)} />
+              </div>
+            )}
+
             {form.provider_name === 'azure_openai' && (
               <>
                 <div className="form-group">
@@ -37,6 +37,8 @@ export default function ProvidersListView({
             <option value="cohere">Cohere</option>
             <option value="azure">Azure</option>
             <option value="gemini">Gemini</option>
+            <option value="ollama">Ollama</option>
+            <option value="mock">Mock</option>
           </select>
           <select className="filter-dropdown" value={filters.active} onChange={(event) => setFilters({ ...filters, active: event.target.value })}>
             <option value="all">Active + Inactive</option>